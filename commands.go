@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: fritzbox-cloudflare-dyndns [options] [command]
+
+Without a command the service is started.
+
+Options:
+  --no-history     keep no history of update attempts, for devices with little memory
+  --<variable>     set any environment variable, i.e. --fritzbox-endpoint-url=... for FRITZBOX_ENDPOINT_URL
+  --fritzbox-url   FRITZBOX_ENDPOINT_URL
+  --interval       FRITZBOX_ENDPOINT_INTERVAL
+  --cf-token       CLOUDFLARE_API_TOKEN
+  --cf-zones-ipv4  CLOUDFLARE_ZONES_IPV4
+  --cf-zones-ipv6  CLOUDFLARE_ZONES_IPV6
+  --bind           DYNDNS_SERVER_BIND
+  --username       DYNDNS_SERVER_USERNAME
+  --password       DYNDNS_SERVER_PASSWORD
+
+Commands:
+  check        validate the configuration and exit
+  selftest     run simulated pushes and polls against a noop updater
+  notify test  send a test notification through every configured notifier
+  migrate      convert a ddclient or inadyn configuration, see migrate --help
+  explain      explain how a record is updated, i.e. explain home.example.com
+`
+
+// runCommand runs the command given on the command line, it returns false if
+// there is none and the service should be started instead.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var ok bool
+
+	switch args[0] {
+	case "check":
+		ok = runCheck()
+	case "selftest":
+		ok = runSelftest()
+	case "notify":
+		ok = runNotify(args[1:])
+	case "migrate":
+		ok = runMigrate(args[1:])
+	case "explain":
+		ok = runExplain(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		ok = true
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+
+	return true
+}
+
+// runCheck validates the configuration without starting the service.
+func runCheck() bool {
+	if !loadConfig() {
+		return false
+	}
+
+	fmt.Println("Configuration is valid")
+
+	return true
+}
+
+func runNotify(args []string) bool {
+	if len(args) != 1 || args[0] != "test" {
+		fmt.Fprint(os.Stderr, usage)
+		return false
+	}
+
+	results := newDispatcher().Test()
+
+	if len(results) == 0 {
+		fmt.Println("No notifiers configured")
+		return false
+	}
+
+	ok := true
+
+	fmt.Printf("%-12s %s\n", "NOTIFIER", "RESULT")
+
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%-12s FAIL: %s\n", result.Name, result.Err)
+			ok = false
+		} else {
+			fmt.Printf("%-12s pass\n", result.Name)
+		}
+	}
+
+	return ok
+}
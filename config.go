@@ -0,0 +1,759 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/publicip"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/schedule"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/snmp"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/whoami"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefixes lists the prefixes of the environment variables owned by this service.
+var envPrefixes = []string{
+	"CLEANUP_",
+	"CLOUDFLARE_",
+	"CONFIG_",
+	"DEVICE_",
+	"DNSIMPLE_",
+	"DOCKER_WATCH",
+	"DRY_RUN",
+	"DYNDNS_",
+	"FAILOVER_",
+	"FIREWALL_",
+	"FRITZBOX_",
+	"HEALTH_",
+	"INFOMANIAK_",
+	"INTERFACE_",
+	"IP_FILE",
+	"JSON_",
+	"KUBERNETES_WATCH",
+	"LOG_",
+	"MIKROTIK_",
+	"NAMECHEAP_",
+	"NOTIFY_",
+	"NS1_",
+	"OPENWRT_",
+	"OPS_BIND",
+	"PROFILE",
+	"PUBLIC_IP_",
+	"PUBLISH_",
+	"SCALEWAY_",
+	"SECRETS_",
+	"SNMP_",
+	"STATE_",
+	"STRICT_",
+	"STUN_",
+	"UPDATE_",
+	"UPNP_",
+	"WHOAMI_",
+}
+
+// knownEnv lists every environment variable that is read for configuration.
+var knownEnv = []string{
+	"CLEANUP_FALLBACK_IPV4",
+	"CLEANUP_FALLBACK_IPV6",
+	"CLEANUP_ON_EXIT",
+	"CLOUDFLARE_ACCOUNT_ID",
+	"CLOUDFLARE_API_EMAIL",
+	"CLOUDFLARE_API_KEY",
+	"CLOUDFLARE_API_TOKEN",
+	"CLOUDFLARE_API_URL",
+	"CLOUDFLARE_CUTOVER_IPV4",
+	"CLOUDFLARE_CUTOVER_IPV6",
+	"CLOUDFLARE_CUTOVER_PROBE_PORT",
+	"CLOUDFLARE_DELEGATIONS",
+	"CLOUDFLARE_DELETE_UNPUBLISHED",
+	"CLOUDFLARE_EXCLUSIVE",
+	"CLOUDFLARE_HTTPS_HINTS",
+	"CLOUDFLARE_INSTANCE_ID",
+	"CLOUDFLARE_MAX_RPS",
+	"CLOUDFLARE_METADATA_RECORDS",
+	"CLOUDFLARE_MIRROR",
+	"CLOUDFLARE_OWNERSHIP",
+	"CLOUDFLARE_PARALLELISM",
+	"CLOUDFLARE_PREFIX_RECORDS",
+	"CLOUDFLARE_PROBES",
+	"CLOUDFLARE_PURGE_CACHE",
+	"CLOUDFLARE_PURGE_DELAY",
+	"CLOUDFLARE_PURGE_URLS",
+	"CLOUDFLARE_STAND_DOWN",
+	"CLOUDFLARE_TTL",
+	"CLOUDFLARE_VIEWS",
+	"CLOUDFLARE_ZONE_ID_MAP",
+	"CLOUDFLARE_ZONE_TOKENS",
+	"CLOUDFLARE_ZONES_IPV4",
+	"CLOUDFLARE_ZONES_IPV6",
+	"CONFIG_FILE",
+	"CONFIG_WATCH",
+	"CREDENTIALS_DIRECTORY",
+	"DEVICE_LOCAL_ADDRESS_IPV6",
+	"DNSIMPLE_API_TOKEN",
+	"DNSIMPLE_ZONES_IPV4",
+	"DNSIMPLE_ZONES_IPV6",
+	"DOCKER_HOST",
+	"DOCKER_WATCH",
+	"DOCKER_WATCH_LABEL",
+	"DRY_RUN",
+	"DYNDNS_SERVER_ACCESS_LOG",
+	"DYNDNS_SERVER_ADMIN",
+	"DYNDNS_SERVER_BIND",
+	"DYNDNS_SERVER_CLIENT_IP_FALLBACK",
+	"DYNDNS_SERVER_CONFIRM",
+	"DYNDNS_SERVER_CONFIRM_TIMEOUT",
+	"DYNDNS_SERVER_HMAC_MAX_AGE",
+	"DYNDNS_SERVER_HMAC_SECRET",
+	"DYNDNS_SERVER_HTPASSWD",
+	"DYNDNS_SERVER_INTROSPECTION_CLIENT_ID",
+	"DYNDNS_SERVER_INTROSPECTION_CLIENT_SECRET",
+	"DYNDNS_SERVER_INTROSPECTION_URL",
+	"DYNDNS_SERVER_LOCKOUT_DURATION",
+	"DYNDNS_SERVER_LOCKOUT_FAILURES",
+	"DYNDNS_SERVER_PARAMS",
+	"DYNDNS_SERVER_PASSWORD",
+	"DYNDNS_SERVER_PATH",
+	"DYNDNS_SERVER_PATH_PREFIX",
+	"DYNDNS_SERVER_PROPAGATION_RECORDS",
+	"DYNDNS_SERVER_PROPAGATION_RESOLVER",
+	"DYNDNS_SERVER_RATE_LIMIT",
+	"DYNDNS_SERVER_SITES",
+	"DYNDNS_SERVER_TLS_CERT",
+	"DYNDNS_SERVER_TLS_CLIENT_CA",
+	"DYNDNS_SERVER_TLS_KEY",
+	"DYNDNS_SERVER_TOKEN",
+	"DYNDNS_SERVER_TRUSTED_PROXIES",
+	"DYNDNS_SERVER_USERNAME",
+	"FAILOVER_BACKUP_IPV4",
+	"FAILOVER_BACKUP_IPV6",
+	"FAILOVER_CHECK_INTERVAL",
+	"FAILOVER_FAILBACK_AFTER",
+	"FAILOVER_PROBE",
+	"FAILOVER_PROBE_FAILURES",
+	"FAILOVER_PROBE_PORT",
+	"FIREWALL_INSECURE",
+	"FIREWALL_INTERFACE",
+	"FIREWALL_KEY",
+	"FIREWALL_PREFIX_INTERFACE",
+	"FIREWALL_SECRET",
+	"FIREWALL_URL",
+	"FRITZBOX_ENDPOINT_FAILURES",
+	"FRITZBOX_ENDPOINT_FALLBACK",
+	"FRITZBOX_ENDPOINT_INTERVAL",
+	"FRITZBOX_ENDPOINT_JITTER",
+	"FRITZBOX_ENDPOINT_RETRY_INTERVAL",
+	"FRITZBOX_ENDPOINT_SCHEDULE",
+	"FRITZBOX_ENDPOINT_SOURCE",
+	"FRITZBOX_ENDPOINT_STATUS_INTERVAL",
+	"FRITZBOX_ENDPOINT_TIMEOUT",
+	"FRITZBOX_ENDPOINT_URL",
+	"FRITZBOX_TR064_INSECURE",
+	"FRITZBOX_TR064_DESCRIPTION",
+	"FRITZBOX_TR064_PASSWORD",
+	"FRITZBOX_TR064_PROFILE",
+	"FRITZBOX_TR064_URL",
+	"FRITZBOX_TR064_USERNAME",
+	"FRITZBOX_WEBUI_PASSWORD",
+	"FRITZBOX_WEBUI_URL",
+	"FRITZBOX_WEBUI_USERNAME",
+	"HEALTH_POLL_MAX_AGE",
+	"INFOMANIAK_API_TOKEN",
+	"INFOMANIAK_ZONES_IPV4",
+	"INFOMANIAK_ZONES_IPV6",
+	"INTERFACE_INTERVAL",
+	"INTERFACE_NAME",
+	"IP_FILE",
+	"IP_FILE_INTERVAL",
+	"JSON_INSECURE",
+	"JSON_IPV4_PATH",
+	"JSON_IPV6_PATH",
+	"JSON_PASSWORD",
+	"JSON_PREFIX_PATH",
+	"JSON_TOKEN",
+	"JSON_URL",
+	"JSON_USERNAME",
+	"KUBERNETES_SERVICE_HOST",
+	"KUBERNETES_SERVICE_PORT",
+	"KUBERNETES_WATCH",
+	"KUBERNETES_WATCH_ANNOTATION",
+	"KUBERNETES_WATCH_INTERVAL",
+	"KUBERNETES_WATCH_NAMESPACE",
+	"LOG_FILE",
+	"LOG_FILE_BACKUPS",
+	"LOG_FILE_MAX_AGE",
+	"LOG_FILE_MAX_BYTES",
+	"LOG_FORMAT",
+	"LOG_LEVEL",
+	"LOG_SYSLOG",
+	"LOG_SYSLOG_FACILITY",
+	"MIKROTIK_INSECURE",
+	"MIKROTIK_INTERFACE",
+	"MIKROTIK_PASSWORD",
+	"MIKROTIK_POOL",
+	"MIKROTIK_URL",
+	"MIKROTIK_USERNAME",
+	"NAMECHEAP_DDNS_PASSWORD",
+	"NAMECHEAP_ZONES_IPV4",
+	"NOTIFY_OVERFLOW",
+	"NOTIFY_QUEUE_SIZE",
+	"NOTIFY_TIMEOUT",
+	"NOTIFY_WEBHOOK_URL",
+	"NS1_API_KEY",
+	"NS1_ZONES_IPV4",
+	"NS1_ZONES_IPV6",
+	"OPENWRT_INTERFACE_IPV4",
+	"OPENWRT_INTERFACE_IPV6",
+	"OPENWRT_PASSWORD",
+	"OPENWRT_URL",
+	"OPENWRT_USERNAME",
+	"OPS_BIND",
+	"PROFILE",
+	"PUBLIC_IP_SERVICES",
+	"PUBLISH_IPV4",
+	"PUBLISH_IPV6",
+	"PUBLISH_SHARED_IPV4",
+	"SCALEWAY_SECRET_KEY",
+	"SCALEWAY_ZONES_IPV4",
+	"SCALEWAY_ZONES_IPV6",
+	"SECRETS_DIR",
+	"SNMP_ADDRESS",
+	"SNMP_AUTH_PASSWORD",
+	"SNMP_AUTH_PROTOCOL",
+	"SNMP_COMMUNITY",
+	"SNMP_INTERFACE",
+	"SNMP_IPV4_OID",
+	"SNMP_IPV6_OID",
+	"SNMP_PREFIX_OID",
+	"SNMP_PRIV_PASSWORD",
+	"SNMP_PRIV_PROTOCOL",
+	"SNMP_USERNAME",
+	"SNMP_VERSION",
+	"STATE_FILE",
+	"STATE_HISTORY_BYTES",
+	"STATE_HISTORY_ENTRIES",
+	"STRICT_CONFIG",
+	"STUN_SERVERS",
+	"UPDATE_FAILURE_THRESHOLD",
+	"UPNP_LOCATION",
+	"WHOAMI_SERVICES",
+}
+
+// sourceNames lists the values of FRITZBOX_ENDPOINT_SOURCE that name a single source.
+var sourceNames = []string{"soap", "webui", "tr064", "upnp", "https", "stun", "dns", "openwrt", "mikrotik", "json", "snmp", "opnsense", "pfsense"}
+
+// envParsers validates the variables that are not plain strings, so all
+// invalid values can be reported together on startup instead of one per restart.
+var envParsers = map[string]func(string) error{
+	"CLEANUP_FALLBACK_IPV4":              parseIpv4,
+	"CLEANUP_FALLBACK_IPV6":              parseIpv6,
+	"CLEANUP_ON_EXIT":                    parseBool,
+	"CLOUDFLARE_CUTOVER_PROBE_PORT":      parseInt,
+	"CLOUDFLARE_DELETE_UNPUBLISHED":      parseBool,
+	"CLOUDFLARE_EXCLUSIVE":               parseBool,
+	"CLOUDFLARE_HTTPS_HINTS":             parseBool,
+	"CLOUDFLARE_MAX_RPS":                 parseFloat,
+	"CLOUDFLARE_OWNERSHIP":               parseBool,
+	"CLOUDFLARE_PARALLELISM":             parseInt,
+	"CLOUDFLARE_PURGE_CACHE":             parseBool,
+	"CLOUDFLARE_PURGE_DELAY":             parseDuration,
+	"CLOUDFLARE_STAND_DOWN":              parseBool,
+	"CLOUDFLARE_TTL":                     parseInt,
+	"CONFIG_WATCH":                       parseBool,
+	"DOCKER_WATCH":                       parseBool,
+	"DRY_RUN":                            parseBool,
+	"DYNDNS_SERVER_ACCESS_LOG":           parseBool,
+	"DYNDNS_SERVER_ADMIN":                parseBool,
+	"DYNDNS_SERVER_CLIENT_IP_FALLBACK":   parseBool,
+	"DYNDNS_SERVER_CONFIRM":              parseBool,
+	"DYNDNS_SERVER_CONFIRM_TIMEOUT":      parseDuration,
+	"DYNDNS_SERVER_HMAC_MAX_AGE":         parseDuration,
+	"DYNDNS_SERVER_LOCKOUT_DURATION":     parseDuration,
+	"DYNDNS_SERVER_LOCKOUT_FAILURES":     parseInt,
+	"DYNDNS_SERVER_PROPAGATION_RESOLVER": parseHostPorts,
+	"DYNDNS_SERVER_RATE_LIMIT":           parseInt,
+	"FAILOVER_CHECK_INTERVAL":            parseDuration,
+	"FAILOVER_FAILBACK_AFTER":            parseDuration,
+	"FAILOVER_PROBE_FAILURES":            parseInt,
+	"FAILOVER_PROBE_PORT":                parseInt,
+	"FIREWALL_INSECURE":                  parseBool,
+	"FRITZBOX_ENDPOINT_FAILURES":         parseInt,
+	"FRITZBOX_ENDPOINT_FALLBACK":         parseEachOf(sourceNames...),
+	"FRITZBOX_ENDPOINT_INTERVAL":         parseDuration,
+	"FRITZBOX_ENDPOINT_JITTER":           parseDuration,
+	"FRITZBOX_ENDPOINT_RETRY_INTERVAL":   parseDuration,
+	"FRITZBOX_ENDPOINT_SCHEDULE":         parseSchedule,
+	"FRITZBOX_ENDPOINT_SOURCE":           parseOneOf(append(sourceNames, "auto")...),
+	"FRITZBOX_ENDPOINT_STATUS_INTERVAL":  parseDuration,
+	"FRITZBOX_ENDPOINT_TIMEOUT":          parseDuration,
+	"FRITZBOX_TR064_INSECURE":            parseBool,
+	"FRITZBOX_TR064_PROFILE":             parseOneOf("fritzbox", "generic"),
+	"HEALTH_POLL_MAX_AGE":                parseDuration,
+	"INTERFACE_INTERVAL":                 parseDuration,
+	"IP_FILE_INTERVAL":                   parseDuration,
+	"JSON_INSECURE":                      parseBool,
+	"KUBERNETES_WATCH":                   parseBool,
+	"KUBERNETES_WATCH_INTERVAL":          parseDuration,
+	"LOG_FILE_BACKUPS":                   parseInt,
+	"LOG_FILE_MAX_AGE":                   parseDuration,
+	"LOG_FILE_MAX_BYTES":                 parseSize,
+	"LOG_FORMAT":                         parseOneOf("text", "json"),
+	"LOG_LEVEL":                          parseOneOf("debug", "info", "warn", "error"),
+	"LOG_SYSLOG":                         parseSyslog,
+	"LOG_SYSLOG_FACILITY":                logging.ParseSyslogFacility,
+	"MIKROTIK_INSECURE":                  parseBool,
+	"NOTIFY_QUEUE_SIZE":                  parseInt,
+	"NOTIFY_TIMEOUT":                     parseDuration,
+	"PUBLIC_IP_SERVICES":                 parsePublicIpServices,
+	"PUBLISH_IPV4":                       parseBool,
+	"PUBLISH_IPV6":                       parseBool,
+	"PUBLISH_SHARED_IPV4":                parseBool,
+	"SNMP_AUTH_PROTOCOL":                 parseOneOf(snmp.AuthProtocols...),
+	"SNMP_INTERFACE":                     parseInt,
+	"SNMP_IPV4_OID":                      parseOid,
+	"SNMP_IPV6_OID":                      parseOid,
+	"SNMP_PREFIX_OID":                    parseOid,
+	"SNMP_PRIV_PROTOCOL":                 parseOneOf(snmp.PrivProtocols...),
+	"SNMP_VERSION":                       parseOneOf("2c", "3"),
+	"STATE_HISTORY_BYTES":                parseSize,
+	"STATE_HISTORY_ENTRIES":              parseInt,
+	"STRICT_CONFIG":                      parseBool,
+	"STUN_SERVERS":                       parseHostPorts,
+	"UPDATE_FAILURE_THRESHOLD":           parseInt,
+	"WHOAMI_SERVICES":                    parseWhoamiServices,
+}
+
+// loadConfig applies the profile and validates the variables, it logs why the
+// configuration is invalid.
+func loadConfig() bool {
+	if envBool("STRICT_CONFIG", false) {
+		if unknown := unknownEnv(); len(unknown) > 0 {
+			for _, name := range unknown {
+				if suggestion := suggestEnv(name); suggestion != "" {
+					slog.Error("Unknown env variable", slog.String("name", name), slog.String("suggestion", suggestion))
+				} else {
+					slog.Error("Unknown env variable", slog.String("name", name))
+				}
+			}
+
+			slog.Error("Strict config enabled and unknown env variables found")
+			return false
+		}
+	}
+
+	if profile := os.Getenv("PROFILE"); profile != "" {
+		err := applyProfile(profile)
+
+		if err != nil {
+			slog.Error("Failed to apply PROFILE", logging.ErrorAttr(err))
+			return false
+		}
+
+		slog.Info("Using configuration profile", slog.String("profile", profile))
+	}
+
+	if errs := validateEnv(); len(errs) > 0 {
+		for _, err := range errs {
+			slog.Error("Invalid env variable", logging.ErrorAttr(err))
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// validateEnv checks every typed variable and returns all invalid ones at once.
+func validateEnv() []error {
+	names := make([]string, 0, len(envParsers))
+
+	for name := range envParsers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var errs []error
+
+	for _, name := range names {
+		value := os.Getenv(name)
+
+		if value == "" {
+			continue
+		}
+
+		if err := envParsers[name](value); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", name, value, err))
+		}
+	}
+
+	return errs
+}
+
+// envBool reads a boolean variable, falling back to def if it is unset.
+func envBool(name string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envInt reads an integer variable, falling back to def if it is unset.
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envFloat reads a decimal variable, falling back to def if it is unset.
+func envFloat(name string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(name), 64)
+
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envDuration reads a duration like `5m` or `1h30m`, plain numbers are
+// seconds. It falls back to def if the variable is unset.
+func envDuration(name string, def time.Duration) time.Duration {
+	v, err := toDuration(os.Getenv(name))
+
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// envSize reads a size like `10MiB` or `64KiB`, plain numbers are bytes. It
+// falls back to def if the variable is unset.
+func envSize(name string, def int64) int64 {
+	v, err := toSize(os.Getenv(name))
+
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+func parseBool(value string) error {
+	_, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return errors.New("expected true or false")
+	}
+
+	return nil
+}
+
+func parseInt(value string) error {
+	v, err := strconv.Atoi(value)
+
+	if err != nil || v < 0 {
+		return errors.New("expected a positive number")
+	}
+
+	return nil
+}
+
+func parseFloat(value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || v < 0 {
+		return errors.New("expected a positive decimal number")
+	}
+
+	return nil
+}
+
+// parseSchedule accepts a cron expression or a macro like `@every 5m`.
+func parseSchedule(value string) error {
+	_, err := schedule.Parse(value, time.Local)
+
+	return err
+}
+
+func parseSyslog(value string) error {
+	_, _, err := logging.ParseSyslogAddress(value)
+
+	return err
+}
+
+// parseOneOf accepts only the given values.
+func parseOneOf(values ...string) func(string) error {
+	return func(value string) error {
+		if !slices.Contains(values, value) {
+			return fmt.Errorf("expected one of %s", strings.Join(values, ", "))
+		}
+
+		return nil
+	}
+}
+
+// parseEachOf accepts a comma separated list of the given values.
+func parseEachOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range strings.Split(value, ",") {
+			if err := parseOneOf(values...)(strings.TrimSpace(v)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func parseIpv4(value string) error {
+	ip := net.ParseIP(value)
+
+	if ip == nil || ip.To4() == nil {
+		return errors.New("expected an IPv4 address")
+	}
+
+	return nil
+}
+
+func parseIpv6(value string) error {
+	ip := net.ParseIP(value)
+
+	if ip == nil || ip.To4() != nil {
+		return errors.New("expected an IPv6 address")
+	}
+
+	return nil
+}
+
+// parsePublicIpServices accepts the names of known services and URLs.
+func parsePublicIpServices(value string) error {
+	for _, service := range strings.Split(value, ",") {
+		service = strings.TrimSpace(service)
+
+		if _, ok := publicip.Services[service]; ok {
+			continue
+		}
+
+		if u, err := url.ParseRequestURI(service); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("expected URLs or one of %s, got %q", strings.Join(publicip.DefaultServices, ", "), service)
+		}
+	}
+
+	return nil
+}
+
+// parseWhoamiServices accepts the names of known services, each optionally
+// followed by @host:port.
+func parseWhoamiServices(value string) error {
+	for _, service := range strings.Split(value, ",") {
+		name, server, found := strings.Cut(strings.TrimSpace(service), "@")
+
+		if _, ok := whoami.Services[name]; !ok {
+			return fmt.Errorf("expected one of %s, got %q", strings.Join(whoami.DefaultServices, ", "), name)
+		}
+
+		if found {
+			if err := parseHostPorts(server); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseHostPorts accepts host:port pairs, like the STUN servers.
+func parseHostPorts(value string) error {
+	for _, hostPort := range strings.Split(value, ",") {
+		if _, _, err := net.SplitHostPort(strings.TrimSpace(hostPort)); err != nil {
+			return fmt.Errorf("expected host:port pairs, got %q", strings.TrimSpace(hostPort))
+		}
+	}
+
+	return nil
+}
+
+func parseOid(value string) error {
+	_, err := snmp.ParseOid(value)
+
+	if err != nil {
+		return errors.New("expected an OID like 1.3.6.1.2.1.4.20.1.1")
+	}
+
+	return nil
+}
+
+func parseDuration(value string) error {
+	_, err := toDuration(value)
+
+	if err != nil {
+		return errors.New("expected a duration like 300, 5m or 1h30m")
+	}
+
+	return nil
+}
+
+func toDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		value = fmt.Sprintf("%ds", seconds)
+	}
+
+	v, err := time.ParseDuration(value)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if v < 0 {
+		return 0, errors.New("negative duration")
+	}
+
+	return v, nil
+}
+
+func parseSize(value string) error {
+	_, err := toSize(value)
+
+	if err != nil {
+		return errors.New("expected a size like 65536, 64KiB or 10MiB")
+	}
+
+	return nil
+}
+
+// sizeUnits are the binary units a size may be given in.
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+func toSize(value string) (int64, error) {
+	factor := int64(1)
+
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value = strings.TrimSpace(number)
+			factor = unit.factor
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if v < 0 || v > math.MaxInt64/factor {
+		return 0, errors.New("size out of range")
+	}
+
+	return v * factor, nil
+}
+
+// unknownEnv returns every environment variable which uses one of our prefixes
+// but is not known, i.e. because of a typo.
+func unknownEnv() []string {
+	var unknown []string
+
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+
+		if !hasEnvPrefix(name) || isKnownEnv(name) {
+			continue
+		}
+
+		unknown = append(unknown, name)
+	}
+
+	return unknown
+}
+
+func hasEnvPrefix(name string) bool {
+	for _, prefix := range envPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isKnownEnv(name string) bool {
+	if secret, ok := strings.CutSuffix(name, "_FILE"); ok && slices.Contains(secretEnv, secret) {
+		return true
+	}
+
+	for _, known := range knownEnv {
+		if name == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suggestEnv finds the known variable closest to name, as long as it is
+// close enough to be a plausible typo.
+func suggestEnv(name string) string {
+	best := ""
+	bestDistance := len(name)/3 + 1
+
+	for _, known := range knownEnv {
+		if d := levenshtein(name, known); d < bestDistance {
+			best = known
+			bestDistance = d
+		}
+	}
+
+	return best
+}
+
+func levenshtein(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestToSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		fails bool
+	}{
+		{value: "0", want: 0},
+		{value: "65536", want: 65536},
+		{value: "512B", want: 512},
+		{value: "64KiB", want: 64 << 10},
+		{value: "10 MiB", want: 10 << 20},
+		{value: "2GiB", want: 2 << 30},
+		{value: "", fails: true},
+		{value: "MiB", fails: true},
+		{value: "-1", fails: true},
+		{value: "1.5MiB", fails: true},
+		{value: "10MB", fails: true},
+		{value: "9000000000GiB", fails: true},
+	}
+
+	for _, test := range tests {
+		v, err := toSize(test.value)
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("expected %q to be rejected, got %d", test.value, v)
+			}
+
+			continue
+		}
+
+		if err != nil || v != test.want {
+			t.Errorf("toSize(%q) = %d, %v, expected %d", test.value, v, err, test.want)
+		}
+	}
+}
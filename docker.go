@@ -0,0 +1,64 @@
+//go:build !nodocker && !slim
+
+package main
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cloudflare"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/docker"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+// startDockerWatcher returns the channel the Docker watcher receives the
+// prefixes on, or nil if it's disabled.
+func startDockerWatcher(cloudflareUpdater *cloudflare.Updater) chan *net.IPNet {
+	w := newDockerWatcher(cloudflareUpdater)
+
+	if w == nil {
+		return nil
+	}
+
+	w.StartWorker()
+
+	return w.Prefixes
+}
+
+func newDockerWatcher(cloudflareUpdater *cloudflare.Updater) *docker.Watcher {
+	if !envBool("DOCKER_WATCH", false) {
+		return nil
+	}
+
+	if cloudflareUpdater == nil {
+		slog.Warn("Docker containers can only be published to Cloudflare, disabling Docker watcher")
+		return nil
+	}
+
+	socket := "/var/run/docker.sock"
+
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if !strings.HasPrefix(host, "unix://") {
+			slog.Warn("Only unix sockets are supported in DOCKER_HOST, disabling Docker watcher")
+			return nil
+		}
+
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+
+	records := make(chan *docker.Record, 10)
+
+	w := docker.NewWatcher(socket, records, slog.Default())
+
+	if label := os.Getenv("DOCKER_WATCH_LABEL"); label != "" {
+		w.Label = label
+	}
+
+	go func() {
+		for record := range records {
+			cloudflareUpdater.Records <- &cloudflare.DynamicRecord{Name: record.Name, IpVersion: 6, IP: record.IP}
+		}
+	}()
+
+	return w
+}
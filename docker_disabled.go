@@ -0,0 +1,17 @@
+//go:build nodocker || slim
+
+package main
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cloudflare"
+	"log/slog"
+	"net"
+)
+
+func startDockerWatcher(_ *cloudflare.Updater) chan *net.IPNet {
+	if envBool("DOCKER_WATCH", false) {
+		slog.Warn("Docker watcher is not included in this build, disabling Docker watcher")
+	}
+
+	return nil
+}
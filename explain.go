@@ -0,0 +1,387 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/avm"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cloudflare"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/firewall"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/state"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// runExplain prints how a record is managed: the sources feeding it, the
+// filters in between, where it's published to, its current content and the
+// outcome of the last update.
+func runExplain(args []string) bool {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, "Usage: fritzbox-cloudflare-dyndns explain <record>\n")
+		return false
+	}
+
+	record := strings.TrimSuffix(strings.ToLower(args[0]), ".")
+
+	// Keep the output readable, only failures are of interest
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	if profile := os.Getenv("PROFILE"); profile != "" {
+		err := applyProfile(profile)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+	}
+
+	fmt.Printf("Record: %s\n", record)
+
+	fmt.Println("\nSources:")
+	explainSources()
+
+	fmt.Println("\nFilters:")
+	explainFilters()
+
+	fmt.Println("\nProviders:")
+
+	found := explainCloudflare(record)
+
+	if explainProviders(record) {
+		found = true
+	}
+
+	if !found {
+		fmt.Println("  none, the record is not configured")
+	}
+
+	fmt.Println("\nLast updates:")
+	explainState(record)
+
+	return true
+}
+
+func explainSources() {
+	var sources []string
+
+	url := strings.ReplaceAll(os.Getenv("FRITZBOX_ENDPOINT_URL"), ",", ", ")
+
+	switch os.Getenv("FRITZBOX_ENDPOINT_SOURCE") {
+	case "webui":
+		url = newWebUi().Url + " (web interface)"
+	case "tr064":
+		url = newTr064().Url + " (TR-064, " + cmp.Or(os.Getenv("FRITZBOX_TR064_PROFILE"), "fritzbox") + " profile)"
+	case "openwrt":
+		url = newUbus().Url + " (OpenWrt)"
+	case "mikrotik":
+		url = newMikrotik().Url + " (RouterOS)"
+	case "json":
+		url = newHttpJson().Url + " (JSON)"
+	case "snmp":
+		url = newSnmp().Client.Address + " (SNMP)"
+	case "opnsense":
+		url = newFirewall(firewall.NewOpnsense()).Url + " (OPNsense)"
+	case "pfsense":
+		url = newFirewall(firewall.NewPfsense()).Url + " (pfSense)"
+	case "https":
+		url = strings.Join(newPublicIp().Urls, ", ")
+	case "stun":
+		url = strings.Join(newStun().Servers, ", ") + " (STUN)"
+	case "dns":
+		var names []string
+
+		for _, service := range newWhoami().Services {
+			names = append(names, service.Name)
+		}
+
+		url = strings.Join(names, ", ") + " (DNS)"
+	case "upnp":
+		url = "UPnP gateway discovered with SSDP"
+
+		if location := os.Getenv("UPNP_LOCATION"); location != "" {
+			url = location + " (UPnP)"
+		}
+	}
+
+	interval := os.Getenv("FRITZBOX_ENDPOINT_INTERVAL")
+	cron := os.Getenv("FRITZBOX_ENDPOINT_SCHEDULE")
+
+	if url != "" && (interval != "" || cron != "") {
+		when := "every " + envDuration("FRITZBOX_ENDPOINT_INTERVAL", 0).String()
+
+		if cron != "" {
+			when = "at " + cron
+		}
+
+		if jitter := envDuration("FRITZBOX_ENDPOINT_JITTER", 0); jitter > 0 {
+			when += fmt.Sprintf(", delayed by up to %s", jitter)
+		}
+
+		sources = append(sources, fmt.Sprintf("%-12s %s %s", "poll", url, when))
+
+		if fallback := os.Getenv("FRITZBOX_ENDPOINT_FALLBACK"); fallback != "" {
+			failures := max(envInt("FRITZBOX_ENDPOINT_FAILURES", 3), 1)
+			sources = append(sources, fmt.Sprintf("%-12s %s after %d failures in a row", "fallback", strings.ReplaceAll(fallback, ",", ", "), failures))
+		}
+	}
+
+	switch os.Getenv("FRITZBOX_ENDPOINT_SOURCE") {
+	// Only the authenticated API tells whether the connection is DS-Lite
+	case "tr064":
+		status, err := newTr064().GetConnectionStatus()
+
+		if err != nil {
+			sources = append(sources, fmt.Sprintf("%-12s unknown: %s", "connection", err))
+		} else {
+			sources = append(sources, fmt.Sprintf("%-12s %s for %s, DS-Lite: %t", "connection", status.Status, status.Uptime, status.DsLite))
+		}
+	case "", "soap":
+		if os.Getenv("FRITZBOX_ENDPOINT_URL") == "" {
+			break
+		}
+
+		if status, ok := newFritzBox().(avm.StatusSource); ok {
+			s, err := status.GetConnectionStatus()
+
+			if err != nil {
+				sources = append(sources, fmt.Sprintf("%-12s unknown: %s", "connection", err))
+			} else {
+				sources = append(sources, fmt.Sprintf("%-12s %s for %s", "connection", s.Status, s.Uptime))
+			}
+		}
+	}
+
+	if bind := os.Getenv("DYNDNS_SERVER_BIND"); bind != "" {
+		endpoint := os.Getenv("DYNDNS_SERVER_PATH")
+
+		if endpoint == "" {
+			endpoint = "/ip"
+		}
+
+		sources = append(sources, fmt.Sprintf("%-12s %s on %s", "push", endpoint, bind))
+	}
+
+	if name := os.Getenv("INTERFACE_NAME"); name != "" {
+		sources = append(sources, fmt.Sprintf("%-12s global addresses of %s", "interface", name))
+	}
+
+	if path := os.Getenv("IP_FILE"); path != "" {
+		sources = append(sources, fmt.Sprintf("%-12s IPs written to %s", "file", path))
+	}
+
+	for _, name := range []string{"FAILOVER_BACKUP_IPV4", "FAILOVER_BACKUP_IPV6"} {
+		if backup := os.Getenv(name); backup != "" {
+			sources = append(sources, fmt.Sprintf("%-12s backup %s while the primary WAN is down", "failover", backup))
+		}
+	}
+
+	explainList(sources)
+}
+
+func explainFilters() {
+	var filters []string
+
+	if !envBool("PUBLISH_IPV4", true) {
+		filters = append(filters, "IPv4 is not published (PUBLISH_IPV4)")
+	} else if !envBool("PUBLISH_SHARED_IPV4", false) {
+		filters = append(filters, "IPv4 of a carrier-grade NAT or DS-Lite is not published (PUBLISH_SHARED_IPV4)")
+	}
+
+	if !envBool("PUBLISH_IPV6", true) {
+		filters = append(filters, "IPv6 is not published (PUBLISH_IPV6)")
+	}
+
+	if localIp := os.Getenv("DEVICE_LOCAL_ADDRESS_IPV6"); localIp != "" {
+		filters = append(filters, fmt.Sprintf("IPv6 is constructed from the prefix and %s (DEVICE_LOCAL_ADDRESS_IPV6)", localIp))
+	}
+
+	if envBool("DRY_RUN", false) {
+		filters = append(filters, "records are not changed (DRY_RUN)")
+	}
+
+	explainList(filters)
+}
+
+func explainList(lines []string) {
+	if len(lines) == 0 {
+		fmt.Println("  none")
+		return
+	}
+
+	for _, line := range lines {
+		fmt.Printf("  %s\n", line)
+	}
+}
+
+// explainCloudflare prints the Cloudflare records, it returns whether the
+// record is managed there.
+func explainCloudflare(record string) bool {
+	if os.Getenv("CLOUDFLARE_ZONES_IPV4") == "" && os.Getenv("CLOUDFLARE_ZONES_IPV6") == "" && os.Getenv("CLOUDFLARE_VIEWS") == "" && os.Getenv("CLOUDFLARE_PREFIX_RECORDS") == "" {
+		return false
+	}
+
+	// Dry run, so looking things up never changes any record
+	u := newCloudflareUpdater(true)
+
+	if u == nil {
+		fmt.Printf("  %-12s failed to initialize, see the errors above\n", "cloudflare")
+		return true
+	}
+
+	infos := u.Explain(record)
+
+	for _, info := range infos {
+		fmt.Printf("  %-12s %s in zone %s%s\n", "cloudflare", info.Type, info.Action.CfZoneId, explainOptions(info))
+
+		if info.Err != nil {
+			fmt.Printf("  %-12s current: failed to look up: %s\n", "", info.Err)
+			continue
+		}
+
+		if len(info.Records) == 0 {
+			fmt.Printf("  %-12s current: none\n", "")
+		}
+
+		for _, r := range info.Records {
+			proxied := "dns-only"
+
+			if r.Proxied != nil && *r.Proxied {
+				proxied = "proxied"
+			}
+
+			fmt.Printf("  %-12s current: %s (id %s, ttl %d, %s)\n", "", r.Content, r.ID, r.TTL, proxied)
+		}
+	}
+
+	return len(infos) > 0
+}
+
+func explainOptions(info cloudflare.RecordInfo) string {
+	var options []string
+
+	if info.View != "" {
+		options = append(options, "view "+info.View)
+	}
+
+	if info.Action.Staging {
+		options = append(options, "staging")
+	}
+
+	if info.Action.MirrorOf != "" {
+		options = append(options, "mirror of "+info.Action.MirrorOf)
+	}
+
+	if info.Action.Proxied != nil && *info.Action.Proxied {
+		options = append(options, "proxied")
+	} else if info.Action.Proxied != nil {
+		options = append(options, "dns-only")
+	}
+
+	if info.Action.TTL != 0 {
+		options = append(options, fmt.Sprintf("ttl=%d", info.Action.TTL))
+	}
+
+	if info.Action.Exclusive {
+		options = append(options, "exclusive")
+	}
+
+	if info.Action.Mode != cloudflare.ModeUpsert {
+		options = append(options, string(info.Action.Mode))
+	}
+
+	if len(options) == 0 {
+		return ""
+	}
+
+	return " (" + strings.Join(options, ", ") + ")"
+}
+
+// explainProviders prints the other providers updating the record, it returns
+// whether there are any.
+func explainProviders(record string) bool {
+	providers := []struct {
+		name   string
+		prefix string
+	}{
+		{"namecheap", "NAMECHEAP"},
+		{"infomaniak", "INFOMANIAK"},
+		{"scaleway", "SCALEWAY"},
+		{"dnsimple", "DNSIMPLE"},
+		{"ns1", "NS1"},
+	}
+
+	found := false
+
+	for _, p := range providers {
+		for _, family := range []string{"IPV4", "IPV6"} {
+			zones := strings.Split(strings.ToLower(os.Getenv(p.prefix+"_ZONES_"+family)), ",")
+
+			if slices.Contains(zones, record) {
+				fmt.Printf("  %-12s %s (%s_ZONES_%s)\n", p.name, map[string]string{"IPV4": "A", "IPV6": "AAAA"}[family], p.prefix, family)
+				found = true
+			}
+		}
+	}
+
+	return found
+}
+
+func explainState(record string) {
+	path := os.Getenv("STATE_FILE")
+
+	if path == "" {
+		fmt.Println("  unknown, set STATE_FILE to keep track of updates")
+		return
+	}
+
+	// Show everything that was persisted, whatever the limits are
+	s, err := state.Load(path, state.NewHistory(math.MaxInt, 0))
+
+	if err != nil {
+		fmt.Printf("  unknown, %s\n", err)
+		return
+	}
+
+	records := s.Find(record)
+
+	if len(records) == 0 {
+		fmt.Println("  none")
+	}
+
+	for _, r := range records {
+		outcome := "ok"
+
+		if r.Error != "" {
+			outcome = "failed: " + r.Error
+		}
+
+		fmt.Printf("  %-12s IPv%d %s at %s, %s\n", r.Provider, r.IpVersion, r.Content, r.Attempt.Format(time.RFC3339), outcome)
+
+		if r.Error != "" && !r.Success.IsZero() {
+			fmt.Printf("  %-12s last success at %s\n", "", r.Success.Format(time.RFC3339))
+		}
+	}
+
+	history := s.FindHistory(record)
+
+	if len(history) == 0 {
+		return
+	}
+
+	stats := s.HistoryStats()
+
+	fmt.Printf("\nHistory (%d of %d entries, %d bytes, %d evicted):\n", len(history), stats.Entries, stats.Bytes, stats.Evicted)
+
+	for _, r := range history {
+		outcome := "ok"
+
+		if r.Error != "" {
+			outcome = "failed: " + r.Error
+		}
+
+		fmt.Printf("  %s %-12s IPv%d %s, %s\n", r.Attempt.Format(time.RFC3339), r.Provider, r.IpVersion, r.Content, outcome)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+)
+
+// fanOut relays every IP or prefix from the sources to all updaters, without
+// letting a lagging updater hold back the others. While an updater is busy
+// only the latest value of each IP version waits for it, so a burst of IPv4
+// updates can't push out a pending IPv6 one.
+func fanOut[T any](in <-chan T, outs []chan T, version func(T) int) {
+	relays := make([]*relay[T], 0, len(outs))
+
+	for _, out := range outs {
+		r := &relay[T]{
+			out:     out,
+			version: version,
+			pending: map[int]T{},
+			wake:    make(chan struct{}, 1),
+		}
+
+		relays = append(relays, r)
+
+		go r.run()
+	}
+
+	for v := range in {
+		for _, r := range relays {
+			r.put(v)
+		}
+	}
+
+	for _, r := range relays {
+		close(r.wake)
+	}
+}
+
+// relay holds the values waiting for a single updater, one per IP version.
+type relay[T any] struct {
+	out     chan T
+	version func(T) int
+
+	mu      sync.Mutex
+	pending map[int]T
+	// order are the versions of the pending values in the order they arrived
+	order []int
+	// sending is set while a value taken from pending waits for the updater
+	sending bool
+
+	wake chan struct{}
+}
+
+func (r *relay[T]) put(v T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Nothing is in the way of the value if the updater keeps up
+	if len(r.order) == 0 && !r.sending {
+		select {
+		case r.out <- v:
+			return
+		default:
+		}
+	}
+
+	version := r.version(v)
+
+	if _, ok := r.pending[version]; ok {
+		// The updater is backlogged, the older value is the one not worth publishing anymore
+		slog.Debug("Updater is lagging behind, replacing its pending update request", slog.Int("version", version))
+	} else {
+		r.order = append(r.order, version)
+	}
+
+	r.pending[version] = v
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes the value that waits the longest.
+func (r *relay[T]) take() (T, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.order) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	version := r.order[0]
+	r.order = slices.Delete(r.order, 0, 1)
+
+	v := r.pending[version]
+	delete(r.pending, version)
+	r.sending = true
+
+	return v, true
+}
+
+func (r *relay[T]) run() {
+	for range r.wake {
+		for {
+			v, ok := r.take()
+
+			if !ok {
+				break
+			}
+
+			r.out <- v
+
+			r.mu.Lock()
+			r.sending = false
+			r.mu.Unlock()
+		}
+	}
+}
+
+// ipVersion is 4 for IPv4 and 6 for IPv6 addresses.
+func ipVersion(ip *net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+
+	return 6
+}
+
+func prefixVersion(prefix *net.IPNet) int {
+	return ipVersion(&prefix.IP)
+}
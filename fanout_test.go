@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestFanOutKeepsEveryVersion checks that a burst of IPv4 updates for a
+// lagging updater keeps the pending IPv6 update.
+func TestFanOutKeepsEveryVersion(t *testing.T) {
+	in := make(chan *net.IP)
+	lagging := make(chan *net.IP)
+	fast := make(chan *net.IP, 10)
+
+	go fanOut(in, []chan *net.IP{lagging, fast}, ipVersion)
+
+	sent := []string{"2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"}
+
+	for _, s := range sent {
+		ip := net.ParseIP(s)
+		in <- &ip
+	}
+
+	for _, want := range sent {
+		if ip := receive(t, fast); ip.String() != want {
+			t.Errorf("fast updater received %s, expected %s", ip, want)
+		}
+	}
+
+	for _, want := range []string{"2001:db8::1", "192.0.2.3"} {
+		if ip := receive(t, lagging); ip.String() != want {
+			t.Errorf("lagging updater received %s, expected %s", ip, want)
+		}
+	}
+
+	close(in)
+
+	select {
+	case ip := <-lagging:
+		t.Errorf("lagging updater received the superseded %s", ip)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func receive(t *testing.T, out <-chan *net.IP) *net.IP {
+	t.Helper()
+
+	select {
+	case ip := <-out:
+		return ip
+	case <-time.After(5 * time.Second):
+		t.Fatal("no IP received")
+		return nil
+	}
+}
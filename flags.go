@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// flagAliases are short names for the flags of the most common variables.
+var flagAliases = map[string]string{
+	"bind":          "DYNDNS_SERVER_BIND",
+	"cf-token":      "CLOUDFLARE_API_TOKEN",
+	"cf-zones-ipv4": "CLOUDFLARE_ZONES_IPV4",
+	"cf-zones-ipv6": "CLOUDFLARE_ZONES_IPV6",
+	"fritzbox-url":  "FRITZBOX_ENDPOINT_URL",
+	"interval":      "FRITZBOX_ENDPOINT_INTERVAL",
+	"password":      "DYNDNS_SERVER_PASSWORD",
+	"username":      "DYNDNS_SERVER_USERNAME",
+}
+
+// envFlag sets its variable, so flags take precedence over the environment
+// and the .env files.
+type envFlag struct {
+	name   string
+	isBool bool
+}
+
+func (f *envFlag) String() string {
+	return ""
+}
+
+func (f *envFlag) Set(value string) error {
+	if parse, ok := envParsers[f.name]; ok {
+		if err := parse(value); err != nil {
+			return err
+		}
+	}
+
+	return os.Setenv(f.name, value)
+}
+
+func (f *envFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// flagName is the flag of the variable, i.e. --fritzbox-endpoint-url for
+// FRITZBOX_ENDPOINT_URL.
+func flagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
+
+func newEnvFlag(name string) *envFlag {
+	f := &envFlag{name: name}
+
+	// Variables taking true and false can be switched on by the bare flag
+	if parse, ok := envParsers[name]; ok {
+		f.isBool = parse("true") == nil && parse("false") == nil
+	}
+
+	return f
+}
+
+// parseFlags sets the variables given as flags and returns the arguments
+// after them, i.e. the command.
+func parseFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("fritzbox-cloudflare-dyndns", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Usage = func() {}
+
+	// Switches small devices to a constant memory usage
+	flags.Var(&constFlag{envFlag{name: "STATE_HISTORY_ENTRIES", isBool: true}, "0"}, "no-history", "")
+
+	for _, name := range knownEnv {
+		flags.Var(newEnvFlag(name), flagName(name), "")
+	}
+
+	for _, name := range secretEnv {
+		flags.Var(newEnvFlag(name+"_FILE"), flagName(name+"_FILE"), "")
+	}
+
+	for alias, name := range flagAliases {
+		flags.Var(newEnvFlag(name), alias, "")
+	}
+
+	err := flags.Parse(args)
+
+	return flags.Args(), err
+}
+
+// constFlag is a switch that sets its variable to a fixed value.
+type constFlag struct {
+	envFlag
+	value string
+}
+
+func (f *constFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+
+	if err != nil || !on {
+		return err
+	}
+
+	return os.Setenv(f.name, f.value)
+}
@@ -5,6 +5,7 @@ go 1.22
 require (
 	github.com/cloudflare/cloudflare-go v0.100.0
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.25.0
 	golang.org/x/net v0.27.0
 	gopkg.in/xmlpath.v2 v2.0.0-20150820204837-860cbeca3ebc
 )
@@ -0,0 +1,1406 @@
+//go:build integration
+
+// Command integration runs the daemon against a fake FritzBox and a fake
+// Cloudflare API and checks the records it publishes, so refactoring the
+// updaters can be done with confidence. Run it with:
+//
+//	go run -tags integration ./integration
+//
+// A prebuilt binary, i.e. one built with different build tags, can be checked
+// with -binary and single scenarios can be selected with -run. With -race the
+// daemon is built with the race detector and exits on the first data race
+// between its workers, failing the scenario.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/internal/cloudflaremock"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/avm"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/firewall"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/httpjson"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/mikrotik"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/openwrt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/snmp"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	zone           = "example.com"
+	ipv4Record     = "ipv4.example.com"
+	ipv6Record     = "ipv6.example.com"
+	prefixRecord   = "_prefix.example.com"
+	metadataRecord = "_dyndns.example.com"
+	mirrorZone     = "example.dev"
+	mirrorRecord   = "ipv4.example.dev"
+	siteRecord     = "branch.example.com"
+	pushUsername   = "integration"
+	pushPassword   = "integration"
+	pollInterval   = "1s"
+	timeout        = 15 * time.Second
+	checkInterval  = 100 * time.Millisecond
+)
+
+var (
+	wanIpv4         = net.ParseIP("203.0.113.10")
+	wanIpv6         = net.ParseIP("2001:db8::10")
+	localIp         = net.ParseIP("::1234:5678:90ab:cdef")
+	constructed     = net.ParseIP("2001:db8:1:2:1234:5678:90ab:cdef")
+	_, wanPrefix, _ = net.ParseCIDR("2001:db8:1:2::/64")
+)
+
+// expectation is a record the daemon has to publish.
+type expectation struct {
+	recordType string
+	name       string
+	content    string
+}
+
+// scenario configures the daemon with env and optionally flags, triggers an
+// update once it is running and expects the records to be published.
+type scenario struct {
+	name    string
+	env     func(e *environment) []string
+	args    func(e *environment) []string
+	trigger func(e *environment) error
+	expect  []expectation
+}
+
+// environment holds the fake backends of a single scenario.
+type environment struct {
+	fritzbox   string
+	cloudflare *cloudflaremock.Mock
+	pushBind   string
+	// publicIp answers like the Cloudflare trace
+	publicIp string
+	stun     string
+	dns      string
+	openwrt  string
+	mikrotik string
+	firewall string
+	json     string
+	snmp     string
+	// dir is the working directory of the daemon
+	dir string
+}
+
+var scenarios = []scenario{
+	{
+		name: "poll",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-schedule",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_SCHEDULE=@every " + pollInterval + "; 0 4 * * *",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-jitter",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FRITZBOX_ENDPOINT_JITTER=" + pollInterval,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "log-json",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"LOG_LEVEL=debug",
+				"LOG_FORMAT=json",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "log-syslog",
+		env: func(e *environment) []string {
+			syslog, _ := freeAddress()
+
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				// Nothing listens, the logs are dropped without holding up the updates
+				"LOG_SYSLOG=tcp://" + syslog,
+				"LOG_SYSLOG_FACILITY=local0",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "log-file",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"LOG_FILE=" + filepath.Join(e.dir, "dyndns.log"),
+				"LOG_FILE_MAX_BYTES=512",
+				"LOG_FILE_BACKUPS=1",
+			}
+		},
+		trigger: func(e *environment) error {
+			_, err := os.Stat(filepath.Join(e.dir, "dyndns.log.1"))
+
+			return err
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "health",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+			}
+		},
+		trigger: func(e *environment) error {
+			// Ready once the zones are resolved and the router was polled
+			for _, endpoint := range []string{"healthz", "readyz"} {
+				response, err := http.Get(fmt.Sprintf("http://%s/%s", e.pushBind, endpoint))
+
+				if err != nil {
+					return err
+				}
+
+				_ = response.Body.Close()
+
+				if response.StatusCode != http.StatusOK {
+					return fmt.Errorf("%s responded with %s", endpoint, response.Status)
+				}
+			}
+
+			return nil
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "metrics",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+			}
+		},
+		trigger: func(e *environment) error {
+			response, err := http.Get(fmt.Sprintf("http://%s/metrics", e.pushBind))
+
+			if err != nil {
+				return err
+			}
+
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+
+			if err != nil {
+				return err
+			}
+
+			// Both records are created once polled
+			metric := `fritzbox_cloudflare_dyndns_record_changes_total{provider="cloudflare",change="created"} 2`
+
+			if !strings.Contains(string(body), metric) {
+				return fmt.Errorf("metrics lack %s", metric)
+			}
+
+			return nil
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "status",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+			}
+		},
+		trigger: func(e *environment) error {
+			params := url.Values{"username": {pushUsername}, "password": {pushPassword}}
+			response, err := http.Get(fmt.Sprintf("http://%s/status?%s", e.pushBind, params.Encode()))
+
+			if err != nil {
+				return err
+			}
+
+			defer response.Body.Close()
+
+			var status struct {
+				Ipv4    struct{ IP string }
+				Records []struct{ Name string }
+			}
+
+			err = json.NewDecoder(response.Body).Decode(&status)
+
+			if err != nil {
+				return err
+			}
+
+			// Both records are known once they were published
+			if status.Ipv4.IP != wanIpv4.String() || len(status.Records) != 2 {
+				return fmt.Errorf("unexpected status %+v", status)
+			}
+
+			return nil
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "ops",
+		env: func(e *environment) []string {
+			// Without the push server
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"OPS_BIND=" + e.pushBind,
+			}
+		},
+		trigger: func(e *environment) error {
+			for _, endpoint := range []string{"readyz", "status", "metrics"} {
+				response, err := http.Get(fmt.Sprintf("http://%s/%s", e.pushBind, endpoint))
+
+				if err != nil {
+					return err
+				}
+
+				_ = response.Body.Close()
+
+				if response.StatusCode != http.StatusOK {
+					return fmt.Errorf("%s responded with %s", endpoint, response.Status)
+				}
+			}
+
+			return nil
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-prefix",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=" + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+				"CLOUDFLARE_PREFIX_RECORDS=" + prefixRecord,
+			}
+		},
+		expect: []expectation{
+			{"AAAA", ipv6Record, constructed.String()},
+			{"TXT", prefixRecord, wanPrefix.String()},
+		},
+	},
+	{
+		name: "poll-chain",
+		env: func(e *environment) []string {
+			return []string{
+				// Nothing listens on the first router
+				"FRITZBOX_ENDPOINT_URL=http://127.0.0.1:1," + e.fritzbox,
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-webui",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=webui",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FRITZBOX_WEBUI_URL=" + e.fritzbox,
+				"FRITZBOX_WEBUI_PASSWORD=integration",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-tr064",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=tr064",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FRITZBOX_TR064_URL=" + e.fritzbox,
+				"FRITZBOX_TR064_USERNAME=integration",
+				"FRITZBOX_TR064_PASSWORD=integration",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-tr064-generic",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=tr064",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FRITZBOX_TR064_URL=" + e.fritzbox,
+				"FRITZBOX_TR064_PROFILE=generic",
+				"FRITZBOX_TR064_USERNAME=integration",
+				"FRITZBOX_TR064_PASSWORD=integration",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "poll-upnp",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=upnp",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"UPNP_LOCATION=" + e.fritzbox + "/igddesc.xml",
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "poll-https",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=https",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"PUBLIC_IP_SERVICES=" + e.publicIp,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "poll-fallback",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_URL=http://127.0.0.1:1",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FRITZBOX_ENDPOINT_FALLBACK=https",
+				"FRITZBOX_ENDPOINT_FAILURES=2",
+				"PUBLIC_IP_SERVICES=" + e.publicIp,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "poll-stun",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=stun",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"STUN_SERVERS=" + e.stun,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "poll-dns",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=dns",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"WHOAMI_SERVICES=opendns@" + e.dns,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "poll-openwrt",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=openwrt",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"OPENWRT_URL=" + e.openwrt + "/ubus",
+				"OPENWRT_PASSWORD=integration",
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, constructed.String()},
+		},
+	},
+	{
+		name: "poll-mikrotik",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=mikrotik",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"MIKROTIK_URL=" + e.mikrotik,
+				"MIKROTIK_PASSWORD=integration",
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, constructed.String()},
+		},
+	},
+	{
+		name: "poll-json",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=json",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"JSON_URL=" + e.json,
+				"JSON_IPV4_PATH=" + httpjson.MockIpv4Path,
+				"JSON_IPV6_PATH=" + httpjson.MockIpv6Path,
+				"JSON_PREFIX_PATH=" + httpjson.MockPrefixPath,
+				"CLOUDFLARE_PREFIX_RECORDS=" + prefixRecord,
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+			{"TXT", prefixRecord, wanPrefix.String()},
+		},
+	},
+	{
+		name: "poll-snmp",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=snmp",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"SNMP_ADDRESS=" + e.snmp,
+				"SNMP_INTERFACE=" + strconv.Itoa(snmp.MockInterface),
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "poll-snmp-v3",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=snmp",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"SNMP_ADDRESS=" + e.snmp,
+				"SNMP_VERSION=3",
+				"SNMP_USERNAME=" + snmp.MockUsername,
+				"SNMP_AUTH_PASSWORD=" + snmp.MockAuthPassword,
+				"SNMP_PRIV_PASSWORD=" + snmp.MockPrivPassword,
+				"SNMP_IPV4_OID=" + snmp.MockIpv4Oid,
+				"SNMP_PREFIX_OID=" + snmp.MockPrefixOid,
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, constructed.String()},
+		},
+	},
+	{
+		name: "poll-opnsense",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=opnsense",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FIREWALL_URL=" + e.firewall,
+				"FIREWALL_KEY=integration",
+				"FIREWALL_SECRET=integration",
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, constructed.String()},
+		},
+	},
+	{
+		name: "poll-pfsense",
+		env: func(e *environment) []string {
+			return []string{
+				"FRITZBOX_ENDPOINT_SOURCE=pfsense",
+				"FRITZBOX_ENDPOINT_INTERVAL=" + pollInterval,
+				"FIREWALL_URL=" + e.firewall,
+				"FIREWALL_KEY=integration",
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+			}
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, constructed.String()},
+		},
+	},
+	{
+		name: "push",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4": {wanIpv4.String()},
+				"v6": {wanIpv6.String()},
+			})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "flags",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_PASSWORD=overridden",
+			}
+		},
+		args: func(e *environment) []string {
+			return []string{
+				"--bind=" + e.pushBind,
+				"--username", pushUsername,
+				"--dyndns-server-password=" + pushPassword,
+				"--no-history",
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4": {wanIpv4.String()},
+			})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "secret-file",
+		env: func(e *environment) []string {
+			_ = os.WriteFile(filepath.Join(e.dir, "password"), []byte(pushPassword+"\n"), 0o600)
+
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD_FILE=" + filepath.Join(e.dir, "password"),
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4": {wanIpv4.String()},
+			})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "secrets-dir",
+		env: func(e *environment) []string {
+			_ = os.WriteFile(filepath.Join(e.dir, "dyndns_server_password"), []byte(pushPassword+"\n"), 0o600)
+
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"SECRETS_DIR=" + e.dir,
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4": {wanIpv4.String()},
+			})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "push-combined",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4":     {wanIpv4.String() + "," + wanIpv6.String()},
+				"prefix": {""},
+			})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "push-prefix",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+				"DEVICE_LOCAL_ADDRESS_IPV6=" + localIp.String(),
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4":           {wanIpv4.String()},
+				"v6":           {wanIpv6.String()},
+				"ip6lanprefix": {wanPrefix.String()},
+			})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, constructed.String()},
+		},
+	},
+	{
+		name: "push-site",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_SITES=" + pushUsername + ":" + pushPassword + "@" + siteRecord,
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{
+				"v4": {wanIpv4.String()},
+				"v6": {wanIpv6.String()},
+			})
+		},
+		expect: []expectation{
+			{"A", siteRecord, wanIpv4.String()},
+			{"AAAA", siteRecord, wanIpv6.String()},
+		},
+	},
+	{
+		name: "push-token",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_TOKEN=" + pushPassword,
+			}
+		},
+		trigger: func(e *environment) error {
+			request, err := http.NewRequest("GET", fmt.Sprintf("http://%s/ip?v4=%s", e.pushBind, wanIpv4), nil)
+
+			if err != nil {
+				return err
+			}
+
+			request.Header.Set("Authorization", "Bearer "+pushPassword)
+
+			response, err := http.DefaultClient.Do(request)
+
+			if err != nil {
+				return err
+			}
+
+			return response.Body.Close()
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "push-hmac",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_HMAC_SECRET=" + pushPassword,
+			}
+		},
+		trigger: func(e *environment) error {
+			query := fmt.Sprintf("v4=%s&timestamp=%d", wanIpv4, time.Now().Unix())
+
+			mac := hmac.New(sha256.New, []byte(pushPassword))
+			mac.Write([]byte(query))
+
+			response, err := http.Get(fmt.Sprintf("http://%s/ip?%s&signature=%s", e.pushBind, query, hex.EncodeToString(mac.Sum(nil))))
+
+			if err != nil {
+				return err
+			}
+
+			return response.Body.Close()
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "push-params",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+				"DYNDNS_SERVER_PATH=/update",
+				"DYNDNS_SERVER_PARAMS=v4=myip,username=user,password=pass",
+				"DYNDNS_SERVER_ACCESS_LOG=true",
+			}
+		},
+		trigger: func(e *environment) error {
+			params := url.Values{"myip": {wanIpv4.String()}, "user": {pushUsername}, "pass": {pushPassword}}
+			response, err := http.Get(fmt.Sprintf("http://%s/update?%s", e.pushBind, params.Encode()))
+
+			if err != nil {
+				return err
+			}
+
+			return response.Body.Close()
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "push-client-ip",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_TRUSTED_PROXIES=127.0.0.1,::1",
+				"DYNDNS_SERVER_CLIENT_IP_FALLBACK=true",
+			}
+		},
+		trigger: func(e *environment) error {
+			request, err := http.NewRequest("GET", fmt.Sprintf("http://%s/ip", e.pushBind), nil)
+
+			if err != nil {
+				return err
+			}
+
+			// The harness poses as a reverse proxy
+			request.Header.Set("X-Real-IP", wanIpv4.String())
+
+			response, err := http.DefaultClient.Do(request)
+
+			if err != nil {
+				return err
+			}
+
+			return response.Body.Close()
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "push-confirm",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+				"DYNDNS_SERVER_CONFIRM=true",
+			}
+		},
+		trigger: func(e *environment) error {
+			body, err := pushBody(e, url.Values{"v4": {wanIpv4.String()}})
+
+			if err != nil {
+				return err
+			}
+
+			// The answer has to wait for the record to be published
+			if body != "good "+wanIpv4.String() {
+				return fmt.Errorf("push server responded with %q", body)
+			}
+
+			return nil
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "push-dyndns2",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+			}
+		},
+		trigger: func(e *environment) error {
+			params := url.Values{
+				"hostname": {ipv4Record},
+				"myip":     {wanIpv4.String() + "," + wanIpv6.String()},
+			}
+
+			request, err := http.NewRequest("GET", fmt.Sprintf("http://%s/nic/update?%s", e.pushBind, params.Encode()), nil)
+
+			if err != nil {
+				return err
+			}
+
+			request.SetBasicAuth(pushUsername, pushPassword)
+
+			response, err := http.DefaultClient.Do(request)
+
+			if err != nil {
+				return err
+			}
+
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+
+			if err != nil {
+				return err
+			}
+
+			if !strings.HasPrefix(string(body), "good ") && !strings.HasPrefix(string(body), "nochg ") {
+				return fmt.Errorf("push server responded with %q", body)
+			}
+
+			return nil
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "file",
+		env: func(e *environment) []string {
+			return []string{
+				"IP_FILE=" + filepath.Join(e.dir, "wan-ip"),
+				// Only inotify notices the change in time
+				"IP_FILE_INTERVAL=1h",
+			}
+		},
+		trigger: func(e *environment) error {
+			content := wanIpv4.String() + "\n" + wanIpv6.String() + "\n"
+
+			return os.WriteFile(filepath.Join(e.dir, "wan-ip"), []byte(content), 0o644)
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"AAAA", ipv6Record, wanIpv6.String()},
+		},
+	},
+	{
+		name: "reload",
+		env: func(e *environment) []string {
+			// IPv4 is only published once the changed file was reloaded
+			_ = os.WriteFile(filepath.Join(e.dir, "dyndns.env"), []byte("PUBLISH_IPV4=false\n"), 0o644)
+
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+				"CONFIG_FILE=" + filepath.Join(e.dir, "dyndns.env"),
+				"CONFIG_WATCH=true",
+			}
+		},
+		trigger: func(e *environment) error {
+			err := push(e, url.Values{
+				"v4": {wanIpv4.String()},
+			})
+
+			if err != nil {
+				return err
+			}
+
+			return os.WriteFile(filepath.Join(e.dir, "dyndns.env"), []byte("PUBLISH_IPV4=true\n"), 0o644)
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+		},
+	},
+	{
+		name: "mirror",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+				"CLOUDFLARE_MIRROR=" + zone + "=" + mirrorZone,
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{"v4": {wanIpv4.String()}})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"A", mirrorRecord, wanIpv4.String()},
+		},
+	},
+	{
+		name: "metadata",
+		env: func(e *environment) []string {
+			return []string{
+				"DYNDNS_SERVER_BIND=" + e.pushBind,
+				"DYNDNS_SERVER_USERNAME=" + pushUsername,
+				"DYNDNS_SERVER_PASSWORD=" + pushPassword,
+				"CLOUDFLARE_METADATA_RECORDS=" + metadataRecord,
+			}
+		},
+		trigger: func(e *environment) error {
+			return push(e, url.Values{"v4": {wanIpv4.String()}})
+		},
+		expect: []expectation{
+			{"A", ipv4Record, wanIpv4.String()},
+			{"TXT", metadataRecord, "source=push"},
+		},
+	},
+}
+
+func main() {
+	binary := flag.String("binary", "", "daemon binary to test, built from the working directory if empty")
+	run := flag.String("run", "", "comma-separated list of scenarios to run, all if empty")
+	verbose := flag.Bool("v", false, "show the output of the daemon")
+	race := flag.Bool("race", false, "build the daemon with the race detector")
+	flag.Parse()
+
+	if *binary == "" {
+		path, err := build(*race)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to build the daemon: %s\n", err)
+			os.Exit(1)
+		}
+
+		defer os.RemoveAll(filepath.Dir(path))
+
+		*binary = path
+	}
+
+	passed := true
+
+	fmt.Printf("%-14s %s\n", "SCENARIO", "RESULT")
+
+	for _, s := range scenarios {
+		if *run != "" && !slices.Contains(strings.Split(*run, ","), s.name) {
+			continue
+		}
+
+		err := s.run(*binary, *verbose)
+		result := "pass"
+
+		if err != nil {
+			result = fmt.Sprintf("FAIL: %s", err)
+			passed = false
+		}
+
+		fmt.Printf("%-14s %s\n", s.name, result)
+	}
+
+	if !passed {
+		os.Exit(1)
+	}
+}
+
+// build compiles the daemon into a temporary directory.
+func build(race bool) (string, error) {
+	dir, err := os.MkdirTemp("", "integration")
+
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "fritzbox-cloudflare-dyndns")
+
+	args := []string{"build", "-o", path}
+
+	if race {
+		args = append(args, "-race")
+	}
+
+	cmd := exec.Command("go", append(args, "github.com/cromefire/fritzbox-cloudflare-dyndns")...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	return path, cmd.Run()
+}
+
+func (s *scenario) run(binary string, verbose bool) error {
+	fritzbox := avm.NewMock(wanIpv4, wanIpv6, wanPrefix)
+	defer fritzbox.Close()
+
+	cf := cloudflaremock.New(zone, mirrorZone)
+	defer cf.Close()
+
+	ubus := openwrt.NewMock(wanIpv4, wanIpv6, wanPrefix)
+	defer ubus.Close()
+
+	routerOs := mikrotik.NewMock(wanIpv4, wanIpv6, wanPrefix)
+	defer routerOs.Close()
+
+	fw := firewall.NewMock(wanIpv4, wanIpv6, wanPrefix)
+	defer fw.Close()
+
+	status := httpjson.NewMock(wanIpv4, wanIpv6, wanPrefix)
+	defer status.Close()
+
+	agent, err := snmp.NewMock(wanIpv4, wanIpv6, wanPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	defer agent.Close()
+
+	publicIp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, "fl=1\nh=one.one.one.one\nip=%s\nts=0\n", wanIpv4)
+	}))
+	defer publicIp.Close()
+
+	stun, err := stunServer(wanIpv4)
+
+	if err != nil {
+		return err
+	}
+
+	defer stun.Close()
+
+	dns, err := dnsServer(wanIpv4)
+
+	if err != nil {
+		return err
+	}
+
+	defer dns.Close()
+
+	bind, err := freeAddress()
+
+	if err != nil {
+		return err
+	}
+
+	e := &environment{
+		fritzbox:   fritzbox.URL,
+		cloudflare: cf,
+		pushBind:   bind,
+		publicIp:   publicIp.URL,
+		stun:       stun.LocalAddr().String(),
+		dns:        dns.LocalAddr().String(),
+		openwrt:    ubus.URL,
+		mikrotik:   routerOs.URL,
+		firewall:   fw.URL,
+		json:       status.URL,
+		snmp:       agent.LocalAddr().String(),
+	}
+
+	// Run in an empty directory with a clean env, so no .env file or variable
+	// of the host leaks into the scenario
+	dir, err := os.MkdirTemp("", "integration")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	e.dir = dir
+
+	var args []string
+
+	if s.args != nil {
+		args = s.args(e)
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{
+		"PATH=" + os.Getenv("PATH"),
+		"GORACE=halt_on_error=1",
+		"STRICT_CONFIG=true",
+		"CLOUDFLARE_API_TOKEN=integration",
+		"CLOUDFLARE_API_URL=" + cf.URL,
+		"CLOUDFLARE_ZONES_IPV4=" + ipv4Record,
+		"CLOUDFLARE_ZONES_IPV6=" + ipv6Record,
+	}, s.env(e)...)
+
+	if verbose {
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+	}
+
+	err = cmd.Start()
+
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	defer func() {
+		_ = cmd.Process.Kill()
+		<-exited
+	}()
+
+	if s.trigger != nil {
+		err := retry(exited, func() error { return s.trigger(e) })
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return retry(exited, func() error { return s.check(cf) })
+}
+
+// check returns an error for the first expected record that is missing.
+func (s *scenario) check(cf *cloudflaremock.Mock) error {
+	for _, exp := range s.expect {
+		records := cf.Records(exp.recordType, exp.name)
+
+		if len(records) == 0 {
+			return fmt.Errorf("expected %s record %s, got nothing", exp.recordType, exp.name)
+		}
+
+		for _, record := range records {
+			if !strings.Contains(record.Content, exp.content) {
+				return fmt.Errorf("expected %s record %s to contain %q, got %q", exp.recordType, exp.name, exp.content, record.Content)
+			}
+		}
+	}
+
+	return nil
+}
+
+// retry calls f until it succeeds, the daemon exits or the timeout is reached.
+func retry(exited <-chan struct{}, f func() error) error {
+	deadline := time.After(timeout)
+
+	for {
+		err := f()
+
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-exited:
+			return fmt.Errorf("daemon exited early: %w", err)
+		case <-deadline:
+			return err
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// push sends an authenticated push request to the daemon.
+func push(e *environment, params url.Values) error {
+	_, err := pushBody(e, params)
+
+	return err
+}
+
+// pushBody pushes the params and returns the answer of the push server.
+func pushBody(e *environment, params url.Values) (string, error) {
+	params.Set("username", pushUsername)
+	params.Set("password", pushPassword)
+
+	response, err := http.Get(fmt.Sprintf("http://%s/ip?%s", e.pushBind, params.Encode()))
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("push server responded with %s", response.Status)
+	}
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return "", err
+	}
+
+	if string(body) == "911" {
+		return "", errors.New("push server responded with 911")
+	}
+
+	return string(body), nil
+}
+
+// freeAddress finds a local address the push server can listen on.
+func freeAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer listener.Close()
+
+	return listener.Addr().String(), nil
+}
+
+// stunServer answers binding requests over IPv4 with ip as the mapped address.
+func stunServer(ip net.IP) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		buf := make([]byte, 1024)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+
+			if err != nil {
+				return
+			}
+
+			if n < 20 {
+				continue
+			}
+
+			// Binding success response with a single XOR-MAPPED-ADDRESS
+			response := make([]byte, 32)
+			binary.BigEndian.PutUint16(response[0:2], 0x0101)
+			binary.BigEndian.PutUint16(response[2:4], 12)
+			copy(response[4:20], buf[4:20])
+			binary.BigEndian.PutUint16(response[20:22], 0x0020)
+			binary.BigEndian.PutUint16(response[22:24], 8)
+			response[25] = 0x01
+
+			for i, b := range ip.To4() {
+				response[28+i] = b ^ buf[4+i]
+			}
+
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn, nil
+}
+
+// dnsServer answers A queries over IPv4 with ip, like myip.opendns.com.
+func dnsServer(ip net.IP) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		buf := make([]byte, 1232)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+				Answers: []dnsmessage.Resource{
+					{
+						Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+						Body:   &dnsmessage.AResource{A: [4]byte(ip.To4())},
+					},
+				},
+			}
+
+			packed, err := response.Pack()
+
+			if err != nil {
+				continue
+			}
+
+			_, _ = conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn, nil
+}
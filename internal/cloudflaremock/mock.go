@@ -0,0 +1,395 @@
+// Package cloudflaremock fakes the Cloudflare API for the integration harness
+// and the tests of the Cloudflare updater.
+package cloudflaremock
+
+import (
+	"encoding/json"
+	"fmt"
+	cf "github.com/cloudflare/cloudflare-go"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+)
+
+// Mock is a fake Cloudflare API keeping the DNS records of its zones in memory,
+// it answers the endpoints used by Updater and has to be closed after use.
+type Mock struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	zones   []cf.Zone
+	records map[string][]cf.DNSRecord
+	lastId  int
+}
+
+// batchRecord and batchRequest are the body of the batch endpoint, see
+// cloudflare.applyBatch.
+type batchRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type batchRequest struct {
+	Deletes []batchRecord `json:"deletes,omitempty"`
+	Posts   []batchRecord `json:"posts,omitempty"`
+	Patches []batchRecord `json:"patches,omitempty"`
+}
+
+// New starts a fake Cloudflare API serving the given zones, use its URL with
+// cloudflare.Updater.SetApiUrl.
+func New(zones ...string) *Mock {
+	m := &Mock{
+		records: make(map[string][]cf.DNSRecord),
+	}
+
+	for _, name := range zones {
+		m.zones = append(m.zones, cf.Zone{ID: m.nextId(), Name: name, Status: "active"})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", m.handleUser)
+	mux.HandleFunc("GET /user/tokens/verify", m.handleVerify)
+	mux.HandleFunc("GET /zones", m.handleZones)
+	mux.HandleFunc("GET /zones/{zone}/dns_records", m.handleList)
+	mux.HandleFunc("POST /zones/{zone}/dns_records", m.handleCreate)
+	mux.HandleFunc("POST /zones/{zone}/dns_records/batch", m.handleBatch)
+	mux.HandleFunc("PATCH /zones/{zone}/dns_records/{id}", m.handleUpdate)
+	mux.HandleFunc("DELETE /zones/{zone}/dns_records/{id}", m.handleDelete)
+
+	m.Server = httptest.NewServer(mux)
+
+	return m
+}
+
+// Records returns the records with the given type and name.
+func (m *Mock) Records(recordType string, name string) []cf.DNSRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found []cf.DNSRecord
+
+	for _, records := range m.records {
+		for _, record := range records {
+			if record.Type == recordType && record.Name == name {
+				found = append(found, record)
+			}
+		}
+	}
+
+	return found
+}
+
+// ZoneId returns the current ID of the zone.
+func (m *Mock) ZoneId(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, z := range m.zones {
+		if z.Name == name {
+			return z.ID
+		}
+	}
+
+	return ""
+}
+
+// MoveZone gives the zone a new ID, like moving it to another account does,
+// requests with the old ID fail.
+func (m *Mock) MoveZone(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, z := range m.zones {
+		if z.Name != name {
+			continue
+		}
+
+		id := m.nextId()
+		m.records[id] = m.records[z.ID]
+		delete(m.records, z.ID)
+		m.zones[i].ID = id
+	}
+}
+
+func (m *Mock) nextId() string {
+	m.lastId++
+
+	return fmt.Sprintf("%032x", m.lastId)
+}
+
+func (m *Mock) handleUser(w http.ResponseWriter, _ *http.Request) {
+	writeMockResult(w, cf.User{ID: "mock"}, nil)
+}
+
+func (m *Mock) handleVerify(w http.ResponseWriter, _ *http.Request) {
+	writeMockResult(w, cf.APITokenVerifyBody{ID: "mock", Status: "active"}, nil)
+}
+
+func (m *Mock) handleZones(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var zones []cf.Zone
+
+	for _, z := range m.zones {
+		if name := r.URL.Query().Get("name"); name != "" && name != z.Name {
+			continue
+		}
+
+		if account := r.URL.Query().Get("account.id"); account != "" && account != z.Account.ID {
+			continue
+		}
+
+		zones = append(zones, z)
+	}
+
+	writeMockResult(w, zones, &cf.ResultInfo{Page: 1, PerPage: len(zones), TotalPages: 1, Count: len(zones), Total: len(zones)})
+}
+
+func (m *Mock) handleList(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	zoneId, ok := m.zone(w, r)
+
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	records := []cf.DNSRecord{}
+
+	for _, record := range m.records[zoneId] {
+		if t := query.Get("type"); t != "" && t != record.Type {
+			continue
+		}
+
+		if name := query.Get("name"); name != "" && name != record.Name {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	writeMockResult(w, records, &cf.ResultInfo{Page: 1, PerPage: len(records), TotalPages: 1, Count: len(records), Total: len(records)})
+}
+
+func (m *Mock) handleCreate(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	zoneId, ok := m.zone(w, r)
+
+	if !ok {
+		return
+	}
+
+	var record cf.DNSRecord
+
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		writeMockError(w, http.StatusBadRequest, 1004, err.Error())
+		return
+	}
+
+	writeMockResult(w, m.create(zoneId, record), nil)
+}
+
+func (m *Mock) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	zoneId, ok := m.zone(w, r)
+
+	if !ok {
+		return
+	}
+
+	var patch cf.DNSRecord
+
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeMockError(w, http.StatusBadRequest, 1004, err.Error())
+		return
+	}
+
+	patch.ID = r.PathValue("id")
+	record, ok := m.update(zoneId, patch)
+
+	if !ok {
+		writeMockError(w, http.StatusNotFound, 81044, "Record does not exist.")
+		return
+	}
+
+	writeMockResult(w, record, nil)
+}
+
+func (m *Mock) handleDelete(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	zoneId, ok := m.zone(w, r)
+
+	if !ok {
+		return
+	}
+
+	if !m.delete(zoneId, r.PathValue("id")) {
+		writeMockError(w, http.StatusNotFound, 81044, "Record does not exist.")
+		return
+	}
+
+	writeMockResult(w, cf.DNSRecord{ID: r.PathValue("id")}, nil)
+}
+
+func (m *Mock) handleBatch(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	zoneId, ok := m.zone(w, r)
+
+	if !ok {
+		return
+	}
+
+	var request batchRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeMockError(w, http.StatusBadRequest, 1004, err.Error())
+		return
+	}
+
+	// Batches are all or nothing
+	for _, record := range slices.Concat(request.Deletes, request.Patches) {
+		if !slices.ContainsFunc(m.records[zoneId], func(r cf.DNSRecord) bool { return r.ID == record.ID }) {
+			writeMockError(w, http.StatusNotFound, 81044, "Record does not exist.")
+			return
+		}
+	}
+
+	for _, record := range request.Deletes {
+		m.delete(zoneId, record.ID)
+	}
+
+	for _, record := range request.Patches {
+		m.update(zoneId, cf.DNSRecord{ID: record.ID, Type: record.Type, Name: record.Name, Content: record.Content, TTL: record.TTL, Proxied: record.Proxied, Comment: record.Comment})
+	}
+
+	for _, record := range request.Posts {
+		m.create(zoneId, cf.DNSRecord{Type: record.Type, Name: record.Name, Content: record.Content, TTL: record.TTL, Proxied: record.Proxied, Comment: record.Comment})
+	}
+
+	writeMockResult(w, struct{}{}, nil)
+}
+
+// zone returns the id of the requested zone, or responds with an error if it
+// doesn't exist.
+func (m *Mock) zone(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("zone")
+
+	for _, z := range m.zones {
+		if z.ID == id {
+			return id, true
+		}
+	}
+
+	writeMockError(w, http.StatusNotFound, 7003, "Could not route to /zones/"+id+", perhaps your object identifier is invalid?")
+
+	return "", false
+}
+
+func (m *Mock) create(zoneId string, record cf.DNSRecord) cf.DNSRecord {
+	record.ID = m.nextId()
+	record.ZoneID = zoneId
+
+	if record.TTL == 0 {
+		record.TTL = 1
+	}
+
+	m.records[zoneId] = append(m.records[zoneId], record)
+
+	return record
+}
+
+func (m *Mock) update(zoneId string, patch cf.DNSRecord) (cf.DNSRecord, bool) {
+	for i, record := range m.records[zoneId] {
+		if record.ID != patch.ID {
+			continue
+		}
+
+		if patch.Type != "" {
+			record.Type = patch.Type
+		}
+
+		if patch.Name != "" {
+			record.Name = patch.Name
+		}
+
+		if patch.Content != "" {
+			record.Content = patch.Content
+		}
+
+		if patch.TTL != 0 {
+			record.TTL = patch.TTL
+		}
+
+		if patch.Proxied != nil {
+			record.Proxied = patch.Proxied
+		}
+
+		if patch.Data != nil {
+			record.Data = patch.Data
+		}
+
+		if patch.Comment != "" {
+			record.Comment = patch.Comment
+		}
+
+		m.records[zoneId][i] = record
+
+		return record, true
+	}
+
+	return cf.DNSRecord{}, false
+}
+
+func (m *Mock) delete(zoneId string, id string) bool {
+	records := m.records[zoneId]
+
+	for i, record := range records {
+		if record.ID == id {
+			m.records[zoneId] = slices.Delete(records, i, i+1)
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeMockResult(w http.ResponseWriter, result any, info *cf.ResultInfo) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"success":     true,
+		"errors":      []any{},
+		"messages":    []any{},
+		"result":      result,
+		"result_info": info,
+	})
+}
+
+func writeMockError(w http.ResponseWriter, status int, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"success":  false,
+		"errors":   []any{map[string]any{"code": code, "message": message}},
+		"messages": []any{},
+		"result":   nil,
+	})
+}
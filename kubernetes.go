@@ -0,0 +1,62 @@
+//go:build !nokubernetes && !slim
+
+package main
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cloudflare"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/kubernetes"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"os"
+)
+
+// startKubernetesWatcher returns the channels the Kubernetes watcher receives
+// the IPs and prefixes on, or nil if it's disabled.
+func startKubernetesWatcher(cloudflareUpdater *cloudflare.Updater) (chan *net.IP, chan *net.IPNet) {
+	w := newKubernetesWatcher(cloudflareUpdater)
+
+	if w == nil {
+		return nil, nil
+	}
+
+	w.StartWorker()
+
+	return w.In, w.Prefixes
+}
+
+func newKubernetesWatcher(cloudflareUpdater *cloudflare.Updater) *kubernetes.Watcher {
+	if !envBool("KUBERNETES_WATCH", false) {
+		return nil
+	}
+
+	if cloudflareUpdater == nil {
+		slog.Warn("Kubernetes hostnames can only be published to Cloudflare, disabling Kubernetes watcher")
+		return nil
+	}
+
+	records := make(chan *kubernetes.Record, 10)
+
+	w, err := kubernetes.NewInClusterWatcher(records, slog.Default())
+
+	if err != nil {
+		slog.Error("Failed to connect to Kubernetes, disabling Kubernetes watcher", logging.ErrorAttr(err))
+		return nil
+	}
+
+	if annotation := os.Getenv("KUBERNETES_WATCH_ANNOTATION"); annotation != "" {
+		w.Annotation = annotation
+	}
+
+	w.Namespace = os.Getenv("KUBERNETES_WATCH_NAMESPACE")
+
+	w.Interval = envDuration("KUBERNETES_WATCH_INTERVAL", w.Interval)
+
+	go func() {
+		for record := range records {
+			cloudflareUpdater.Records <- &cloudflare.DynamicRecord{Name: record.Name, IpVersion: record.IpVersion, IP: record.IP}
+		}
+	}()
+
+	return w
+}
@@ -0,0 +1,17 @@
+//go:build nokubernetes || slim
+
+package main
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cloudflare"
+	"log/slog"
+	"net"
+)
+
+func startKubernetesWatcher(_ *cloudflare.Updater) (chan *net.IP, chan *net.IPNet) {
+	if envBool("KUBERNETES_WATCH", false) {
+		slog.Warn("Kubernetes watcher is not included in this build, disabling Kubernetes watcher")
+	}
+
+	return nil, nil
+}
@@ -1,28 +1,220 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/avm"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cgnat"
 	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/cloudflare"
 	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/dyndns"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/failover"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/families"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/firewall"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/health"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/httpjson"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/iface"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipfile"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipv6"
 	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/mikrotik"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/notify"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/openwrt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/probe"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/publicip"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/schedule"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/snmp"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/state"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/stun"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/upnp"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/whoami"
 	"github.com/joho/godotenv"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	"strings"
 	"syscall"
 	"time"
 )
 
 func main() {
+	resumed := takeHandover()
+
+	// A reload starts over with the environment of the service, so the changes
+	// of the .env files are picked up
+	environ := os.Environ()
+
 	// Load any env variables defined in .env.dev files
 	_ = godotenv.Load(".env", ".env.dev")
 
-	updater := newUpdater()
-	updater.StartWorker()
+	args, err := parseFlags(os.Args[1:])
+
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(usage)
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(1)
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		err := godotenv.Load(path)
+
+		if err != nil {
+			slog.Error("Failed to load CONFIG_FILE, exiting", logging.ErrorAttr(err))
+			os.Exit(1)
+		}
+	}
+
+	err = logging.Setup(logging.Options{
+		Level:          os.Getenv("LOG_LEVEL"),
+		Format:         os.Getenv("LOG_FORMAT"),
+		Syslog:         os.Getenv("LOG_SYSLOG"),
+		SyslogFacility: os.Getenv("LOG_SYSLOG_FACILITY"),
+		File:           os.Getenv("LOG_FILE"),
+		FileMaxBytes:   envSize("LOG_FILE_MAX_BYTES", 10*1024*1024),
+		FileMaxAge:     envDuration("LOG_FILE_MAX_AGE", 0),
+		FileBackups:    envInt("LOG_FILE_BACKUPS", 3),
+	})
+
+	if err != nil {
+		slog.Error("Failed to set up logging, exiting", logging.ErrorAttr(err))
+		os.Exit(1)
+	}
+
+	err = loadSecretFiles()
+
+	if err != nil {
+		slog.Error("Failed to read secret, exiting", logging.ErrorAttr(err))
+		os.Exit(1)
+	}
+
+	if runCommand(args) {
+		return
+	}
+
+	if !loadConfig() {
+		slog.Error("Invalid configuration, exiting")
+		os.Exit(1)
+	}
+
+	started := time.Now()
+
+	metrics.BuildInfo.Set(1, version())
+	metrics.StartTime.Set(float64(started.Unix()))
+
+	dispatcher := newDispatcher()
+
+	dryRun := envBool("DRY_RUN", false)
+
+	if dryRun {
+		slog.Warn("Dry run enabled, no DNS records will be changed")
+	}
+
+	store := newStateStore()
+
+	var outs []chan *net.IP
+
+	tracker := &sourceTracker{}
+
+	checks := health.New()
+
+	cloudflareUpdater := newCloudflareUpdater(dryRun)
+
+	if cloudflareUpdater != nil {
+		checks.Ready("cloudflare", 0).Ok()
+	} else if cloudflareConfigured() {
+		// Records can't be updated until the daemon is restarted
+		checks.Ready("cloudflare", 0).Fail(errors.New("failed to initialize, see the logs"))
+	}
+
+	// The last IPs published by every updater, to confirm pushed updates
+	var lasts []*state.Last
+
+	if cloudflareUpdater != nil {
+		lasts = append(lasts, cloudflareUpdater.Last())
+		cloudflareUpdater.State = store
+		cloudflareUpdater.Source = tracker.Last
+		cloudflareUpdater.Version = version()
+		cloudflareUpdater.StartWorker()
+		outs = append(outs, cloudflareUpdater.In)
+	}
+
+	updaters := []*updater.Updater{
+		newNamecheapUpdater(),
+		newInfomaniakUpdater(),
+		newScalewayUpdater(),
+		newDnsimpleUpdater(),
+		newNs1Updater(),
+	}
+
+	for _, u := range updaters {
+		if u != nil {
+			u.SetDryRun(dryRun)
+			u.State = store
+			u.Source = tracker.Last
+			u.StartWorker()
+			outs = append(outs, u.In)
+			lasts = append(lasts, u.Last())
+		}
+	}
+
+	kubernetesIn, kubernetesPrefixes := startKubernetesWatcher(cloudflareUpdater)
+
+	if kubernetesIn != nil {
+		outs = append(outs, kubernetesIn)
+	}
+
+	in := make(chan *net.IP, 10)
+	go fanOut(in, outs, ipVersion)
+
+	sources := in
+
+	publishIpv4 := envBool("PUBLISH_IPV4", true)
+	publishIpv6 := envBool("PUBLISH_IPV6", true)
+
+	if !publishIpv4 || !publishIpv6 {
+		filtered := make(chan *net.IP, 10)
+		go filterFamilies(filtered, sources, publishIpv4, publishIpv6)
+		sources = filtered
+	}
+
+	// Allows to stop publishing an IP version at runtime
+	switcher := families.NewSwitch(sources, slog.Default())
+	switcher.StartWorker()
+	sources = switcher.In
+
+	if f := newFailover(sources, dispatcher); f != nil {
+		f.StartWorker()
+		sources = f.In
+	}
+
+	// Called by the poller if the router tells whether the connection is DS-Lite
+	var dsLite func(bool)
+
+	if publishIpv4 && !envBool("PUBLISH_SHARED_IPV4", false) {
+		filter := cgnat.NewFilter(sources, slog.Default())
+
+		if cloudflareUpdater != nil && envBool("CLOUDFLARE_DELETE_UNPUBLISHED", false) {
+			filter.Clear = func() {
+				cloudflareUpdater.DeleteRecords(4)
+			}
+		}
+
+		filter.StartWorker()
+		sources = filter.In
+		dsLite = filter.SetDsLite
+	}
 
 	ipv6LocalAddress := os.Getenv("DEVICE_LOCAL_ADDRESS_IPV6")
 
@@ -33,230 +225,1372 @@ func main() {
 			slog.Error("Failed to parse IP from DEVICE_LOCAL_ADDRESS_IPV6, exiting")
 			return
 		}
-		slog.Info("Using the IPv6 Prefix to construct the IPv6 Address")
+		slog.Info("Using the IPv6 Prefix to construct the IPv6 Address")
+	}
+
+	var prefixOuts []chan *net.IPNet
+
+	if cloudflareUpdater != nil && cloudflareUpdater.NeedsPrefixes() {
+		prefixOuts = append(prefixOuts, cloudflareUpdater.Prefixes)
+	}
+
+	if dockerPrefixes := startDockerWatcher(cloudflareUpdater); dockerPrefixes != nil {
+		prefixOuts = append(prefixOuts, dockerPrefixes)
+	}
+
+	if kubernetesPrefixes != nil {
+		prefixOuts = append(prefixOuts, kubernetesPrefixes)
+	}
+
+	var prefixes chan<- *net.IPNet
+
+	if len(prefixOuts) > 0 {
+		in := make(chan *net.IPNet, 10)
+		go fanOut(in, prefixOuts, prefixVersion)
+		prefixes = in
+	}
+
+	// Feeds the outcome of the updates back to the sources
+	threshold := envInt("UPDATE_FAILURE_THRESHOLD", 3)
+	failing := func() bool {
+		return len(store.Failing(threshold)) > 0
+	}
+
+	startPollServer(tracker.Tag("poll", sources), prefixes, &localIp, failing, dsLite, checks)
+	// IP versions that are not published can't be confirmed
+	published := func(ip net.IP) bool {
+		if (ip.To4() != nil && !publishIpv4) || (ip.To4() == nil && !publishIpv6) || !switcher.Enabled(ip) {
+			return true
+		}
+
+		for _, last := range lasts {
+			if !last.Is(ip) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	status := newStatusHandler(tracker, store, &localIp, started)
+	opsServer := startOpsServer(checks, status)
+	pushServer := startPushServer(tracker.Tag("push", sources), prefixes, &localIp, switcher, failing, published, cloudflareUpdater, checks, status)
+	startInterfaceWatcher(tracker.Tag("interface", sources))
+	startFileWatcher(tracker.Tag("file", sources))
+
+	if resumed != nil {
+		resumed.resume(tracker, sources)
+	}
+
+	signals := make(chan os.Signal, 1)
+
+	signal.Notify(signals, append([]os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}, cutoverSignals...)...)
+	startConfigWatcher(signals)
+
+	reloading := false
+
+	for sig := range signals {
+		if isCutoverSignal(sig) {
+			slog.Info("Cutover confirmation received")
+
+			if cloudflareUpdater != nil {
+				cloudflareUpdater.ConfirmCutover()
+			}
+
+			continue
+		}
+
+		if sig == syscall.SIGHUP {
+			slog.Info("Reloading the configuration")
+
+			if !checkConfig(environ) {
+				slog.Error("Invalid configuration, keeping the current one")
+				continue
+			}
+
+			reloading = true
+		}
+
+		break
+	}
+
+	if reloading {
+		slog.Info("Restarting with the new configuration")
+	} else {
+		slog.Info("Shutdown detected")
+	}
+
+	if pushServer != nil {
+		// Let the routers in the middle of an update get their answer
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := pushServer.Shutdown(ctx)
+		cancel()
+
+		if err != nil {
+			slog.Warn("Failed to shut down the DynDns server gracefully", logging.ErrorAttr(err))
+		}
+	}
+
+	if opsServer != nil {
+		_ = opsServer.Close()
+	}
+
+	if stats := store.HistoryStats(); stats.Evicted > 0 {
+		slog.Info("History was trimmed to its limits", slog.Int("entries", stats.Entries), slog.Int("bytes", stats.Bytes), slog.Uint64("evicted", stats.Evicted))
+	}
+
+	if reloading {
+		// Let the updaters finish the records they are changing and write the
+		// state, the new process publishes the last IPs again
+		stopUpdaters(cloudflareUpdater, updaters)
+
+		err := store.Close()
+
+		if err != nil {
+			slog.Warn("Failed to save the state", logging.ErrorAttr(err))
+		}
+
+		err = reload(environ, tracker)
+		slog.Error("Failed to reload, exiting", logging.ErrorAttr(err))
+		os.Exit(1)
+	}
+
+	if envBool("CLEANUP_ON_EXIT", false) {
+		cleanup(cloudflareUpdater, updaters)
+	}
+}
+
+// stopUpdaters waits for the updaters to finish their current update and stops
+// them.
+func stopUpdaters(cloudflareUpdater *cloudflare.Updater, updaters []*updater.Updater) {
+	if cloudflareUpdater != nil {
+		cloudflareUpdater.Stop()
+	}
+
+	for _, u := range updaters {
+		if u != nil {
+			u.Stop()
+		}
+	}
+}
+
+// cleanup deletes the managed records, or points them to the fallback IPs.
+func cleanup(cloudflareUpdater *cloudflare.Updater, updaters []*updater.Updater) {
+	fallbackIpv4 := net.ParseIP(os.Getenv("CLEANUP_FALLBACK_IPV4"))
+	fallbackIpv6 := net.ParseIP(os.Getenv("CLEANUP_FALLBACK_IPV6"))
+
+	slog.Info("Cleaning up managed records", slog.Any("fallback-ipv4", fallbackIpv4), slog.Any("fallback-ipv6", fallbackIpv6))
+
+	if cloudflareUpdater != nil {
+		cloudflareUpdater.Cleanup(fallbackIpv4, fallbackIpv6)
+	}
+
+	for _, u := range updaters {
+		if u != nil {
+			u.Cleanup(fallbackIpv4, fallbackIpv6)
+		}
+	}
+}
+
+func newStateStore() *state.Store {
+	path := os.Getenv("STATE_FILE")
+	history := state.NewHistory(envInt("STATE_HISTORY_ENTRIES", 100), int(envSize("STATE_HISTORY_BYTES", 64*1024)))
+
+	if path == "" {
+		return state.NewStore("", history)
+	}
+
+	s, err := state.Load(path, history)
+
+	if err != nil {
+		slog.Warn("Failed to read STATE_FILE, starting with an empty state", logging.ErrorAttr(err))
+		return state.NewStore(path, history)
+	}
+
+	return s
+}
+
+// newWanSource creates the source of the polled WAN IPs, see FRITZBOX_ENDPOINT_SOURCE,
+// followed by the ones of FRITZBOX_ENDPOINT_FALLBACK.
+func newWanSource() avm.WanSource {
+	name := os.Getenv("FRITZBOX_ENDPOINT_SOURCE")
+	source := newSource(name)
+	fallback := os.Getenv("FRITZBOX_ENDPOINT_FALLBACK")
+
+	if fallback == "" {
+		return source
+	}
+
+	chain := avm.NewChain(slog.Default())
+	chain.Failures = max(envInt("FRITZBOX_ENDPOINT_FAILURES", 3), 1)
+
+	sources := 0
+
+	if source != nil {
+		chain.Add(cmp.Or(name, "soap"), source)
+		sources++
+	}
+
+	for _, name := range strings.Split(fallback, ",") {
+		name = strings.TrimSpace(name)
+
+		if source := newSource(name); source != nil {
+			chain.Add(name, source)
+			sources++
+		}
+	}
+
+	if sources == 0 {
+		return nil
+	}
+
+	return chain
+}
+
+// newSource creates a single source of the WAN IPs by its name, nil if it is
+// not configured.
+func newSource(name string) avm.WanSource {
+	switch name {
+	case "webui":
+		return newWebUi()
+	case "tr064":
+		return newTr064()
+	case "upnp":
+		return newIgd()
+	case "https":
+		return newPublicIp()
+	case "stun":
+		return newStun()
+	case "dns":
+		return newWhoami()
+	case "openwrt":
+		return newUbus()
+	case "mikrotik":
+		return newMikrotik()
+	case "snmp":
+		if os.Getenv("SNMP_ADDRESS") == "" {
+			slog.Info("Env SNMP_ADDRESS not found, disabling polling")
+			return nil
+		}
+
+		return newSnmp()
+	case "json":
+		if os.Getenv("JSON_URL") == "" {
+			slog.Info("Env JSON_URL not found, disabling polling")
+			return nil
+		}
+
+		return newHttpJson()
+	case "opnsense":
+		return newFirewall(firewall.NewOpnsense())
+	case "pfsense":
+		return newFirewall(firewall.NewPfsense())
+	case "auto":
+		fb := newFritzBox()
+
+		if fb == nil {
+			return nil
+		}
+
+		return avm.NewFallback(fb, newWebUi(), slog.Default())
+	default:
+		return newFritzBox()
+	}
+}
+
+func newIgd() *upnp.Igd {
+	i := upnp.NewIgd(slog.Default())
+	i.Location = os.Getenv("UPNP_LOCATION")
+	i.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", i.Timeout)
+
+	if i.Location == "" {
+		slog.Info("Env UPNP_LOCATION not found, discovering the router with SSDP")
+	}
+
+	return i
+}
+
+func newPublicIp() *publicip.Source {
+	s := publicip.NewSource(slog.Default())
+	s.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", s.Timeout)
+
+	if services := os.Getenv("PUBLIC_IP_SERVICES"); services != "" {
+		s.Urls = nil
+
+		for _, service := range strings.Split(services, ",") {
+			service = strings.TrimSpace(service)
+
+			// Known services can be given by name
+			if u, ok := publicip.Services[service]; ok {
+				service = u
+			}
+
+			s.Urls = append(s.Urls, service)
+		}
+	}
+
+	return s
+}
+
+func newStun() *stun.Source {
+	s := stun.NewSource(slog.Default())
+	s.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", s.Timeout)
+
+	if servers := os.Getenv("STUN_SERVERS"); servers != "" {
+		s.Servers = nil
+
+		for _, server := range strings.Split(servers, ",") {
+			s.Servers = append(s.Servers, strings.TrimSpace(server))
+		}
+	}
+
+	return s
+}
+
+func newWhoami() *whoami.Source {
+	s := whoami.NewSource(slog.Default())
+	s.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", s.Timeout)
+
+	if services := os.Getenv("WHOAMI_SERVICES"); services != "" {
+		s.Services = nil
+
+		for _, service := range strings.Split(services, ",") {
+			name, server, _ := strings.Cut(strings.TrimSpace(service), "@")
+			ws := whoami.Services[name]
+
+			// The service can be asked at another address of its servers
+			if server != "" {
+				ws.Server4 = server
+				ws.Server6 = server
+			}
+
+			s.Services = append(s.Services, ws)
+		}
+	}
+
+	return s
+}
+
+func newWebUi() *avm.WebUi {
+	w := avm.NewWebUi()
+
+	if webUiUrl := os.Getenv("FRITZBOX_WEBUI_URL"); webUiUrl != "" {
+		w.Url = strings.TrimRight(webUiUrl, "/")
+	}
+
+	w.Username = os.Getenv("FRITZBOX_WEBUI_USERNAME")
+	w.Password = os.Getenv("FRITZBOX_WEBUI_PASSWORD")
+	w.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", w.Timeout)
+
+	return w
+}
+
+func newTr064() *avm.Tr064 {
+	t := avm.NewTr064()
+
+	if tr064Url := os.Getenv("FRITZBOX_TR064_URL"); tr064Url != "" {
+		t.Url = strings.TrimRight(tr064Url, "/")
+	}
+
+	if profile, ok := avm.Tr064Profiles[os.Getenv("FRITZBOX_TR064_PROFILE")]; ok {
+		t.Profile = profile
+	}
+
+	if description := os.Getenv("FRITZBOX_TR064_DESCRIPTION"); description != "" {
+		t.Profile.Description = "/" + strings.TrimLeft(description, "/")
+	}
+
+	t.Username = os.Getenv("FRITZBOX_TR064_USERNAME")
+	t.Password = os.Getenv("FRITZBOX_TR064_PASSWORD")
+	t.Insecure = envBool("FRITZBOX_TR064_INSECURE", false)
+	t.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", t.Timeout)
+
+	return t
+}
+
+func newUbus() *openwrt.Ubus {
+	u := openwrt.NewUbus()
+
+	if ubusUrl := os.Getenv("OPENWRT_URL"); ubusUrl != "" {
+		u.Url = ubusUrl
+	}
+
+	if username := os.Getenv("OPENWRT_USERNAME"); username != "" {
+		u.Username = username
+	}
+
+	u.Password = os.Getenv("OPENWRT_PASSWORD")
+
+	if name := os.Getenv("OPENWRT_INTERFACE_IPV4"); name != "" {
+		u.Ipv4Interface = name
+	}
+
+	if name := os.Getenv("OPENWRT_INTERFACE_IPV6"); name != "" {
+		u.Ipv6Interface = name
+	}
+
+	u.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", u.Timeout)
+
+	return u
+}
+
+func newSnmp() *snmp.Source {
+	address := os.Getenv("SNMP_ADDRESS")
+
+	// The standard port of agents
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+
+	c := snmp.NewClient(address)
+
+	if version := os.Getenv("SNMP_VERSION"); version != "" {
+		c.Version = version
+	}
+
+	if community := os.Getenv("SNMP_COMMUNITY"); community != "" {
+		c.Community = community
+	}
+
+	c.Username = os.Getenv("SNMP_USERNAME")
+
+	if protocol := os.Getenv("SNMP_AUTH_PROTOCOL"); protocol != "" {
+		c.AuthProtocol = protocol
+	}
+
+	c.AuthPassword = os.Getenv("SNMP_AUTH_PASSWORD")
+
+	if protocol := os.Getenv("SNMP_PRIV_PROTOCOL"); protocol != "" {
+		c.PrivProtocol = protocol
+	}
+
+	c.PrivPassword = os.Getenv("SNMP_PRIV_PASSWORD")
+	c.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", c.Timeout)
+
+	s := snmp.NewSource(c)
+	s.Ipv4Oid = os.Getenv("SNMP_IPV4_OID")
+	s.Ipv6Oid = os.Getenv("SNMP_IPV6_OID")
+	s.PrefixOid = os.Getenv("SNMP_PREFIX_OID")
+	s.Interface = envInt("SNMP_INTERFACE", 0)
+
+	return s
+}
+
+func newHttpJson() *httpjson.Source {
+	s := httpjson.NewSource(os.Getenv("JSON_URL"))
+	s.Username = os.Getenv("JSON_USERNAME")
+	s.Password = os.Getenv("JSON_PASSWORD")
+	s.Token = os.Getenv("JSON_TOKEN")
+	s.Ipv4Path = os.Getenv("JSON_IPV4_PATH")
+	s.Ipv6Path = os.Getenv("JSON_IPV6_PATH")
+	s.PrefixPath = os.Getenv("JSON_PREFIX_PATH")
+	s.Insecure = envBool("JSON_INSECURE", false)
+	s.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", s.Timeout)
+
+	return s
+}
+
+func newMikrotik() *mikrotik.Rest {
+	r := mikrotik.NewRest()
+
+	if restUrl := os.Getenv("MIKROTIK_URL"); restUrl != "" {
+		r.Url = strings.TrimRight(restUrl, "/")
+	}
+
+	if username := os.Getenv("MIKROTIK_USERNAME"); username != "" {
+		r.Username = username
+	}
+
+	r.Password = os.Getenv("MIKROTIK_PASSWORD")
+
+	if name := os.Getenv("MIKROTIK_INTERFACE"); name != "" {
+		r.Interface = name
+	}
+
+	r.Pool = os.Getenv("MIKROTIK_POOL")
+	r.Insecure = envBool("MIKROTIK_INSECURE", false)
+	r.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", r.Timeout)
+
+	return r
+}
+
+func newFirewall(f *firewall.Firewall) *firewall.Firewall {
+	if firewallUrl := os.Getenv("FIREWALL_URL"); firewallUrl != "" {
+		f.Url = strings.TrimRight(firewallUrl, "/")
+	}
+
+	f.Key = os.Getenv("FIREWALL_KEY")
+	f.Secret = os.Getenv("FIREWALL_SECRET")
+
+	if name := os.Getenv("FIREWALL_INTERFACE"); name != "" {
+		f.Interface = name
+	}
+
+	if name := os.Getenv("FIREWALL_PREFIX_INTERFACE"); name != "" {
+		f.PrefixInterface = name
+	}
+
+	f.Insecure = envBool("FIREWALL_INSECURE", false)
+	f.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", f.Timeout)
+
+	return f
+}
+
+// newFritzBox polls the FritzBox, or several ones in the given order if
+// FRITZBOX_ENDPOINT_URL is a comma separated list.
+func newFritzBox() avm.WanSource {
+	// Import FritzBox endpoint url
+	endpointUrl := os.Getenv("FRITZBOX_ENDPOINT_URL")
+
+	if endpointUrl == "" {
+		slog.Info("Env FRITZBOX_ENDPOINT_URL not found, disabling FritzBox polling")
+		return nil
+	}
+
+	var boxes []*avm.FritzBox
+
+	for _, u := range strings.Split(endpointUrl, ",") {
+		v, err := url.ParseRequestURI(strings.TrimSpace(u))
+
+		if err != nil {
+			slog.Error("Failed to parse env FRITZBOX_ENDPOINT_URL", logging.ErrorAttr(err))
+			panic(err)
+		}
+
+		fb := avm.NewFritzBox()
+		fb.Url = strings.TrimRight(v.String(), "/")
+
+		// Import FritzBox endpoint timeout setting
+		fb.Timeout = envDuration("FRITZBOX_ENDPOINT_TIMEOUT", fb.Timeout)
+
+		boxes = append(boxes, fb)
+	}
+
+	if len(boxes) == 1 {
+		return boxes[0]
+	}
+
+	chain := avm.NewChain(slog.Default())
+
+	for _, fb := range boxes {
+		chain.Add(fb.Url, fb)
+	}
+
+	return chain
+}
+
+// cloudflareConfigured tells whether Cloudflare credentials are configured.
+func cloudflareConfigured() bool {
+	if os.Getenv("CLOUDFLARE_API_TOKEN") != "" || os.Getenv("CLOUDFLARE_ZONE_TOKENS") != "" {
+		return true
+	}
+
+	return os.Getenv("CLOUDFLARE_API_EMAIL") != "" && os.Getenv("CLOUDFLARE_API_KEY") != ""
+}
+
+func newCloudflareUpdater(dryRun bool) *cloudflare.Updater {
+	u := cloudflare.NewUpdater(slog.Default())
+
+	token := os.Getenv("CLOUDFLARE_API_TOKEN")
+	zoneTokens := os.Getenv("CLOUDFLARE_ZONE_TOKENS")
+	email := os.Getenv("CLOUDFLARE_API_EMAIL")
+	key := os.Getenv("CLOUDFLARE_API_KEY")
+
+	if token == "" && zoneTokens == "" {
+		if email == "" || key == "" {
+			slog.Info("Env CLOUDFLARE_API_TOKEN not found, disabling CloudFlare updates")
+			return nil
+		} else {
+			slog.Warn("Using deprecated credentials via the API key")
+		}
+	}
+
+	ipv4Zone := os.Getenv("CLOUDFLARE_ZONES_IPV4")
+	ipv6Zone := os.Getenv("CLOUDFLARE_ZONES_IPV6")
+	views := os.Getenv("CLOUDFLARE_VIEWS")
+	delegations := os.Getenv("CLOUDFLARE_DELEGATIONS")
+
+	if ipv4Zone == "" && ipv6Zone == "" && views == "" && delegations == "" {
+		slog.Warn("Env CLOUDFLARE_ZONES_IPV4 and CLOUDFLARE_ZONES_IPV6 not found, disabling CloudFlare updates")
+		return nil
+	}
+
+	if ipv4Zone != "" {
+		u.SetIPv4Zones(ipv4Zone)
+	}
+
+	if ipv6Zone != "" {
+		u.SetIPv6Zones(ipv6Zone)
+	}
+
+	ipv4Staging := os.Getenv("CLOUDFLARE_CUTOVER_IPV4")
+	ipv6Staging := os.Getenv("CLOUDFLARE_CUTOVER_IPV6")
+
+	if ipv4Staging != "" {
+		u.SetIPv4StagingZones(ipv4Staging)
+	}
+
+	if ipv6Staging != "" {
+		u.SetIPv6StagingZones(ipv6Staging)
+	}
+
+	if views != "" {
+		err := u.SetViews(views)
+
+		if err != nil {
+			slog.Error("Failed to parse CLOUDFLARE_VIEWS, disabling CloudFlare updates", logging.ErrorAttr(err))
+			return nil
+		}
+	}
+
+	u.SetTTL(envInt("CLOUDFLARE_TTL", 0))
+	u.SetParallelism(envInt("CLOUDFLARE_PARALLELISM", 4))
+	u.SetMaxRequestsPerSecond(envFloat("CLOUDFLARE_MAX_RPS", 0))
+	u.SetApiUrl(os.Getenv("CLOUDFLARE_API_URL"))
+	u.SetAccountId(os.Getenv("CLOUDFLARE_ACCOUNT_ID"))
+
+	if zoneIds := os.Getenv("CLOUDFLARE_ZONE_ID_MAP"); zoneIds != "" {
+		err := u.SetZoneIds(zoneIds)
+
+		if err != nil {
+			slog.Error("Failed to parse CLOUDFLARE_ZONE_ID_MAP, disabling CloudFlare updates", logging.ErrorAttr(err))
+			return nil
+		}
+	}
+
+	if mirrors := os.Getenv("CLOUDFLARE_MIRROR"); mirrors != "" {
+		err := u.SetMirrors(mirrors)
+
+		if err != nil {
+			slog.Error("Failed to parse CLOUDFLARE_MIRROR, disabling CloudFlare updates", logging.ErrorAttr(err))
+			return nil
+		}
+	}
+
+	// Marks the records, so other instances updating them are detected
+	if envBool("CLOUDFLARE_OWNERSHIP", true) {
+		instanceId := os.Getenv("CLOUDFLARE_INSTANCE_ID")
+
+		if instanceId == "" {
+			instanceId, _ = os.Hostname()
+		}
+
+		u.SetInstanceId(instanceId)
+		u.SetStandDown(envBool("CLOUDFLARE_STAND_DOWN", false))
+	}
+
+	u.SetDryRun(dryRun)
+	u.SetExclusive(envBool("CLOUDFLARE_EXCLUSIVE", false))
+	u.SetHttpsHints(envBool("CLOUDFLARE_HTTPS_HINTS", false))
+	u.SetPurgeCache(envBool("CLOUDFLARE_PURGE_CACHE", false))
+
+	if purgeUrls := os.Getenv("CLOUDFLARE_PURGE_URLS"); purgeUrls != "" {
+		err := u.SetPurgeUrls(purgeUrls)
+
+		if err != nil {
+			slog.Warn("Failed to parse CLOUDFLARE_PURGE_URLS, purging whole hosts", logging.ErrorAttr(err))
+			u.SetPurgeCache(true)
+		}
+	}
+
+	u.SetPurgeDelay(envDuration("CLOUDFLARE_PURGE_DELAY", 0))
+
+	if prefixRecords := os.Getenv("CLOUDFLARE_PREFIX_RECORDS"); prefixRecords != "" {
+		u.SetPrefixRecords(prefixRecords)
+	}
+
+	if metadataRecords := os.Getenv("CLOUDFLARE_METADATA_RECORDS"); metadataRecords != "" {
+		u.SetMetadataRecords(metadataRecords)
+	}
+
+	if delegations != "" {
+		err := u.SetDelegations(delegations)
+
+		if err != nil {
+			slog.Error("Failed to parse CLOUDFLARE_DELEGATIONS, disabling CloudFlare updates", logging.ErrorAttr(err))
+			return nil
+		}
+	}
+
+	u.SetCutoverProbe(envInt("CLOUDFLARE_CUTOVER_PROBE_PORT", 0))
+
+	if probes := os.Getenv("CLOUDFLARE_PROBES"); probes != "" {
+		err := u.SetProbes(probes)
+
+		if err != nil {
+			slog.Warn("Failed to parse CLOUDFLARE_PROBES, waiting for manual confirmation", logging.ErrorAttr(err))
+		}
+	}
+
+	if zoneTokens != "" {
+		err := u.SetZoneTokens(zoneTokens)
+
+		if err != nil {
+			slog.Error("Failed to parse CLOUDFLARE_ZONE_TOKENS, disabling CloudFlare updates", logging.ErrorAttr(err))
+			return nil
+		}
+	}
+
+	var err error
+
+	if token != "" || zoneTokens != "" {
+		var tokens []string
+
+		if token != "" {
+			tokens = strings.Split(token, ",")
+		}
+
+		err = u.InitWithTokens(tokens)
+	} else {
+		err = u.InitWithKey(email, key)
+	}
+
+	if err != nil {
+		slog.Error("Failed to init Cloudflare updater, disabling CloudFlare updates", logging.ErrorAttr(err))
+		return nil
+	}
+
+	if envBool("CLOUDFLARE_DELETE_UNPUBLISHED", false) {
+		if !envBool("PUBLISH_IPV4", true) {
+			u.DeleteRecords(4)
+		}
+
+		if !envBool("PUBLISH_IPV6", true) {
+			u.DeleteRecords(6)
+		}
 	}
 
-	startPollServer(updater.In, &localIp)
-	startPushServer(updater.In, &localIp)
+	return u
+}
+
+func newDispatcher() *notify.Dispatcher {
+	d := notify.NewDispatcher(slog.Default())
 
-	shutdown := make(chan os.Signal)
+	d.QueueSize = envInt("NOTIFY_QUEUE_SIZE", d.QueueSize)
+	d.Timeout = envDuration("NOTIFY_TIMEOUT", d.Timeout)
 
-	signal.Notify(shutdown, syscall.SIGTERM)
-	signal.Notify(shutdown, syscall.SIGINT)
+	switch overflow := notify.Overflow(os.Getenv("NOTIFY_OVERFLOW")); overflow {
+	case "":
+	case notify.OverflowDrop, notify.OverflowAggregate:
+		d.Overflow = overflow
+	default:
+		slog.Warn("Unknown NOTIFY_OVERFLOW, using defaults", slog.String("overflow", string(overflow)))
+	}
 
-	<-shutdown
+	if webhook := os.Getenv("NOTIFY_WEBHOOK_URL"); webhook != "" {
+		d.Add(notify.NewWebhook(webhook))
+	}
 
-	slog.Info("Shutdown detected")
+	return d
 }
 
-func newFritzBox() *avm.FritzBox {
-	fb := avm.NewFritzBox()
+func newFailover(out chan<- *net.IP, dispatcher *notify.Dispatcher) *failover.Failover {
+	backupIpv4 := os.Getenv("FAILOVER_BACKUP_IPV4")
+	backupIpv6 := os.Getenv("FAILOVER_BACKUP_IPV6")
 
-	// Import FritzBox endpoint url
-	endpointUrl := os.Getenv("FRITZBOX_ENDPOINT_URL")
+	if backupIpv4 == "" && backupIpv6 == "" {
+		return nil
+	}
 
-	if endpointUrl != "" {
-		v, err := url.ParseRequestURI(endpointUrl)
+	var p *probe.Probe
+
+	if definition := os.Getenv("FAILOVER_PROBE"); definition != "" {
+		v, err := probe.Parse(definition)
 
 		if err != nil {
-			slog.Error("Failed to parse env FRITZBOX_ENDPOINT_URL", logging.ErrorAttr(err))
-			panic(err)
+			slog.Warn("Failed to parse FAILOVER_PROBE, disabling failover", logging.ErrorAttr(err))
+			return nil
 		}
 
-		fb.Url = strings.TrimRight(v.String(), "/")
+		p = v
 	} else {
-		slog.Info("Env FRITZBOX_ENDPOINT_URL not found, disabling FritzBox polling")
-		return nil
+		probePort := envInt("FAILOVER_PROBE_PORT", 0)
+
+		if probePort == 0 {
+			slog.Warn("Env FAILOVER_PROBE_PORT not found, disabling failover")
+			return nil
+		}
+
+		p = probe.NewTCP(probePort)
 	}
 
-	// Import FritzBox endpoint timeout setting
-	endpointTimeout := os.Getenv("FRITZBOX_ENDPOINT_TIMEOUT")
+	f := failover.NewFailover(out, p, dispatcher, slog.Default())
 
-	if endpointTimeout != "" {
-		v, err := time.ParseDuration(endpointTimeout)
+	if backupIpv4 != "" {
+		ip := net.ParseIP(backupIpv4)
 
-		if err != nil {
-			slog.Warn("Failed to parse FRITZBOX_ENDPOINT_TIMEOUT, using defaults", logging.ErrorAttr(err))
-		} else {
-			fb.Timeout = v
+		if ip == nil || ip.To4() == nil {
+			slog.Warn("Failed to parse FAILOVER_BACKUP_IPV4, disabling failover")
+			return nil
 		}
+
+		f.SetIPv4Backup(ip)
 	}
 
-	return fb
-}
+	if backupIpv6 != "" {
+		ip := net.ParseIP(backupIpv6)
 
-func newUpdater() *cloudflare.Updater {
-	u := cloudflare.NewUpdater(slog.Default())
+		if ip == nil || ip.To4() != nil {
+			slog.Warn("Failed to parse FAILOVER_BACKUP_IPV6, disabling failover")
+			return nil
+		}
 
-	token := os.Getenv("CLOUDFLARE_API_TOKEN")
-	email := os.Getenv("CLOUDFLARE_API_EMAIL")
-	key := os.Getenv("CLOUDFLARE_API_KEY")
+		f.SetIPv6Backup(ip)
+	}
 
-	if token == "" {
-		if email == "" || key == "" {
-			slog.Info("Env CLOUDFLARE_API_TOKEN not found, disabling CloudFlare updates")
-			return u
-		} else {
-			slog.Warn("Using deprecated credentials via the API key")
+	f.Interval = envDuration("FAILOVER_CHECK_INTERVAL", f.Interval)
+	f.Failures = envInt("FAILOVER_PROBE_FAILURES", f.Failures)
+	f.FailbackAfter = envDuration("FAILOVER_FAILBACK_AFTER", f.FailbackAfter)
+
+	slog.Info("Failover to backup WAN enabled")
+
+	return f
+}
+
+// filterFamilies relays only IPs of the families that should be published.
+func filterFamilies(in <-chan *net.IP, out chan<- *net.IP, ipv4 bool, ipv6 bool) {
+	for ip := range in {
+		if ip.To4() == nil && !ipv6 || ip.To4() != nil && !ipv4 {
+			slog.Debug("Skipping IP of unpublished family", slog.Any("ip", ip))
+			continue
 		}
+
+		out <- ip
 	}
+}
 
-	ipv4Zone := os.Getenv("CLOUDFLARE_ZONES_IPV4")
-	ipv6Zone := os.Getenv("CLOUDFLARE_ZONES_IPV6")
+func startInterfaceWatcher(out chan<- *net.IP) {
+	name := os.Getenv("INTERFACE_NAME")
 
-	if ipv4Zone == "" && ipv6Zone == "" {
-		slog.Warn("Env CLOUDFLARE_ZONES_IPV4 and CLOUDFLARE_ZONES_IPV6 not found, disabling CloudFlare updates")
-		return u
+	if name == "" {
+		return
 	}
 
-	if ipv4Zone != "" {
-		u.SetIPv4Zones(ipv4Zone)
+	w := iface.NewWatcher(name, out, slog.Default())
+	w.Interval = envDuration("INTERFACE_INTERVAL", w.Interval)
+	w.StartWorker()
+}
+
+func startFileWatcher(out chan<- *net.IP) {
+	path := os.Getenv("IP_FILE")
+
+	if path == "" {
+		return
 	}
 
-	if ipv6Zone != "" {
-		u.SetIPv6Zones(ipv6Zone)
+	w := ipfile.NewWatcher(path, out, slog.Default())
+	w.Interval = envDuration("IP_FILE_INTERVAL", w.Interval)
+	w.StartWorker()
+}
+
+// newConfirmation makes the push server wait for the pushed IPs to be published,
+// see DYNDNS_SERVER_CONFIRM.
+func newConfirmation(published func(net.IP) bool) *dyndns.Confirmation {
+	records := os.Getenv("DYNDNS_SERVER_PROPAGATION_RECORDS")
+
+	if !envBool("DYNDNS_SERVER_CONFIRM", false) && records == "" {
+		return nil
 	}
 
-	var err error
+	c := dyndns.NewConfirmation(published)
+	c.Timeout = envDuration("DYNDNS_SERVER_CONFIRM_TIMEOUT", c.Timeout)
 
-	if token != "" {
-		err = u.InitWithToken(token)
-	} else {
-		err = u.InitWithKey(email, key)
+	if records != "" {
+		c.Records = strings.Split(records, ",")
 	}
 
-	if err != nil {
-		slog.Error("Failed to init Cloudflare updater, disabling CloudFlare updates")
-		return u
+	if resolver := os.Getenv("DYNDNS_SERVER_PROPAGATION_RESOLVER"); resolver != "" {
+		dialer := &net.Dialer{}
+
+		c.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, resolver)
+			},
+		}
 	}
 
-	return u
+	return c
 }
 
-func startPushServer(out chan<- *net.IP, localIp *net.IP) {
+func startPushServer(out chan<- *net.IP, prefixes chan<- *net.IPNet, localIp *net.IP, switcher *families.Switch, failing func() bool, published func(net.IP) bool, cloudflareUpdater *cloudflare.Updater, checks *health.Health, status http.HandlerFunc) *http.Server {
 	bind := os.Getenv("DYNDNS_SERVER_BIND")
 
 	if bind == "" {
 		slog.Info("Env DYNDNS_SERVER_BIND not found, disabling DynDns server")
-		return
+		return nil
 	}
 
 	server := dyndns.NewServer(out, localIp, slog.Default())
 	server.Username = os.Getenv("DYNDNS_SERVER_USERNAME")
 	server.Password = os.Getenv("DYNDNS_SERVER_PASSWORD")
+	server.Prefixes = prefixes
+	server.Failing = failing
+	server.Confirmation = newConfirmation(published)
+	server.Published = published
+
+	if htpasswd := os.Getenv("DYNDNS_SERVER_HTPASSWD"); htpasswd != "" {
+		a, err := dyndns.NewHtpasswd(htpasswd)
+
+		if err != nil {
+			slog.Error("Failed to read DYNDNS_SERVER_HTPASSWD, disabling DynDns server", logging.ErrorAttr(err))
+			return nil
+		}
+
+		server.Authenticators = append(server.Authenticators, a)
+	}
+
+	if token := os.Getenv("DYNDNS_SERVER_TOKEN"); token != "" {
+		server.Authenticators = append(server.Authenticators, dyndns.NewTokenAuth(token))
+	}
+
+	if secret := os.Getenv("DYNDNS_SERVER_HMAC_SECRET"); secret != "" {
+		a := dyndns.NewHmacAuth(secret)
+		a.MaxAge = envDuration("DYNDNS_SERVER_HMAC_MAX_AGE", a.MaxAge)
+
+		server.Authenticators = append(server.Authenticators, a)
+	}
+
+	introspectionUrl := os.Getenv("DYNDNS_SERVER_INTROSPECTION_URL")
+
+	// Keep accepting the configured credentials next to the other backends
+	if (len(server.Authenticators) > 0 || introspectionUrl != "") && (server.Username != "" || server.Password != "") {
+		server.Authenticators = append(server.Authenticators, dyndns.NewStaticAuth(server.Username, server.Password))
+	}
+
+	// Last, so the local backends answer without a round trip to the endpoint
+	if introspectionUrl != "" {
+		a := dyndns.NewIntrospection(introspectionUrl)
+		a.ClientId = os.Getenv("DYNDNS_SERVER_INTROSPECTION_CLIENT_ID")
+		a.ClientSecret = os.Getenv("DYNDNS_SERVER_INTROSPECTION_CLIENT_SECRET")
+
+		server.Authenticators = append(server.Authenticators, a)
+	}
+
+	if requests := envInt("DYNDNS_SERVER_RATE_LIMIT", 30); requests > 0 || envInt("DYNDNS_SERVER_LOCKOUT_FAILURES", 5) > 0 {
+		l := dyndns.NewLimiter()
+		l.Requests = requests
+		l.Failures = envInt("DYNDNS_SERVER_LOCKOUT_FAILURES", l.Failures)
+		l.Lockout = envDuration("DYNDNS_SERVER_LOCKOUT_DURATION", l.Lockout)
+
+		server.Limiter = l
+	}
+
+	if sites := os.Getenv("DYNDNS_SERVER_SITES"); sites != "" {
+		v, err := dyndns.ParseSites(sites)
+
+		switch {
+		case err != nil:
+			slog.Error("Failed to parse DYNDNS_SERVER_SITES, disabling DynDns server", logging.ErrorAttr(err))
+			return nil
+		case cloudflareUpdater == nil:
+			slog.Warn("Sites can only be published to Cloudflare, ignoring DYNDNS_SERVER_SITES")
+		default:
+			server.Sites = v
+			server.SiteRecords = startSiteRecords(cloudflareUpdater)
+		}
+	}
+
+	if proxies := os.Getenv("DYNDNS_SERVER_TRUSTED_PROXIES"); proxies != "" {
+		v, err := dyndns.ParseTrustedProxies(proxies)
+
+		if err != nil {
+			slog.Warn("Failed to parse DYNDNS_SERVER_TRUSTED_PROXIES, trusting no proxies", logging.ErrorAttr(err))
+		} else {
+			server.TrustedProxies = v
+		}
+	}
+
+	server.ClientIpFallback = envBool("DYNDNS_SERVER_CLIENT_IP_FALLBACK", false)
+
+	if params := os.Getenv("DYNDNS_SERVER_PARAMS"); params != "" {
+		v, err := dyndns.ParseParams(params)
+
+		if err != nil {
+			slog.Error("Failed to parse DYNDNS_SERVER_PARAMS, disabling DynDns server", logging.ErrorAttr(err))
+			return nil
+		}
+
+		server.Params = v
+	}
+
+	endpoint := os.Getenv("DYNDNS_SERVER_PATH")
+
+	if endpoint == "" {
+		endpoint = "/ip"
+	}
+
+	// Reverse proxies might serve us on a subpath without stripping it
+	prefix := os.Getenv("DYNDNS_SERVER_PATH_PREFIX")
+	endpoint = path.Join("/", prefix, endpoint)
+
+	handler, nicUpdate := server.Handler, server.NicUpdate
+
+	if envBool("DYNDNS_SERVER_ACCESS_LOG", false) {
+		handler, nicUpdate = server.AccessLog(handler), server.AccessLog(nicUpdate)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(endpoint, handler)
+	mux.HandleFunc(path.Join("/", prefix, "nic/update"), nicUpdate)
+	// Served by the ops server instead if it has its own address
+	if os.Getenv("OPS_BIND") == "" {
+		mux.HandleFunc(path.Join("/", prefix, "healthz"), checks.Liveness)
+		mux.HandleFunc(path.Join("/", prefix, "readyz"), checks.Readiness)
+		mux.HandleFunc(path.Join("/", prefix, "status"), server.Protect(status))
+		mux.HandleFunc(path.Join("/", prefix, "metrics"), metrics.Default.Handler)
+	}
+
+	if envBool("DYNDNS_SERVER_ADMIN", false) {
+		mux.HandleFunc(path.Join("/", prefix, "admin/families"), server.Protect(switcher.Handler))
+
+		if server.Limiter != nil {
+			mux.HandleFunc(path.Join("/", prefix, "admin/limits"), server.Protect(server.Limiter.Handler))
+		}
+	}
 
 	s := &http.Server{
 		Addr:     bind,
+		Handler:  mux,
 		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo),
 	}
 
-	http.HandleFunc("/ip", server.Handler)
+	certFile := os.Getenv("DYNDNS_SERVER_TLS_CERT")
+	keyFile := os.Getenv("DYNDNS_SERVER_TLS_KEY")
+
+	if certFile != "" || keyFile != "" {
+		certificate, err := dyndns.NewCertificate(certFile, keyFile, slog.Default())
+
+		if err != nil {
+			slog.Error("Failed to read DYNDNS_SERVER_TLS_CERT and DYNDNS_SERVER_TLS_KEY, disabling DynDns server", logging.ErrorAttr(err))
+			return nil
+		}
+
+		s.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificate.GetCertificate,
+		}
+	}
+
+	if clientCa := os.Getenv("DYNDNS_SERVER_TLS_CLIENT_CA"); clientCa != "" {
+		if s.TLSConfig == nil {
+			slog.Error("Env DYNDNS_SERVER_TLS_CLIENT_CA requires DYNDNS_SERVER_TLS_CERT and DYNDNS_SERVER_TLS_KEY, disabling DynDns server")
+			return nil
+		}
+
+		pool, err := dyndns.LoadClientCas(clientCa)
+
+		if err != nil {
+			slog.Error("Failed to read DYNDNS_SERVER_TLS_CLIENT_CA, disabling DynDns server", logging.ErrorAttr(err))
+			return nil
+		}
+
+		// Clients without a certificate of the CA fail the handshake
+		s.TLSConfig.ClientCAs = pool
+		s.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	go func() {
+		var err error
+
+		if s.TLSConfig != nil {
+			err = s.ListenAndServeTLS("", "")
+		} else {
+			err = s.ListenAndServe()
+		}
+
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+
+		slog.Error("Server stopped", logging.ErrorAttr(err))
+	}()
+
+	return s
+}
+
+// startOpsServer serves the health checks, the status and the metrics on their
+// own address, so they can be kept from the network the router pushes from. The
+// status isn't protected there, the address has to be.
+func startOpsServer(checks *health.Health, status http.HandlerFunc) *http.Server {
+	bind := os.Getenv("OPS_BIND")
+
+	if bind == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", checks.Liveness)
+	mux.HandleFunc("/readyz", checks.Readiness)
+	mux.HandleFunc("/status", status)
+	mux.HandleFunc("/metrics", metrics.Default.Handler)
+
+	s := &http.Server{
+		Addr:     bind,
+		Handler:  mux,
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo),
+	}
 
 	go func() {
 		err := s.ListenAndServe()
-		slog.Error("Server stopped", logging.ErrorAttr(err))
+
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+
+		slog.Error("Ops server stopped", logging.ErrorAttr(err))
 	}()
+
+	return s
+}
+
+// startSiteRecords publishes the IPs pushed by sites as dynamic records.
+func startSiteRecords(cloudflareUpdater *cloudflare.Updater) chan<- *dyndns.SiteRecord {
+	records := make(chan *dyndns.SiteRecord, 10)
+
+	go func() {
+		for record := range records {
+			ipVersion := 6
+
+			if record.IP.To4() != nil {
+				ipVersion = 4
+			}
+
+			cloudflareUpdater.Records <- &cloudflare.DynamicRecord{Name: record.Name, IpVersion: ipVersion, IP: record.IP}
+		}
+	}()
+
+	return records
 }
 
-func startPollServer(out chan<- *net.IP, localIp *net.IP) {
-	fritzbox := newFritzBox()
+func startPollServer(out chan<- *net.IP, prefixes chan<- *net.IPNet, localIp *net.IP, failing func() bool, dsLite func(bool), checks *health.Health) {
+	fritzbox := newWanSource()
+
+	if fritzbox == nil {
+		return
+	}
 
 	// Import endpoint polling interval duration
-	interval := os.Getenv("FRITZBOX_ENDPOINT_INTERVAL")
-	useIpv4 := os.Getenv("CLOUDFLARE_ZONES_IPV4") != ""
-	useIpv6 := os.Getenv("CLOUDFLARE_ZONES_IPV6") != ""
+	interval := envDuration("FRITZBOX_ENDPOINT_INTERVAL", 0)
+	useIpv4 := zonesConfigured("IPV4")
+	useIpv6 := zonesConfigured("IPV6")
 
-	var ticker *time.Ticker
+	var normal schedule.Schedule = schedule.Every(interval)
 
-	if interval != "" {
-		v, err := time.ParseDuration(interval)
+	if expr := os.Getenv("FRITZBOX_ENDPOINT_SCHEDULE"); expr != "" {
+		s, err := schedule.Parse(expr, time.Local)
 
 		if err != nil {
-			slog.Warn("Failed to parse FRITZBOX_ENDPOINT_INTERVAL, using defaults", logging.ErrorAttr(err))
-			ticker = time.NewTicker(300 * time.Second)
-		} else {
-			ticker = time.NewTicker(v)
+			slog.Error("Failed to parse FRITZBOX_ENDPOINT_SCHEDULE, disabling polling", logging.ErrorAttr(err))
+			return
 		}
-	} else {
-		slog.Info("Env FRITZBOX_ENDPOINT_INTERVAL not found, disabling polling")
+
+		normal = s
+		// The longest pause between two polls stands in for the interval
+		interval = schedule.MaxGap(s, time.Now())
+	}
+
+	if interval == 0 {
+		slog.Info("Env FRITZBOX_ENDPOINT_INTERVAL or FRITZBOX_ENDPOINT_SCHEDULE not found, disabling polling")
 		return
 	}
 
+	// Poll more often while updates fail, so the current IPs are published soon
+	// after the provider recovered
+	retryInterval := min(envDuration("FRITZBOX_ENDPOINT_RETRY_INTERVAL", 30*time.Second), interval)
+
+	// Spreads the polls of many instances started at the same time, i.e. after
+	// a power outage, instead of all of them asking the router and the
+	// providers at the same second
+	jitter := min(envDuration("FRITZBOX_ENDPOINT_JITTER", 0), interval)
+
+	ticker := schedule.NewTicker(schedule.Adaptive{
+		Normal:     schedule.Jitter{Schedule: normal, Max: jitter},
+		Degraded:   schedule.Jitter{Schedule: schedule.Every(retryInterval), Max: min(jitter, retryInterval)},
+		IsDegraded: failing,
+	})
+
+	poll := newPoller(fritzbox, out, prefixes, localIp, useIpv4, useIpv6)
+
+	// Signals a reconnect of the router, so the new IPs are polled right away
+	recheck := make(chan struct{}, 1)
+
+	if status, ok := fritzbox.(avm.StatusSource); ok {
+		if useIpv4 && dsLite != nil {
+			poll = withDsLite(poll, status, dsLite)
+		}
+
+		if statusInterval := envDuration("FRITZBOX_ENDPOINT_STATUS_INTERVAL", 30*time.Second); statusInterval > 0 && statusInterval < interval {
+			go watchReconnects(status, statusInterval, recheck)
+		}
+	}
+
+	// The loop hangs if the router or an updater stops responding, the IPs
+	// are stale once the polls keep failing
+	alive := checks.Live("poll", 3*interval)
+	ready := checks.Ready("poll", envDuration("HEALTH_POLL_MAX_AGE", 3*interval))
+
+	run := func() {
+		err := poll()
+		alive.Ok()
+
+		if err != nil {
+			ready.Fail(err)
+		} else {
+			ready.Ok()
+			metrics.LastPoll.Set(float64(time.Now().Unix()))
+		}
+	}
+
 	go func() {
-		lastV4 := net.IP{}
-		lastV6 := net.IP{}
-
-		poll := func() {
-			slog.Debug("Polling WAN IPs from router")
-
-			if useIpv4 {
-				ipv4, err := fritzbox.GetWanIpv4()
-
-				if err != nil {
-					slog.Warn("Failed to poll WAN IPv4 from router", logging.ErrorAttr(err))
-				} else {
-					out <- &ipv4
-					if !lastV4.Equal(ipv4) {
-						slog.Info("New WAN IPv4 found", slog.Any("ipv4", ipv4))
-						lastV4 = ipv4
-					}
+		if jitter > 0 {
+			delay := rand.N(jitter)
+			slog.Debug("Delaying the first poll", slog.Duration("delay", delay))
+			time.Sleep(delay)
+		}
+
+		run()
+
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-recheck:
+				run()
+			}
+		}
+	}()
+}
+
+// watchReconnects polls the connection status every interval and signals
+// recheck once the router is connected again or its uptime was reset, i.e.
+// after the nightly forced reconnect.
+func watchReconnects(status avm.StatusSource, interval time.Duration, recheck chan<- struct{}) {
+	var last *avm.ConnectionStatus
+
+	ticker := time.NewTicker(interval)
+
+	for range ticker.C {
+		s, err := status.GetConnectionStatus()
+
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Debug("Router can't report the connection status, not watching for reconnects", logging.ErrorAttr(err))
+			ticker.Stop()
+			return
+		}
+
+		if err != nil {
+			slog.Debug("Failed to poll the connection status from router", logging.ErrorAttr(err))
+			continue
+		}
+
+		reconnected := last != nil && s.Status == "Connected" && (last.Status != "Connected" || s.Uptime < last.Uptime)
+		last = s
+
+		if !reconnected {
+			continue
+		}
+
+		slog.Info("Router reconnected, polling the WAN IPs", slog.Duration("uptime", s.Uptime))
+
+		select {
+		case recheck <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// withDsLite reports whether the connection is DS-Lite before every poll.
+func withDsLite(poll func() error, status avm.StatusSource, dsLite func(bool)) func() error {
+	return func() error {
+		s, err := status.GetConnectionStatus()
+
+		if err != nil {
+			slog.Debug("Failed to poll the connection status from router", logging.ErrorAttr(err))
+		} else {
+			dsLite(s.DsLite)
+		}
+
+		return poll()
+	}
+}
+
+// newPoller creates a function polling the WAN IPs from the router and relaying
+// them to out, IPv6 addresses get constructed from the prefix if localIp is set.
+// The prefix itself is relayed to prefixes unless it is nil. It returns the
+// failures of the poll.
+func newPoller(fritzbox avm.WanSource, out chan<- *net.IP, prefixes chan<- *net.IPNet, localIp *net.IP, useIpv4 bool, useIpv6 bool) func() error {
+	lastV4 := net.IP{}
+	lastV6 := net.IP{}
+
+	return func() error {
+		slog.Debug("Polling WAN IPs from router")
+
+		var errs []error
+
+		if useIpv4 {
+			ipv4, err := fritzbox.GetWanIpv4()
+
+			if err != nil {
+				slog.Warn("Failed to poll WAN IPv4 from router", logging.ErrorAttr(err))
+				metrics.PollFailures.Inc("ipv4")
+				errs = append(errs, err)
+			} else {
+				out <- &ipv4
+				if !lastV4.Equal(ipv4) {
+					slog.Info("New WAN IPv4 found", slog.String("source", "poll"), slog.Any("ip", ipv4))
+					lastV4 = ipv4
 				}
 			}
+		}
 
-			if *localIp == nil && useIpv6 {
-				ipv6, err := fritzbox.GetwanIpv6()
+		if *localIp == nil && useIpv6 {
+			ipv6, err := fritzbox.GetwanIpv6()
 
-				if err != nil {
-					slog.Warn("Failed to poll WAN IPv6 from router", logging.ErrorAttr(err))
-				} else {
-					if !lastV6.Equal(ipv6) {
-						slog.Info("New WAN IPv6 found", slog.Any("ipv6", ipv6))
-						out <- &ipv6
-						lastV6 = ipv6
-					}
+			if errors.Is(err, errors.ErrUnsupported) {
+				slog.Debug("Router can't report the WAN IPv6", logging.ErrorAttr(err))
+			} else if err != nil {
+				slog.Warn("Failed to poll WAN IPv6 from router", logging.ErrorAttr(err))
+				metrics.PollFailures.Inc("ipv6")
+				errs = append(errs, err)
+			} else {
+				if !lastV6.Equal(ipv6) {
+					slog.Info("New WAN IPv6 found", slog.String("source", "poll"), slog.Any("ip", ipv6))
+					out <- &ipv6
+					lastV6 = ipv6
 				}
-			} else if useIpv6 {
-				prefix, err := fritzbox.GetIpv6Prefix()
-
-				if err != nil {
-					slog.Warn("Failed to poll IPv6 Prefix from router", logging.ErrorAttr(err))
-				} else {
-					constructedIp := make(net.IP, net.IPv6len)
-					copy(constructedIp, prefix.IP)
-
-					maskLen, _ := prefix.Mask.Size()
-
-					for i := 0; i < net.IPv6len; i++ {
-						b := constructedIp[i]
-						lb := (*localIp)[i]
-						var mask byte = 0b00000000
-						for j := 0; j < 8; j++ {
-							if (i*8 + j) >= maskLen {
-								mask += 0b00000001 << (7 - j)
-							}
-						}
-						b += lb & mask
-						constructedIp[i] = b
-					}
+			}
+		}
 
-					slog.Info("New IPv6 Prefix found", slog.Any("prefix", prefix), slog.Any("ipv6", constructedIp))
+		if (*localIp != nil && useIpv6) || prefixes != nil {
+			prefix, err := fritzbox.GetIpv6Prefix()
 
-					out <- &constructedIp
+			if errors.Is(err, errors.ErrUnsupported) {
+				slog.Debug("Router can't report the IPv6 Prefix", logging.ErrorAttr(err))
+			} else if err != nil {
+				slog.Warn("Failed to poll IPv6 Prefix from router", logging.ErrorAttr(err))
+				metrics.PollFailures.Inc("prefix")
+				errs = append(errs, err)
+			} else if prefix != nil {
+				if prefixes != nil {
+					prefixes <- prefix
+				}
+
+				if *localIp != nil && useIpv6 {
+					constructedIp := ipv6.FromPrefix(prefix, *localIp)
 
 					if !lastV6.Equal(prefix.IP) {
+						slog.Info("New IPv6 Prefix found", slog.String("source", "poll"), slog.Any("prefix", prefix), slog.Any("ip", constructedIp))
 						lastV6 = prefix.IP
+					} else {
+						slog.Debug("IPv6 Prefix unchanged", slog.String("source", "poll"), slog.Any("prefix", prefix), slog.Any("ip", constructedIp))
 					}
+
+					out <- &constructedIp
 				}
 			}
 		}
 
-		poll()
-
-		for {
-			select {
-			case <-ticker.C:
-				poll()
-			}
-		}
-	}()
+		return errors.Join(errs...)
+	}
 }
@@ -0,0 +1,173 @@
+//go:build !nomigrate && !slim
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/migrate"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envFile collects variables in the order they were first set.
+type envFile struct {
+	names    []string
+	values   map[string][]string
+	comments []string
+}
+
+// add appends the values to the comma-separated list of the variable.
+func (e *envFile) add(name string, values ...string) {
+	if e.values == nil {
+		e.values = make(map[string][]string)
+	}
+
+	if _, ok := e.values[name]; !ok {
+		e.names = append(e.names, name)
+	}
+
+	for _, value := range values {
+		if !contains(e.values[name], value) {
+			e.values[name] = append(e.values[name], value)
+		}
+	}
+}
+
+func (e *envFile) comment(format string, args ...any) {
+	e.comments = append(e.comments, fmt.Sprintf(format, args...))
+}
+
+func (e *envFile) write(w io.Writer) {
+	for _, comment := range e.comments {
+		fmt.Fprintf(w, "# %s\n", comment)
+	}
+
+	for _, name := range e.names {
+		fmt.Fprintf(w, "%s=%s\n", name, strings.Join(e.values[name], ","))
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runMigrate converts the configuration of another dynamic DNS client to
+// environment variables for this service.
+func runMigrate(args []string) bool {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := flags.String("from", "", "client to migrate from, ddclient or inadyn")
+
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, "Usage: fritzbox-cloudflare-dyndns migrate --from ddclient|inadyn <path>\n")
+		return false
+	}
+
+	f, err := os.Open(flags.Arg(0))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	defer f.Close()
+
+	var services []*migrate.Service
+
+	switch *from {
+	case "ddclient":
+		services, err = migrate.ParseDdclient(f)
+	case "inadyn":
+		services, err = migrate.ParseInadyn(f)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown client %q, expected ddclient or inadyn\n", *from)
+		return false
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	env := &envFile{}
+	env.comment("Migrated from %s (%s)", *from, flags.Arg(0))
+
+	for _, service := range services {
+		migrateService(env, service)
+	}
+
+	env.write(os.Stdout)
+
+	return true
+}
+
+func migrateService(env *envFile, s *migrate.Service) {
+	records := s.Records()
+
+	zones := func(prefix string) {
+		if s.IPv4 {
+			env.add(prefix+"_ZONES_IPV4", records...)
+		}
+
+		if s.IPv6 {
+			env.add(prefix+"_ZONES_IPV6", records...)
+		}
+	}
+
+	switch s.Protocol {
+	case "cloudflare":
+		if s.Proxied {
+			for i, record := range records {
+				records[i] = record + ":proxied"
+			}
+		}
+
+		// Tokens are used with the login `token`, or none at all
+		if strings.Contains(s.Login, "@") {
+			env.add("CLOUDFLARE_API_EMAIL", s.Login)
+			env.add("CLOUDFLARE_API_KEY", s.Password)
+		} else {
+			env.add("CLOUDFLARE_API_TOKEN", s.Password)
+		}
+
+		zones("CLOUDFLARE")
+
+		// Cloudflare uses a TTL of 1 for automatic
+		if s.TTL > 1 {
+			env.add("CLOUDFLARE_TTL", strconv.Itoa(s.TTL))
+		}
+	case "namecheap":
+		if passwords := env.values["NAMECHEAP_DDNS_PASSWORD"]; len(passwords) > 0 && !contains(passwords, s.Password) {
+			env.comment("Namecheap domains with different passwords are not supported, skipped %s", strings.Join(records, ","))
+			return
+		}
+
+		env.add("NAMECHEAP_DDNS_PASSWORD", s.Password)
+
+		if s.IPv4 {
+			env.add("NAMECHEAP_ZONES_IPV4", records...)
+		}
+
+		if s.IPv6 {
+			env.comment("Namecheap only supports IPv4, skipped IPv6 for %s", strings.Join(records, ","))
+		}
+	case "infomaniak":
+		// The DynDNS credentials can't be used with the API
+		env.comment("Create an Infomaniak API token for INFOMANIAK_API_TOKEN, the DynDNS credentials of %s can't be used", strings.Join(records, ","))
+		env.add("INFOMANIAK_API_TOKEN")
+		zones("INFOMANIAK")
+	case "dnsimple":
+		env.add("DNSIMPLE_API_TOKEN", s.Password)
+		zones("DNSIMPLE")
+	default:
+		env.comment("Provider %q is not supported, skipped %s", s.Protocol, strings.Join(records, ","))
+	}
+}
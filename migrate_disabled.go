@@ -0,0 +1,13 @@
+//go:build nomigrate || slim
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runMigrate(_ []string) bool {
+	fmt.Fprint(os.Stderr, "The migrate command is not included in this build\n")
+	return false
+}
@@ -0,0 +1,156 @@
+package avm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// link is a source of the chain with the name it is logged as.
+type link struct {
+	name   string
+	source WanSource
+}
+
+// Chain polls several sources in priority order, i.e. cascaded boxes, the
+// master and a repeater of a mesh or the router and a public service. Once a
+// source failed Failures times in a row the next one is asked, until it
+// answers again.
+type Chain struct {
+	links []link
+	// Failures in a row after which the next source is asked, 1 to ask it
+	// right away
+	Failures int
+
+	mutex    sync.Mutex
+	current  string
+	failures map[string]int
+
+	log *slog.Logger
+}
+
+func NewChain(log *slog.Logger) *Chain {
+	return &Chain{
+		Failures: 1,
+		failures: map[string]int{},
+		log:      log.With(slog.String("module", "avm")),
+	}
+}
+
+// Add appends a source with a lower priority than all previous ones.
+func (c *Chain) Add(name string, source WanSource) {
+	c.links = append(c.links, link{name: name, source: source})
+}
+
+// Current returns the name of the source that answered last.
+func (c *Chain) Current() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.current
+}
+
+func (c *Chain) GetWanIpv4() (net.IP, error) {
+	return poll(c, "IPv4", WanSource.GetWanIpv4)
+}
+
+func (c *Chain) GetwanIpv6() (net.IP, error) {
+	return poll(c, "IPv6", WanSource.GetwanIpv6)
+}
+
+func (c *Chain) GetIpv6Prefix() (*net.IPNet, error) {
+	return poll(c, "IPv6 prefix", WanSource.GetIpv6Prefix)
+}
+
+func poll[T any](c *Chain, what string, get func(WanSource) (T, error)) (T, error) {
+	var errs []error
+
+	for i, l := range c.links {
+		v, err := get(l.source)
+
+		if err == nil {
+			c.answered(l.name, what)
+			c.log.Debug("Polled "+what, slog.String("source", l.name), slog.Any("value", v))
+
+			return v, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", l.name, err))
+
+		// Sources that don't know the value at all are skipped right away
+		if errors.Is(err, errors.ErrUnsupported) || i == len(c.links)-1 {
+			continue
+		}
+
+		if c.failed(l.name, what) < c.Failures {
+			c.log.Debug("Source failed, retrying it before falling back", slog.String("source", l.name), logging.ErrorAttr(err))
+			break
+		}
+
+		c.log.Debug("Source failed, asking the next one", slog.String("source", l.name), logging.ErrorAttr(err))
+	}
+
+	var zero T
+	return zero, errors.Join(errs...)
+}
+
+// failed counts a failure of the source and returns the failures in a row.
+func (c *Chain) failed(name string, what string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := name + " " + what
+	c.failures[key]++
+
+	if c.failures[key] == c.Failures {
+		c.log.Warn("Source keeps failing, falling back to the next one", slog.String("source", name), slog.String("value", what), slog.Int("failures", c.failures[key]))
+	}
+
+	return c.failures[key]
+}
+
+// answered resets the failures of the source and logs when another source
+// than before answers.
+func (c *Chain) answered(name string, what string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.failures, name+" "+what)
+
+	if c.current == name {
+		return
+	}
+
+	if c.current != "" {
+		c.log.Info("Polling another source", slog.String("source", name), slog.String("previous", c.current))
+	}
+
+	c.current = name
+}
+
+// GetConnectionStatus asks the sources knowing the state of the connection
+// in order, without counting their failures.
+func (c *Chain) GetConnectionStatus() (*ConnectionStatus, error) {
+	var errs []error
+
+	for _, l := range c.links {
+		if source, ok := l.source.(StatusSource); ok {
+			status, err := source.GetConnectionStatus()
+
+			if err == nil {
+				return status, nil
+			}
+
+			errs = append(errs, fmt.Errorf("%s: %w", l.name, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("connection status: %w", errors.ErrUnsupported)
+	}
+
+	return nil, errors.Join(errs...)
+}
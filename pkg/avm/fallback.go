@@ -0,0 +1,83 @@
+package avm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+)
+
+// Fallback asks the secondary source whenever the primary one fails, i.e. the
+// web interface if SOAP is disabled.
+type Fallback struct {
+	Primary   WanSource
+	Secondary WanSource
+
+	log *slog.Logger
+}
+
+func NewFallback(primary WanSource, secondary WanSource, log *slog.Logger) *Fallback {
+	return &Fallback{
+		Primary:   primary,
+		Secondary: secondary,
+		log:       log.With(slog.String("module", "avm")),
+	}
+}
+
+func (f *Fallback) GetWanIpv4() (net.IP, error) {
+	ip, err := f.Primary.GetWanIpv4()
+
+	if err != nil {
+		f.log.Debug("Primary source failed, falling back", logging.ErrorAttr(err))
+		return f.Secondary.GetWanIpv4()
+	}
+
+	return ip, nil
+}
+
+func (f *Fallback) GetwanIpv6() (net.IP, error) {
+	ip, err := f.Primary.GetwanIpv6()
+
+	if err != nil {
+		f.log.Debug("Primary source failed, falling back", logging.ErrorAttr(err))
+		return f.Secondary.GetwanIpv6()
+	}
+
+	return ip, nil
+}
+
+func (f *Fallback) GetIpv6Prefix() (*net.IPNet, error) {
+	prefix, err := f.Primary.GetIpv6Prefix()
+
+	if err != nil {
+		f.log.Debug("Primary source failed, falling back", logging.ErrorAttr(err))
+		return f.Secondary.GetIpv6Prefix()
+	}
+
+	return prefix, nil
+}
+
+// GetConnectionStatus asks the first source knowing the state of the
+// connection.
+func (f *Fallback) GetConnectionStatus() (*ConnectionStatus, error) {
+	err := fmt.Errorf("connection status: %w", errors.ErrUnsupported)
+
+	if primary, ok := f.Primary.(StatusSource); ok {
+		var status *ConnectionStatus
+
+		status, err = primary.GetConnectionStatus()
+
+		if err == nil {
+			return status, nil
+		}
+
+		f.log.Debug("Primary source failed, falling back", logging.ErrorAttr(err))
+	}
+
+	if secondary, ok := f.Secondary.(StatusSource); ok {
+		return secondary.GetConnectionStatus()
+	}
+
+	return nil, err
+}
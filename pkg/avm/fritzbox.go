@@ -125,3 +125,41 @@ func (fb *FritzBox) GetIpv6Prefix() (*net.IPNet, error) {
 
 	return ipNet, nil
 }
+
+// GetConnectionStatus returns the status and uptime of the WAN connection, the
+// anonymous endpoint can't tell whether it's DS-Lite.
+func (fb *FritzBox) GetConnectionStatus() (*ConnectionStatus, error) {
+	body := fmt.Sprintf(soapAction, "GetStatusInfo", "urn:schemas-upnp-org:service:WANIPConnection:1")
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/igdupnp/control/WANIPConn1", fb.Url), bytes.NewBufferString(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "text/xml; charset=utf-8;")
+	request.Header.Set("SoapAction", "urn:schemas-upnp-org:service:WANIPConnection:1#GetStatusInfo")
+
+	client := &http.Client{
+		Timeout: fb.Timeout,
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetStatusInfo responded with %s", response.Status)
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGetStatusInfo(responseBody)
+}
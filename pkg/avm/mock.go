@@ -0,0 +1,118 @@
+package avm
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+const soapResponse string = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/" xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+    <s:Body>
+        <u:%[1]sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+%[2]s
+        </u:%[1]sResponse>
+    </s:Body>
+</s:Envelope>
+`
+
+const mockSessionInfo string = `<?xml version="1.0" encoding="utf-8"?>
+<SessionInfo><SID>%s</SID><Challenge>2$10$5a1711$10$5a1722</Challenge><BlockTime>0</BlockTime><Users><User last="1">mock</User></Users></SessionInfo>
+`
+
+const mockIgdDescription string = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/igdupnp/control/WANIPConn1</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>
+`
+
+const mockTr064Description string = `<?xml version="1.0"?>
+<root xmlns="urn:dslforum-org:device-1-0">
+<device>
+<deviceType>urn:dslforum-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:dslforum-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:dslforum-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:dslforum-org:service:WANIPConnection:1</serviceType>
+<controlURL>/tr064/control/WANIPConnection</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>
+`
+
+// NewMock starts a fake FritzBox answering the SOAP actions used by FritzBox
+// and the pages used by WebUi with the given addresses, it has to be closed
+// after use. Any login to the web interface succeeds. The UPnP description is
+// served at /igddesc.xml like the real router does, the TR-064 description at
+// /tr64desc.xml has the control URLs of another vendor.
+func NewMock(ipv4 net.IP, ipv6 net.IP, prefix *net.IPNet) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login_sid.lua":
+			sid := emptySid
+
+			if r.FormValue("response") != "" {
+				sid = "5a17000000000001"
+			}
+
+			w.Header().Set("Content-Type", "text/xml")
+			_, _ = fmt.Fprintf(w, mockSessionInfo, sid)
+			return
+		case "/igddesc.xml":
+			w.Header().Set("Content-Type", "text/xml")
+			_, _ = fmt.Fprint(w, mockIgdDescription)
+			return
+		case "/tr64desc.xml":
+			w.Header().Set("Content-Type", "text/xml")
+			_, _ = fmt.Fprint(w, mockTr064Description)
+			return
+		case "/data.lua":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"sid":%q,"data":{"connections":[{"ipv4":{"ip":%q},"ipv6":{"ip":%q,"prefix":%q}}]}}`, r.FormValue("sid"), ipv4, ipv6, prefix)
+			return
+		}
+
+		// UPnP clients quote the header
+		_, action, _ := strings.Cut(strings.Trim(r.Header.Get("SoapAction"), `"`), "#")
+
+		var body string
+
+		switch action {
+		case "GetExternalIPAddress":
+			body = fmt.Sprintf("<NewExternalIPAddress>%s</NewExternalIPAddress>", ipv4)
+		case "X_AVM_DE_GetExternalIPv6Address":
+			body = fmt.Sprintf("<NewExternalIPv6Address>%s</NewExternalIPv6Address><NewPrefixLength>64</NewPrefixLength><NewValidLifetime>3600</NewValidLifetime>", ipv6)
+		case "GetStatusInfo":
+			body = "<NewConnectionStatus>Connected</NewConnectionStatus><NewLastConnectionError>ERROR_NONE</NewLastConnectionError><NewUptime>3600</NewUptime>"
+		case "X_AVM_DE_GetDSLiteStatus":
+			body = "<NewX_AVM_DE_DSLiteStatus>0</NewX_AVM_DE_DSLiteStatus>"
+		case "X_AVM_DE_GetIPv6Prefix":
+			length, _ := prefix.Mask.Size()
+			body = fmt.Sprintf("<NewIPv6Prefix>%s</NewIPv6Prefix><NewPrefixLength>%d</NewPrefixLength><NewValidLifetime>3600</NewValidLifetime>", prefix.IP, length)
+		default:
+			http.Error(w, "unknown action", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/xml; charset=\"utf-8\"")
+		_, _ = fmt.Fprintf(w, soapResponse, action, body)
+	}))
+}
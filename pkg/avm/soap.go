@@ -5,6 +5,8 @@ import (
 	"errors"
 	"gopkg.in/xmlpath.v2"
 	"net"
+	"strconv"
+	"time"
 )
 
 func parseGetExternalIPAddressResponse(xml []byte) (net.IP, error) {
@@ -112,3 +114,21 @@ func parseGetIPv6Prefix(xml []byte) (*net.IPNet, error) {
 
 	return ipNet, nil
 }
+
+func parseGetStatusInfo(xml []byte) (*ConnectionStatus, error) {
+	root, err := xmlpath.Parse(bytes.NewBuffer(xml))
+
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ConnectionStatus{}
+	status.Status, _ = xmlpath.MustCompile("//NewConnectionStatus").String(root)
+
+	if v, ok := xmlpath.MustCompile("//NewUptime").String(root); ok {
+		seconds, _ := strconv.Atoi(v)
+		status.Uptime = time.Duration(seconds) * time.Second
+	}
+
+	return status, nil
+}
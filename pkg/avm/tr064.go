@@ -0,0 +1,487 @@
+package avm
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const soapAction string = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/" xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+    <s:Body>
+        <u:%s xmlns:u="%s" />
+    </s:Body>
+</s:Envelope>
+`
+
+// Tr064Service is a TR-064 service with the URL it is controlled at.
+type Tr064Service struct {
+	ControlUrl  string
+	ServiceType string
+}
+
+// Tr064Profile describes the services a router offers over TR-064, which
+// differ between the vendors.
+type Tr064Profile struct {
+	// Description is the path of the device description, the control URLs of
+	// the connections are looked up in it if set
+	Description   string
+	IpConnection  Tr064Service
+	PppConnection Tr064Service
+	// Ipv6Action, PrefixAction and DsLiteAction are vendor actions of the IP
+	// connection, empty if the router has none
+	Ipv6Action   string
+	PrefixAction string
+	DsLiteAction string
+}
+
+// Tr064Profiles are the known profiles by name. Generic works with any router
+// implementing the standard, i.e. a Telekom Speedport, some Zyxel and Draytek,
+// but only knows the IPv4.
+var Tr064Profiles = map[string]Tr064Profile{
+	"fritzbox": {
+		IpConnection:  Tr064Service{"/upnp/control/wanipconnection1", "urn:dslforum-org:service:WANIPConnection:1"},
+		PppConnection: Tr064Service{"/upnp/control/wanpppconn1", "urn:dslforum-org:service:WANPPPConnection:1"},
+		Ipv6Action:    "X_AVM_DE_GetExternalIPv6Address",
+		PrefixAction:  "X_AVM_DE_GetIPv6Prefix",
+		DsLiteAction:  "X_AVM_DE_GetDSLiteStatus",
+	},
+	"generic": {
+		Description:   "/tr64desc.xml",
+		IpConnection:  Tr064Service{ServiceType: "urn:dslforum-org:service:WANIPConnection:1"},
+		PppConnection: Tr064Service{ServiceType: "urn:dslforum-org:service:WANPPPConnection:1"},
+	},
+}
+
+// ConnectionStatus describes the WAN connection of the router.
+type ConnectionStatus struct {
+	// Status is i.e. Connected, Connecting or Disconnected
+	Status string
+	Uptime time.Duration
+	// DsLite is false as well if the router doesn't report it
+	DsLite bool
+}
+
+// StatusSource is implemented by the sources knowing the state of the WAN
+// connection.
+type StatusSource interface {
+	GetConnectionStatus() (*ConnectionStatus, error)
+}
+
+// Tr064 talks to the TR-064 API of the router with a username and password,
+// which works with the anonymous igdupnp endpoints disabled and offers more
+// services, like the connection status.
+type Tr064 struct {
+	Url      string
+	Username string
+	Password string
+	Timeout  time.Duration
+	// Insecure skips verifying the self-signed certificate of the router
+	Insecure bool
+	Profile  Tr064Profile
+
+	mu          sync.Mutex
+	connection  *Tr064Service
+	connections []Tr064Service
+}
+
+func NewTr064() *Tr064 {
+	return &Tr064{
+		Url:     "https://fritz.box:49443",
+		Timeout: 5 * time.Second,
+		Profile: Tr064Profiles["fritzbox"],
+	}
+}
+
+func (t *Tr064) GetWanIpv4() (net.IP, error) {
+	connection, err := t.wanConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.call(connection, "GetExternalIPAddress")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGetExternalIPAddressResponse(body)
+}
+
+func (t *Tr064) GetwanIpv6() (net.IP, error) {
+	if t.Profile.Ipv6Action == "" {
+		return nil, fmt.Errorf("WAN IPv6 over TR-064: %w", errors.ErrUnsupported)
+	}
+
+	connection, err := t.ipConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.call(connection, t.Profile.Ipv6Action)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGetExternalIPv6Address(body)
+}
+
+func (t *Tr064) GetIpv6Prefix() (*net.IPNet, error) {
+	if t.Profile.PrefixAction == "" {
+		return nil, fmt.Errorf("IPv6 prefix over TR-064: %w", errors.ErrUnsupported)
+	}
+
+	connection, err := t.ipConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.call(connection, t.Profile.PrefixAction)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGetIPv6Prefix(body)
+}
+
+// GetConnectionStatus returns the status and uptime of the WAN connection.
+func (t *Tr064) GetConnectionStatus() (*ConnectionStatus, error) {
+	connection, err := t.wanConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.call(connection, "GetStatusInfo")
+
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := parseGetStatusInfo(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Profile.DsLiteAction == "" {
+		return status, nil
+	}
+
+	ipConnection, err := t.ipConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Older firmware doesn't know the action
+	if body, err := t.call(ipConnection, t.Profile.DsLiteAction); err == nil {
+		status.DsLite = elementContaining(body, "dslite") == "1"
+	}
+
+	return status, nil
+}
+
+// wanConnection finds the service of the WAN connection, DSL connections are
+// PPP connections while cable and fiber ones are IP connections.
+func (t *Tr064) wanConnection() (Tr064Service, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.connection != nil {
+		return *t.connection, nil
+	}
+
+	connections, err := t.services()
+
+	if err != nil {
+		return Tr064Service{}, err
+	}
+
+	var errs []error
+
+	// PPP comes first, as DSL routers often have an unused IP connection
+	for _, connection := range []Tr064Service{connections[1], connections[0]} {
+		body, err := t.call(connection, "GetStatusInfo")
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if elementContaining(body, "connectionstatus") == "Connected" {
+			t.connection = &connection
+			return connection, nil
+		}
+	}
+
+	if len(errs) == 2 {
+		return Tr064Service{}, errors.Join(errs...)
+	}
+
+	// Not connected right now, so we can't tell and try again next time
+	return connections[0], nil
+}
+
+// ipConnection returns the service of the IP connection, which the vendor
+// actions belong to.
+func (t *Tr064) ipConnection() (Tr064Service, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	connections, err := t.services()
+
+	if err != nil {
+		return Tr064Service{}, err
+	}
+
+	return connections[0], nil
+}
+
+// services returns the IP and the PPP connection of the profile, with the
+// control URLs of the device description if the profile has one. It has to
+// be called with the lock held.
+func (t *Tr064) services() ([]Tr064Service, error) {
+	if t.connections != nil {
+		return t.connections, nil
+	}
+
+	connections := []Tr064Service{t.Profile.IpConnection, t.Profile.PppConnection}
+
+	if t.Profile.Description == "" {
+		t.connections = connections
+		return connections, nil
+	}
+
+	controlUrls, err := t.controlUrls()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, connection := range connections {
+		if controlUrl, ok := controlUrls[connection.ServiceType]; ok {
+			connections[i].ControlUrl = controlUrl
+		}
+	}
+
+	t.connections = connections
+
+	return connections, nil
+}
+
+type tr064ServiceDescription struct {
+	ServiceType string `xml:"serviceType"`
+	ControlUrl  string `xml:"controlURL"`
+}
+
+type tr064Device struct {
+	Services []tr064ServiceDescription `xml:"serviceList>service"`
+	Devices  []tr064Device             `xml:"deviceList>device"`
+}
+
+// controlUrls reads the device description and returns the control URLs by
+// service type.
+func (t *Tr064) controlUrls() (map[string]string, error) {
+	client := &http.Client{
+		Timeout: t.Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: t.Insecure},
+		},
+	}
+
+	response, err := client.Get(t.Url + t.Profile.Description)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TR-064 description %s responded with %s", t.Profile.Description, response.Status)
+	}
+
+	var description struct {
+		Device tr064Device `xml:"device"`
+	}
+
+	err = xml.NewDecoder(response.Body).Decode(&description)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the TR-064 description: %w", err)
+	}
+
+	controlUrls := make(map[string]string)
+
+	var walk func(d tr064Device)
+	walk = func(d tr064Device) {
+		for _, s := range d.Services {
+			if _, ok := controlUrls[s.ServiceType]; !ok && s.ControlUrl != "" {
+				controlUrls[s.ServiceType] = "/" + strings.TrimLeft(s.ControlUrl, "/")
+			}
+		}
+
+		for _, child := range d.Devices {
+			walk(child)
+		}
+	}
+
+	walk(description.Device)
+
+	return controlUrls, nil
+}
+
+// call invokes the action, answering the digest challenge of the router.
+func (t *Tr064) call(service Tr064Service, action string) ([]byte, error) {
+	if service.ControlUrl == "" {
+		return nil, fmt.Errorf("router doesn't offer %s", service.ServiceType)
+	}
+
+	client := &http.Client{
+		Timeout: t.Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: t.Insecure},
+		},
+	}
+
+	response, err := t.do(client, service, action, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized {
+		_ = response.Body.Close()
+
+		authorization, err := digestAuthorization(response.Header.Get("WWW-Authenticate"), "POST", service.ControlUrl, t.Username, t.Password)
+
+		if err != nil {
+			return nil, err
+		}
+
+		response, err = t.do(client, service, action, authorization)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusUnauthorized:
+		return nil, errors.New("TR-064 login failed, check username and password")
+	default:
+		return nil, fmt.Errorf("TR-064 action %s failed with %s", action, response.Status)
+	}
+}
+
+func (t *Tr064) do(client *http.Client, service Tr064Service, action string, authorization string) (*http.Response, error) {
+	request, err := http.NewRequest("POST", t.Url+service.ControlUrl, bytes.NewBufferString(fmt.Sprintf(soapAction, action, service.ServiceType)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "text/xml; charset=utf-8;")
+	request.Header.Set("SoapAction", service.ServiceType+"#"+action)
+
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	return client.Do(request)
+}
+
+// digestAuthorization answers an HTTP digest challenge (RFC 2617) with MD5.
+func digestAuthorization(challenge string, method string, uri string, username string, password string) (string, error) {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("unsupported authentication %q", challenge)
+	}
+
+	params := make(map[string]string)
+
+	for _, part := range strings.Split(rest, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+
+	cnonce := make([]byte, 8)
+
+	if _, err := rand.Read(cnonce); err != nil {
+		return "", err
+	}
+
+	hash := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	ha1 := hash(username + ":" + params["realm"] + ":" + password)
+	ha2 := hash(method + ":" + uri)
+
+	authorization := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=MD5`, username, params["realm"], params["nonce"], uri)
+
+	if params["qop"] == "" {
+		return authorization + fmt.Sprintf(`, response=%q`, hash(ha1+":"+params["nonce"]+":"+ha2)), nil
+	}
+
+	nc := "00000001"
+	cn := hex.EncodeToString(cnonce)
+	response := hash(strings.Join([]string{ha1, params["nonce"], nc, cn, "auth", ha2}, ":"))
+
+	return authorization + fmt.Sprintf(`, qop=auth, nc=%s, cnonce=%q, response=%q`, nc, cn, response), nil
+}
+
+// elementContaining returns the text of the first element whose name contains
+// the given lowercase string, for elements named differently by firmware versions.
+func elementContaining(body []byte, name string) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		token, err := decoder.Token()
+
+		if err != nil {
+			return ""
+		}
+
+		if start, ok := token.(xml.StartElement); ok && strings.Contains(strings.ToLower(start.Name.Local), name) {
+			var text string
+
+			if decoder.DecodeElement(&text, &start) == nil {
+				return strings.TrimSpace(text)
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package avm
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// emptySid is returned by the router if the login failed.
+const emptySid = "0000000000000000"
+
+// WanSource provides the WAN IPs of a router.
+type WanSource interface {
+	GetWanIpv4() (net.IP, error)
+	GetwanIpv6() (net.IP, error)
+	GetIpv6Prefix() (*net.IPNet, error)
+}
+
+// WebUi reads the WAN IPs from the data.lua interface of the web interface, for
+// routers with UPnP and TR-064 disabled. It logs in with the challenge-response
+// login of login_sid.lua and keeps the session until it expires.
+type WebUi struct {
+	Url      string
+	Username string
+	Password string
+	Timeout  time.Duration
+
+	mu  sync.Mutex
+	sid string
+}
+
+func NewWebUi() *WebUi {
+	return &WebUi{
+		Url:     "http://fritz.box",
+		Timeout: 5 * time.Second,
+	}
+}
+
+type sessionInfo struct {
+	Sid       string   `xml:"SID"`
+	Challenge string   `xml:"Challenge"`
+	BlockTime int      `xml:"BlockTime"`
+	Users     []string `xml:"Users>User"`
+}
+
+func (w *WebUi) GetWanIpv4() (net.IP, error) {
+	data, err := w.netMonitor()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(findString(data, "ipv4", "ip"))
+
+	if ip == nil || ip.To4() == nil {
+		return nil, errors.New("no IPv4 found in the web interface")
+	}
+
+	return ip, nil
+}
+
+func (w *WebUi) GetwanIpv6() (net.IP, error) {
+	data, err := w.netMonitor()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(findString(data, "ipv6", "ip"))
+
+	if ip == nil || ip.To4() != nil {
+		return nil, errors.New("no IPv6 found in the web interface")
+	}
+
+	return ip, nil
+}
+
+func (w *WebUi) GetIpv6Prefix() (*net.IPNet, error) {
+	data, err := w.netMonitor()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, prefix, err := net.ParseCIDR(findString(data, "ipv6", "prefix"))
+
+	if err != nil {
+		return nil, fmt.Errorf("no IPv6 prefix found in the web interface: %w", err)
+	}
+
+	return prefix, nil
+}
+
+// netMonitor reads the online monitor page, logging in again once if the
+// session expired.
+func (w *WebUi) netMonitor() (any, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if w.sid == "" {
+			err := w.login()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := w.page("netMoni")
+
+		if err == nil || attempt > 0 {
+			return data, err
+		}
+
+		w.sid = ""
+	}
+}
+
+func (w *WebUi) page(name string) (any, error) {
+	client := &http.Client{
+		Timeout: w.Timeout,
+	}
+
+	response, err := client.PostForm(w.Url+"/data.lua", url.Values{
+		"xhr":   {"1"},
+		"sid":   {w.sid},
+		"lang":  {"en"},
+		"page":  {name},
+		"xhrId": {"all"},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("web interface responded with %s", response.Status)
+	}
+
+	var page struct {
+		Sid  string `json:"sid"`
+		Data any    `json:"data"`
+	}
+
+	// Expired sessions get the login page instead
+	err = json.NewDecoder(response.Body).Decode(&page)
+
+	if err != nil || page.Data == nil {
+		return nil, errors.New("session expired")
+	}
+
+	return page.Data, nil
+}
+
+func (w *WebUi) login() error {
+	info, err := w.session(nil)
+
+	if err != nil {
+		return err
+	}
+
+	if info.BlockTime > 0 {
+		return fmt.Errorf("login blocked for %d seconds after failed attempts", info.BlockTime)
+	}
+
+	username := w.Username
+
+	// Routers without users log in the last one
+	if username == "" && len(info.Users) > 0 {
+		username = info.Users[0]
+	}
+
+	response, err := solveChallenge(info.Challenge, w.Password)
+
+	if err != nil {
+		return err
+	}
+
+	info, err = w.session(url.Values{
+		"username": {username},
+		"response": {response},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if info.Sid == "" || info.Sid == emptySid {
+		return errors.New("login to the web interface failed, check username and password")
+	}
+
+	w.sid = info.Sid
+
+	return nil
+}
+
+// session requests login_sid.lua, with the credentials if they are given.
+func (w *WebUi) session(credentials url.Values) (*sessionInfo, error) {
+	client := &http.Client{
+		Timeout: w.Timeout,
+	}
+
+	var response *http.Response
+	var err error
+
+	if credentials == nil {
+		response, err = client.Get(w.Url + "/login_sid.lua?version=2")
+	} else {
+		response, err = client.PostForm(w.Url+"/login_sid.lua?version=2", credentials)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &sessionInfo{}
+	err = xml.Unmarshal(body, info)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse login response: %w", err)
+	}
+
+	return info, nil
+}
+
+// solveChallenge answers PBKDF2 challenges like `2$<iter1>$<salt1>$<iter2>$<salt2>`,
+// or legacy MD5 challenges of older firmware.
+func solveChallenge(challenge string, password string) (string, error) {
+	if !strings.HasPrefix(challenge, "2$") {
+		var b strings.Builder
+
+		// Characters beyond latin-1 are replaced with dots, like the web interface does
+		for _, c := range challenge + "-" + password {
+			if c > 255 {
+				c = '.'
+			}
+
+			b.WriteRune(c)
+		}
+
+		var utf16le []byte
+
+		for _, c := range utf16.Encode([]rune(b.String())) {
+			utf16le = append(utf16le, byte(c), byte(c>>8))
+		}
+
+		sum := md5.Sum(utf16le)
+
+		return challenge + "-" + hex.EncodeToString(sum[:]), nil
+	}
+
+	parts := strings.Split(challenge, "$")
+
+	if len(parts) != 5 {
+		return "", fmt.Errorf("unsupported challenge %s", challenge)
+	}
+
+	iter1, err1 := strconv.Atoi(parts[1])
+	salt1, err2 := hex.DecodeString(parts[2])
+	iter2, err3 := strconv.Atoi(parts[3])
+	salt2, err4 := hex.DecodeString(parts[4])
+
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return "", fmt.Errorf("invalid challenge %s: %w", challenge, err)
+	}
+
+	hash1 := pbkdf2.Key([]byte(password), salt1, iter1, sha256.Size, sha256.New)
+	hash2 := pbkdf2.Key(hash1, salt2, iter2, sha256.Size, sha256.New)
+
+	return parts[4] + "$" + hex.EncodeToString(hash2), nil
+}
+
+// findString finds the first string at the path in the JSON data, at any depth,
+// i.e. `ipv4.ip` of the first connection of the online monitor.
+func findString(data any, path ...string) string {
+	switch v := data.(type) {
+	case map[string]any:
+		if s, ok := lookup(v, path); ok {
+			return s
+		}
+
+		keys := make([]string, 0, len(v))
+
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		// Keep the result stable if multiple connections are listed
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			if s := findString(v[key], path...); s != "" {
+				return s
+			}
+		}
+	case []any:
+		for _, child := range v {
+			if s := findString(child, path...); s != "" {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
+
+func lookup(data map[string]any, path []string) (string, bool) {
+	var current any = data
+
+	for _, key := range path {
+		m, ok := current.(map[string]any)
+
+		if !ok {
+			return "", false
+		}
+
+		current = m[key]
+	}
+
+	s, ok := current.(string)
+
+	return s, ok && s != ""
+}
@@ -0,0 +1,130 @@
+package cgnat
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+)
+
+var shared = []*net.IPNet{
+	// Carrier-grade NAT, RFC 6598
+	mustParseCIDR("100.64.0.0/10"),
+	// The B4 element of DS-Lite, RFC 6333
+	mustParseCIDR("192.0.0.0/29"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return ipNet
+}
+
+// IsShared tells whether ip is an IPv4 shared by the customers of the ISP,
+// which isn't reachable from the internet.
+func IsShared(ip net.IP) bool {
+	for _, ipNet := range shared {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter holds back the IPv4 of a carrier-grade NAT or DS-Lite connection, as
+// publishing it breaks dual-stack clients preferring the unreachable IPv4.
+// IPv6 is relayed as is.
+type Filter struct {
+	// Clear is called whenever the IPv4 becomes shared, i.e. to delete the A
+	// records still pointing to the last public IPv4
+	Clear func()
+
+	mu     sync.Mutex
+	dsLite bool
+	shared bool
+
+	log *slog.Logger
+
+	In  chan *net.IP
+	out chan<- *net.IP
+}
+
+func NewFilter(out chan<- *net.IP, log *slog.Logger) *Filter {
+	return &Filter{
+		log: log.With(slog.String("module", "cgnat")),
+		In:  make(chan *net.IP, 10),
+		out: out,
+	}
+}
+
+func (f *Filter) StartWorker() {
+	go f.spawnWorker()
+}
+
+func (f *Filter) spawnWorker() {
+	for ip := range f.In {
+		if ip.To4() == nil {
+			f.out <- ip
+			continue
+		}
+
+		f.mu.Lock()
+		dsLite := f.dsLite
+		f.mu.Unlock()
+
+		if dsLite || IsShared(*ip) {
+			f.log.Debug("Holding back shared IPv4", slog.Any("ip", ip), slog.Bool("ds-lite", dsLite))
+			f.setShared(true)
+			continue
+		}
+
+		f.setShared(false)
+		f.out <- ip
+	}
+}
+
+// SetDsLite tells whether the router reports a DS-Lite connection, in which
+// case every IPv4 is held back.
+func (f *Filter) SetDsLite(dsLite bool) {
+	f.mu.Lock()
+	changed := f.dsLite != dsLite
+	f.dsLite = dsLite
+	f.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if dsLite {
+		f.log.Info("Router reports a DS-Lite connection, holding back IPv4")
+		f.setShared(true)
+	} else {
+		f.log.Info("Router doesn't report a DS-Lite connection anymore")
+	}
+}
+
+func (f *Filter) setShared(shared bool) {
+	f.mu.Lock()
+	changed := f.shared != shared
+	f.shared = shared
+	f.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if !shared {
+		f.log.Info("Public IPv4 is back, publishing it again")
+		return
+	}
+
+	f.log.Warn("IPv4 is shared behind a carrier-grade NAT or DS-Lite, not publishing it")
+
+	if f.Clear != nil {
+		go f.Clear()
+	}
+}
@@ -0,0 +1,157 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// batchRecord is a record of the batch endpoint, which isn't supported by
+// cloudflare-go yet.
+type batchRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type batchRequest struct {
+	Deletes []batchRecord `json:"deletes,omitempty"`
+	Posts   []batchRecord `json:"posts,omitempty"`
+	Patches []batchRecord `json:"patches,omitempty"`
+}
+
+// applyBatch updates the records of actions sharing a zone with a single
+// listing and a single batch request, instead of a few round-trips per record.
+// It returns the actions whose proxied records point to a new origin.
+func (u *Updater) applyBatch(actions []*Action, ip *net.IP) ([]*Action, error) {
+	zoneId, _ := u.zoneOf(actions[0])
+	zlog := u.log.With(slog.String("zone-id", zoneId), slog.Int("records", len(actions)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	records, err := u.listRecords(ctx, actions[0], recordType(ip), "")
+
+	if err != nil {
+		zlog.Error("Batch failed, could not research DNS records", logging.ErrorAttr(err))
+		u.recordAttempts(actions, ip, err)
+		return nil, err
+	}
+
+	byName := make(map[string][]cf.DNSRecord)
+
+	for _, record := range records {
+		name := strings.ToLower(record.Name)
+		byName[name] = append(byName[name], record)
+	}
+
+	var changes []*change
+	request := batchRequest{}
+
+	for _, action := range actions {
+		c := u.plan(action, ip, byName[strings.ToLower(action.DnsRecord)])
+
+		for _, id := range c.deletes {
+			request.Deletes = append(request.Deletes, batchRecord{ID: id})
+		}
+
+		if c.create != nil {
+			request.Posts = append(request.Posts, batchRecord{
+				Type:    c.create.Type,
+				Name:    c.create.Name,
+				Content: c.create.Content,
+				TTL:     c.create.TTL,
+				Proxied: c.create.Proxied,
+				Comment: c.create.Comment,
+			})
+		}
+
+		for _, update := range c.updates {
+			patch := batchRecord{
+				ID:      update.ID,
+				Content: update.Content,
+				TTL:     update.TTL,
+				Proxied: update.Proxied,
+			}
+
+			if update.Comment != nil {
+				patch.Comment = *update.Comment
+			}
+
+			request.Patches = append(request.Patches, patch)
+		}
+
+		changes = append(changes, c)
+	}
+
+	if len(request.Deletes) == 0 && len(request.Posts) == 0 && len(request.Patches) == 0 {
+		u.recordAttempts(actions, ip, nil)
+		return nil, nil
+	}
+
+	if u.dryRun {
+		var changed []*Action
+
+		for _, c := range changes {
+			u.report(c)
+
+			if c.originChanged {
+				changed = append(changed, c.action)
+			}
+		}
+
+		return changed, nil
+	}
+
+	zlog.Info("Updating DNS records in batch", slog.Int("creates", len(request.Posts)), slog.Int("updates", len(request.Patches)), slog.Int("deletes", len(request.Deletes)))
+
+	// The zone might have been relocated by the listing
+	zoneId, api := u.zoneOf(actions[0])
+	_, err = api.Raw(ctx, "POST", "/zones/"+zoneId+"/dns_records/batch", request, nil)
+
+	var changed []*Action
+
+	if err != nil {
+		// Batches are all or nothing, so fall back to updating record by record
+		zlog.Warn("Batch failed, updating records one by one", logging.ErrorAttr(err))
+
+		var errs []error
+
+		for _, c := range changes {
+			originChanged, err := u.execute(ctx, c)
+			u.recordAttempt(c.action, ip, err)
+
+			if originChanged {
+				changed = append(changed, c.action)
+			}
+
+			errs = append(errs, err)
+		}
+
+		return changed, errors.Join(errs...)
+	}
+
+	u.recordAttempts(actions, ip, nil)
+
+	metrics.RecordChanges.Add(len(request.Posts), "cloudflare", "created")
+	metrics.RecordChanges.Add(len(request.Patches), "cloudflare", "updated")
+	metrics.RecordChanges.Add(len(request.Deletes), "cloudflare", "deleted")
+
+	for _, c := range changes {
+		if c.originChanged {
+			changed = append(changed, c.action)
+		}
+	}
+
+	return changed, nil
+}
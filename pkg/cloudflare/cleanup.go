@@ -0,0 +1,41 @@
+package cloudflare
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+)
+
+// Cleanup deletes the managed A and AAAA records on shutdown, or points them
+// to the fallback IP of their IP version if one is given. The worker is
+// stopped first, so a late update can't recreate the records.
+func (u *Updater) Cleanup(fallbackIpv4 net.IP, fallbackIpv6 net.IP) {
+	if !u.isInit {
+		return
+	}
+
+	u.Stop()
+
+	for _, family := range []struct {
+		version  int
+		fallback net.IP
+	}{
+		{4, fallbackIpv4},
+		{6, fallbackIpv6},
+	} {
+		fallback := family.fallback
+
+		if fallback == nil {
+			u.DeleteRecords(family.version)
+			continue
+		}
+
+		u.log.Info("Publishing fallback IP", slog.Any("ip", fallback))
+
+		err := u.publish(&fallback, false)
+
+		if err != nil {
+			u.log.Error("Failed to publish fallback IP", slog.Any("ip", fallback), logging.ErrorAttr(err))
+		}
+	}
+}
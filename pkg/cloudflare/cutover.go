@@ -0,0 +1,241 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/probe"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+const probeInterval = 10 * time.Second
+
+// pending is an IP that has been published to the staging records, but not
+// yet to the production ones.
+type pending struct {
+	ip     *net.IP
+	cancel context.CancelFunc
+}
+
+// ConfirmCutover publishes all IPs currently waiting on the staging records
+// to the production records.
+func (u *Updater) ConfirmCutover() {
+	select {
+	case u.confirm <- nil:
+	default:
+	}
+}
+
+func (u *Updater) hasStaging(ip *net.IP) bool {
+	if ip.To4() == nil {
+		return len(u.ipv6StagingZones) > 0
+	}
+
+	return len(u.ipv4StagingZones) > 0
+}
+
+func (u *Updater) pendingFor(ip *net.IP) **pending {
+	if ip.To4() == nil {
+		return &u.pendingIpv6
+	}
+
+	return &u.pendingIpv4
+}
+
+// stage publishes the IP to the staging records and holds it back from the
+// production records until the cutover gets confirmed.
+func (u *Updater) stage(ip *net.IP) {
+	p := u.pendingFor(ip)
+
+	if *p != nil && (*p).ip.Equal(*ip) {
+		return
+	}
+
+	// Nothing to migrate if production already serves this IP, i.e. after a restart
+	if u.isPublished(ip) {
+		u.log.Info("IP already published, skipping cutover", slog.Any("ip", ip))
+		u.setLast(ip)
+		return
+	}
+
+	if *p != nil {
+		u.log.Info("Superseding staged IP", slog.Any("ip", (*p).ip))
+		(*p).cancel()
+		*p = nil
+	}
+
+	err := u.publish(ip, true)
+
+	if err != nil {
+		u.retryLater(ip, u.retries, "Failed to publish IP to staging records, retrying later", err)
+		return
+	}
+
+	u.backoffFor(ip).attempts = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	*p = &pending{ip: ip, cancel: cancel}
+
+	u.log.Info("Published to staging records, waiting for cutover confirmation", slog.Any("ip", ip))
+
+	if probes := u.probesFor(ip); len(probes) > 0 {
+		go u.runProbe(ctx, ip, probes)
+	}
+}
+
+// cutover publishes the staged IPs to the production records, a nil ip
+// selects all of them.
+func (u *Updater) cutover(ip *net.IP) {
+	found := false
+
+	for _, p := range []**pending{&u.pendingIpv4, &u.pendingIpv6} {
+		if *p == nil || (ip != nil && !(*p).ip.Equal(*ip)) {
+			continue
+		}
+
+		found = true
+		staged := (*p).ip
+
+		u.log.Info("Cutting over production records", slog.Any("ip", staged))
+
+		(*p).cancel()
+		*p = nil
+
+		err := u.publish(staged, false)
+
+		if err != nil {
+			// Stays staged, the probes already passed or it was confirmed
+			*p = &pending{ip: staged, cancel: func() {}}
+			u.retryLater(staged, u.confirm, "Failed to cut over production records, retrying later", err)
+			continue
+		}
+
+		u.backoffFor(staged).attempts = 0
+		u.setLast(staged)
+		u.publishMetadata()
+	}
+
+	if !found && ip == nil {
+		u.log.Warn("Cutover confirmed, but no IP is staged")
+	}
+}
+
+// SetProbes defines how to probe the service behind a record, given as
+// `record=definition` pairs separated by commas, see probe.Parse. A staged IP
+// only gets confirmed once the probes of all its records pass.
+func (u *Updater) SetProbes(definitions string) error {
+	u.probes = make(map[string]*probe.Probe)
+
+	for _, entry := range strings.Split(definitions, ",") {
+		record, definition, ok := strings.Cut(entry, "=")
+
+		if !ok {
+			return fmt.Errorf("invalid probe %q, expected <record>=<probe>", entry)
+		}
+
+		p, err := probe.Parse(definition)
+
+		if err != nil {
+			return fmt.Errorf("invalid probe for %s: %w", record, err)
+		}
+
+		u.probes[record] = p
+	}
+
+	return nil
+}
+
+// probesFor collects the probes of all production records of the IP version,
+// records without their own probe fall back to the cutover probe port.
+func (u *Updater) probesFor(ip *net.IP) []*probe.Probe {
+	var probes []*probe.Probe
+
+	for _, action := range u.actions {
+		if action.Staging || (ip.To4() == nil) != (action.IpVersion == 6) {
+			continue
+		}
+
+		if p, ok := u.probes[action.DnsRecord]; ok {
+			probes = append(probes, p)
+		}
+	}
+
+	if len(probes) == 0 && u.probePort != 0 {
+		probes = append(probes, probe.NewTCP(u.probePort))
+	}
+
+	return probes
+}
+
+// checkAll runs all probes against the IP and returns the first failure.
+func checkAll(ctx context.Context, ip *net.IP, probes []*probe.Probe) error {
+	for _, p := range probes {
+		err := p.Check(ctx, *ip)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+func (u *Updater) runProbe(ctx context.Context, ip *net.IP, probes []*probe.Probe) {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+
+	for {
+		pctx, cancel := context.WithTimeout(ctx, probeInterval/2)
+		err := checkAll(pctx, ip, probes)
+		cancel()
+
+		if err == nil {
+			u.log.Info("Health probe passed on staged IP", slog.Any("ip", ip))
+
+			select {
+			case u.confirm <- ip:
+			case <-ctx.Done():
+			}
+
+			return
+		}
+
+		u.log.Debug("Health probe failed on staged IP", slog.Any("ip", ip), logging.ErrorAttr(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isPublished checks whether all production records for the IP version
+// already point to the IP.
+func (u *Updater) isPublished(ip *net.IP) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, action := range u.actions {
+		if action.Staging || (ip.To4() == nil) != (action.IpVersion == 6) {
+			continue
+		}
+
+		records, err := u.listRecords(ctx, action, recordType(ip), action.DnsRecord)
+
+		if err != nil || len(records) == 0 {
+			return false
+		}
+
+		for _, record := range records {
+			if record.Content != ip.String() {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,147 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipv6"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// delegation delegates a subzone to a downstream router, which serves it from
+// its address in the delegated IPv6 prefix.
+type delegation struct {
+	subzone    string
+	nameserver string
+	localIp    net.IP
+
+	// ns is the NS record in the parent zone, glue the AAAA record of the nameserver
+	ns   *Action
+	glue *Action
+}
+
+// SetDelegations parses delegations like `lab.example.com=ns.lab.example.com@::1`,
+// the nameserver of the subzone is reachable at the interface identifier in
+// the prefix.
+func (u *Updater) SetDelegations(definitions string) error {
+	for _, definition := range strings.Split(definitions, ",") {
+		subzone, target, ok := strings.Cut(definition, "=")
+		nameserver, localIp, ok2 := strings.Cut(target, "@")
+
+		if !ok || !ok2 || subzone == "" || nameserver == "" {
+			return fmt.Errorf("invalid delegation %s, expected <subzone>=<nameserver>@<interface identifier>", definition)
+		}
+
+		d := &delegation{
+			subzone:    strings.TrimSuffix(strings.ToLower(subzone), "."),
+			nameserver: strings.TrimSuffix(strings.ToLower(nameserver), "."),
+			localIp:    net.ParseIP(localIp),
+		}
+
+		if d.localIp == nil || d.localIp.To4() != nil {
+			return fmt.Errorf("invalid interface identifier %s of delegation %s", localIp, subzone)
+		}
+
+		if !strings.Contains(d.subzone, ".") {
+			return fmt.Errorf("invalid subzone %s, it must have a parent zone", subzone)
+		}
+
+		u.delegations = append(u.delegations, d)
+	}
+
+	return nil
+}
+
+// initDelegations resolves the parent zones of the delegations, the subzone
+// might be on Cloudflare itself but the NS records belong to the parent.
+func (u *Updater) initDelegations() error {
+	for _, d := range u.delegations {
+		_, parent, _ := strings.Cut(d.subzone, ".")
+
+		z, err := u.resolveZone(parent)
+
+		if err != nil {
+			return err
+		}
+
+		d.ns = &Action{DnsRecord: d.subzone, CfZoneId: z.id, IpVersion: 6, api: z.api}
+
+		// In-bailiwick nameservers need glue in the parent zone
+		if d.nameserver != d.subzone && !strings.HasSuffix(d.nameserver, "."+d.subzone) {
+			z, err = u.resolveZone(d.nameserver)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		d.glue = &Action{DnsRecord: d.nameserver, CfZoneId: z.id, IpVersion: 6, Exclusive: true, api: z.api}
+
+		u.log.Info("Delegating subzone", slog.String("subzone", d.subzone), slog.String("nameserver", d.nameserver))
+	}
+
+	return nil
+}
+
+// publishDelegations points the nameservers of the subzones to their address
+// in the prefix and makes sure the subzones are delegated to them.
+func (u *Updater) publishDelegations(prefix *net.IPNet) {
+	for _, d := range u.delegations {
+		ip := ipv6.FromPrefix(prefix, d.localIp)
+
+		_, _ = u.apply(d.glue, &ip)
+
+		u.ensureNs(d)
+	}
+}
+
+// ensureNs creates the NS record of the delegation if it is missing, other
+// nameservers of the subzone are left alone.
+func (u *Updater) ensureNs(d *delegation) {
+	alog := u.log.With(slog.String("zone", d.subzone+"/NS"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	records, err := u.listRecords(ctx, d.ns, "NS", d.subzone)
+
+	if err != nil {
+		alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
+		return
+	}
+
+	for _, record := range records {
+		if strings.TrimSuffix(strings.ToLower(record.Content), ".") == d.nameserver {
+			return
+		}
+	}
+
+	if u.dryRun {
+		alog.Info("Dry run, would create DNS record", slog.String("content", d.nameserver))
+		return
+	}
+
+	alog.Info("Creating DNS record", slog.String("content", d.nameserver))
+
+	ttl := u.ttl
+
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	_, err = d.ns.api.CreateDNSRecord(ctx, cf.ZoneIdentifier(d.ns.CfZoneId), cf.CreateDNSRecordParams{
+		Type:    "NS",
+		Name:    d.subzone,
+		Content: d.nameserver,
+		TTL:     ttl,
+		ZoneID:  d.ns.CfZoneId,
+	})
+
+	if err != nil {
+		alog.Error("Action failed, could not create DNS record", logging.ErrorAttr(err))
+	}
+}
@@ -0,0 +1,39 @@
+package cloudflare
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// SetDryRun makes the updater log the changes it would make to the records
+// instead of making them, discovery still talks to the API.
+func (u *Updater) SetDryRun(dryRun bool) {
+	u.dryRun = dryRun
+}
+
+// report logs the change instead of executing it.
+func (u *Updater) report(c *change) {
+	alog := u.log.With(slog.String("zone", fmt.Sprintf("%s/IPv%d", c.action.DnsRecord, c.action.IpVersion)))
+
+	if c.create != nil {
+		alog.Info("Dry run, would create DNS record", slog.String("type", c.create.Type), slog.String("content", c.create.Content), slog.Int("ttl", c.create.TTL), slog.Bool("proxied", *c.create.Proxied))
+	}
+
+	for _, id := range c.deletes {
+		alog.Info("Dry run, would delete stale DNS record", slog.String("record-id", id), slog.String("content", c.previous[id]))
+	}
+
+	for _, update := range c.updates {
+		attrs := []any{slog.String("record-id", update.ID), slog.String("old", c.previous[update.ID]), slog.String("new", update.Content), slog.Int("ttl", update.TTL)}
+
+		if update.Proxied != nil {
+			attrs = append(attrs, slog.Bool("proxied", *update.Proxied))
+		}
+
+		alog.Info("Dry run, would update DNS record", attrs...)
+	}
+
+	if c.create == nil && len(c.deletes) == 0 && len(c.updates) == 0 {
+		alog.Info("Dry run, DNS records are up to date")
+	}
+}
@@ -0,0 +1,76 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// DynamicRecord is a record managed at runtime instead of being configured,
+// i.e. for a container. A nil IP deletes the record.
+type DynamicRecord struct {
+	Name      string
+	IpVersion int
+	IP        net.IP
+}
+
+// applyDynamic creates, updates or deletes the dynamic record.
+func (u *Updater) applyDynamic(record *DynamicRecord) {
+	key := fmt.Sprintf("%s/IPv%d", record.Name, record.IpVersion)
+	action, ok := u.dynamicActions[key]
+
+	if !ok {
+		z, err := u.resolveZone(record.Name)
+
+		if err != nil {
+			u.log.Error("Failed to resolve zone of dynamic record", slog.String("zone", record.Name), logging.ErrorAttr(err))
+			return
+		}
+
+		action = &Action{
+			DnsRecord: record.Name,
+			CfZoneId:  z.id,
+			IpVersion: record.IpVersion,
+			api:       z.api,
+		}
+
+		u.dynamicActions[key] = action
+	}
+
+	if record.IP != nil {
+		_, _ = u.apply(action, &record.IP)
+		return
+	}
+
+	delete(u.dynamicActions, key)
+
+	alog := u.log.With(slog.String("zone", key))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	recordType := "AAAA"
+
+	if action.IpVersion == 4 {
+		recordType = "A"
+	}
+
+	records, err := u.listRecords(ctx, action, recordType, record.Name)
+
+	if err != nil {
+		alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
+		return
+	}
+
+	c := &change{action: action, previous: make(map[string]string)}
+
+	for _, r := range records {
+		c.deletes = append(c.deletes, r.ID)
+		c.previous[r.ID] = r.Content
+	}
+
+	_, _ = u.execute(ctx, c)
+}
@@ -0,0 +1,98 @@
+package cloudflare
+
+import (
+	"context"
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/state"
+	"net"
+	"strings"
+	"time"
+)
+
+// RecordInfo describes how a record is managed, for the explain command.
+type RecordInfo struct {
+	Action *Action
+	// Type is the type of the managed DNS records
+	Type string
+	// View is the name of the view the record belongs to, if any
+	View string
+	// Records are the current records on Cloudflare
+	Records []cf.DNSRecord
+	Err     error
+}
+
+// Explain finds every action managing the record and looks up its current
+// records on Cloudflare.
+func (u *Updater) Explain(name string) []RecordInfo {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	var infos []RecordInfo
+
+	for _, action := range u.actions {
+		if action.DnsRecord == name {
+			t := "A"
+
+			if action.IpVersion == 6 {
+				t = "AAAA"
+			}
+
+			infos = append(infos, RecordInfo{Action: action, Type: t})
+		}
+	}
+
+	for _, v := range u.views {
+		for _, action := range v.actions {
+			if action.DnsRecord == name {
+				infos = append(infos, RecordInfo{Action: action, Type: "AAAA", View: v.name + " (" + v.localIp.String() + ")"})
+			}
+		}
+	}
+
+	for _, action := range u.prefixActions {
+		if action.DnsRecord == name {
+			infos = append(infos, RecordInfo{Action: action, Type: "TXT"})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for i := range infos {
+		infos[i].Records, infos[i].Err = u.listRecords(ctx, infos[i].Action, infos[i].Type, name)
+	}
+
+	return infos
+}
+
+// recordAttempt keeps the outcome of updating the records of the action.
+func (u *Updater) recordAttempt(action *Action, ip *net.IP, err error) {
+	if u.dryRun {
+		return
+	}
+
+	if err != nil {
+		metrics.ProviderErrors.Inc("cloudflare")
+	}
+
+	zoneId, _ := u.zoneOf(action)
+
+	saveErr := u.State.Attempt(state.Record{
+		Name:      action.DnsRecord,
+		IpVersion: action.IpVersion,
+		Provider:  "cloudflare",
+		ZoneId:    zoneId,
+		Content:   ip.String(),
+	}, err)
+
+	if saveErr != nil {
+		u.log.Warn("Failed to save state", logging.ErrorAttr(saveErr))
+	}
+}
+
+func (u *Updater) recordAttempts(actions []*Action, ip *net.IP, err error) {
+	for _, action := range actions {
+		u.recordAttempt(action, ip, err)
+	}
+}
@@ -0,0 +1,150 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// hintRecordTypes are the record types carrying SvcParams, HTTPS is SVCB
+// specialized for HTTP.
+var hintRecordTypes = []string{"HTTPS", "SVCB"}
+
+// SetHttpsHints enables rewriting the ipv4hint and ipv6hint parameters of the
+// HTTPS and SVCB records next to the updated A and AAAA records, so clients
+// using the hints connect to the same IP.
+func (u *Updater) SetHttpsHints(enabled bool) {
+	u.httpsHints = enabled
+}
+
+// publishHints rewrites the hint of the IP version in the HTTPS and SVCB records
+// of the actions, records without the hint are left alone.
+func (u *Updater) publishHints(actions []*Action, ip *net.IP) {
+	if !u.httpsHints {
+		return
+	}
+
+	key := "ipv6hint"
+
+	if ip.To4() != nil {
+		key = "ipv4hint"
+	}
+
+	seen := make(map[string]bool)
+
+	for _, action := range actions {
+		if seen[action.DnsRecord] {
+			continue
+		}
+
+		seen[action.DnsRecord] = true
+
+		for _, recordType := range hintRecordTypes {
+			u.applyHint(action, recordType, key, ip.String())
+		}
+	}
+}
+
+func (u *Updater) applyHint(action *Action, recordType string, key string, hint string) {
+	alog := u.log.With(slog.String("zone", action.DnsRecord+"/"+recordType))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	records, err := u.listRecords(ctx, action, recordType, action.DnsRecord)
+
+	if err != nil {
+		alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
+		return
+	}
+
+	for _, record := range records {
+		data, ok := record.Data.(map[string]interface{})
+
+		if !ok {
+			continue
+		}
+
+		value, _ := data["value"].(string)
+		updated, ok := replaceSvcParam(value, key, hint)
+
+		// AliasMode records and records without the hint carry nothing to rewrite
+		if !ok || updated == value {
+			continue
+		}
+
+		if u.dryRun {
+			alog.Info("Dry run, would update DNS record", slog.Any("record-id", record.ID), slog.String("old", value), slog.String("new", updated))
+			continue
+		}
+
+		alog.Info("Updating hint of DNS record", slog.Any("record-id", record.ID), slog.String("hint", key))
+
+		zoneId, api := u.zoneOf(action)
+
+		_, err := api.UpdateDNSRecord(ctx, cf.ZoneIdentifier(zoneId), cf.UpdateDNSRecordParams{
+			ID:   record.ID,
+			Type: recordType,
+			Data: map[string]interface{}{
+				"priority": data["priority"],
+				"target":   data["target"],
+				"value":    updated,
+			},
+		})
+
+		if err != nil {
+			alog.Error("Action failed, could not update DNS record", logging.ErrorAttr(err))
+		}
+	}
+}
+
+// replaceSvcParam replaces the value of key in SvcParams like
+// `alpn="h3,h2" ipv4hint="192.0.2.1"`, it returns false if the key isn't set.
+func replaceSvcParam(params string, key string, value string) (string, bool) {
+	fields := splitSvcParams(params)
+	found := false
+
+	for i, field := range fields {
+		if name, _, _ := strings.Cut(field, "="); strings.EqualFold(name, key) {
+			fields[i] = fmt.Sprintf("%s=%q", key, value)
+			found = true
+		}
+	}
+
+	return strings.Join(fields, " "), found
+}
+
+// splitSvcParams splits SvcParams at spaces outside of quoted values.
+func splitSvcParams(params string) []string {
+	var fields []string
+	var field strings.Builder
+
+	quoted := false
+
+	for _, c := range params {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+
+			continue
+		}
+
+		field.WriteRune(c)
+	}
+
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
@@ -0,0 +1,36 @@
+package cloudflare
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SetMetadataRecords enables publishing when and how the records were last
+// updated as TXT records, i.e. `_dyndns.home.example.com`.
+func (u *Updater) SetMetadataRecords(records string) {
+	u.metadataRecords = strings.Split(records, ",")
+}
+
+// source returns where the last IP came from, unknown if Source isn't set.
+func (u *Updater) source() string {
+	if u.Source == nil {
+		return "unknown"
+	}
+
+	return u.Source()
+}
+
+// publishMetadata updates the metadata records after the records were updated.
+func (u *Updater) publishMetadata() {
+	if len(u.metadataActions) == 0 {
+		return
+	}
+
+	content := fmt.Sprintf("updated=%s source=%s version=%s", time.Now().UTC().Format(time.RFC3339), u.source(), u.Version)
+
+	// Failures are logged, the metadata is refreshed with the next update
+	for _, action := range u.metadataActions {
+		_ = u.applyTxt(action, content)
+	}
+}
@@ -0,0 +1,51 @@
+package cloudflare
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetMirrors mirrors every record of a domain into another domain, given as
+// `domain=mirror` pairs separated by commas. With `example.com=example.dev`
+// every update of home.example.com is published to home.example.dev as well,
+// so consumers can be tested against a staging zone.
+func (u *Updater) SetMirrors(mapping string) error {
+	u.mirrors = make(map[string]string)
+
+	for _, entry := range strings.Split(mapping, ",") {
+		name, mirror, ok := strings.Cut(entry, "=")
+
+		if !ok || name == "" || mirror == "" {
+			return fmt.Errorf("invalid mirror mapping %q, expected <domain>=<mirror domain>", entry)
+		}
+
+		u.mirrors[strings.TrimSuffix(name, ".")] = strings.TrimSuffix(mirror, ".")
+	}
+
+	return nil
+}
+
+// mirrorAction returns a copy of the action updating the mirrored record, or
+// nil if the record isn't mirrored.
+func (u *Updater) mirrorAction(a *Action) (*Action, error) {
+	domain, mirror, ok := mostSpecific(a.DnsRecord, u.mirrors)
+
+	if !ok {
+		return nil, nil
+	}
+
+	m := *a
+	m.DnsRecord = strings.TrimSuffix(a.DnsRecord, domain) + mirror
+	m.MirrorOf = a.DnsRecord
+
+	z, err := u.resolveZone(m.DnsRecord)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve mirror of %s: %w", a.DnsRecord, err)
+	}
+
+	m.CfZoneId = z.id
+	m.api = z.api
+
+	return &m, nil
+}
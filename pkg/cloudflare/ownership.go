@@ -0,0 +1,90 @@
+package cloudflare
+
+import (
+	cf "github.com/cloudflare/cloudflare-go"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ownerMarker starts the comments marking the records written by an instance.
+const ownerMarker = "fritzbox-cloudflare-dyndns"
+
+// conflictWindow is how long a record counts as owned by the instance that
+// wrote it last.
+const conflictWindow = 15 * time.Minute
+
+// SetInstanceId marks the records with the id of this instance in their
+// comment, so multiple instances updating the same records can be detected.
+// Records with a comment of their own are never marked.
+func (u *Updater) SetInstanceId(id string) {
+	u.instanceId = id
+}
+
+// SetStandDown makes the updater leave records alone that another instance
+// updated recently, instead of only warning about them.
+func (u *Updater) SetStandDown(standDown bool) {
+	u.standDown = standDown
+}
+
+// ownerComment returns the marker of this instance, or nil if the existing
+// comment must not be replaced.
+func (u *Updater) ownerComment(existing string) *string {
+	if u.instanceId == "" || (existing != "" && !strings.HasPrefix(existing, ownerMarker)) {
+		return nil
+	}
+
+	comment := ownerMarker + " instance=" + u.instanceId + " updated=" + time.Now().UTC().Format(time.RFC3339)
+
+	return &comment
+}
+
+// owner parses the marker of the record, it returns false if there is none.
+func owner(record cf.DNSRecord) (string, time.Time, bool) {
+	rest, ok := strings.CutPrefix(record.Comment, ownerMarker)
+
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	var instance string
+	var updated time.Time
+
+	for _, field := range strings.Fields(rest) {
+		key, value, _ := strings.Cut(field, "=")
+
+		switch key {
+		case "instance":
+			instance = value
+		case "updated":
+			updated, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+
+	return instance, updated, instance != ""
+}
+
+// conflicts reports whether another instance updated the record recently with
+// a different content, it returns true if the record has to be left alone.
+func (u *Updater) conflicts(action *Action, record cf.DNSRecord, content string) bool {
+	if u.instanceId == "" || record.Content == content {
+		return false
+	}
+
+	instance, updated, ok := owner(record)
+
+	if !ok || instance == u.instanceId || time.Since(updated) > conflictWindow {
+		return false
+	}
+
+	u.log.Warn("CONFLICT: another instance updated this record recently, two instances seem to be fighting over it",
+		slog.String("zone", action.DnsRecord),
+		slog.Any("record-id", record.ID),
+		slog.String("other-instance", instance),
+		slog.Time("updated", updated),
+		slog.String("content", record.Content),
+		slog.Bool("standing-down", u.standDown),
+	)
+
+	return u.standDown
+}
@@ -0,0 +1,135 @@
+package cloudflare
+
+import (
+	"context"
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SetPurgeCache enables purging the cache of proxied records once their origin
+// IP changed, so the proxy doesn't hold on to connections to the old origin.
+func (u *Updater) SetPurgeCache(purge bool) {
+	u.purgeCache = purge
+}
+
+// SetPurgeUrls limits the purge of a host to the given URLs, hosts without
+// configured URLs get purged completely.
+func (u *Updater) SetPurgeUrls(urls string) error {
+	u.purgeUrls = make(map[string][]string)
+
+	for _, raw := range strings.Split(urls, ",") {
+		parsed, err := url.Parse(raw)
+
+		if err != nil {
+			return err
+		}
+
+		u.purgeUrls[parsed.Hostname()] = append(u.purgeUrls[parsed.Hostname()], raw)
+	}
+
+	u.purgeCache = true
+
+	return nil
+}
+
+// SetPurgeDelay delays the purge, i.e. to give the proxy time to pick up the
+// changed records first.
+func (u *Updater) SetPurgeDelay(delay time.Duration) {
+	u.purgeDelay = delay
+}
+
+// purgeUrlsFor returns the URLs configured for the record, wildcard records
+// cover the URLs of all hosts below them.
+func (u *Updater) purgeUrlsFor(record string) []string {
+	base, wildcard := strings.CutPrefix(record, "*.")
+
+	if !wildcard {
+		return u.purgeUrls[record]
+	}
+
+	var urls []string
+
+	for host, hostUrls := range u.purgeUrls {
+		if strings.HasSuffix(host, "."+base) {
+			urls = append(urls, hostUrls...)
+		}
+	}
+
+	return urls
+}
+
+// purge purges the cache of the proxied records whose origin changed.
+func (u *Updater) purge(actions []*Action) {
+	if !u.purgeCache || len(actions) == 0 {
+		return
+	}
+
+	// One request per zone
+	requests := make(map[string]*cf.PurgeCacheRequest)
+	apis := make(map[string]*cf.API)
+
+	for _, action := range actions {
+		zoneId, api := u.zoneOf(action)
+		request, ok := requests[zoneId]
+
+		if !ok {
+			request = &cf.PurgeCacheRequest{}
+			requests[zoneId] = request
+			apis[zoneId] = api
+		}
+
+		if urls := u.purgeUrlsFor(action.DnsRecord); len(urls) > 0 {
+			request.Files = append(request.Files, urls...)
+		} else if strings.HasPrefix(action.DnsRecord, "*.") {
+			// Hosts can't be purged by wildcard, only their URLs
+			u.log.Warn("Can't purge the cache of wildcard records, configure their URLs instead", slog.String("zone", action.DnsRecord))
+		} else {
+			request.Hosts = append(request.Hosts, action.DnsRecord)
+		}
+	}
+
+	// The worker goes on with the next updates while the purge waits
+	if u.purgeDelay > 0 && !u.dryRun {
+		u.log.Info("Waiting before purging the cache", slog.Duration("delay", u.purgeDelay))
+
+		time.AfterFunc(u.purgeDelay, func() {
+			u.sendPurges(requests, apis)
+		})
+
+		return
+	}
+
+	u.sendPurges(requests, apis)
+}
+
+// sendPurges sends the purge requests, keyed by the zone ID.
+func (u *Updater) sendPurges(requests map[string]*cf.PurgeCacheRequest, apis map[string]*cf.API) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for zoneId, request := range requests {
+		if len(request.Files) == 0 && len(request.Hosts) == 0 {
+			continue
+		}
+
+		plog := u.log.With(slog.String("zone-id", zoneId), slog.Any("hosts", request.Hosts), slog.Any("files", request.Files))
+
+		if u.dryRun {
+			plog.Info("Dry run, would purge cache")
+			continue
+		}
+
+		_, err := apis[zoneId].PurgeCache(ctx, zoneId, *request)
+
+		if err != nil {
+			plog.Error("Failed to purge cache", logging.ErrorAttr(err))
+			continue
+		}
+
+		plog.Info("Purged cache")
+	}
+}
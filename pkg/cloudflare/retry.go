@@ -0,0 +1,96 @@
+package cloudflare
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	retryInitialDelay = 5 * time.Second
+	retryMaxDelay     = 10 * time.Minute
+)
+
+// backoff tracks the retries of the latest IP of a single IP version.
+type backoff struct {
+	latest   *net.IP
+	attempts int
+}
+
+// reset starts over for a newly received IP.
+func (b *backoff) reset(ip *net.IP) {
+	if b.latest == nil || !b.latest.Equal(*ip) {
+		b.attempts = 0
+	}
+
+	b.latest = ip
+}
+
+// next returns the delay before the next retry, doubling with every attempt.
+func (b *backoff) next() time.Duration {
+	delay := retryInitialDelay << b.attempts
+
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	} else {
+		b.attempts++
+	}
+
+	return delay
+}
+
+func (u *Updater) backoffFor(ip *net.IP) *backoff {
+	if ip.To4() == nil {
+		return u.backoffIpv6
+	}
+
+	return u.backoffIpv4
+}
+
+// handle publishes the IP, or stages it for the cutover. If publishing failed
+// the IP gets queued again after a backoff, instead of waiting for the next
+// IP change.
+func (u *Updater) handle(ip *net.IP) {
+	if u.hasStaging(ip) {
+		u.stage(ip)
+		return
+	}
+
+	err := u.publish(ip, false)
+
+	if err != nil {
+		u.retryLater(ip, u.retries, "Failed to publish IP, retrying later", err)
+		return
+	}
+
+	u.backoffFor(ip).attempts = 0
+	u.setLast(ip)
+	u.publishMetadata()
+}
+
+// retryLater queues the IP again once the backoff of its IP version elapsed.
+func (u *Updater) retryLater(ip *net.IP, queue chan<- *net.IP, msg string, err error) {
+	delay := u.backoffFor(ip).next()
+
+	u.log.Warn(msg, slog.Any("ip", ip), slog.Duration("delay", delay), logging.ErrorAttr(err))
+
+	time.AfterFunc(delay, func() {
+		queue <- ip
+	})
+}
+
+// retry publishes the IP again, unless it has been superseded meanwhile.
+func (u *Updater) retry(ip *net.IP) {
+	if b := u.backoffFor(ip); b.latest == nil || !b.latest.Equal(*ip) {
+		return
+	}
+
+	if u.last.Is(*ip) {
+		return
+	}
+
+	u.log.Info("Retrying update request", slog.Any("ip", ip))
+
+	u.handle(ip)
+}
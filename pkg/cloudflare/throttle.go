@@ -0,0 +1,145 @@
+package cloudflare
+
+import (
+	"context"
+	cf "github.com/cloudflare/cloudflare-go"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxThrottleRetries limits how often a rate limited request is retried
+const maxThrottleRetries = 3
+
+// defaultRetryAfter is used if a rate limited response has no Retry-After header
+const defaultRetryAfter = time.Minute
+
+// throttle honors the Retry-After header of rate limited responses, every
+// request is held back until Cloudflare accepts requests again. Without it the
+// SDK keeps retrying within seconds, risking a temporary ban of the account.
+type throttle struct {
+	next http.RoundTripper
+	log  *slog.Logger
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// SetMaxRequestsPerSecond limits the requests sent to Cloudflare, the SDK
+// defaults to 4 per second.
+func (u *Updater) SetMaxRequestsPerSecond(rps float64) {
+	u.maxRps = rps
+}
+
+// SetApiUrl replaces the Cloudflare API, i.e. with cloudflaremock for testing.
+func (u *Updater) SetApiUrl(url string) {
+	u.apiUrl = url
+}
+
+// apiOptions returns the options every API client is created with.
+func (u *Updater) apiOptions() []cf.Option {
+	if u.throttle == nil {
+		u.throttle = &throttle{
+			next: http.DefaultTransport,
+			log:  u.log,
+		}
+	}
+
+	options := []cf.Option{cf.HTTPClient(&http.Client{Transport: u.throttle})}
+
+	if u.maxRps > 0 {
+		options = append(options, cf.UsingRateLimit(u.maxRps))
+	}
+
+	if u.apiUrl != "" {
+		options = append(options, cf.BaseURL(u.apiUrl))
+	}
+
+	return options
+}
+
+func (t *throttle) RoundTrip(r *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		err := t.wait(r.Context())
+
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := t.next.RoundTrip(r)
+
+		if err != nil || response.StatusCode != http.StatusTooManyRequests || attempt >= maxThrottleRetries {
+			return response, err
+		}
+
+		// The body was consumed, so it must be replayable
+		if r.Body != nil && r.GetBody == nil {
+			return response, nil
+		}
+
+		delay := retryAfter(response.Header.Get("Retry-After"))
+
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+
+		t.pause(delay)
+		t.log.Warn("Rate limited by Cloudflare, pausing requests", slog.Duration("retry-after", delay))
+
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+
+			if err != nil {
+				return nil, err
+			}
+
+			r = r.Clone(r.Context())
+			r.Body = body
+		}
+	}
+}
+
+// pause holds back all requests for the delay.
+func (t *throttle) pause(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(t.until) {
+		t.until = until
+	}
+}
+
+func (t *throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := time.Until(t.until)
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter parses a Retry-After header, which is either in seconds or an HTTP date.
+func retryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return defaultRetryAfter
+}
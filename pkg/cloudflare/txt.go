@@ -0,0 +1,161 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// SetPrefixRecords enables publishing the delegated IPv6 prefix as TXT records,
+// i.e. `_prefix.home.example.com`.
+func (u *Updater) SetPrefixRecords(records string) {
+	u.prefixRecords = strings.Split(records, ",")
+}
+
+func (u *Updater) receivePrefix(prefix *net.IPNet) {
+	if last := u.last.Prefix(); last != nil && last.String() == prefix.String() {
+		return
+	}
+
+	u.log.Info("Received prefix update request", slog.Any("prefix", prefix))
+
+	if u.latestPrefix == nil || u.latestPrefix.String() != prefix.String() {
+		u.backoffPrefix.attempts = 0
+	}
+
+	u.latestPrefix = prefix
+	u.publishPrefix(prefix)
+}
+
+// retryPrefix publishes the prefix again, unless it has been superseded
+// meanwhile.
+func (u *Updater) retryPrefix(prefix *net.IPNet) {
+	if u.latestPrefix == nil || u.latestPrefix.String() != prefix.String() {
+		return
+	}
+
+	if last := u.last.Prefix(); last != nil && last.String() == prefix.String() {
+		return
+	}
+
+	u.log.Info("Retrying prefix update request", slog.Any("prefix", prefix))
+
+	u.publishPrefix(prefix)
+}
+
+// publishPrefix publishes the prefix to the TXT records, the views and the
+// delegations. If a TXT record failed the prefix gets queued again after a
+// backoff.
+func (u *Updater) publishPrefix(prefix *net.IPNet) {
+	var errs []error
+
+	for _, action := range u.prefixActions {
+		errs = append(errs, u.applyTxt(action, prefix.String()))
+	}
+
+	u.publishViews(prefix)
+	u.publishDelegations(prefix)
+
+	if err := errors.Join(errs...); err != nil {
+		delay := u.backoffPrefix.next()
+
+		u.log.Warn("Failed to publish prefix, retrying later", slog.Any("prefix", prefix), slog.Duration("delay", delay), logging.ErrorAttr(err))
+
+		time.AfterFunc(delay, func() {
+			u.prefixRetries <- prefix
+		})
+
+		return
+	}
+
+	u.backoffPrefix.attempts = 0
+	u.last.SetPrefix(prefix)
+}
+
+// applyTxt creates or updates the TXT records of the action with the content,
+// it returns the errors of all failed records.
+func (u *Updater) applyTxt(action *Action, content string) error {
+	alog := u.log.With(slog.String("zone", action.DnsRecord+"/TXT"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	records, err := u.listRecords(ctx, action, "TXT", action.DnsRecord)
+
+	if err != nil {
+		alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
+		return err
+	}
+
+	zoneId, api := u.zoneOf(action)
+	rc := cf.ZoneIdentifier(zoneId)
+
+	ttl := u.ttlFor(action)
+
+	if len(records) == 0 {
+		if ttl == 0 {
+			ttl = defaultTTL
+		}
+
+		if u.dryRun {
+			alog.Info("Dry run, would create DNS record", slog.String("content", content), slog.Int("ttl", ttl))
+			return nil
+		}
+
+		alog.Info("Creating DNS record")
+
+		_, err := api.CreateDNSRecord(ctx, rc, cf.CreateDNSRecordParams{
+			Type:    "TXT",
+			Name:    action.DnsRecord,
+			Content: content,
+			TTL:     ttl,
+			ZoneID:  zoneId,
+		})
+
+		if err != nil {
+			alog.Error("Action failed, could not create DNS record", logging.ErrorAttr(err))
+		}
+
+		return err
+	}
+
+	var errs []error
+
+	for _, record := range records {
+		recordTtl := record.TTL
+
+		if ttl != 0 {
+			recordTtl = ttl
+		}
+
+		// Cloudflare may return TXT contents quoted
+		if strings.Trim(record.Content, `"`) == content && recordTtl == record.TTL {
+			continue
+		}
+
+		if u.dryRun {
+			alog.Info("Dry run, would update DNS record", slog.Any("record-id", record.ID), slog.String("old", record.Content), slog.String("new", content), slog.Int("ttl", recordTtl))
+			continue
+		}
+
+		alog.Info("Updating DNS record", slog.Any("record-id", record.ID))
+
+		_, err := api.UpdateDNSRecord(ctx, rc, cf.UpdateDNSRecordParams{
+			ID:      record.ID,
+			Content: content,
+			TTL:     recordTtl,
+		})
+
+		if err != nil {
+			alog.Error("Action failed, could not update DNS record", logging.ErrorAttr(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
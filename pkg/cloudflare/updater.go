@@ -2,13 +2,19 @@ package cloudflare
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	cf "github.com/cloudflare/cloudflare-go"
 	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
-	"golang.org/x/net/publicsuffix"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/probe"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/state"
 	"log/slog"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,34 +22,213 @@ type Action struct {
 	DnsRecord string
 	CfZoneId  string
 	IpVersion int
+	Staging   bool
+	// MirrorOf is the record this one mirrors, see SetMirrors
+	MirrorOf string
+
+	// Proxied enforces the proxy status of the records, nil keeps it as is
+	Proxied *bool
+	// TTL enforces the TTL of the records, 0 keeps it as is
+	TTL int
+	// Exclusive deletes all but one record instead of updating them all
+	Exclusive bool
+	// Mode restricts the action to creating or updating records
+	Mode Mode
+
+	api *cf.API
 }
 
 type Updater struct {
 	ipv4Zones []string
 	ipv6Zones []string
 
-	actions []*Action
+	ipv4StagingZones []string
+	ipv6StagingZones []string
+
+	prefixRecords   []string
+	metadataRecords []string
+	views           []*view
+	delegations     []*delegation
+
+	apis []*cf.API
+	// Resolve every zone only once, using the first API client with access to it
+	zones map[string]*zone
+	// The zones every API client has access to, listed on demand
+	accessible [][]cf.Zone
+	accountId  string
+	zoneIds    map[string]string
+	zoneTokens map[string]string
+	mirrors    map[string]string
+
+	instanceId string
+	standDown  bool
+	// Indices of the API clients of zone tokens, they follow the shared ones
+	zoneApis   map[string]int
+	sharedApis int
+
+	purgeCache bool
+	purgeUrls  map[string][]string
+	purgeDelay time.Duration
+
+	actions         []*Action
+	prefixActions   []*Action
+	metadataActions []*Action
+
+	ttl        int
+	exclusive  bool
+	dryRun     bool
+	httpsHints bool
+	// Zones updated at the same time
+	parallelism int
+	maxRps      float64
+	apiUrl      string
+	throttle    *throttle
+	// Serializes resolving zones between parallel updates
+	zonesMu sync.Mutex
 
 	isInit bool
-	api    *cf.API
 	log    *slog.Logger
 
-	In chan *net.IP
+	// State optionally keeps the outcome of every update attempt
+	State *state.Store
 
-	lastIpv4 *net.IP
-	lastIpv6 *net.IP
+	// Source tells where the last IP came from, for the metadata records
+	Source func() string
+	// Version of the service, for the metadata records
+	Version string
+
+	In       chan *net.IP
+	Prefixes chan *net.IPNet
+	// Records receives records managed at runtime, see DynamicRecord
+	Records chan *DynamicRecord
+
+	dynamicActions map[string]*Action
+
+	retries     chan *net.IP
+	backoffIpv4 *backoff
+	backoffIpv6 *backoff
+
+	prefixRetries chan *net.IPNet
+	backoffPrefix *backoff
+	// latestPrefix is the last prefix received, retries of older ones are dropped
+	latestPrefix *net.IPNet
+
+	last state.Last
+
+	confirm     chan *net.IP
+	probePort   int
+	probes      map[string]*probe.Probe
+	pendingIpv4 *pending
+	pendingIpv6 *pending
+
+	// stop ends the worker, which closes stopped once it returned
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	started  bool
 }
 
 func NewUpdater(log *slog.Logger) *Updater {
 	return &Updater{
-		isInit:    false,
-		In:        make(chan *net.IP, 10),
-		log:       log.With(slog.String("module", "cloudflare")),
-		ipv4Zones: make([]string, 0),
-		ipv6Zones: make([]string, 0),
+		isInit:        false,
+		In:            make(chan *net.IP, 10),
+		Prefixes:      make(chan *net.IPNet, 10),
+		Records:       make(chan *DynamicRecord, 10),
+		retries:       make(chan *net.IP, 2),
+		backoffIpv4:   &backoff{},
+		backoffIpv6:   &backoff{},
+		prefixRetries: make(chan *net.IPNet, 1),
+		backoffPrefix: &backoff{},
+		confirm:       make(chan *net.IP, 1),
+		log:           log.With(slog.String("updater", "cloudflare")),
+		ipv4Zones:     make([]string, 0),
+		ipv6Zones:     make([]string, 0),
+		parallelism:   4,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
 }
 
+// Mode restricts which changes an action may make to its records.
+type Mode string
+
+const (
+	// ModeUpsert creates missing records and updates existing ones
+	ModeUpsert Mode = ""
+	// ModeCreateOnly only creates missing records, existing ones are left alone
+	ModeCreateOnly Mode = "create-only"
+	// ModeUpdateOnly only updates existing records, missing ones aren't created
+	ModeUpdateOnly Mode = "update-only"
+)
+
+// defaultTTL is used for new records if no TTL is configured
+const defaultTTL = 120
+
+// recordOptions are the options a zone list entry can carry.
+type recordOptions struct {
+	proxied   *bool
+	ttl       int
+	exclusive bool
+	mode      Mode
+}
+
+// parseRecord splits a zone list entry like `www.example.com:proxied:ttl=300`
+// into the record name and its options.
+func parseRecord(entry string) (string, recordOptions, error) {
+	parts := strings.Split(entry, ":")
+	name := strings.TrimSuffix(strings.ToLower(parts[0]), ".")
+	options := recordOptions{}
+
+	// Wildcards are only allowed as the leftmost label, i.e. `*.home.example.com`
+	if strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+		return "", options, fmt.Errorf("invalid wildcard record %s, only `*.<domain>` is supported", name)
+	}
+
+	for _, option := range parts[1:] {
+		switch {
+		case option == "proxied":
+			proxied := true
+			options.proxied = &proxied
+		case option == "dns-only":
+			proxied := false
+			options.proxied = &proxied
+		case option == "exclusive":
+			options.exclusive = true
+		case option == string(ModeCreateOnly) || option == string(ModeUpdateOnly):
+			options.mode = Mode(option)
+		case strings.HasPrefix(option, "ttl="):
+			ttl, err := strconv.Atoi(strings.TrimPrefix(option, "ttl="))
+
+			if err != nil {
+				return "", options, fmt.Errorf("invalid ttl for record %s: %w", name, err)
+			}
+
+			options.ttl = ttl
+		default:
+			return "", options, fmt.Errorf("unknown option %q for record %s, expected proxied, dns-only, exclusive, create-only, update-only or ttl=<seconds>", option, name)
+		}
+	}
+
+	return name, options, nil
+}
+
+// SetTTL sets the TTL for all records without their own TTL, otherwise
+// existing records keep their TTL.
+func (u *Updater) SetTTL(ttl int) {
+	u.ttl = ttl
+}
+
+// SetParallelism sets how many zones are updated at the same time.
+func (u *Updater) SetParallelism(parallelism int) {
+	u.parallelism = max(parallelism, 1)
+}
+
+// SetExclusive makes every record exclusive, so stale records of previous tools
+// get deleted instead of updated.
+func (u *Updater) SetExclusive(exclusive bool) {
+	u.exclusive = exclusive
+}
+
 func (u *Updater) SetIPv4Zones(zones string) {
 	u.ipv4Zones = strings.Split(zones, ",")
 }
@@ -52,194 +237,679 @@ func (u *Updater) SetIPv6Zones(zones string) {
 	u.ipv6Zones = strings.Split(zones, ",")
 }
 
-func (u *Updater) InitWithToken(token string) error {
-	api, err := cf.NewWithAPIToken(token)
+// SetIPv4StagingZones enables the blue/green cutover for IPv4, see ConfirmCutover.
+func (u *Updater) SetIPv4StagingZones(zones string) {
+	u.ipv4StagingZones = strings.Split(zones, ",")
+}
 
-	if err != nil {
-		return err
-	}
+// SetIPv6StagingZones enables the blue/green cutover for IPv6, see ConfirmCutover.
+func (u *Updater) SetIPv6StagingZones(zones string) {
+	u.ipv6StagingZones = strings.Split(zones, ",")
+}
 
-	return u.init(api)
+// SetCutoverProbe lets a successful TCP connect to the staged IP on the given
+// port confirm the cutover automatically.
+func (u *Updater) SetCutoverProbe(port int) {
+	u.probePort = port
 }
 
-func (u *Updater) InitWithKey(email string, key string) error {
-	api, err := cf.New(key, email)
+// SetAccountId limits the zone lookup to the zones of the account, for tokens
+// with access to multiple accounts.
+func (u *Updater) SetAccountId(id string) {
+	u.accountId = id
+}
 
-	if err != nil {
-		return err
+// SetZoneIds maps domains to zone IDs, given as `domain=id` pairs separated by
+// commas. Records of the domain and its subdomains skip the zone lookup, which
+// is required for delegated subzones the public suffix list doesn't know.
+func (u *Updater) SetZoneIds(mapping string) error {
+	u.zoneIds = make(map[string]string)
+
+	for _, entry := range strings.Split(mapping, ",") {
+		name, id, ok := strings.Cut(entry, "=")
+
+		if !ok || name == "" || id == "" {
+			return fmt.Errorf("invalid zone id mapping %q, expected <domain>=<zone id>", entry)
+		}
+
+		u.zoneIds[normalizeDomain(name)] = id
 	}
 
-	return u.init(api)
+	return nil
 }
 
-func (u *Updater) init(api *cf.API) error {
-	// Create unique list of zones and fetch their CloudFlare zone IDs
-	zoneIdMap := make(map[string]string)
+// zoneIdFor returns the manually mapped zone ID of the most specific domain
+// which the record is part of.
+func (u *Updater) zoneIdFor(record string) (string, string, bool) {
+	return mostSpecific(normalizeDomain(record), u.zoneIds)
+}
+
+// normalizeDomain lowercases the domain and drops a trailing dot, so names
+// match however they were written.
+func normalizeDomain(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// SetZoneTokens maps domains to their own API tokens, given as `domain=token`
+// pairs separated by commas. Records of the domain and its subdomains only get
+// updated with that token, all others with the tokens passed to InitWithTokens.
+func (u *Updater) SetZoneTokens(mapping string) error {
+	u.zoneTokens = make(map[string]string)
 
-	for _, val := range u.ipv4Zones {
-		zoneIdMap[val] = ""
+	for _, entry := range strings.Split(mapping, ",") {
+		name, token, ok := strings.Cut(entry, "=")
+
+		if !ok || name == "" || token == "" {
+			return fmt.Errorf("invalid zone token mapping for %q, expected <domain>=<token>", name)
+		}
+
+		u.zoneTokens[normalizeDomain(name)] = token
 	}
 
-	for _, val := range u.ipv6Zones {
-		zoneIdMap[val] = ""
+	return nil
+}
+
+// mostSpecific looks up the most specific domain which the record is part of.
+func mostSpecific[V any](record string, domains map[string]V) (string, V, bool) {
+	for name := record; name != ""; {
+		if v, ok := domains[name]; ok {
+			return name, v, true
+		}
+
+		_, parent, found := strings.Cut(name, ".")
+
+		if !found {
+			break
+		}
+
+		name = parent
 	}
 
-	for val := range zoneIdMap {
-		zone, err := publicsuffix.EffectiveTLDPlusOne(val)
+	var zero V
+
+	return "", zero, false
+}
+
+func (u *Updater) InitWithToken(token string) error {
+	return u.InitWithTokens([]string{token})
+}
+
+// InitWithTokens allows to use separate tokens for different zones, every zone
+// gets updated with the first token which has access to it.
+func (u *Updater) InitWithTokens(tokens []string) error {
+	apis := make([]*cf.API, 0, len(tokens))
+
+	for _, token := range tokens {
+		api, err := cf.NewWithAPIToken(token, u.apiOptions()...)
 
 		if err != nil {
 			return err
 		}
 
-		id, err := api.ZoneIDByName(zone)
+		apis = append(apis, api)
+	}
+
+	u.sharedApis = len(apis)
+	u.zoneApis = make(map[string]int)
+
+	for name, token := range u.zoneTokens {
+		api, err := cf.NewWithAPIToken(token, u.apiOptions()...)
 
 		if err != nil {
 			return err
 		}
 
-		zoneIdMap[val] = id
+		u.zoneApis[name] = len(apis)
+		apis = append(apis, api)
+	}
+
+	return u.init(apis)
+}
+
+func (u *Updater) InitWithKey(email string, key string) error {
+	api, err := cf.New(key, email, u.apiOptions()...)
+
+	if err != nil {
+		return err
+	}
+
+	u.sharedApis = 1
+
+	return u.init([]*cf.API{api})
+}
+
+func (u *Updater) init(apis []*cf.API) error {
+	u.apis = apis
+	u.zones = make(map[string]*zone)
+	u.dynamicActions = make(map[string]*Action)
+	u.accessible = make([][]cf.Zone, len(apis))
+
+	err := u.verifyCredentials()
+
+	if err != nil {
+		return err
 	}
 
 	// Now create an updater action list
-	for _, val := range u.ipv4Zones {
-		a := &Action{
-			DnsRecord: val,
-			CfZoneId:  zoneIdMap[val],
-			IpVersion: 4,
+	groups := []struct {
+		records   []string
+		ipVersion int
+		staging   bool
+	}{
+		{u.ipv4Zones, 4, false},
+		{u.ipv6Zones, 6, false},
+		{u.ipv4StagingZones, 4, true},
+		{u.ipv6StagingZones, 6, true},
+	}
+
+	for _, group := range groups {
+		for _, entry := range group.records {
+			val, options, err := parseRecord(entry)
+
+			if err != nil {
+				return err
+			}
+
+			z, err := u.resolveZone(val)
+
+			if err != nil {
+				return err
+			}
+
+			a := &Action{
+				DnsRecord: val,
+				CfZoneId:  z.id,
+				IpVersion: group.ipVersion,
+				Staging:   group.staging,
+				Proxied:   options.proxied,
+				TTL:       options.ttl,
+				Exclusive: options.exclusive || u.exclusive,
+				Mode:      options.mode,
+				api:       z.api,
+			}
+
+			u.actions = append(u.actions, a)
+
+			m, err := u.mirrorAction(a)
+
+			if err != nil {
+				return err
+			}
+
+			if m != nil {
+				u.actions = append(u.actions, m)
+			}
 		}
+	}
+
+	err = u.initViews()
 
-		u.actions = append(u.actions, a)
+	if err != nil {
+		return err
 	}
 
-	for _, val := range u.ipv6Zones {
-		a := &Action{
-			DnsRecord: val,
-			CfZoneId:  zoneIdMap[val],
-			IpVersion: 6,
+	err = u.initDelegations()
+
+	if err != nil {
+		return err
+	}
+
+	for _, group := range []struct {
+		records []string
+		actions *[]*Action
+	}{
+		{u.prefixRecords, &u.prefixActions},
+		{u.metadataRecords, &u.metadataActions},
+	} {
+		for _, val := range group.records {
+			z, err := u.resolveZone(val)
+
+			if err != nil {
+				return err
+			}
+
+			a := &Action{
+				DnsRecord: val,
+				CfZoneId:  z.id,
+				IpVersion: 6,
+				api:       z.api,
+			}
+
+			*group.actions = append(*group.actions, a)
 		}
+	}
+
+	err = u.verifyZones()
+
+	if err != nil {
+		return err
+	}
 
-		u.actions = append(u.actions, a)
+	if len(apis) > 1 {
+		u.log.Info("Resolved zones with multiple API tokens", slog.Int("tokens", len(apis)), slog.Int("zones", len(u.zones)))
 	}
 
-	u.api = api
 	u.isInit = true
 
 	return nil
 }
 
+// DeleteRecords deletes the records of all zones of the IP version, i.e. because
+// the IP version isn't published anymore.
+func (u *Updater) DeleteRecords(ipVersion int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	recordType := "A"
+
+	if ipVersion == 6 {
+		recordType = "AAAA"
+	}
+
+	for _, action := range u.actions {
+		if action.IpVersion != ipVersion {
+			continue
+		}
+
+		alog := u.log.With(slog.String("zone", fmt.Sprintf("%s/IPv%d", action.DnsRecord, action.IpVersion)))
+		records, err := u.listRecords(ctx, action, recordType, action.DnsRecord)
+
+		if err != nil {
+			alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
+			continue
+		}
+
+		zoneId, api := u.zoneOf(action)
+		rc := cf.ZoneIdentifier(zoneId)
+
+		for _, record := range records {
+			if u.dryRun {
+				alog.Info("Dry run, would delete unpublished DNS record", slog.Any("record-id", record.ID), slog.String("content", record.Content))
+				continue
+			}
+
+			alog.Info("Deleting unpublished DNS record", slog.Any("record-id", record.ID))
+
+			err := api.DeleteDNSRecord(ctx, rc, record.ID)
+
+			if err != nil {
+				alog.Error("Action failed, could not delete DNS record", logging.ErrorAttr(err))
+			}
+		}
+	}
+
+	// Publish the IP again once the IP version comes back
+	u.last.Forget(ipVersion)
+}
+
 func (u *Updater) StartWorker() {
 	if !u.isInit {
 		return
 	}
 
+	u.started = true
+
 	go u.spawnWorker()
 }
 
+// Stop stops the worker once it finished the current update and waits for
+// it, queued IPs and pending retries are dropped.
+func (u *Updater) Stop() {
+	if !u.started {
+		return
+	}
+
+	u.stopOnce.Do(func() {
+		close(u.stop)
+	})
+
+	<-u.stopped
+}
+
 func (u *Updater) spawnWorker() {
+	defer close(u.stopped)
+
 	for {
 		select {
 		case ip := <-u.In:
-			if ip.To4() == nil {
-				if u.lastIpv6 != nil && u.lastIpv6.Equal(*ip) {
-					continue
-				}
-			} else {
-				if u.lastIpv4 != nil && u.lastIpv4.Equal(*ip) {
-					continue
-				}
+			if u.last.Is(*ip) {
+				continue
 			}
-			u.log.Info("Received update request", slog.Any("ip", ip))
-
-			for _, action := range u.actions {
-				// Skip IPv6 action mismatching IP version
-				if ip.To4() == nil && action.IpVersion != 6 {
-					continue
-				}
-
-				// Skip IPv4 action mismatching IP version
-				if ip.To4() != nil && action.IpVersion == 6 {
-					continue
-				}
-
-				// Create detailed sub-logger for this action
-				alog := u.log.With(slog.String("domain", fmt.Sprintf("%s/IPv%d", action.DnsRecord, action.IpVersion)))
-
-				// Decide record type on ip version
-				var recordType string
-
-				if ip.To4() == nil {
-					recordType = "AAAA"
-				} else {
-					recordType = "A"
-				}
-
-				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-
-				rc := cf.ZoneIdentifier(action.CfZoneId)
-
-				// Research all current records matching the current scheme
-				records, _, err := u.api.ListDNSRecords(ctx, rc, cf.ListDNSRecordsParams{
-					Type: recordType,
-					Name: action.DnsRecord,
-				})
-
-				if err != nil {
-					alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
-					continue
-				}
-
-				// Create record if none were found
-				if len(records) == 0 {
-					alog.Info("Creating DNS record")
-
-					proxied := false
-
-					_, err := u.api.CreateDNSRecord(ctx, rc, cf.CreateDNSRecordParams{
-						Type:    recordType,
-						Name:    action.DnsRecord,
-						Content: ip.String(),
-						Proxied: &proxied,
-						TTL:     120,
-						ZoneID:  action.CfZoneId,
-					})
-
-					if err != nil {
-						alog.Error("Action failed, could not create DNS record", logging.ErrorAttr(err))
-						continue
-					}
-				}
-
-				// Update existing records
-				for _, record := range records {
-					alog.Info("Updating DNS record", slog.Any("record-id", record.ID))
-
-					if record.Content == ip.String() {
-						continue
-					}
-
-					// Ensure we submit all required fields even if they did not change,otherwise
-					// cloudflare-go might revert them to default values.
-					_, err := u.api.UpdateDNSRecord(ctx, rc, cf.UpdateDNSRecordParams{
-						ID:      record.ID,
-						Content: ip.String(),
-						TTL:     record.TTL,
-						Proxied: record.Proxied,
-					})
-
-					if err != nil {
-						alog.Error("Action failed, could not update DNS record", logging.ErrorAttr(err))
-						continue
-					}
-				}
-
-				cancel()
+
+			u.log.Info("Received update request", slog.String("source", u.source()), slog.Any("ip", ip))
+
+			u.backoffFor(ip).reset(ip)
+			u.handle(ip)
+		case ip := <-u.retries:
+			u.retry(ip)
+		case ip := <-u.confirm:
+			u.cutover(ip)
+		case prefix := <-u.Prefixes:
+			u.receivePrefix(prefix)
+		case prefix := <-u.prefixRetries:
+			u.retryPrefix(prefix)
+		case record := <-u.Records:
+			u.applyDynamic(record)
+		case <-u.stop:
+			return
+		}
+	}
+}
+
+func (u *Updater) setLast(ip *net.IP) {
+	u.last.Set(*ip)
+}
+
+// Last returns the last published IPs and prefix, it's safe to read while the
+// worker is running.
+func (u *Updater) Last() *state.Last {
+	return &u.last
+}
+
+// publish updates all production or staging records matching the IP version,
+// it returns the errors of all failed records. Zones are updated in parallel.
+func (u *Updater) publish(ip *net.IP, staging bool) error {
+	var changed []*Action
+	var published []*Action
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	// Records sharing a zone get updated in a single batch
+	var zoneIds []string
+	zones := make(map[string][]*Action)
+
+	for _, action := range u.actions {
+		if action.Staging != staging {
+			continue
+		}
+
+		// Skip IPv6 action mismatching IP version
+		if ip.To4() == nil && action.IpVersion != 6 {
+			continue
+		}
+
+		// Skip IPv4 action mismatching IP version
+		if ip.To4() != nil && action.IpVersion == 6 {
+			continue
+		}
+
+		zoneId, _ := u.zoneOf(action)
+
+		if _, ok := zones[zoneId]; !ok {
+			zoneIds = append(zoneIds, zoneId)
+		}
+
+		zones[zoneId] = append(zones[zoneId], action)
+		published = append(published, action)
+	}
+
+	slots := make(chan struct{}, u.parallelism)
+
+	for _, zoneId := range zoneIds {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			zoneChanged, err := u.applyZone(zones[zoneId], ip)
+
+			mu.Lock()
+			changed = append(changed, zoneChanged...)
+			errs = append(errs, err)
+			mu.Unlock()
+
+			<-slots
+		}()
+	}
+
+	wg.Wait()
+
+	u.publishHints(published, ip)
+
+	// Nobody visits staging records through the proxy
+	if !staging {
+		u.purge(changed)
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyZone updates the records of actions sharing a zone, it returns the
+// actions whose proxied records point to a new origin.
+func (u *Updater) applyZone(actions []*Action, ip *net.IP) ([]*Action, error) {
+	if len(actions) > 1 {
+		return u.applyBatch(actions, ip)
+	}
+
+	originChanged, err := u.apply(actions[0], ip)
+
+	if originChanged {
+		return actions, err
+	}
+
+	return nil, err
+}
+
+// ttlFor returns the TTL configured for the action, or 0 if none is.
+func (u *Updater) ttlFor(action *Action) int {
+	if action.TTL != 0 {
+		return action.TTL
+	}
+
+	return u.ttl
+}
+
+// recordType decides the DNS record type on the IP version.
+func recordType(ip *net.IP) string {
+	if ip.To4() == nil {
+		return "AAAA"
+	}
+
+	return "A"
+}
+
+// apply updates the records of the action to the IP, it returns whether the
+// origin of a proxied record changed.
+func (u *Updater) apply(action *Action, ip *net.IP) (bool, error) {
+	// Create detailed sub-logger for this action
+	alog := u.log.With(slog.String("zone", fmt.Sprintf("%s/IPv%d", action.DnsRecord, action.IpVersion)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Research all current records matching the current scheme
+	records, err := u.listRecords(ctx, action, recordType(ip), action.DnsRecord)
+
+	if err != nil {
+		alog.Error("Action failed, could not research DNS records", logging.ErrorAttr(err))
+		u.recordAttempt(action, ip, err)
+		return false, err
+	}
+
+	originChanged, err := u.execute(ctx, u.plan(action, ip, records))
+	u.recordAttempt(action, ip, err)
+
+	return originChanged, err
+}
+
+// change holds the modifications required to point the records of an action
+// to a new IP.
+type change struct {
+	action  *Action
+	create  *cf.CreateDNSRecordParams
+	updates []cf.UpdateDNSRecordParams
+	deletes []string
+
+	// previous holds the current content of the updated and deleted records
+	previous map[string]string
+
+	// originChanged tells whether a proxied record points to a new IP
+	originChanged bool
+}
+
+// plan decides how the records of the action have to change for the IP.
+func (u *Updater) plan(action *Action, ip *net.IP, records []cf.DNSRecord) *change {
+	c := &change{action: action, previous: make(map[string]string)}
+
+	// Create record if none were found
+	if len(records) == 0 && action.Mode != ModeUpdateOnly {
+		proxied := false
+
+		if action.Proxied != nil {
+			proxied = *action.Proxied
+		}
+
+		ttl := u.ttlFor(action)
+
+		if ttl == 0 {
+			ttl = defaultTTL
+		}
+
+		c.create = &cf.CreateDNSRecordParams{
+			Type:    recordType(ip),
+			Name:    action.DnsRecord,
+			Content: ip.String(),
+			Proxied: &proxied,
+			TTL:     ttl,
+		}
+
+		if comment := u.ownerComment(""); comment != nil {
+			c.create.Comment = *comment
+		}
+	}
+
+	// Another instance wrote the records recently, so they're left alone
+	if slices.ContainsFunc(records, func(r cf.DNSRecord) bool { return u.conflicts(action, r, ip.String()) }) {
+		return c
+	}
+
+	// Records managed elsewhere must not be touched
+	if action.Mode == ModeCreateOnly {
+		return c
+	}
+
+	// Keep only a single record, preferably one already pointing to the IP
+	if action.Exclusive && len(records) > 1 {
+		keep := 0
+
+		for i, record := range records {
+			if record.Content == ip.String() {
+				keep = i
+				break
 			}
+		}
 
-			if ip.To4() == nil {
-				u.lastIpv6 = ip
-			} else {
-				u.lastIpv4 = ip
+		for i, record := range records {
+			if i != keep {
+				c.deletes = append(c.deletes, record.ID)
+				c.previous[record.ID] = record.Content
 			}
 		}
+
+		records = records[keep : keep+1]
+	}
+
+	// Update existing records
+	for _, record := range records {
+		proxied := record.Proxied
+
+		if action.Proxied != nil {
+			proxied = action.Proxied
+		}
+
+		ttl := record.TTL
+
+		if v := u.ttlFor(action); v != 0 {
+			ttl = v
+		}
+
+		if record.Content == ip.String() && ttl == record.TTL && (proxied == nil || record.Proxied != nil && *record.Proxied == *proxied) {
+			continue
+		}
+
+		// Ensure we submit all required fields even if they did not change,otherwise
+		// cloudflare-go might revert them to default values.
+		c.updates = append(c.updates, cf.UpdateDNSRecordParams{
+			ID:      record.ID,
+			Content: ip.String(),
+			TTL:     ttl,
+			Proxied: proxied,
+			Comment: u.ownerComment(record.Comment),
+		})
+
+		c.previous[record.ID] = record.Content
+
+		if record.Content != ip.String() && proxied != nil && *proxied {
+			c.originChanged = true
+		}
+	}
+
+	return c
+}
+
+// execute applies the change record by record, it returns whether the origin
+// of a proxied record changed.
+func (u *Updater) execute(ctx context.Context, c *change) (bool, error) {
+	if u.dryRun {
+		u.report(c)
+		return c.originChanged, nil
+	}
+
+	alog := u.log.With(slog.String("zone", fmt.Sprintf("%s/IPv%d", c.action.DnsRecord, c.action.IpVersion)))
+
+	zoneId, api := u.zoneOf(c.action)
+	rc := cf.ZoneIdentifier(zoneId)
+
+	if c.create != nil {
+		alog.Info("Creating DNS record")
+
+		create := *c.create
+		create.ZoneID = zoneId
+
+		_, err := api.CreateDNSRecord(ctx, rc, create)
+
+		if err != nil {
+			alog.Error("Action failed, could not create DNS record", logging.ErrorAttr(err))
+			return false, err
+		}
+
+		metrics.RecordChanges.Inc("cloudflare", "created")
+	}
+
+	var errs []error
+
+	for _, id := range c.deletes {
+		alog.Info("Deleting stale DNS record", slog.Any("record-id", id))
+
+		err := api.DeleteDNSRecord(ctx, rc, id)
+
+		if err != nil {
+			alog.Error("Action failed, could not delete DNS record", logging.ErrorAttr(err))
+			errs = append(errs, err)
+			continue
+		}
+
+		metrics.RecordChanges.Inc("cloudflare", "deleted")
 	}
+
+	originChanged := false
+
+	for _, update := range c.updates {
+		alog.Info("Updating DNS record", slog.Any("record-id", update.ID))
+
+		_, err := api.UpdateDNSRecord(ctx, rc, update)
+
+		if err != nil {
+			alog.Error("Action failed, could not update DNS record", logging.ErrorAttr(err))
+			errs = append(errs, err)
+			continue
+		}
+
+		metrics.RecordChanges.Inc("cloudflare", "updated")
+		originChanged = c.originChanged
+	}
+
+	return originChanged, errors.Join(errs...)
 }
@@ -0,0 +1,185 @@
+package cloudflare
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/internal/cloudflaremock"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testZones = []string{"a.test", "b.test", "c.test", "d.test", "e.test", "f.test"}
+
+// testRecords has a record in every zone and two in one of them, so the zones
+// are updated in parallel and one of them in a batch.
+const testRecords = "home.a.test,home.b.test,home.c.test,home.d.test,home.e.test,home.f.test,vpn.f.test"
+
+func newTestUpdater(t *testing.T) (*Updater, *cloudflaremock.Mock) {
+	t.Helper()
+
+	mock := cloudflaremock.New(testZones...)
+	t.Cleanup(mock.Close)
+
+	u := NewUpdater(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	u.SetApiUrl(mock.URL)
+	u.SetMaxRequestsPerSecond(1000)
+	u.SetParallelism(3)
+	u.SetIPv4Zones(testRecords)
+
+	err := u.InitWithToken("token")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u, mock
+}
+
+// unpublished returns the records which don't point to the IP yet.
+func unpublished(mock *cloudflaremock.Mock, ip net.IP) []string {
+	var names []string
+
+	for _, name := range strings.Split(testRecords, ",") {
+		records := mock.Records("A", name)
+
+		if len(records) != 1 || records[0].Content != ip.String() {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func TestPublish(t *testing.T) {
+	u, mock := newTestUpdater(t)
+
+	for _, ip := range []net.IP{net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")} {
+		err := u.publish(&ip, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if names := unpublished(mock, ip); len(names) > 0 {
+			t.Fatalf("expected all records to point to %s, these don't: %v", ip, names)
+		}
+	}
+}
+
+// TestPublishRelocates moves zones between two updates, so the parallel
+// updates resolve them again while the others are still running. It's meant
+// to be run with -race.
+func TestPublishRelocates(t *testing.T) {
+	u, mock := newTestUpdater(t)
+
+	ip := net.ParseIP("203.0.113.1")
+
+	if err := u.publish(&ip, false); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.MoveZone("b.test")
+	mock.MoveZone("f.test")
+
+	ip = net.ParseIP("203.0.113.2")
+
+	if err := u.publish(&ip, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := unpublished(mock, ip); len(names) > 0 {
+		t.Fatalf("expected all records to point to %s, these don't: %v", ip, names)
+	}
+}
+
+// TestWorker feeds IPs to the worker while the last IPs are read, like the
+// servers do, and stops it. It's meant to be run with -race.
+func TestWorker(t *testing.T) {
+	u, mock := newTestUpdater(t)
+	u.StartWorker()
+
+	done := make(chan struct{})
+	read := make(chan struct{})
+
+	go func() {
+		defer close(read)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				u.Last().Ipv4()
+			}
+		}
+	}()
+
+	ip := net.ParseIP("203.0.113.3")
+	u.In <- &ip
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !u.Last().Is(ip) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the worker didn't publish %s, these records are missing: %v", ip, unpublished(mock, ip))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(done)
+	<-read
+
+	u.Stop()
+
+	if names := unpublished(mock, ip); len(names) > 0 {
+		t.Errorf("expected all records to point to %s, these don't: %v", ip, names)
+	}
+}
+
+// TestZoneIdsMixedCase maps the zones however they are written, the mapping
+// swaps the IDs of the zones to tell it apart from the listed zones.
+func TestZoneIdsMixedCase(t *testing.T) {
+	mock := cloudflaremock.New(testZones...)
+	t.Cleanup(mock.Close)
+
+	u := NewUpdater(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	u.SetApiUrl(mock.URL)
+	u.SetIPv4Zones("Home.A.Test,home.b.test.,HOME.C.TEST")
+
+	err := u.SetZoneIds("A.TEST=" + mock.ZoneId("b.test") + ",B.Test.=" + mock.ZoneId("c.test") + ",c.test=" + mock.ZoneId("a.test"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.InitWithToken("token"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		record string
+		want   string
+	}{
+		{"Home.A.Test", mock.ZoneId("b.test")},
+		{"home.b.test.", mock.ZoneId("c.test")},
+		{"HOME.C.TEST", mock.ZoneId("a.test")},
+		// Not mapped, but matched against the listed zones all the same
+		{"VPN.D.TEST.", ""},
+	}
+
+	for _, test := range tests {
+		z, err := u.resolveZone(test.record)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.record, err)
+			continue
+		}
+
+		if test.want != "" && z.id != test.want {
+			t.Errorf("%s resolved to zone %s, expected %s", test.record, z.id, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	cf "github.com/cloudflare/cloudflare-go"
+	"log/slog"
+	"time"
+)
+
+// verifyCredentials checks every API client, so invalid credentials are
+// reported on startup instead of on the first IP update.
+func (u *Updater) verifyCredentials() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for i, api := range u.apis {
+		// Legacy API keys can't be verified themselves
+		if api.APIToken == "" {
+			_, err := api.UserDetails(ctx)
+
+			if err != nil {
+				return fmt.Errorf("invalid API key: %w", err)
+			}
+
+			continue
+		}
+
+		token, err := api.VerifyAPIToken(ctx)
+
+		if err != nil {
+			return fmt.Errorf("invalid API token #%d: %w", i+1, err)
+		}
+
+		if token.Status != "active" {
+			return fmt.Errorf("API token #%d is %s", i+1, token.Status)
+		}
+
+		if !token.ExpiresOn.IsZero() && time.Until(token.ExpiresOn) < 7*24*time.Hour {
+			u.log.Warn("API token expires soon", slog.Int("token", i+1), slog.Time("expires", token.ExpiresOn))
+		}
+	}
+
+	return nil
+}
+
+// verifyZones checks that the DNS records of every resolved zone are
+// accessible.
+func (u *Updater) verifyZones() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for name, z := range u.zones {
+		_, _, err := z.api.ListDNSRecords(ctx, cf.ZoneIdentifier(z.id), cf.ListDNSRecordsParams{
+			ResultInfo: cf.ResultInfo{Page: 1, PerPage: 1},
+		})
+
+		if err != nil {
+			return fmt.Errorf("no permission to read the DNS records of zone %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package cloudflare
+
+import (
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipv6"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// view publishes the same IP event to its own records, i.e. internal names
+// pointing to a LAN address next to external names pointing to the WAN.
+type view struct {
+	name string
+	// source is `ipv4` or `ipv6` for the WAN IPs, or `lan` for an address
+	// constructed from the IPv6 prefix and localIp
+	source  string
+	localIp net.IP
+	records []string
+	actions []*Action
+}
+
+// SetViews parses view definitions like
+// `ext:ipv4=host.ext.example.com;int:lan@::1234:5678:90ab:cdef=host.int.example.com`.
+func (u *Updater) SetViews(definitions string) error {
+	for _, definition := range strings.Split(definitions, ";") {
+		if definition == "" {
+			continue
+		}
+
+		head, records, ok := strings.Cut(definition, "=")
+
+		if !ok || records == "" {
+			return fmt.Errorf("invalid view %s, expected <name>:<source>=<records>", definition)
+		}
+
+		name, source, ok := strings.Cut(head, ":")
+
+		if !ok || name == "" {
+			return fmt.Errorf("invalid view %s, expected <name>:<source>=<records>", definition)
+		}
+
+		v := &view{name: name, source: source, records: strings.Split(records, ",")}
+
+		if localIp, ok := strings.CutPrefix(source, "lan@"); ok {
+			v.source = "lan"
+			v.localIp = net.ParseIP(localIp)
+
+			if v.localIp == nil || v.localIp.To4() != nil {
+				return fmt.Errorf("invalid interface identifier %s of view %s", localIp, name)
+			}
+		} else if source != "ipv4" && source != "ipv6" {
+			return fmt.Errorf("invalid source %s of view %s, expected ipv4, ipv6 or lan@<interface identifier>", source, name)
+		}
+
+		u.views = append(u.views, v)
+	}
+
+	return nil
+}
+
+// NeedsPrefixes tells whether the updater has to receive the IPv6 prefix.
+func (u *Updater) NeedsPrefixes() bool {
+	if len(u.prefixRecords) > 0 || len(u.delegations) > 0 {
+		return true
+	}
+
+	for _, v := range u.views {
+		if v.source == "lan" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// initViews creates the actions of all views, the ones of WAN views are
+// published along with the configured zones.
+func (u *Updater) initViews() error {
+	for _, v := range u.views {
+		for _, entry := range v.records {
+			val, options, err := parseRecord(entry)
+
+			if err != nil {
+				return err
+			}
+
+			z, err := u.resolveZone(val)
+
+			if err != nil {
+				return err
+			}
+
+			a := &Action{
+				DnsRecord: val,
+				CfZoneId:  z.id,
+				IpVersion: 6,
+				Proxied:   options.proxied,
+				TTL:       options.ttl,
+				Exclusive: options.exclusive || u.exclusive,
+				Mode:      options.mode,
+				api:       z.api,
+			}
+
+			switch v.source {
+			case "ipv4":
+				a.IpVersion = 4
+				u.actions = append(u.actions, a)
+			case "ipv6":
+				u.actions = append(u.actions, a)
+			default:
+				v.actions = append(v.actions, a)
+			}
+		}
+
+		u.log.Info("Publishing view", slog.String("view", v.name), slog.String("source", v.source), slog.Any("records", v.records))
+	}
+
+	return nil
+}
+
+// publishViews points the records of LAN views to their address in the prefix.
+func (u *Updater) publishViews(prefix *net.IPNet) {
+	for _, v := range u.views {
+		if v.source != "lan" {
+			continue
+		}
+
+		ip := ipv6.FromPrefix(prefix, v.localIp)
+
+		for _, action := range v.actions {
+			_, _ = u.apply(action, &ip)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cf "github.com/cloudflare/cloudflare-go"
+	"log/slog"
+	"strings"
+)
+
+type zone struct {
+	id  string
+	api *cf.API
+}
+
+// resolveZone finds the zone of the record, see SetZoneIds.
+func (u *Updater) resolveZone(record string) (*zone, error) {
+	u.zonesMu.Lock()
+	defer u.zonesMu.Unlock()
+
+	return u.resolveZoneLocked(record)
+}
+
+// resolveZoneLocked is resolveZone for callers already holding zonesMu.
+func (u *Updater) resolveZoneLocked(record string) (*zone, error) {
+	if name, id, ok := u.zoneIdFor(record); ok {
+		return u.resolveZoneId(name, id)
+	}
+
+	var best *zone
+	bestName := ""
+	record = normalizeDomain(record)
+
+	for _, i := range u.apisFor(record) {
+		api := u.apis[i]
+
+		if u.accessible[i] == nil {
+			// Tokens might see same-named zones of other accounts as well
+			list, err := api.ListZonesContext(context.Background(), cf.WithZoneFilters("", u.accountId, ""))
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to list zones: %w", err)
+			}
+
+			u.accessible[i] = append([]cf.Zone{}, list.Result...)
+		}
+
+		// The longest matching suffix is the most specific zone, which
+		// might be a subdomain zone itself
+		for _, z := range u.accessible[i] {
+			name := normalizeDomain(z.Name)
+
+			if len(name) <= len(bestName) || record != name && !strings.HasSuffix(record, "."+name) {
+				continue
+			}
+
+			best = &zone{id: z.ID, api: api}
+			bestName = name
+		}
+	}
+
+	if best == nil && u.accountId != "" {
+		return nil, fmt.Errorf("no API token has access to a zone of %s in account %s", record, u.accountId)
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no API token has access to a zone of %s", record)
+	}
+
+	if z, ok := u.zones[bestName]; ok && z.id == best.id {
+		return z, nil
+	}
+
+	u.zones[bestName] = best
+
+	return best, nil
+}
+
+// resolveZoneId finds the API client with access to the manually mapped zone.
+func (u *Updater) resolveZoneId(name string, id string) (*zone, error) {
+	if z, ok := u.zones[name]; ok {
+		return z, nil
+	}
+
+	candidates := u.apisFor(name)
+
+	// With a single API client there is nothing to choose from
+	if len(candidates) == 1 {
+		u.zones[name] = &zone{id: id, api: u.apis[candidates[0]]}
+
+		return u.zones[name], nil
+	}
+
+	err := errors.New("no API token configured")
+
+	for _, i := range candidates {
+		_, err = u.apis[i].ZoneDetails(context.Background(), id)
+
+		if err != nil {
+			continue
+		}
+
+		u.zones[name] = &zone{id: id, api: u.apis[i]}
+
+		return u.zones[name], nil
+	}
+
+	return nil, fmt.Errorf("no API token has access to zone %s (%s): %w", name, id, err)
+}
+
+// apisFor returns the indices of the API clients allowed to update the record.
+func (u *Updater) apisFor(record string) []int {
+	if _, i, ok := mostSpecific(normalizeDomain(record), u.zoneApis); ok {
+		return []int{i}
+	}
+
+	indices := make([]int, u.sharedApis)
+
+	for i := range indices {
+		indices[i] = i
+	}
+
+	return indices
+}
+
+// isZoneGone checks whether the error indicates that the zone ID is not valid
+// anymore, i.e. because the zone moved to another account.
+func isZoneGone(err error) bool {
+	var notFound *cf.NotFoundError
+	var unauthorized *cf.AuthorizationError
+
+	return errors.As(err, &notFound) || errors.As(err, &unauthorized)
+}
+
+// zoneOf returns the zone ID and the API client of the action, which relocate
+// rewrites while other zones are being updated in parallel.
+func (u *Updater) zoneOf(action *Action) (string, *cf.API) {
+	u.zonesMu.Lock()
+	defer u.zonesMu.Unlock()
+
+	return action.CfZoneId, action.api
+}
+
+// relocate resolves the zone of the action again, it returns whether the zone
+// changed and the operation is worth a retry.
+func (u *Updater) relocate(action *Action) bool {
+	u.zonesMu.Lock()
+	defer u.zonesMu.Unlock()
+
+	old := action.CfZoneId
+
+	// Drop everything we know, the zone might have moved to another token as well
+	u.accessible = make([][]cf.Zone, len(u.apis))
+
+	z, err := u.resolveZoneLocked(action.DnsRecord)
+
+	if err != nil || z.id == old && z.api == action.api {
+		return false
+	}
+
+	u.log.Warn("Zone moved, updating zone ID", slog.String("zone", action.DnsRecord), slog.String("old-zone-id", old), slog.String("zone-id", z.id))
+
+	// Every other action of the same zone moved as well
+	dynamicActions := make([]*Action, 0, len(u.dynamicActions))
+
+	for _, a := range u.dynamicActions {
+		dynamicActions = append(dynamicActions, a)
+	}
+
+	for _, actions := range [][]*Action{u.actions, u.prefixActions, u.metadataActions, dynamicActions} {
+		for _, a := range actions {
+			if a.CfZoneId == old {
+				a.CfZoneId = z.id
+				a.api = z.api
+			}
+		}
+	}
+
+	return true
+}
+
+// listRecords lists the records with the name in the zone of the action, or all
+// of them if the name is empty. If the zone moved it gets resolved again before
+// retrying once.
+func (u *Updater) listRecords(ctx context.Context, action *Action, recordType string, name string) ([]cf.DNSRecord, error) {
+	params := cf.ListDNSRecordsParams{
+		Type: recordType,
+		Name: name,
+	}
+
+	zoneId, api := u.zoneOf(action)
+	records, _, err := api.ListDNSRecords(ctx, cf.ZoneIdentifier(zoneId), params)
+
+	if err != nil && isZoneGone(err) && u.relocate(action) {
+		zoneId, api = u.zoneOf(action)
+		records, _, err = api.ListDNSRecords(ctx, cf.ZoneIdentifier(zoneId), params)
+	}
+
+	return records, err
+}
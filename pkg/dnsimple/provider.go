@@ -0,0 +1,190 @@
+package dnsimple
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+type record struct {
+	Id      int    `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Content string `json:"content"`
+	Ttl     int    `json:"ttl,omitempty"`
+}
+
+// Provider creates or updates A and AAAA records of zones hosted at DNSimple.
+//
+// see https://developer.dnsimple.com/v2/zones/records/
+type Provider struct {
+	Url   string
+	Token string
+	Ttl   int
+
+	client *http.Client
+
+	mu        sync.Mutex
+	accountId string
+}
+
+func NewProvider(token string) *Provider {
+	return &Provider{
+		Url:    "https://api.dnsimple.com/v2",
+		Token:  token,
+		Ttl:    300,
+		client: &http.Client{},
+	}
+}
+
+func (p *Provider) Update(ctx context.Context, name string, ip net.IP) error {
+	host, zone, err := updater.SplitRecord(name)
+
+	if err != nil {
+		return err
+	}
+
+	account, err := p.account(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	recordType := "A"
+
+	if ip.To4() == nil {
+		recordType = "AAAA"
+	}
+
+	params := url.Values{}
+	params.Set("name", host)
+	params.Set("type", recordType)
+
+	var records []record
+
+	err = p.call(ctx, "GET", fmt.Sprintf("/%s/zones/%s/records?%s", account, zone, params.Encode()), nil, &records)
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return p.call(ctx, "POST", fmt.Sprintf("/%s/zones/%s/records", account, zone), &record{
+			Name:    host,
+			Type:    recordType,
+			Content: ip.String(),
+			Ttl:     p.Ttl,
+		}, nil)
+	}
+
+	for _, r := range records {
+		if r.Content == ip.String() {
+			continue
+		}
+
+		err = p.call(ctx, "PATCH", fmt.Sprintf("/%s/zones/%s/records/%d", account, zone, r.Id), &record{
+			Content: ip.String(),
+		}, nil)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// account looks up the account the token belongs to, it is cached after the first success.
+func (p *Provider) account(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.accountId != "" {
+		return p.accountId, nil
+	}
+
+	var whoami struct {
+		Account *struct {
+			Id int `json:"id"`
+		} `json:"account"`
+	}
+
+	err := p.call(ctx, "GET", "/whoami", nil, &whoami)
+
+	if err != nil {
+		return "", err
+	}
+
+	if whoami.Account == nil {
+		return "", fmt.Errorf("dnsimple token is not an account token")
+	}
+
+	p.accountId = fmt.Sprint(whoami.Account.Id)
+
+	return p.accountId, nil
+}
+
+func (p *Provider) call(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewBuffer(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, p.Url+path, body)
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+p.Token)
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+
+		_ = json.NewDecoder(response.Body).Decode(&e)
+
+		return fmt.Errorf("dnsimple API error %s: %s", response.Status, e.Message)
+	}
+
+	if out != nil {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+
+		err = json.NewDecoder(response.Body).Decode(&envelope)
+
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(envelope.Data, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipv6"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Record is the IPv6 address of a labeled container, a nil IP means the
+// container is gone and its record should be deleted.
+type Record struct {
+	Name string
+	IP   net.IP
+}
+
+type container struct {
+	Labels          map[string]string `json:"Labels"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Watcher publishes the IPv6 addresses of containers labeled with a hostname.
+// The interface identifier of a container gets combined with the current
+// prefix, so the records follow prefix changes.
+type Watcher struct {
+	client *http.Client
+	log    *slog.Logger
+
+	Label string
+
+	Prefixes chan *net.IPNet
+	out      chan<- *Record
+
+	prefix    *net.IPNet
+	published map[string]string
+	resync    chan struct{}
+}
+
+func NewWatcher(socket string, out chan<- *Record, log *slog.Logger) *Watcher {
+	return &Watcher{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		log:       log.With(slog.String("module", "docker")),
+		Label:     "dyndns.hostname",
+		Prefixes:  make(chan *net.IPNet, 10),
+		out:       out,
+		published: make(map[string]string),
+		resync:    make(chan struct{}, 1),
+	}
+}
+
+func (w *Watcher) StartWorker() {
+	go w.watchEvents()
+	go w.spawnWorker()
+}
+
+func (w *Watcher) spawnWorker() {
+	w.sync()
+
+	for {
+		select {
+		case prefix := <-w.Prefixes:
+			if w.prefix != nil && w.prefix.String() == prefix.String() {
+				continue
+			}
+
+			w.prefix = prefix
+		case <-w.resync:
+		}
+
+		w.sync()
+	}
+}
+
+// sync publishes the differences between the running containers and the
+// records published so far.
+func (w *Watcher) sync() {
+	containers, err := w.list()
+
+	if err != nil {
+		w.log.Error("Failed to list containers", logging.ErrorAttr(err))
+		return
+	}
+
+	current := make(map[string]string)
+
+	for _, c := range containers {
+		name := c.Labels[w.Label]
+
+		if name == "" {
+			continue
+		}
+
+		if ip := w.address(c); ip != nil {
+			current[name] = ip.String()
+		}
+	}
+
+	for name, ip := range current {
+		if w.published[name] == ip {
+			continue
+		}
+
+		w.log.Info("Publishing container", slog.String("zone", name), slog.String("ip", ip))
+		w.out <- &Record{Name: name, IP: net.ParseIP(ip)}
+	}
+
+	for name := range w.published {
+		if _, ok := current[name]; !ok {
+			w.log.Info("Container is gone, deleting its record", slog.String("zone", name))
+			w.out <- &Record{Name: name}
+		}
+	}
+
+	w.published = current
+}
+
+// address derives the public IPv6 of the container from the current prefix
+// and the interface identifier of its first global address.
+func (w *Watcher) address(c container) net.IP {
+	for _, network := range c.NetworkSettings.Networks {
+		ip := net.ParseIP(network.GlobalIPv6Address)
+
+		if ip == nil {
+			continue
+		}
+
+		if w.prefix != nil {
+			return ipv6.FromPrefix(w.prefix, ip)
+		}
+
+		return ip
+	}
+
+	return nil
+}
+
+func (w *Watcher) list() ([]container, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {w.Label}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", "http://docker/containers/json?filters="+url.QueryEscape(string(filters)), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := w.client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("docker responded with %s", response.Status)
+	}
+
+	var containers []container
+
+	err = json.NewDecoder(response.Body).Decode(&containers)
+
+	return containers, err
+}
+
+// watchEvents triggers a sync whenever a container starts or stops, it
+// reconnects if the event stream breaks.
+func (w *Watcher) watchEvents() {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "die"},
+		"label": {w.Label},
+	})
+
+	for {
+		err := w.streamEvents(string(filters))
+
+		w.log.Warn("Docker event stream stopped, reconnecting", logging.ErrorAttr(err))
+		time.Sleep(10 * time.Second)
+
+		// Events might have been missed in between
+		w.triggerSync()
+	}
+}
+
+func (w *Watcher) streamEvents(filters string) error {
+	response, err := w.client.Get("http://docker/events?filters=" + url.QueryEscape(filters))
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker responded with %s", response.Status)
+	}
+
+	decoder := json.NewDecoder(response.Body)
+
+	for {
+		var event struct {
+			Action string `json:"Action"`
+		}
+
+		err := decoder.Decode(&event)
+
+		if err != nil {
+			return err
+		}
+
+		w.log.Debug("Received container event", slog.String("action", event.Action))
+		w.triggerSync()
+	}
+}
+
+func (w *Watcher) triggerSync() {
+	select {
+	case w.resync <- struct{}{}:
+	default:
+	}
+}
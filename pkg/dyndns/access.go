@@ -0,0 +1,114 @@
+package dyndns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// accessEntry collects what a handler did with a request for the access log.
+type accessEntry struct {
+	result string
+	ips    []net.IP
+}
+
+type accessKey struct{}
+
+// noteAccess records the outcome of the request for the access log, if it's
+// enabled.
+func noteAccess(r *http.Request, result string, ips []net.IP) {
+	if entry, ok := r.Context().Value(accessKey{}).(*accessEntry); ok {
+		entry.result = result
+		entry.ips = ips
+	}
+}
+
+// statusRecorder remembers the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AccessLog wraps the handler, so every request is logged with its client,
+// user, result, forwarded IPs and duration. Secrets in the query are redacted.
+func (s *Server) AccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next(recorder, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+
+		status := recorder.status
+
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		ips := make([]string, 0, len(entry.ips))
+
+		for _, ip := range entry.ips {
+			ips = append(ips, ip.String())
+		}
+
+		s.log.Info("Push request",
+			slog.Any("client", s.clientIp(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", s.redact(r.URL.RawQuery)),
+			slog.String("user", s.credentialsFrom(r).Username),
+			slog.Int("status", status),
+			slog.String("result", entry.result),
+			slog.String("ips", strings.Join(ips, ",")),
+			slog.Duration("duration", time.Since(start)),
+		)
+	}
+}
+
+// redact replaces the values of the parameters holding secrets, under their
+// default and configured names.
+func (s *Server) redact(query string) string {
+	secrets := []string{"password", "token"}
+
+	for _, name := range secrets {
+		if renamed := s.Params[name]; renamed != "" {
+			secrets = append(secrets, strings.ToLower(renamed))
+		}
+	}
+
+	parts := strings.Split(query, "&")
+
+	for i, part := range parts {
+		key, _, ok := strings.Cut(part, "=")
+
+		if ok && slices.Contains(secrets, strings.ToLower(key)) {
+			parts[i] = key + "=REDACTED"
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,219 @@
+package dyndns
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Credentials are the credentials a client submitted with a request.
+type Credentials struct {
+	Username string
+	Password string
+	// Token is a bearer token from the Authorization header
+	Token string
+	// Query is the raw query string, for signed requests
+	Query string
+}
+
+// Authenticator verifies the credentials of push requests.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, credentials Credentials) (bool, error)
+}
+
+// credentialsFrom reads the credentials from the query parameters, the router
+// can't send anything else, or from the Authorization header.
+func (s *Server) credentialsFrom(r *http.Request) Credentials {
+	params := r.URL.Query()
+
+	c := Credentials{
+		Username: s.paramValue(params, "username"),
+		Password: s.paramValue(params, "password"),
+		Query:    r.URL.RawQuery,
+	}
+
+	if username, password, ok := r.BasicAuth(); ok && c.Username == "" && c.Password == "" {
+		c.Username = username
+		c.Password = password
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		c.Token = token
+	} else {
+		c.Token = s.paramValue(params, "token")
+	}
+
+	return c
+}
+
+// authenticate accepts the request if any of the authenticators accepts it,
+// without authenticators the configured username and password must match.
+func (s *Server) authenticate(r *http.Request) bool {
+	c := s.credentialsFrom(r)
+
+	if len(s.Authenticators) == 0 {
+		if !equal(c.Username, s.Username) {
+			s.log.Warn("Rejected due to username mismatch")
+			return false
+		}
+
+		if !equal(c.Password, s.Password) {
+			s.log.Warn("Rejected due to password mismatch")
+			return false
+		}
+
+		return true
+	}
+
+	for _, a := range s.Authenticators {
+		ok, err := a.Authenticate(r.Context(), c)
+
+		if err != nil {
+			s.log.Warn("Failed to authenticate", slog.String("authenticator", a.Name()), logging.ErrorAttr(err))
+			continue
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	s.log.Warn("Rejected due to invalid credentials", slog.String("username", c.Username))
+
+	return false
+}
+
+// limitedError is returned by login for clients that exceeded the rate limit or
+// are locked out.
+type limitedError struct {
+	wait time.Duration
+}
+
+func (e *limitedError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %s", e.wait.Round(time.Second))
+}
+
+var errUnauthorized = errors.New("invalid credentials")
+
+// login authenticates the request within the limits of the client and returns
+// the site the credentials belong to, nil for the shared credentials.
+func (s *Server) login(r *http.Request) (*Site, error) {
+	ip := s.clientIp(r)
+
+	if s.Limiter != nil {
+		if ok, wait := s.Limiter.Allow(ip); !ok {
+			s.log.Warn("Rejected due to too many requests", slog.Any("client", ip))
+			return nil, &limitedError{wait: wait}
+		}
+	}
+
+	site := s.site(s.credentialsFrom(r))
+	ok := site != nil || s.authenticate(r)
+
+	if s.Limiter != nil && s.Limiter.Result(ip, ok) {
+		s.log.Warn("Locking out client after repeated failed logins", slog.Any("client", ip), slog.Duration("lockout", s.Limiter.Lockout))
+	}
+
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	return site, nil
+}
+
+// rejectLimited answers a limited request with its wait, it returns false for
+// other errors.
+func rejectLimited(w http.ResponseWriter, err error, body string) bool {
+	var limited *limitedError
+
+	if !errors.As(err, &limited) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(limited.wait.Seconds()+1)))
+	http.Error(w, body, http.StatusTooManyRequests)
+
+	return true
+}
+
+// StaticAuth accepts a single username and password.
+type StaticAuth struct {
+	Username string
+	Password string
+}
+
+func NewStaticAuth(username string, password string) *StaticAuth {
+	return &StaticAuth{
+		Username: username,
+		Password: password,
+	}
+}
+
+func (a *StaticAuth) Name() string {
+	return "static"
+}
+
+func (a *StaticAuth) Authenticate(_ context.Context, c Credentials) (bool, error) {
+	// Both are compared, so the time doesn't tell which one is wrong
+	username := equal(c.Username, a.Username)
+	password := equal(c.Password, a.Password)
+
+	return username && password, nil
+}
+
+// TokenAuth accepts a single bearer token, sent in the Authorization header or
+// the token parameter, for clients that can't send a username and password.
+type TokenAuth struct {
+	Token string
+}
+
+func NewTokenAuth(token string) *TokenAuth {
+	return &TokenAuth{
+		Token: token,
+	}
+}
+
+func (a *TokenAuth) Name() string {
+	return "token"
+}
+
+func (a *TokenAuth) Authenticate(_ context.Context, c Credentials) (bool, error) {
+	return c.Token != "" && equal(c.Token, a.Token), nil
+}
+
+// equal compares the secrets in constant time, hashing them first so the
+// time doesn't leak their length either.
+func equal(a string, b string) bool {
+	hashA := sha256.Sum256([]byte(a))
+	hashB := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(hashA[:], hashB[:]) == 1
+}
+
+// Protect wraps the handler, so it only serves requests with valid credentials.
+func (s *Server) Protect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		site, err := s.login(r)
+
+		if rejectLimited(w, err, "too many requests") {
+			return
+		}
+
+		// Sites may only push
+		if err != nil || site != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
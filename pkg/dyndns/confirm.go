@@ -0,0 +1,106 @@
+package dyndns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Confirmation makes the server wait with its answer until the pushed IPs are
+// published, and optionally resolvable, so the router shows what actually
+// happened in DNS instead of just that the request was received.
+type Confirmation struct {
+	// Published reports whether every provider published the IP
+	Published func(ip net.IP) bool
+
+	// Records are resolved after publishing until they return the IP, records
+	// without an address of its IP version are skipped
+	Records  []string
+	Resolver *net.Resolver
+
+	// Timeout is the maximum wait, routers give up on slow servers
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+func NewConfirmation(published func(ip net.IP) bool) *Confirmation {
+	return &Confirmation{
+		Published: published,
+		Resolver:  net.DefaultResolver,
+		Timeout:   20 * time.Second,
+		Interval:  500 * time.Millisecond,
+	}
+}
+
+// Wait blocks until all ips are published and resolvable, or the timeout.
+func (c *Confirmation) Wait(ctx context.Context, ips []net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for _, ip := range ips {
+		for !c.Published(ip) {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s not published: %w", ip, ctx.Err())
+			case <-ticker.C:
+			}
+		}
+
+		for _, record := range c.Records {
+			for {
+				ok, err := c.resolves(ctx, record, ip)
+
+				if ok {
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+					if err != nil {
+						return fmt.Errorf("%s not resolvable: %w", record, err)
+					}
+
+					return fmt.Errorf("%s doesn't resolve to %s yet: %w", record, ip, ctx.Err())
+				case <-ticker.C:
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolves tells whether the record resolves to ip, or has no address of its
+// IP version at all.
+func (c *Confirmation) resolves(ctx context.Context, record string, ip net.IP) (bool, error) {
+	network := "ip6"
+
+	if ip.To4() != nil {
+		network = "ip4"
+	}
+
+	ips, err := c.Resolver.LookupIP(ctx, network, record)
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, resolved := range ips {
+		if resolved.Equal(ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,108 @@
+package dyndns
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NicUpdate implements the update request of the DynDNS2 protocol spoken by
+// ddclient, NAS boxes and most routers, so they can push their IPs as well.
+// The records are configured on this side, so the hostnames are only used to
+// answer with a line for each of them.
+//
+// Expected parameters can be
+//
+//	"hostname" comma separated list of hostnames
+//	"myip" IPv4 and IPv6 address separated by a comma, defaults to the IP of the client
+//	"myipv6" IPv6 address, as sent by some clients
+//
+// see https://help.dyn.com/remote-access-api/perform-update/
+func (s *Server) NicUpdate(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	s.log.Info("Received incoming DynDNS2 update", slog.Any("client", s.clientIp(r)))
+
+	result := "ok"
+	var forwarded []net.IP
+
+	defer func() {
+		metrics.PushRequests.Inc("nic/update", result)
+		noteAccess(r, result, forwarded)
+	}()
+
+	site, err := s.login(r)
+
+	if rejectLimited(w, err, "abuse") {
+		result = "limited"
+		return
+	}
+
+	if err != nil {
+		result = "unauthorized"
+		w.Header().Set("WWW-Authenticate", `Basic realm="DynDNS"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("badauth"))
+		return
+	}
+
+	ipv4, ipv6Address := s.addresses(params["myip"], params["myipv6"])
+
+	// Clients leave it to the server to detect their public IP
+	if ipv4 == nil && ipv6Address == nil {
+		ipv4, ipv6Address = s.clientAddresses(r)
+	}
+
+	var ips []net.IP
+
+	for _, ip := range []net.IP{ipv4, ipv6Address} {
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	if site != nil {
+		forwarded = s.publishSite(site, ips...)
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(strings.TrimSpace("good " + joinIps(forwarded))))
+		return
+	}
+
+	unchanged := s.Published != nil
+
+	for _, ip := range ips {
+		// The host address is constructed from the prefix of pushes to the
+		// update endpoint instead
+		if ip.To4() == nil && *s.localIp != nil {
+			continue
+		}
+
+		unchanged = unchanged && s.Published(ip)
+
+		s.log.Info("Forwarding update request", slog.Any("ip", ip))
+		s.out <- &ip
+		forwarded = append(forwarded, ip)
+	}
+
+	answer := strings.TrimSpace("good " + joinIps(forwarded))
+
+	if s.failed(r, forwarded) {
+		result = "failed"
+		answer = "911"
+	} else if unchanged || len(forwarded) == 0 {
+		answer = strings.TrimSpace("nochg " + joinIps(forwarded))
+	}
+
+	// Clients expect a line for every hostname they asked for
+	lines := make([]string, len(strings.Split(params.Get("hostname"), ",")))
+
+	for i := range lines {
+		lines[i] = answer
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(strings.Join(lines, "\n")))
+}
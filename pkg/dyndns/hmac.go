@@ -0,0 +1,125 @@
+package dyndns
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HmacAuth accepts requests signed with a shared secret, so the credentials
+// aren't sent along and a captured request can't be replayed, when TLS isn't
+// possible. The client sends the current unix time as timestamp parameter and
+// appends the hex encoded HMAC-SHA256 of the query string before it as
+// signature parameter, i.e.
+//
+//	v4=1.2.3.4&timestamp=1700000000&signature=<hmac of "v4=1.2.3.4&timestamp=1700000000">
+type HmacAuth struct {
+	Secret []byte
+	// MaxAge is how far the timestamp may be off, in both directions
+	MaxAge time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewHmacAuth(secret string) *HmacAuth {
+	return &HmacAuth{
+		Secret: []byte(secret),
+		MaxAge: 5 * time.Minute,
+		seen:   map[string]time.Time{},
+	}
+}
+
+func (a *HmacAuth) Name() string {
+	return "hmac"
+}
+
+func (a *HmacAuth) Authenticate(_ context.Context, c Credentials) (bool, error) {
+	signed, signature, ok := splitSignature(c.Query)
+
+	if !ok {
+		return false, nil
+	}
+
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(signed))
+
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return false, nil
+	}
+
+	params, err := url.ParseQuery(signed)
+
+	if err != nil {
+		return false, err
+	}
+
+	unix, err := strconv.ParseInt(params.Get("timestamp"), 10, 64)
+
+	if err != nil {
+		return false, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	now := time.Now()
+	timestamp := time.Unix(unix, 0)
+
+	if timestamp.Before(now.Add(-a.MaxAge)) || timestamp.After(now.Add(a.MaxAge)) {
+		return false, fmt.Errorf("timestamp %s is off by more than %s", timestamp.Format(time.RFC3339), a.MaxAge)
+	}
+
+	if !a.remember(signature, now) {
+		return false, errors.New("signature was already used")
+	}
+
+	return true, nil
+}
+
+// remember records the signature until it has become stale and tells whether
+// it is new.
+func (a *HmacAuth) remember(signature string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for s, expiry := range a.seen {
+		if now.After(expiry) {
+			delete(a.seen, s)
+		}
+	}
+
+	signature = strings.ToLower(signature)
+
+	if _, ok := a.seen[signature]; ok {
+		return false
+	}
+
+	// Timestamps up to MaxAge in the future stay valid for twice as long
+	a.seen[signature] = now.Add(2 * a.MaxAge)
+
+	return true
+}
+
+// splitSignature splits the raw query into the part that was signed and the
+// signature, which has to be the last parameter.
+func splitSignature(query string) (string, string, bool) {
+	if signature, ok := strings.CutPrefix(query, "signature="); ok {
+		return "", signature, signature != ""
+	}
+
+	i := strings.LastIndex(query, "&signature=")
+
+	if i < 0 || strings.Contains(query[i+len("&signature="):], "&") {
+		return "", "", false
+	}
+
+	signature := query[i+len("&signature="):]
+
+	return query[:i], signature, signature != ""
+}
@@ -0,0 +1,80 @@
+package dyndns
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signature(secret string, query string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(query))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sign(secret string, query string) string {
+	return query + "&signature=" + signature(secret, query)
+}
+
+func TestHmacAuth(t *testing.T) {
+	now := time.Now().Unix()
+	fresh := fmt.Sprintf("v4=203.0.113.1&timestamp=%d", now)
+	upper := fmt.Sprintf("v6=2001:db8::1&timestamp=%d", now)
+
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+		fails bool
+	}{
+		{"signed", sign("secret", fresh), true, false},
+		{"uppercase hex", upper + "&signature=" + strings.ToUpper(signature("secret", upper)), true, false},
+		{"other secret", sign("other", fmt.Sprintf("v4=203.0.113.2&timestamp=%d", now)), false, false},
+		{"tampered", strings.Replace(sign("secret", fmt.Sprintf("v4=203.0.113.3&timestamp=%d", now)), "203.0.113.3", "203.0.113.4", 1), false, false},
+		{"unsigned", fresh, false, false},
+		{"signature not last", sign("secret", fresh) + "&v6=2001:db8::1", false, false},
+		{"stale", sign("secret", fmt.Sprintf("v4=203.0.113.5&timestamp=%d", now-600)), false, true},
+		{"future", sign("secret", fmt.Sprintf("v4=203.0.113.6&timestamp=%d", now+600)), false, true},
+		{"no timestamp", sign("secret", "v4=203.0.113.7"), false, true},
+		{"replayed", sign("secret", fresh), false, true},
+	}
+
+	a := NewHmacAuth("secret")
+
+	for _, test := range tests {
+		ok, err := a.Authenticate(context.Background(), Credentials{Query: test.query})
+
+		if ok != test.ok || (err != nil) != test.fails {
+			t.Errorf("%s: got %t, %v, expected %t and an error %t", test.name, ok, err, test.ok, test.fails)
+		}
+	}
+}
+
+func TestSplitSignature(t *testing.T) {
+	tests := []struct {
+		query     string
+		signed    string
+		signature string
+		ok        bool
+	}{
+		{"v4=1.2.3.4&timestamp=1&signature=ab", "v4=1.2.3.4&timestamp=1", "ab", true},
+		{"signature=ab", "", "ab", true},
+		{"v4=1.2.3.4&signature=", "v4=1.2.3.4", "", false},
+		{"v4=1.2.3.4&signature=ab&v6=::1", "", "", false},
+		{"v4=1.2.3.4", "", "", false},
+	}
+
+	for _, test := range tests {
+		signed, signature, ok := splitSignature(test.query)
+
+		if signed != test.signed || signature != test.signature || ok != test.ok {
+			t.Errorf("splitSignature(%q) = %q, %q, %t", test.query, signed, signature, ok)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package dyndns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Htpasswd accepts the users of an htpasswd file with bcrypt hashes, as created
+// by `htpasswd -B`. The file is read again once it changed.
+type Htpasswd struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string][]byte
+}
+
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+
+	err := h.reload()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Htpasswd) Name() string {
+	return "htpasswd"
+}
+
+func (h *Htpasswd) Authenticate(_ context.Context, c Credentials) (bool, error) {
+	h.mu.Lock()
+	err := h.reload()
+	hash, ok := h.users[c.Username]
+	h.mu.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(c.Password)) == nil, nil
+}
+
+// reload reads the file if it changed since it was last read.
+func (h *Htpasswd) reload() error {
+	info, err := os.Stat(h.path)
+
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(h.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(h.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(line, ":")
+
+		if !ok {
+			return fmt.Errorf("invalid line in %s, expected <username>:<hash>", h.path)
+		}
+
+		if !strings.HasPrefix(hash, "$2") {
+			return fmt.Errorf("unsupported hash of user %s in %s, only bcrypt is supported", username, h.path)
+		}
+
+		users[username] = []byte(hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	h.users = users
+	h.modTime = info.ModTime()
+
+	return nil
+}
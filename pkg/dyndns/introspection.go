@@ -0,0 +1,93 @@
+package dyndns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Introspection accepts OAuth2 access tokens the authorization server reports
+// as active, see RFC 7662. Only bearer tokens are introspected, passwords are
+// never sent to the endpoint.
+type Introspection struct {
+	Url          string
+	ClientId     string
+	ClientSecret string
+	// Timeout limits how long a push request waits for the endpoint
+	Timeout time.Duration
+
+	client *http.Client
+}
+
+func NewIntrospection(url string) *Introspection {
+	return &Introspection{
+		Url:     url,
+		Timeout: 10 * time.Second,
+		client:  &http.Client{},
+	}
+}
+
+func (i *Introspection) Name() string {
+	return "introspection"
+}
+
+func (i *Introspection) Authenticate(ctx context.Context, c Credentials) (bool, error) {
+	if c.Token == "" {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, i.Timeout)
+	defer cancel()
+
+	form := url.Values{
+		"token":           {c.Token},
+		"token_type_hint": {"access_token"},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", i.Url, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	if i.ClientId != "" {
+		request.SetBasicAuth(url.QueryEscape(i.ClientId), url.QueryEscape(i.ClientSecret))
+	}
+
+	response, err := i.client.Do(request)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("introspection endpoint responded with %s", response.Status)
+	}
+
+	var result struct {
+		Active   bool   `json:"active"`
+		Username string `json:"username"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+
+	if err != nil {
+		return false, err
+	}
+
+	// Tokens issued to someone else must not pass with a matching username
+	if c.Username != "" && result.Username != "" && c.Username != result.Username {
+		return false, nil
+	}
+
+	return result.Active, nil
+}
@@ -0,0 +1,58 @@
+package dyndns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIntrospection(t *testing.T) {
+	var calls atomic.Int32
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		if r.FormValue("token") == "slow" {
+			time.Sleep(time.Second)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"active":%t,"username":"router"}`, r.FormValue("token") == "valid")
+	}))
+	defer endpoint.Close()
+
+	i := NewIntrospection(endpoint.URL)
+	i.Timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		credentials Credentials
+		ok          bool
+		fails       bool
+		calls       int32
+	}{
+		{"password only", Credentials{Username: "router", Password: "secret"}, false, false, 0},
+		{"active token", Credentials{Token: "valid"}, true, false, 1},
+		{"inactive token", Credentials{Token: "revoked"}, false, false, 1},
+		{"other username", Credentials{Username: "nas", Token: "valid"}, false, false, 1},
+		{"slow endpoint", Credentials{Token: "slow"}, false, true, 1},
+	}
+
+	for _, test := range tests {
+		calls.Store(0)
+
+		ok, err := i.Authenticate(context.Background(), test.credentials)
+
+		if ok != test.ok || (err != nil) != test.fails {
+			t.Errorf("%s: got %t, %v", test.name, ok, err)
+		}
+
+		if n := calls.Load(); n != test.calls {
+			t.Errorf("%s: endpoint was called %d times, expected %d", test.name, n, test.calls)
+		}
+	}
+}
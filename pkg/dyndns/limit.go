@@ -0,0 +1,160 @@
+package dyndns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter limits the requests of every client IP and locks clients out after
+// repeated failed logins, so credentials can't be guessed.
+type Limiter struct {
+	// Requests of a client within Window, 0 for no limit
+	Requests int
+	Window   time.Duration
+	// Failures in a row after which a client is locked out for Lockout, 0 to
+	// never lock clients out
+	Failures int
+	Lockout  time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*limitedClient
+	pruned  time.Time
+	stats   LimiterStats
+}
+
+// LimiterStats counts the rejected requests since the start.
+type LimiterStats struct {
+	// Limited requests exceeded the rate limit
+	Limited uint64 `json:"limited"`
+	// LockedOut requests came from a locked out client
+	LockedOut uint64 `json:"lockedOut"`
+	// Failures are failed logins
+	Failures uint64 `json:"failures"`
+	// Lockouts is how often a client was locked out
+	Lockouts uint64 `json:"lockouts"`
+}
+
+type limitedClient struct {
+	windowStart time.Time
+	requests    int
+	failures    int
+	lockedUntil time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		Requests: 30,
+		Window:   time.Minute,
+		Failures: 5,
+		Lockout:  15 * time.Minute,
+		clients:  map[string]*limitedClient{},
+	}
+}
+
+// Allow counts a request of the client and tells whether it may be served,
+// otherwise how long the client has to wait.
+func (l *Limiter) Allow(ip net.IP) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	c, ok := l.clients[ip.String()]
+
+	if !ok {
+		c = &limitedClient{windowStart: now}
+		l.clients[ip.String()] = c
+	}
+
+	if now.Before(c.lockedUntil) {
+		l.stats.LockedOut++
+		return false, c.lockedUntil.Sub(now)
+	}
+
+	if now.Sub(c.windowStart) >= l.Window {
+		c.windowStart = now
+		c.requests = 0
+	}
+
+	c.requests++
+
+	if l.Requests > 0 && c.requests > l.Requests {
+		l.stats.Limited++
+		return false, c.windowStart.Add(l.Window).Sub(now)
+	}
+
+	return true, 0
+}
+
+// Result records the outcome of the login of the client and tells whether
+// the client got locked out by it.
+func (l *Limiter) Result(ip net.IP, ok bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, found := l.clients[ip.String()]
+
+	if !found {
+		return false
+	}
+
+	if ok {
+		c.failures = 0
+		return false
+	}
+
+	l.stats.Failures++
+	c.failures++
+
+	if l.Failures == 0 || c.failures < l.Failures {
+		return false
+	}
+
+	l.stats.Lockouts++
+	c.failures = 0
+	c.lockedUntil = time.Now().Add(l.Lockout)
+
+	return true
+}
+
+// Stats returns the counters of rejected requests.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+// Handler serves the counters as JSON.
+func (l *Limiter) Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l.Stats())
+}
+
+// prune forgets the clients that are neither limited nor locked out, once per
+// window. Failed logins are forgotten after a lockout of inactivity.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.Window {
+		return
+	}
+
+	l.pruned = now
+
+	for key, c := range l.clients {
+		idle := now.Sub(c.windowStart)
+
+		if idle >= l.Window && now.After(c.lockedUntil) && (c.failures == 0 || idle >= l.Lockout) {
+			delete(l.clients, key)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package dyndns
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestLimiter sends push requests of several clients through the server, the
+// limits of one client must not affect the others.
+func TestLimiter(t *testing.T) {
+	out := make(chan *net.IP, 10)
+	var localIp net.IP
+
+	s := NewServer(out, &localIp, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.Username = "router"
+	s.Password = "secret"
+	s.Limiter = NewLimiter()
+	s.Limiter.Requests = 3
+	s.Limiter.Failures = 2
+
+	steps := []struct {
+		client   string
+		password string
+		want     string
+	}{
+		{"192.0.2.1", "secret", "ok"},
+		{"192.0.2.1", "guess", "unauthorized"},
+		// Locks the client out
+		{"192.0.2.1", "guess", "unauthorized"},
+		{"192.0.2.1", "secret", "limited"},
+		{"192.0.2.2", "secret", "ok"},
+		{"192.0.2.2", "secret", "ok"},
+		{"192.0.2.2", "guess", "unauthorized"},
+		// Exceeds the requests of the window
+		{"192.0.2.2", "secret", "limited"},
+		{"192.0.2.3", "secret", "ok"},
+	}
+
+	for i, step := range steps {
+		query := url.Values{"username": {"router"}, "password": {step.password}, "v4": {"203.0.113.1"}}
+
+		r := httptest.NewRequest("GET", "/ip?"+query.Encode(), nil)
+		r.RemoteAddr = net.JoinHostPort(step.client, "40000")
+		w := httptest.NewRecorder()
+
+		s.Handler(w, r)
+
+		got := "unauthorized"
+
+		switch {
+		case w.Code == http.StatusTooManyRequests:
+			got = "limited"
+
+			if w.Header().Get("Retry-After") == "" {
+				t.Errorf("step %d: limited without Retry-After", i)
+			}
+		case len(out) > 0:
+			got = "ok"
+			<-out
+		}
+
+		if got != step.want {
+			t.Errorf("step %d: request of %s with password %s was %s, expected %s", i, step.client, step.password, got, step.want)
+		}
+	}
+
+	want := LimiterStats{Limited: 1, LockedOut: 1, Failures: 3, Lockouts: 1}
+
+	if stats := s.Limiter.Stats(); stats != want {
+		t.Errorf("stats are %+v, expected %+v", stats, want)
+	}
+}
@@ -0,0 +1,60 @@
+package dyndns
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// paramNames are the query parameters that can be renamed.
+var paramNames = []string{"v4", "v6", "prefix", "username", "password", "token"}
+
+// ParseParams parses a comma separated list of renamed query parameters
+// declared as `<name>=<renamed>`, i.e. `v4=ip,username=user`.
+func ParseParams(definitions string) (map[string]string, error) {
+	params := map[string]string{}
+
+	for _, definition := range strings.Split(definitions, ",") {
+		definition = strings.TrimSpace(definition)
+
+		if definition == "" {
+			continue
+		}
+
+		name, renamed, ok := strings.Cut(definition, "=")
+		name = strings.TrimSpace(name)
+		renamed = strings.TrimSpace(renamed)
+
+		if !ok || renamed == "" {
+			return nil, fmt.Errorf("invalid parameter %q, expected <name>=<renamed>", definition)
+		}
+
+		if !slices.Contains(paramNames, name) {
+			return nil, fmt.Errorf("unknown parameter %q, expected one of %s", name, strings.Join(paramNames, ", "))
+		}
+
+		params[name] = renamed
+	}
+
+	return params, nil
+}
+
+// param returns the values of the query parameter under its configured name,
+// the default name is accepted as well.
+func (s *Server) param(values url.Values, name string) []string {
+	if renamed, ok := s.Params[name]; ok && len(values[renamed]) > 0 {
+		return values[renamed]
+	}
+
+	return values[name]
+}
+
+// paramValue returns the first value of the query parameter, see param.
+func (s *Server) paramValue(values url.Values, name string) string {
+	if v := s.param(values, name); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,99 @@
+package dyndns
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IPs.
+func ParseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, entry := range strings.Split(proxies, ",") {
+		entry = strings.TrimSpace(entry)
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+
+			bits := 128
+
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	for _, n := range s.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIp returns the IP of the client, requests of trusted proxies are
+// attributed to the last untrusted hop of the X-Forwarded-For header, or to
+// their X-Real-IP header without one.
+func (s *Server) clientIp(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || !s.isTrustedProxy(ip) {
+		return ip
+	}
+
+	// Some proxies, like nginx, are commonly configured to only set X-Real-IP
+	if len(r.Header.Values("X-Forwarded-For")) == 0 {
+		if realIp := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIp != nil {
+			return realIp
+		}
+
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
@@ -1,10 +1,16 @@
 package dyndns
 
 import (
+	"cmp"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipv6"
 	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
 	"log/slog"
 	"net"
 	"net/http"
+	"slices"
+	"strings"
 )
 
 type Server struct {
@@ -14,6 +20,41 @@ type Server struct {
 
 	Username string
 	Password string
+
+	// Authenticators replace Username and Password if set
+	Authenticators []Authenticator
+
+	// Prefixes optionally receives the submitted IPv6 prefix
+	Prefixes chan<- *net.IPNet
+
+	// TrustedProxies may forward requests with X-Forwarded-For headers
+	TrustedProxies []*net.IPNet
+
+	// Failing optionally reports if updates keep failing, the server then
+	// answers with the DynDNS `911` code, so the router reports the failure
+	Failing func() bool
+
+	// Confirmation optionally delays the answer until the IPs are published
+	Confirmation *Confirmation
+
+	// Limiter optionally limits the requests and failed logins per client
+	Limiter *Limiter
+
+	// Sites push into their own records, which are sent to SiteRecords
+	Sites       []*Site
+	SiteRecords chan<- *SiteRecord
+
+	// Published optionally reports whether the IP is published already, so
+	// DynDNS2 clients are answered with `nochg`
+	Published func(ip net.IP) bool
+
+	// Params renames the query parameters by their default name, i.e. for
+	// routers that send `ip` instead of `v4`
+	Params map[string]string
+
+	// ClientIpFallback publishes the IP the request came from if it carries
+	// no IPs at all, i.e. for scripts calling the endpoint with curl
+	ClientIpFallback bool
 }
 
 func NewServer(out chan<- *net.IP, localIp *net.IP, log *slog.Logger) *Server {
@@ -32,67 +73,181 @@ func NewServer(out chan<- *net.IP, localIp *net.IP, log *slog.Logger) *Server {
 //
 //	"v4" IPv4 address
 //	"v6" IPv6 address
-//	"prefix" IPv6 prefix
+//	"prefix" or "ip6lanprefix" IPv6 prefix, the host address of the device is
+//	  constructed from it if configured
+//
+// All of them are forwarded together, so a single request updates both IP
+// versions. The parameters, as well as those of the credentials, can be
+// renamed with Params. Without any of them the IP of the client is used if
+// ClientIpFallback is set.
 //
 // see https://service.avm.de/help/de/FRITZ-Box-Fon-WLAN-7490/016/hilfe_dyndns
 func (s *Server) Handler(w http.ResponseWriter, r *http.Request) {
 	params := r.URL.Query()
 
-	s.log.Info("Received incoming DynDNS update")
+	s.log.Info("Received incoming DynDNS update", slog.Any("client", s.clientIp(r)))
+
+	result := "ok"
+	var forwarded []net.IP
 
-	if params.Get("username") != s.Username {
-		s.log.Warn("Rejected due to username mismatch")
+	defer func() {
+		metrics.PushRequests.Inc("ip", result)
+		noteAccess(r, result, forwarded)
+	}()
+
+	site, err := s.login(r)
+
+	if rejectLimited(w, err, "") {
+		result = "limited"
 		return
 	}
 
-	if params.Get("password") != s.Password {
-		s.log.Warn("Rejected due to password mismatch")
+	if err != nil {
+		result = "unauthorized"
 		return
 	}
 
-	// Parse IPv4
-	ipv4 := net.ParseIP(params.Get("v4"))
-	if ipv4 != nil && ipv4.To4() != nil {
-		s.log.Info("Forwarding update request for IPv4", slog.Any("ipv4", ipv4))
+	ipv4, ipv6Address := s.addresses(s.param(params, "v4"), s.param(params, "v6"))
+	rawPrefix := cmp.Or(s.paramValue(params, "prefix"), params.Get("ip6lanprefix"))
+
+	if ipv4 == nil && ipv6Address == nil && rawPrefix == "" && s.ClientIpFallback {
+		ipv4, ipv6Address = s.clientAddresses(r)
+	}
+
+	// Sites have no device addresses to construct
+	if site != nil {
+		forwarded = s.publishSite(site, ipv4, ipv6Address)
+		w.WriteHeader(200)
+		return
+	}
+
+	if ipv4 != nil {
+		s.log.Info("Forwarding update request for IPv4", slog.Any("ip", ipv4))
 		s.out <- &ipv4
+		forwarded = append(forwarded, ipv4)
 	}
 
-	if *s.localIp == nil {
-		// Parse IPv6
-		ipv6 := net.ParseIP(params.Get("v6"))
-		if ipv6 != nil && ipv6.To4() == nil {
-			s.log.Info("Forwarding update request for IPv6", slog.Any("ipv6", ipv6))
-			s.out <- &ipv6
-		}
-	} else {
-		// Parse Prefix
-		_, prefix, err := net.ParseCIDR(params.Get("prefix"))
+	if ipv6Address != nil && *s.localIp == nil {
+		s.log.Info("Forwarding update request for IPv6", slog.Any("ip", ipv6Address))
+		s.out <- &ipv6Address
+		forwarded = append(forwarded, ipv6Address)
+	}
+
+	// LAN prefixes are /64, some clients leave out the length
+	if rawPrefix != "" && !strings.Contains(rawPrefix, "/") {
+		rawPrefix += "/64"
+	}
+
+	// Routers without IPv6 substitute an empty prefix
+	if rawPrefix != "" && (*s.localIp != nil || s.Prefixes != nil) {
+		_, prefix, err := net.ParseCIDR(rawPrefix)
 		if err != nil {
-			s.log.Warn("Failed to parse prefix", slog.Any("prefix", prefix), logging.ErrorAttr(err))
+			s.log.Warn("Failed to parse prefix", slog.String("prefix", rawPrefix), logging.ErrorAttr(err))
 		} else {
+			if s.Prefixes != nil {
+				s.log.Info("Forwarding update request for IPv6 prefix", slog.Any("prefix", prefix))
+				s.Prefixes <- prefix
+			}
+
+			if *s.localIp != nil {
+				constructedIp := ipv6.FromPrefix(prefix, *s.localIp)
+
+				s.log.Info("Forwarding update request for IPv6", slog.Any("prefix", prefix), slog.Any("ip", constructedIp))
+				s.out <- &constructedIp
+				forwarded = append(forwarded, constructedIp)
+			}
+		}
+	}
 
-			constructedIp := make(net.IP, net.IPv6len)
-			copy(constructedIp, prefix.IP)
+	if s.failed(r, forwarded) {
+		result = "failed"
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("911"))
+		return
+	}
+
+	if s.Confirmation != nil && len(forwarded) > 0 {
+		w.WriteHeader(200)
+		_, _ = fmt.Fprintf(w, "good %s", joinIps(forwarded))
+		return
+	}
+
+	w.WriteHeader(200)
+}
 
-			maskLen, _ := prefix.Mask.Size()
+// addresses picks the first IPv4 and IPv6 of the values. The router fills in
+// all placeholders of the Update-URL at once, but every value may as well hold
+// both addresses separated by a comma, i.e. `v4=<ipaddr>,<ip6addr>`. Empty
+// values stand for an IP version the router doesn't have.
+func (s *Server) addresses(values ...[]string) (net.IP, net.IP) {
+	var ipv4, ipv6Address net.IP
 
-			for i := 0; i < net.IPv6len; i++ {
-				b := constructedIp[i]
-				lb := (*s.localIp)[i]
-				var mask byte = 0b00000000
-				for j := 0; j < 8; j++ {
-					if (i*8 + j) >= maskLen {
-						mask += 0b00000001 << (7 - j)
-					}
+	for _, v := range slices.Concat(values...) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+
+			ip := net.ParseIP(part)
+
+			switch {
+			case ip == nil:
+				s.log.Warn("Failed to parse IP", slog.String("ip", part))
+			case ip.To4() != nil:
+				if ipv4 == nil {
+					ipv4 = ip.To4()
 				}
-				b += lb & mask
-				constructedIp[i] = b
+			case ipv6Address == nil:
+				ipv6Address = ip
 			}
+		}
+	}
+
+	return ipv4, ipv6Address
+}
+
+// failed waits for the forwarded IPs to be published if confirmation is
+// enabled, and tells whether the update has to be answered with `911`.
+func (s *Server) failed(r *http.Request, forwarded []net.IP) bool {
+	if s.Confirmation != nil && len(forwarded) > 0 {
+		err := s.Confirmation.Wait(r.Context(), forwarded)
 
-			s.log.Info("Forwarding update request for IPv6", slog.Any("prefix", prefix), slog.Any("ipv6", constructedIp))
-			s.out <- &constructedIp
+		if err != nil {
+			s.log.Warn("Update not confirmed in time, responding with 911", logging.ErrorAttr(err))
+			return true
 		}
+
+		s.log.Info("Update confirmed", slog.Any("ips", forwarded))
+		return false
 	}
 
-	w.WriteHeader(200)
+	if s.Failing != nil && s.Failing() {
+		s.log.Warn("Updates keep failing, responding with 911")
+		return true
+	}
+
+	return false
+}
+
+// clientAddresses returns the IP the request came from as the IPv4 or IPv6,
+// unless it isn't public, i.e. for a client in the LAN.
+func (s *Server) clientAddresses(r *http.Request) (net.IP, net.IP) {
+	ip := s.clientIp(r)
+
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		s.log.Warn("Ignoring the IP of the client, it isn't public", slog.Any("client", ip))
+		return nil, nil
+	}
+
+	return s.addresses([]string{ip.String()})
+}
+
+func joinIps(ips []net.IP) string {
+	s := make([]string, 0, len(ips))
+
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+
+	return strings.Join(s, ",")
 }
@@ -0,0 +1,102 @@
+package dyndns
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// Site is a caller with its own credentials, i.e. the router of another
+// location, whose IPs are published to its own records instead of the shared
+// ones.
+type Site struct {
+	Username string
+	Password string
+	Records  []string
+}
+
+// SiteRecord is an IP pushed by a site for one of its records.
+type SiteRecord struct {
+	Name string
+	IP   net.IP
+}
+
+// ParseSites parses a `;`-separated list of sites declared as
+// `<username>:<password>@<records>`, the records are separated by commas.
+func ParseSites(definitions string) ([]*Site, error) {
+	var sites []*Site
+
+	for _, definition := range strings.Split(definitions, ";") {
+		definition = strings.TrimSpace(definition)
+
+		if definition == "" {
+			continue
+		}
+
+		// Records never contain an @, passwords might
+		i := strings.LastIndex(definition, "@")
+
+		if i < 0 {
+			return nil, fmt.Errorf("invalid site %q, expected <username>:<password>@<records>", definition)
+		}
+
+		username, password, ok := strings.Cut(definition[:i], ":")
+
+		if !ok || username == "" {
+			return nil, fmt.Errorf("invalid site %q, expected <username>:<password>@<records>", definition)
+		}
+
+		site := &Site{Username: username, Password: password}
+
+		for _, record := range strings.Split(definition[i+1:], ",") {
+			if record = strings.TrimSpace(record); record != "" {
+				site.Records = append(site.Records, record)
+			}
+		}
+
+		if len(site.Records) == 0 {
+			return nil, fmt.Errorf("site %s has no records", username)
+		}
+
+		sites = append(sites, site)
+	}
+
+	return sites, nil
+}
+
+// site returns the site whose credentials the request carries, if any.
+func (s *Server) site(c Credentials) *Site {
+	for _, site := range s.Sites {
+		// Both are compared, so the time doesn't tell which one is wrong
+		username := equal(c.Username, site.Username)
+		password := equal(c.Password, site.Password)
+
+		if username && password {
+			return site
+		}
+	}
+
+	return nil
+}
+
+// publishSite forwards the IPs to the records of the site.
+func (s *Server) publishSite(site *Site, ips ...net.IP) []net.IP {
+	var forwarded []net.IP
+
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+
+		s.log.Info("Forwarding update request of site", slog.String("site", site.Username), slog.Any("ip", ip))
+
+		for _, record := range site.Records {
+			s.SiteRecords <- &SiteRecord{Name: record, IP: ip}
+		}
+
+		forwarded = append(forwarded, ip)
+	}
+
+	return forwarded
+}
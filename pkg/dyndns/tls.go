@@ -0,0 +1,112 @@
+package dyndns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificate serves the key pair of the files to TLS clients. The files are
+// read again once they changed, i.e. after certbot renewed the certificate, so
+// the server doesn't have to be restarted.
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	certModTime time.Time
+	keyModTime  time.Time
+	certificate *tls.Certificate
+
+	log *slog.Logger
+}
+
+func NewCertificate(certFile string, keyFile string, log *slog.Logger) (*Certificate, error) {
+	c := &Certificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      log.With(slog.String("module", "dyndns")),
+	}
+
+	err := c.reload()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate is meant for tls.Config, it keeps serving the previous key
+// pair if the renewed one can't be read, i.e. while only one of the files has
+// been replaced.
+func (c *Certificate) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.reload()
+
+	if err != nil {
+		c.log.Warn("Failed to reload the TLS certificate, keeping the previous one", logging.ErrorAttr(err))
+	}
+
+	return c.certificate, nil
+}
+
+// reload reads the key pair if a file changed since it was last read.
+func (c *Certificate) reload() error {
+	certInfo, err := os.Stat(c.certFile)
+
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(c.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	if certInfo.ModTime().Equal(c.certModTime) && keyInfo.ModTime().Equal(c.keyModTime) {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	if c.certificate != nil {
+		c.log.Info("Reloaded the TLS certificate", slog.String("file", c.certFile))
+	}
+
+	c.certificate = &certificate
+	c.certModTime = certInfo.ModTime()
+	c.keyModTime = keyInfo.ModTime()
+
+	return nil
+}
+
+// LoadClientCas reads the PEM encoded certificates of the CAs that client
+// certificates have to be signed by.
+func LoadClientCas(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
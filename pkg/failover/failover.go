@@ -0,0 +1,160 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/notify"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/probe"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/schedule"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// family holds the failover state of a single IP version.
+type family struct {
+	name    string
+	primary *net.IP
+	backup  net.IP
+
+	failedOver   bool
+	failures     int
+	healthySince time.Time
+}
+
+// Failover relays the IPs of the primary WAN, but publishes the backup WAN's IP
+// while the primary one fails its health probe. Once the primary WAN has been
+// healthy for FailbackAfter it fails back to it.
+type Failover struct {
+	ipv4 *family
+	ipv6 *family
+
+	Probe         *probe.Probe
+	Interval      time.Duration
+	Failures      int
+	FailbackAfter time.Duration
+
+	log    *slog.Logger
+	notify *notify.Dispatcher
+
+	In  chan *net.IP
+	out chan<- *net.IP
+}
+
+func NewFailover(out chan<- *net.IP, p *probe.Probe, dispatcher *notify.Dispatcher, log *slog.Logger) *Failover {
+	return &Failover{
+		ipv4:          &family{name: "IPv4"},
+		ipv6:          &family{name: "IPv6"},
+		Probe:         p,
+		Interval:      30 * time.Second,
+		Failures:      3,
+		FailbackAfter: 5 * time.Minute,
+		log:           log.With(slog.String("module", "failover")),
+		notify:        dispatcher,
+		In:            make(chan *net.IP, 10),
+		out:           out,
+	}
+}
+
+func (f *Failover) SetIPv4Backup(ip net.IP) {
+	f.ipv4.backup = ip
+}
+
+func (f *Failover) SetIPv6Backup(ip net.IP) {
+	f.ipv6.backup = ip
+}
+
+func (f *Failover) StartWorker() {
+	go f.spawnWorker()
+}
+
+func (f *Failover) spawnWorker() {
+	ticker := schedule.NewTicker(schedule.Every(f.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ip := <-f.In:
+			fam := f.ipv4
+
+			if ip.To4() == nil {
+				fam = f.ipv6
+			}
+
+			fam.primary = ip
+
+			// Hold back the primary IP until we fail back
+			if !fam.failedOver {
+				f.out <- ip
+			}
+		case <-ticker.C:
+			f.check(f.ipv4)
+			f.check(f.ipv6)
+		}
+	}
+}
+
+func (f *Failover) check(fam *family) {
+	if fam.backup == nil || fam.primary == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err := f.Probe.Check(ctx, *fam.primary)
+	cancel()
+
+	flog := f.log.With(slog.String("family", fam.name), slog.Any("primary", fam.primary), slog.Any("backup", fam.backup))
+
+	if !fam.failedOver {
+		if err == nil {
+			fam.failures = 0
+			return
+		}
+
+		fam.failures++
+
+		flog.Warn("Primary WAN failed health probe", slog.Int("failures", fam.failures), logging.ErrorAttr(err))
+
+		if fam.failures < f.Failures {
+			return
+		}
+
+		fam.failedOver = true
+		fam.healthySince = time.Time{}
+
+		flog.Warn("Failing over to backup WAN")
+		f.notify.Send("Failed over to backup WAN", fmt.Sprintf("Primary %s %s is unreachable, publishing backup %s", fam.name, fam.primary, fam.backup))
+
+		f.out <- &fam.backup
+
+		return
+	}
+
+	if err != nil {
+		if !fam.healthySince.IsZero() {
+			flog.Warn("Primary WAN failed health probe again, postponing failback", logging.ErrorAttr(err))
+		}
+
+		fam.healthySince = time.Time{}
+		return
+	}
+
+	if fam.healthySince.IsZero() {
+		fam.healthySince = time.Now()
+		flog.Info("Primary WAN is healthy again, scheduling failback", slog.Duration("after", f.FailbackAfter))
+		return
+	}
+
+	if time.Since(fam.healthySince) < f.FailbackAfter {
+		return
+	}
+
+	fam.failedOver = false
+	fam.failures = 0
+
+	flog.Info("Failing back to primary WAN")
+	f.notify.Send("Failed back to primary WAN", fmt.Sprintf("Primary %s %s has been healthy for %s, publishing it again", fam.name, fam.primary, f.FailbackAfter))
+
+	f.out <- fam.primary
+}
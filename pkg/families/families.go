@@ -0,0 +1,187 @@
+package families
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Status describes whether an IP version is published.
+type Status struct {
+	Enabled bool `json:"enabled"`
+	// Until is set if the IP version gets enabled again automatically
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// family holds the state of a single IP version.
+type family struct {
+	name     string
+	disabled bool
+	until    time.Time
+	timer    *time.Timer
+	last     *net.IP
+}
+
+// Switch relays the IPs of the enabled IP versions, they can be disabled at
+// runtime, i.e. while the IPv6 of the ISP is broken, optionally until an expiry.
+// The last IP is published again once its IP version gets enabled.
+type Switch struct {
+	mu   sync.Mutex
+	ipv4 *family
+	ipv6 *family
+
+	log *slog.Logger
+
+	In  chan *net.IP
+	out chan<- *net.IP
+}
+
+func NewSwitch(out chan<- *net.IP, log *slog.Logger) *Switch {
+	return &Switch{
+		ipv4: &family{name: "IPv4"},
+		ipv6: &family{name: "IPv6"},
+		log:  log.With(slog.String("module", "families")),
+		In:   make(chan *net.IP, 10),
+		out:  out,
+	}
+}
+
+func (s *Switch) StartWorker() {
+	go s.spawnWorker()
+}
+
+func (s *Switch) spawnWorker() {
+	for ip := range s.In {
+		s.mu.Lock()
+		fam := s.familyOf(ip)
+		fam.last = ip
+		disabled := fam.disabled
+		s.mu.Unlock()
+
+		if disabled {
+			s.log.Info("Publishing is disabled, holding back IP", slog.String("family", fam.name), slog.Any("ip", ip))
+			continue
+		}
+
+		s.out <- ip
+	}
+}
+
+func (s *Switch) familyOf(ip *net.IP) *family {
+	if ip.To4() != nil {
+		return s.ipv4
+	}
+
+	return s.ipv6
+}
+
+func (s *Switch) family(version int) *family {
+	if version == 4 {
+		return s.ipv4
+	}
+
+	return s.ipv6
+}
+
+// Disable stops publishing the IP version, it gets enabled again after d
+// unless d is 0.
+func (s *Switch) Disable(version int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fam := s.family(version)
+	fam.disabled = true
+	fam.until = time.Time{}
+
+	if fam.timer != nil {
+		fam.timer.Stop()
+		fam.timer = nil
+	}
+
+	if d > 0 {
+		until := time.Now().Add(d)
+
+		fam.until = until
+		fam.timer = time.AfterFunc(d, func() {
+			s.expire(version, until)
+		})
+	}
+
+	s.log.Warn("Disabled publishing", slog.String("family", fam.name), slog.Duration("for", d))
+}
+
+// Enable publishes the IP version again, starting with its last IP.
+func (s *Switch) Enable(version int) {
+	s.mu.Lock()
+	s.enable(s.family(version))
+}
+
+// expire enables the IP version unless it got disabled again in the meantime.
+func (s *Switch) expire(version int, until time.Time) {
+	s.mu.Lock()
+
+	fam := s.family(version)
+
+	if !fam.until.Equal(until) {
+		s.mu.Unlock()
+		return
+	}
+
+	s.enable(fam)
+}
+
+// enable must be called with the lock held, it releases it before relaying.
+func (s *Switch) enable(fam *family) {
+	if !fam.disabled {
+		s.mu.Unlock()
+		return
+	}
+
+	fam.disabled = false
+	fam.until = time.Time{}
+
+	if fam.timer != nil {
+		fam.timer.Stop()
+		fam.timer = nil
+	}
+
+	last := fam.last
+
+	s.mu.Unlock()
+
+	s.log.Info("Enabled publishing", slog.String("family", fam.name))
+
+	if last != nil {
+		s.out <- last
+	}
+}
+
+// Enabled tells whether the IP version of ip is published.
+func (s *Switch) Enabled(ip net.IP) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.familyOf(&ip).disabled
+}
+
+// Status returns whether IPv4 and IPv6 are published.
+func (s *Switch) Status() map[string]Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make(map[string]Status)
+
+	for key, fam := range map[string]*family{"ipv4": s.ipv4, "ipv6": s.ipv6} {
+		st := Status{Enabled: !fam.disabled}
+
+		if !fam.until.IsZero() {
+			until := fam.until
+			st.Until = &until
+		}
+
+		status[key] = st
+	}
+
+	return status
+}
@@ -0,0 +1,72 @@
+package families
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handler shows whether the IP versions are published on GET, and toggles
+// them on POST with the parameters
+//
+//	"family" ipv4 or ipv6
+//	"enabled" true or false
+//	"for" optional duration after which a disabled IP version is enabled again, i.e. 2h
+func (s *Switch) Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if !s.toggle(w, r) {
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Status())
+}
+
+func (s *Switch) toggle(w http.ResponseWriter, r *http.Request) bool {
+	var version int
+
+	switch r.FormValue("family") {
+	case "ipv4":
+		version = 4
+	case "ipv6":
+		version = 6
+	default:
+		http.Error(w, "family must be ipv4 or ipv6", http.StatusBadRequest)
+		return false
+	}
+
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+
+	if err != nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return false
+	}
+
+	if enabled {
+		s.Enable(version)
+		return true
+	}
+
+	var d time.Duration
+
+	if v := r.FormValue("for"); v != "" {
+		d, err = time.ParseDuration(v)
+
+		if err != nil || d < 0 {
+			http.Error(w, "for must be a duration like 2h", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	s.Disable(version, d)
+
+	return true
+}
@@ -0,0 +1,189 @@
+package firewall
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// iface is a logical interface of the firewall with its addresses.
+type iface struct {
+	name      string
+	addresses []*net.IPNet
+}
+
+// Firewall polls the WAN IPs from an OPNsense or pfSense firewall. The prefix
+// is the one of the LAN interface tracking the delegated prefix, as the
+// firewalls don't report the delegation itself.
+type Firewall struct {
+	// Url of the web interface, i.e. https://192.168.1.1
+	Url string
+	// Key and Secret authenticate at the API
+	Key    string
+	Secret string
+	// Interface is the WAN interface, i.e. wan or opt1
+	Interface string
+	// PrefixInterface tracks the delegated prefix, i.e. lan
+	PrefixInterface string
+	Timeout         time.Duration
+	// Insecure skips verifying the self-signed certificate of the firewall
+	Insecure bool
+
+	name       string
+	interfaces func(f *Firewall) ([]iface, error)
+}
+
+func newFirewall(name string, interfaces func(f *Firewall) ([]iface, error)) *Firewall {
+	return &Firewall{
+		Url:             "https://192.168.1.1",
+		Interface:       "wan",
+		PrefixInterface: "lan",
+		Timeout:         5 * time.Second,
+		name:            name,
+		interfaces:      interfaces,
+	}
+}
+
+func (f *Firewall) GetWanIpv4() (net.IP, error) {
+	addresses, err := f.addressesOf(f.Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addresses {
+		if a.IP.To4() != nil {
+			return a.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no IPv4 address", f.Interface)
+}
+
+func (f *Firewall) GetwanIpv6() (net.IP, error) {
+	addresses, err := f.addressesOf(f.Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addresses {
+		if isGlobalIpv6(a.IP) {
+			return a.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no global IPv6 address", f.Interface)
+}
+
+func (f *Firewall) GetIpv6Prefix() (*net.IPNet, error) {
+	addresses, err := f.addressesOf(f.PrefixInterface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addresses {
+		if isGlobalIpv6(a.IP) {
+			return &net.IPNet{IP: a.IP.Mask(a.Mask), Mask: a.Mask}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no global IPv6 prefix", f.PrefixInterface)
+}
+
+func (f *Firewall) addressesOf(name string) ([]*net.IPNet, error) {
+	interfaces, err := f.interfaces(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range interfaces {
+		if i.name == name {
+			return i.addresses, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s has no interface %s", f.name, name)
+}
+
+// get queries the path of the API into v, authenticate adds the credentials.
+func (f *Firewall) get(path string, authenticate func(r *http.Request), v any) error {
+	request, err := http.NewRequest("GET", f.Url+path, nil)
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", "application/json")
+	authenticate(request)
+
+	client := &http.Client{
+		Timeout: f.Timeout,
+	}
+
+	if f.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%s rejected the API key, check the key and its privileges", f.name)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", path, response.Status)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(v)
+
+	if err != nil {
+		return fmt.Errorf("invalid response of %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// parseAddress reads an address with or without the prefix length, which
+// may be given separately.
+func parseAddress(address string, bits int) (*net.IPNet, error) {
+	if ip, ipNet, err := net.ParseCIDR(address); err == nil {
+		return &net.IPNet{IP: ip, Mask: ipNet.Mask}, nil
+	}
+
+	ip := net.ParseIP(address)
+
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+
+	size := 8 * net.IPv6len
+
+	if ip.To4() != nil {
+		ip = ip.To4()
+		size = 8 * net.IPv4len
+	}
+
+	if bits <= 0 || bits > size {
+		bits = size
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, size)}, nil
+}
+
+func isGlobalIpv6(ip net.IP) bool {
+	return ip.To4() == nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
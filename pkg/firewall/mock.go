@@ -0,0 +1,65 @@
+//go:build integration
+
+package firewall
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+// NewMock starts a fake firewall answering the interface status of both the
+// OPNsense and the pfSense API, with the prefix on the lan interface. It has
+// to be closed after use. Any API key is accepted.
+func NewMock(ipv4 net.IP, ipv6 net.IP, prefix *net.IPNet) *httptest.Server {
+	bits, _ := prefix.Mask.Size()
+
+	lanIp := make(net.IP, len(prefix.IP))
+	copy(lanIp, prefix.IP)
+	lanIp[len(lanIp)-1] = 1
+
+	opnsense := map[string]any{
+		"rows": []opnsenseInterface{
+			{
+				Identifier: "wan",
+				Ipv4:       []opnsenseAddress{{IpAddr: ipv4.String(), SubnetBits: 24}},
+				Ipv6:       []opnsenseAddress{{IpAddr: "fe80::1", SubnetBits: 64}, {IpAddr: ipv6.String(), SubnetBits: 64}},
+			},
+			{
+				Identifier: "lan",
+				Ipv4:       []opnsenseAddress{{IpAddr: "192.168.1.1", SubnetBits: 24}},
+				Ipv6:       []opnsenseAddress{{IpAddr: lanIp.String(), SubnetBits: bits}},
+			},
+		},
+	}
+
+	pfsense := map[string]any{
+		"data": []pfsenseInterface{
+			{Name: "wan", IpAddr: ipv4.String(), Subnet: "24", IpAddrV6: ipv6.String(), SubnetV6: "64"},
+			{Name: "lan", IpAddr: "192.168.1.1", Subnet: "24", IpAddrV6: lanIp.String(), SubnetV6: bits},
+		},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok && r.Header.Get("X-API-Key") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var result any
+
+		switch r.URL.Path {
+		case "/api/interfaces/overview/interfacesInfo":
+			result = opnsense
+		case "/api/v2/status/interfaces":
+			result = pfsense
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+}
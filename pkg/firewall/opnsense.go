@@ -0,0 +1,67 @@
+package firewall
+
+import (
+	"net/http"
+	"strconv"
+)
+
+type opnsenseAddress struct {
+	IpAddr     string `json:"ipaddr"`
+	SubnetBits any    `json:"subnetbits"`
+}
+
+type opnsenseInterface struct {
+	Identifier string            `json:"identifier"`
+	Ipv4       []opnsenseAddress `json:"ipv4"`
+	Ipv6       []opnsenseAddress `json:"ipv6"`
+}
+
+// NewOpnsense talks to the API of OPNsense 23.1 or later, Key and Secret are
+// an API key of a user with the privilege "Status: Interfaces".
+func NewOpnsense() *Firewall {
+	return newFirewall("OPNsense", opnsenseInterfaces)
+}
+
+func opnsenseInterfaces(f *Firewall) ([]iface, error) {
+	var overview struct {
+		Rows []opnsenseInterface `json:"rows"`
+	}
+
+	err := f.get("/api/interfaces/overview/interfacesInfo", func(r *http.Request) {
+		r.SetBasicAuth(f.Key, f.Secret)
+	}, &overview)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var interfaces []iface
+
+	for _, row := range overview.Rows {
+		i := iface{name: row.Identifier}
+
+		for _, a := range append(row.Ipv4, row.Ipv6...) {
+			if address, err := parseAddress(a.IpAddr, subnetBits(a.SubnetBits)); err == nil {
+				i.addresses = append(i.addresses, address)
+			}
+		}
+
+		interfaces = append(interfaces, i)
+	}
+
+	return interfaces, nil
+}
+
+// subnetBits reads the prefix length, which is sent as number or string
+// depending on the version.
+func subnetBits(v any) int {
+	switch bits := v.(type) {
+	case float64:
+		return int(bits)
+	case string:
+		n, _ := strconv.Atoi(bits)
+		return n
+	}
+
+	return 0
+}
@@ -0,0 +1,56 @@
+package firewall
+
+import (
+	"net/http"
+)
+
+type pfsenseInterface struct {
+	Name     string `json:"name"`
+	IpAddr   string `json:"ipaddr"`
+	Subnet   any    `json:"subnet"`
+	IpAddrV6 string `json:"ipaddrv6"`
+	SubnetV6 any    `json:"subnetv6"`
+}
+
+// NewPfsense talks to the REST API package of pfSense, version 2. Key is an
+// API key, or Key and Secret are the username and password of a user allowed
+// to read the interface status.
+func NewPfsense() *Firewall {
+	return newFirewall("pfSense", pfsenseInterfaces)
+}
+
+func pfsenseInterfaces(f *Firewall) ([]iface, error) {
+	var status struct {
+		Data []pfsenseInterface `json:"data"`
+	}
+
+	err := f.get("/api/v2/status/interfaces", func(r *http.Request) {
+		if f.Secret != "" {
+			r.SetBasicAuth(f.Key, f.Secret)
+		} else {
+			r.Header.Set("X-API-Key", f.Key)
+		}
+	}, &status)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var interfaces []iface
+
+	for _, data := range status.Data {
+		i := iface{name: data.Name}
+
+		if address, err := parseAddress(data.IpAddr, subnetBits(data.Subnet)); err == nil {
+			i.addresses = append(i.addresses, address)
+		}
+
+		if address, err := parseAddress(data.IpAddrV6, subnetBits(data.SubnetV6)); err == nil {
+			i.addresses = append(i.addresses, address)
+		}
+
+		interfaces = append(interfaces, i)
+	}
+
+	return interfaces, nil
+}
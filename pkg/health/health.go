@@ -0,0 +1,146 @@
+// Package health reports whether the daemon is alive and ready to publish IPs,
+// for the healthchecks of Docker and Kubernetes.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe is a condition of the liveness or readiness, the component it belongs
+// to reports its outcome.
+type Probe struct {
+	name string
+	// MaxAge is how long ago the last success may be, 0 if a single success
+	// is enough until the next failure
+	MaxAge time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	err  error
+}
+
+// Ok records a success of the component.
+func (p *Probe) Ok() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last = time.Now()
+	p.err = nil
+}
+
+// Fail records a failure of the component, with a MaxAge the probe only fails
+// once the last success is too old.
+func (p *Probe) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+// check returns why the probe fails, if it does.
+func (p *Probe) check(now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.last.IsZero() && p.err != nil:
+		return p.err
+	case p.last.IsZero():
+		return errors.New("waiting for the first check")
+	case p.MaxAge == 0:
+		return p.err
+	case now.Sub(p.last) <= p.MaxAge:
+		return nil
+	case p.err != nil:
+		return fmt.Errorf("last success %s ago: %w", now.Sub(p.last).Round(time.Second), p.err)
+	default:
+		return fmt.Errorf("last success %s ago", now.Sub(p.last).Round(time.Second))
+	}
+}
+
+// Health collects the probes of the components.
+type Health struct {
+	mu    sync.Mutex
+	live  []*Probe
+	ready []*Probe
+}
+
+func New() *Health {
+	return &Health{}
+}
+
+// Live adds a probe that fails the liveness, i.e. of a loop that has to keep
+// running, the readiness fails along with it.
+func (h *Health) Live(name string, maxAge time.Duration) *Probe {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p := &Probe{name: name, MaxAge: maxAge}
+	h.live = append(h.live, p)
+
+	return p
+}
+
+// Ready adds a probe that only fails the readiness, i.e. of an initialization
+// or of the polls of the router.
+func (h *Health) Ready(name string, maxAge time.Duration) *Probe {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p := &Probe{name: name, MaxAge: maxAge}
+	h.ready = append(h.ready, p)
+
+	return p
+}
+
+// Liveness answers 200 while all liveness probes pass, 503 otherwise.
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	probes := h.live
+	h.mu.Unlock()
+
+	serve(w, r, probes)
+}
+
+// Readiness answers 200 while all probes pass, 503 otherwise.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	probes := append(h.live[:len(h.live):len(h.live)], h.ready...)
+	h.mu.Unlock()
+
+	serve(w, r, probes)
+}
+
+// serve answers with a line for every failing probe.
+func serve(w http.ResponseWriter, r *http.Request, probes []*Probe) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	var failures []string
+
+	for _, p := range probes {
+		if err := p.check(now); err != nil {
+			failures = append(failures, p.name+": "+err.Error())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(strings.Join(failures, "\n") + "\n"))
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,39 @@
+//go:build integration
+
+package httpjson
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+// MockPaths select the IPs from the status of NewMock.
+const (
+	MockIpv4Path   = `wan.ipv4.address`
+	MockIpv6Path   = `wan.ipv6.#(scope=="global").address`
+	MockPrefixPath = `lan.delegated\.prefix`
+)
+
+// NewMock starts a fake device serving its status as JSON, it has to be closed
+// after use.
+func NewMock(ipv4 net.IP, ipv6 net.IP, prefix *net.IPNet) *httptest.Server {
+	status := map[string]any{
+		"wan": map[string]any{
+			"ipv4": map[string]any{"address": ipv4.String()},
+			"ipv6": []map[string]any{
+				{"scope": "link", "address": "fe80::1/64"},
+				{"scope": "global", "address": ipv6.String() + "/64"},
+			},
+		},
+		"lan": map[string]any{
+			"delegated.prefix": prefix.String(),
+		},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	}))
+}
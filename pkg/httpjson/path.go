@@ -0,0 +1,125 @@
+package httpjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// get resolves a path like the ones of gjson in v, a tree decoded with
+// UseNumber. The path consists of object keys and array indexes separated by
+// dots, i.e. wan.addresses.0, a dot in a key is escaped as \. and
+// #(key==value) selects the first element of an array whose key matches.
+func get(v any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+
+	if path == "" {
+		return v, nil
+	}
+
+	for _, part := range split(path) {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[part]
+
+			if !ok {
+				return nil, fmt.Errorf("no key %q", part)
+			}
+
+			v = child
+		case []any:
+			if strings.HasPrefix(part, "#(") && strings.HasSuffix(part, ")") {
+				match, err := query(node, part[2:len(part)-1])
+
+				if err != nil {
+					return nil, err
+				}
+
+				v = match
+				continue
+			}
+
+			i, err := strconv.Atoi(part)
+
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("no index %q in array of %d elements", part, len(node))
+			}
+
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("can't select %q of a value", part)
+		}
+	}
+
+	return v, nil
+}
+
+// query returns the first element of the array, whose path equals the value
+// of the condition path==value. The value may be quoted.
+func query(array []any, condition string) (any, error) {
+	path, value, found := strings.Cut(condition, "==")
+
+	if !found {
+		return nil, fmt.Errorf("invalid query %q, expected #(key==value)", condition)
+	}
+
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	for _, element := range array {
+		v, err := get(element, path)
+
+		if err == nil && toString(v) == value {
+			return element, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no element matches %q", condition)
+}
+
+// split cuts the path at its dots, except the escaped ones and the ones in a
+// query.
+func split(path string) []string {
+	var parts []string
+	var part strings.Builder
+
+	depth := 0
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+
+		switch {
+		case c == '\\' && i+1 < len(path):
+			i++
+			part.WriteByte(path[i])
+		case c == '(':
+			depth++
+			part.WriteByte(c)
+		case c == ')':
+			depth--
+			part.WriteByte(c)
+		case c == '.' && depth == 0:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+
+	return append(parts, part.String())
+}
+
+func toString(v any) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
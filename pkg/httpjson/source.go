@@ -0,0 +1,169 @@
+package httpjson
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Source polls the WAN IPs from the status JSON of a router or modem, that
+// doesn't offer UPnP or TR-064. The values are selected with paths like the
+// ones of gjson, see get. A value may be an address or an address with its
+// prefix length.
+type Source struct {
+	Url string
+	// Username and Password are sent with basic auth if set
+	Username string
+	Password string
+	// Token is sent as bearer token if set
+	Token string
+	// Ipv4Path, Ipv6Path and PrefixPath select the values, an empty path
+	// means the device doesn't report it
+	Ipv4Path   string
+	Ipv6Path   string
+	PrefixPath string
+	Timeout    time.Duration
+	// Insecure skips verifying the self-signed certificate of the device
+	Insecure bool
+}
+
+func NewSource(url string) *Source {
+	return &Source{
+		Url:     url,
+		Timeout: 5 * time.Second,
+	}
+}
+
+func (s *Source) GetWanIpv4() (net.IP, error) {
+	ip, _, err := s.lookup("IPv4", s.Ipv4Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("%s is no IPv4 address", ip)
+	}
+
+	return ip.To4(), nil
+}
+
+func (s *Source) GetwanIpv6() (net.IP, error) {
+	ip, _, err := s.lookup("IPv6", s.Ipv6Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("%s is no IPv6 address", ip)
+	}
+
+	return ip, nil
+}
+
+func (s *Source) GetIpv6Prefix() (*net.IPNet, error) {
+	ip, ipNet, err := s.lookup("IPv6 prefix", s.PrefixPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ipNet == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("%s is no IPv6 prefix", ip)
+	}
+
+	return ipNet, nil
+}
+
+// lookup fetches the status and returns the address at path, with its network
+// if it has a prefix length.
+func (s *Source) lookup(what string, path string) (net.IP, *net.IPNet, error) {
+	if path == "" {
+		return nil, nil, fmt.Errorf("%s without a path: %w", what, errors.ErrUnsupported)
+	}
+
+	status, err := s.fetch()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v, err := get(status, path)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s at %s: %w", what, path, err)
+	}
+
+	value := strings.TrimSpace(toString(v))
+
+	if ip, ipNet, err := net.ParseCIDR(value); err == nil {
+		return ip, ipNet, nil
+	}
+
+	ip := net.ParseIP(value)
+
+	if ip == nil {
+		return nil, nil, fmt.Errorf("%s at %s is no address: %q", what, path, value)
+	}
+
+	return ip, nil, nil
+}
+
+func (s *Source) fetch() (any, error) {
+	request, err := http.NewRequest("GET", s.Url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	if s.Username != "" || s.Password != "" {
+		request.SetBasicAuth(s.Username, s.Password)
+	}
+
+	if s.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	client := &http.Client{
+		Timeout: s.Timeout,
+	}
+
+	if s.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", s.Url, response.Status)
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+
+	var status any
+
+	err = decoder.Decode(&status)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid status JSON: %w", err)
+	}
+
+	return status, nil
+}
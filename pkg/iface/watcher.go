@@ -0,0 +1,154 @@
+package iface
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// settleDelay coalesces the bursts of changes while an address is added, i.e.
+// duplicate address detection marking it tentative and then valid.
+const settleDelay = time.Second
+
+// sharedAddressSpace is used for carrier-grade NAT, it isn't reachable from the
+// internet like the private ranges.
+var _, sharedAddressSpace, _ = net.ParseCIDR("100.64.0.0/10")
+
+// address is an address of the interface, temporary addresses are the ones
+// created by the IPv6 privacy extensions.
+type address struct {
+	ip        net.IP
+	temporary bool
+}
+
+// Watcher relays the global addresses of a local interface, for hosts getting
+// an address directly from the delegated prefix, which don't need to ask the
+// router at all. On Linux changes are noticed instantly through netlink.
+type Watcher struct {
+	Interface string
+	// Interval re-reads the addresses in case a change is missed, outside of
+	// Linux it's the only way to notice changes
+	Interval time.Duration
+
+	out chan<- *net.IP
+	log *slog.Logger
+
+	lastV4 net.IP
+	lastV6 net.IP
+	resync chan struct{}
+}
+
+func NewWatcher(name string, out chan<- *net.IP, log *slog.Logger) *Watcher {
+	return &Watcher{
+		Interface: name,
+		Interval:  5 * time.Minute,
+		out:       out,
+		log:       log.With(slog.String("module", "iface"), slog.String("interface", name)),
+		resync:    make(chan struct{}, 1),
+	}
+}
+
+func (w *Watcher) StartWorker() {
+	go w.watchEvents()
+	go w.spawnWorker()
+}
+
+func (w *Watcher) spawnWorker() {
+	ticker := time.NewTicker(w.Interval)
+
+	w.sync(true)
+
+	for {
+		select {
+		case <-w.resync:
+			time.Sleep(settleDelay)
+
+			// Drop the changes that happened while settling
+			select {
+			case <-w.resync:
+			default:
+			}
+
+			w.sync(false)
+		case <-ticker.C:
+			w.sync(true)
+		}
+	}
+}
+
+// requestSync schedules reading the addresses, without blocking the caller.
+func (w *Watcher) requestSync() {
+	select {
+	case w.resync <- struct{}{}:
+	default:
+	}
+}
+
+// sync relays the current addresses, unchanged ones only if forced.
+func (w *Watcher) sync(force bool) {
+	addresses, err := w.addresses()
+
+	if err != nil {
+		w.log.Warn("Failed to read the addresses of the interface", logging.ErrorAttr(err))
+		return
+	}
+
+	ipv4, ipv6 := pick(addresses)
+
+	if ipv4 != nil && (force || !ipv4.Equal(w.lastV4)) {
+		if !ipv4.Equal(w.lastV4) {
+			w.log.Info("New interface IPv4 found", slog.Any("ip", ipv4))
+		}
+
+		w.lastV4 = ipv4
+		w.out <- &ipv4
+	}
+
+	if ipv6 != nil && (force || !ipv6.Equal(w.lastV6)) {
+		if !ipv6.Equal(w.lastV6) {
+			w.log.Info("New interface IPv6 found", slog.Any("ip", ipv6))
+		}
+
+		w.lastV6 = ipv6
+		w.out <- &ipv6
+	}
+}
+
+// pick returns the first global address of each IP version, IPv6 addresses of
+// the privacy extensions are only used if there is no stable one, as they
+// change daily.
+func pick(addresses []address) (net.IP, net.IP) {
+	var ipv4, ipv6, temporary net.IP
+
+	for _, a := range addresses {
+		if !isGlobal(a.ip) {
+			continue
+		}
+
+		switch {
+		case a.ip.To4() != nil:
+			if ipv4 == nil {
+				ipv4 = a.ip.To4()
+			}
+		case a.temporary:
+			if temporary == nil {
+				temporary = a.ip
+			}
+		default:
+			if ipv6 == nil {
+				ipv6 = a.ip
+			}
+		}
+	}
+
+	if ipv6 == nil {
+		ipv6 = temporary
+	}
+
+	return ipv4, ipv6
+}
+
+func isGlobal(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
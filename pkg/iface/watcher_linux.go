@@ -0,0 +1,149 @@
+package iface
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	rtmgrpIpv4Ifaddr = 0x10
+	rtmgrpIpv6Ifaddr = 0x100
+
+	// ifaFlags carries the flags of the address if they don't fit into the
+	// 8 bits of the header
+	ifaFlags = 0x8
+)
+
+// watchEvents subscribes to the address changes of all interfaces and
+// requests a sync for every one of them.
+func (w *Watcher) watchEvents() {
+	for {
+		err := w.subscribe()
+
+		w.log.Warn("Netlink subscription failed, retrying in a minute", logging.ErrorAttr(err))
+		time.Sleep(time.Minute)
+	}
+}
+
+func (w *Watcher) subscribe() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(fd)
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIpv4Ifaddr | rtmgrpIpv6Ifaddr,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	w.log.Debug("Watching address changes with netlink")
+
+	buf := make([]byte, 16384)
+
+	for {
+		_, _, err := syscall.Recvfrom(fd, buf, 0)
+
+		// Events were dropped because they came in too fast, reading the
+		// addresses catches up with them
+		if errors.Is(err, syscall.ENOBUFS) {
+			w.requestSync()
+			continue
+		}
+
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		w.requestSync()
+	}
+}
+
+// addresses dumps the addresses of the interface with their flags, which the
+// net package doesn't expose.
+func (w *Watcher) addresses() ([]address, error) {
+	ifi, err := net.InterfaceByName(w.Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := syscall.ParseNetlinkMessage(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []address
+
+	for _, m := range messages {
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		family := m.Data[0]
+		flags := uint32(m.Data[2])
+		index := binary.NativeEndian.Uint32(m.Data[4:8])
+
+		if int(index) != ifi.Index {
+			continue
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var ip net.IP
+
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				if ip == nil {
+					ip = net.IP(attr.Value)
+				}
+			case syscall.IFA_LOCAL:
+				// Differs from IFA_ADDRESS on point-to-point links, where
+				// that is the address of the peer
+				ip = net.IP(attr.Value)
+			case ifaFlags:
+				if len(attr.Value) >= 4 {
+					flags = binary.NativeEndian.Uint32(attr.Value)
+				}
+			}
+		}
+
+		// Addresses being checked for duplicates or on the way out can't be reached
+		if ip == nil || flags&(syscall.IFA_F_TENTATIVE|syscall.IFA_F_DADFAILED|syscall.IFA_F_DEPRECATED) != 0 {
+			continue
+		}
+
+		addresses = append(addresses, address{
+			ip:        ip,
+			temporary: family == syscall.AF_INET6 && flags&syscall.IFA_F_TEMPORARY != 0,
+		})
+	}
+
+	return addresses, nil
+}
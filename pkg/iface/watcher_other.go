@@ -0,0 +1,39 @@
+//go:build !linux
+
+package iface
+
+import (
+	"log/slog"
+	"net"
+)
+
+// watchEvents does nothing, changes are only noticed every Interval.
+func (w *Watcher) watchEvents() {
+	w.log.Debug("Change notifications are only supported on Linux, reading the addresses every interval", slog.Duration("interval", w.Interval))
+}
+
+// addresses returns the addresses of the interface, without flags the
+// temporary ones can't be told apart.
+func (w *Watcher) addresses() ([]address, error) {
+	ifi, err := net.InterfaceByName(w.Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := ifi.Addrs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []address
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			addresses = append(addresses, address{ip: ipNet.IP})
+		}
+	}
+
+	return addresses, nil
+}
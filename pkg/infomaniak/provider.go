@@ -0,0 +1,166 @@
+package infomaniak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"io"
+	"net"
+	"net/http"
+)
+
+type record struct {
+	Id     int    `json:"id,omitempty"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	Ttl    int    `json:"ttl"`
+}
+
+type apiResponse struct {
+	Result string          `json:"result"`
+	Data   json.RawMessage `json:"data"`
+	Error  *struct {
+		Code        string `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// Provider updates A and AAAA records of DNS zones hosted at Infomaniak.
+//
+// see https://developer.infomaniak.com/docs/api
+type Provider struct {
+	Url   string
+	Token string
+	Ttl   int
+
+	client *http.Client
+}
+
+func NewProvider(token string) *Provider {
+	return &Provider{
+		Url:    "https://api.infomaniak.com",
+		Token:  token,
+		Ttl:    300,
+		client: &http.Client{},
+	}
+}
+
+func (p *Provider) Update(ctx context.Context, name string, ip net.IP) error {
+	host, zone, err := updater.SplitRecord(name)
+
+	if err != nil {
+		return err
+	}
+
+	// The apex of a zone is addressed by the source "."
+	if host == "" {
+		host = "."
+	}
+
+	recordType := "A"
+
+	if ip.To4() == nil {
+		recordType = "AAAA"
+	}
+
+	var records []record
+
+	err = p.call(ctx, "GET", fmt.Sprintf("/2/zones/%s/records", zone), nil, &records)
+
+	if err != nil {
+		return err
+	}
+
+	found := false
+
+	for _, r := range records {
+		if r.Source != host || r.Type != recordType {
+			continue
+		}
+
+		found = true
+
+		if r.Target == ip.String() {
+			continue
+		}
+
+		err = p.call(ctx, "PUT", fmt.Sprintf("/2/zones/%s/records/%d", zone, r.Id), &record{
+			Source: r.Source,
+			Type:   r.Type,
+			Target: ip.String(),
+			Ttl:    r.Ttl,
+		}, nil)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if found {
+		return nil
+	}
+
+	return p.call(ctx, "POST", fmt.Sprintf("/2/zones/%s/records", zone), &record{
+		Source: host,
+		Type:   recordType,
+		Target: ip.String(),
+		Ttl:    p.Ttl,
+	}, nil)
+}
+
+func (p *Provider) call(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewBuffer(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, p.Url+path, body)
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+p.Token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	var r apiResponse
+
+	err = json.NewDecoder(response.Body).Decode(&r)
+
+	if err != nil {
+		return err
+	}
+
+	if r.Result != "success" {
+		if r.Error != nil {
+			return fmt.Errorf("infomaniak API error %s: %s", r.Error.Code, r.Error.Description)
+		}
+
+		return errors.New("infomaniak API request failed")
+	}
+
+	if out != nil {
+		return json.Unmarshal(r.Data, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package ipfile
+
+import (
+	"errors"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// settleDelay coalesces the bursts of changes while the file is written, i.e.
+// truncating it and writing the new content.
+const settleDelay = time.Second
+
+// Watcher relays the IPs written to a file by another daemon, i.e. the ip-up
+// script of pppd. The file holds an IPv4, an IPv6 or both, separated by
+// whitespace or commas. On Linux changes are noticed instantly through inotify.
+type Watcher struct {
+	Path string
+	// Interval re-reads the file in case a change is missed, outside of Linux
+	// it's the only way to notice changes
+	Interval time.Duration
+
+	out chan<- *net.IP
+	log *slog.Logger
+
+	lastV4 net.IP
+	lastV6 net.IP
+	resync chan struct{}
+}
+
+func NewWatcher(path string, out chan<- *net.IP, log *slog.Logger) *Watcher {
+	return &Watcher{
+		Path:     path,
+		Interval: time.Minute,
+		out:      out,
+		log:      log.With(slog.String("module", "ipfile"), slog.String("path", path)),
+		resync:   make(chan struct{}, 1),
+	}
+}
+
+func (w *Watcher) StartWorker() {
+	go w.watchEvents()
+	go w.spawnWorker()
+}
+
+func (w *Watcher) spawnWorker() {
+	ticker := time.NewTicker(w.Interval)
+
+	w.sync()
+
+	for {
+		select {
+		case <-w.resync:
+			time.Sleep(settleDelay)
+
+			// Drop the changes that happened while settling
+			select {
+			case <-w.resync:
+			default:
+			}
+
+			w.sync()
+		case <-ticker.C:
+			w.sync()
+		}
+	}
+}
+
+// requestSync schedules reading the file, without blocking the caller.
+func (w *Watcher) requestSync() {
+	select {
+	case w.resync <- struct{}{}:
+	default:
+	}
+}
+
+// sync relays the IPs of the file if they changed.
+func (w *Watcher) sync() {
+	content, err := os.ReadFile(w.Path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		w.log.Debug("File doesn't exist yet")
+		return
+	}
+
+	if err != nil {
+		w.log.Warn("Failed to read the file", logging.ErrorAttr(err))
+		return
+	}
+
+	ipv4, ipv6 := parse(string(content))
+
+	if ipv4 == nil && ipv6 == nil {
+		w.log.Warn("File holds no IP", slog.String("content", strings.TrimSpace(string(content))))
+		return
+	}
+
+	if ipv4 != nil && !ipv4.Equal(w.lastV4) {
+		w.log.Info("New IPv4 found in file", slog.Any("ip", ipv4))
+		w.lastV4 = ipv4
+		w.out <- &ipv4
+	}
+
+	if ipv6 != nil && !ipv6.Equal(w.lastV6) {
+		w.log.Info("New IPv6 found in file", slog.Any("ip", ipv6))
+		w.lastV6 = ipv6
+		w.out <- &ipv6
+	}
+}
+
+// parse returns the first IP of each IP version, anything else is ignored.
+func parse(content string) (net.IP, net.IP) {
+	var ipv4, ipv6 net.IP
+
+	fields := strings.FieldsFunc(content, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+
+	for _, field := range fields {
+		ip := net.ParseIP(field)
+
+		switch {
+		case ip == nil:
+		case ip.To4() != nil:
+			if ipv4 == nil {
+				ipv4 = ip.To4()
+			}
+		default:
+			if ipv6 == nil {
+				ipv6 = ip
+			}
+		}
+	}
+
+	return ipv4, ipv6
+}
@@ -0,0 +1,76 @@
+package ipfile
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchEvents watches the directory of the file, so replacing the file by
+// renaming a new one over it is noticed as well, and requests a sync for
+// every change of the file.
+func (w *Watcher) watchEvents() {
+	for {
+		err := w.subscribe()
+
+		w.log.Warn("Inotify subscription failed, retrying in a minute", logging.ErrorAttr(err))
+		time.Sleep(time.Minute)
+	}
+}
+
+func (w *Watcher) subscribe() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+
+	if err != nil {
+		return err
+	}
+
+	defer syscall.Close(fd)
+
+	_, err = syscall.InotifyAddWatch(fd, filepath.Dir(w.Path), syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO)
+
+	if err != nil {
+		return err
+	}
+
+	w.log.Debug("Watching file changes with inotify")
+
+	name := filepath.Base(w.Path)
+	buf := make([]byte, 16384)
+
+	for {
+		n, err := syscall.Read(fd, buf)
+
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.requestSync()
+				continue
+			}
+
+			if offset > n {
+				break
+			}
+
+			// The name is padded with zeros
+			if string(bytes.TrimRight(buf[start:offset], "\x00")) == name {
+				w.requestSync()
+			}
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package ipfile
+
+import (
+	"log/slog"
+)
+
+// watchEvents does nothing, changes are only noticed every Interval.
+func (w *Watcher) watchEvents() {
+	w.log.Debug("Change notifications are only supported on Linux, reading the file every interval", slog.Duration("interval", w.Interval))
+}
@@ -0,0 +1,27 @@
+package ipv6
+
+import "net"
+
+// FromPrefix constructs the address of a host in the prefix, using the host
+// bits of localIp as its interface identifier.
+func FromPrefix(prefix *net.IPNet, localIp net.IP) net.IP {
+	constructedIp := make(net.IP, net.IPv6len)
+	copy(constructedIp, prefix.IP)
+
+	maskLen, _ := prefix.Mask.Size()
+
+	for i := 0; i < net.IPv6len; i++ {
+		b := constructedIp[i]
+		lb := localIp[i]
+		var mask byte = 0b00000000
+		for j := 0; j < 8; j++ {
+			if (i*8 + j) >= maskLen {
+				mask += 0b00000001 << (7 - j)
+			}
+		}
+		b += lb & mask
+		constructedIp[i] = b
+	}
+
+	return constructedIp
+}
@@ -0,0 +1,315 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ipv6"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/schedule"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Record is an address of an annotated hostname, a nil IP means the hostname
+// is gone and its record should be deleted.
+type Record struct {
+	Name      string
+	IpVersion int
+	IP        net.IP
+}
+
+type metadata struct {
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type service struct {
+	Metadata metadata `json:"metadata"`
+	Status   struct {
+		LoadBalancer struct {
+			Ingress []struct {
+				IP string `json:"ip"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type ingress struct {
+	Metadata metadata `json:"metadata"`
+	Spec     struct {
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// Watcher publishes the hostnames annotated on Services and Ingresses with the
+// IPs of the WAN. Services with an IPv6 load balancer address get it combined
+// with the current prefix instead.
+type Watcher struct {
+	client *http.Client
+	url    string
+	log    *slog.Logger
+
+	Annotation string
+	Namespace  string
+	Interval   time.Duration
+
+	In       chan *net.IP
+	Prefixes chan *net.IPNet
+	out      chan<- *Record
+
+	ipv4      net.IP
+	ipv6      net.IP
+	prefix    *net.IPNet
+	published map[string]*Record
+}
+
+// NewInClusterWatcher connects to the API server of the cluster it runs in,
+// using the service account of the pod.
+func NewInClusterWatcher(out chan<- *Record, log *slog.Logger) (*Watcher, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+
+	ca, err := os.ReadFile(serviceAccount + "/ca.crt")
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid cluster CA certificate")
+	}
+
+	return &Watcher{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			Timeout:   30 * time.Second,
+		},
+		url:        "https://" + net.JoinHostPort(host, port),
+		log:        log.With(slog.String("module", "kubernetes")),
+		Annotation: "fritzbox-cloudflare-dyndns/hostname",
+		Interval:   time.Minute,
+		In:         make(chan *net.IP, 10),
+		Prefixes:   make(chan *net.IPNet, 10),
+		out:        out,
+		published:  make(map[string]*Record),
+	}, nil
+}
+
+func (w *Watcher) StartWorker() {
+	go w.spawnWorker()
+}
+
+func (w *Watcher) spawnWorker() {
+	ticker := schedule.NewTicker(schedule.Every(w.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ip := <-w.In:
+			if ip.To4() == nil {
+				w.ipv6 = *ip
+			} else {
+				w.ipv4 = *ip
+			}
+		case prefix := <-w.Prefixes:
+			w.prefix = prefix
+		case <-ticker.C:
+		}
+
+		w.sync()
+	}
+}
+
+// sync publishes the differences between the annotated hostnames and the
+// records published so far.
+func (w *Watcher) sync() {
+	current, err := w.records()
+
+	if err != nil {
+		w.log.Error("Failed to list annotated resources", logging.ErrorAttr(err))
+		return
+	}
+
+	for key, record := range current {
+		if published, ok := w.published[key]; ok && published.IP.Equal(record.IP) {
+			continue
+		}
+
+		w.log.Info("Publishing hostname", slog.String("zone", record.Name), slog.Any("ip", record.IP))
+		w.out <- record
+	}
+
+	for key, record := range w.published {
+		if _, ok := current[key]; !ok {
+			w.log.Info("Hostname is gone, deleting its record", slog.String("zone", record.Name), slog.Int("version", record.IpVersion))
+			w.out <- &Record{Name: record.Name, IpVersion: record.IpVersion}
+		}
+	}
+
+	w.published = current
+}
+
+// records lists the records every annotated hostname should have.
+func (w *Watcher) records() (map[string]*Record, error) {
+	records := make(map[string]*Record)
+
+	add := func(name string, ip net.IP) {
+		if ip == nil {
+			return
+		}
+
+		version := 6
+
+		if ip.To4() != nil {
+			version = 4
+		}
+
+		records[fmt.Sprintf("%s/IPv%d", name, version)] = &Record{Name: name, IpVersion: version, IP: ip}
+	}
+
+	var services struct {
+		Items []service `json:"items"`
+	}
+
+	err := w.list("/api/v1", "services", &services)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range services.Items {
+		for _, name := range w.hostnames(s.Metadata, nil) {
+			add(name, w.ipv4)
+			add(name, w.serviceIpv6(s))
+		}
+	}
+
+	var ingresses struct {
+		Items []ingress `json:"items"`
+	}
+
+	err = w.list("/apis/networking.k8s.io/v1", "ingresses", &ingresses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range ingresses.Items {
+		var hosts []string
+
+		for _, rule := range i.Spec.Rules {
+			if rule.Host != "" {
+				hosts = append(hosts, rule.Host)
+			}
+		}
+
+		for _, name := range w.hostnames(i.Metadata, hosts) {
+			add(name, w.ipv4)
+			add(name, w.ipv6)
+		}
+	}
+
+	return records, nil
+}
+
+// hostnames returns the hostnames of the annotation, `true` publishes the
+// default hosts of the resource instead.
+func (w *Watcher) hostnames(meta metadata, defaults []string) []string {
+	value, ok := meta.Annotations[w.Annotation]
+
+	if !ok {
+		return nil
+	}
+
+	if value == "true" {
+		return defaults
+	}
+
+	var names []string
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// serviceIpv6 derives the public IPv6 of a load balancer from the current
+// prefix, falling back to the IPv6 of the WAN.
+func (w *Watcher) serviceIpv6(s service) net.IP {
+	for _, lb := range s.Status.LoadBalancer.Ingress {
+		ip := net.ParseIP(lb.IP)
+
+		if ip == nil || ip.To4() != nil {
+			continue
+		}
+
+		if w.prefix != nil {
+			return ipv6.FromPrefix(w.prefix, ip)
+		}
+
+		return ip
+	}
+
+	return w.ipv6
+}
+
+func (w *Watcher) list(group string, resource string, v any) error {
+	path := group + "/" + resource
+
+	if w.Namespace != "" {
+		path = group + "/namespaces/" + w.Namespace + "/" + resource
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", w.url+path, nil)
+
+	if err != nil {
+		return err
+	}
+
+	// Service account tokens get rotated, so always use the current one
+	token, err := os.ReadFile(serviceAccount + "/token")
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	response, err := w.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("listing %s failed with %s", resource, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fileWriter appends the logs to a file, which is rotated once it grew too
+// large or was written to for too long. The rotated files are numbered like
+// logrotate does, the newest is path.1.
+type fileWriter struct {
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+	backups  int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newFileWriter(path string, maxBytes int64, maxAge time.Duration, backups int) (*fileWriter, error) {
+	w := &fileWriter{
+		path:     path,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		backups:  backups,
+	}
+
+	err := w.open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *fileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+	w.opened = time.Now()
+
+	return nil
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tooLarge := w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes
+	tooOld := w.maxAge > 0 && time.Since(w.opened) >= w.maxAge
+
+	if tooLarge || tooOld {
+		// Logging goes on in the current file if it can't be rotated
+		if err := w.rotate(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to rotate the log file: %v\n", err)
+			w.opened = time.Now()
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// rotate shifts the rotated files by one, drops the ones beyond the backups
+// and starts a new file.
+func (w *fileWriter) rotate() error {
+	_ = os.Remove(w.backup(w.backups))
+
+	for i := w.backups - 1; i >= 1; i-- {
+		err := os.Rename(w.backup(i), w.backup(i+1))
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var err error
+
+	if w.backups > 0 {
+		err = os.Rename(w.path, w.backup(1))
+	} else {
+		err = os.Remove(w.path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Until the new file is open the lines go on to the rotated one
+	previous := w.file
+	err = w.open()
+
+	if err != nil {
+		return err
+	}
+
+	return previous.Close()
+}
+
+func (w *fileWriter) backup(i int) string {
+	return w.path + "." + strconv.Itoa(i)
+}
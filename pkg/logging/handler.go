@@ -0,0 +1,214 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Options configure where and how the logs are written.
+type Options struct {
+	// Level is one of debug, info, warn or error, invalid levels fall back
+	// to info
+	Level string
+	// Format is text for the lines of the standard library or json
+	Format string
+	// Syslog is the address of a syslog server the logs are sent to as well,
+	// i.e. udp://192.168.0.2:514
+	Syslog string
+	// SyslogFacility defaults to daemon
+	SyslogFacility string
+	// File is the path of a file the logs are written to as well
+	File string
+	// FileMaxBytes is the size the file is rotated at, 0 for no limit
+	FileMaxBytes int64
+	// FileMaxAge is how long the file is written to before it's rotated, 0
+	// for no limit
+	FileMaxAge time.Duration
+	// FileBackups is the number of rotated files that are kept
+	FileBackups int
+}
+
+// Setup makes the handler of the options the default.
+func Setup(o Options) error {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(o.Level))
+
+	handlers := []slog.Handler{
+		newHandler(o.Format, level, writeLines(os.Stderr, o.Format)),
+	}
+
+	if o.Syslog != "" {
+		w, err := newSyslogWriter(o.Syslog, o.SyslogFacility)
+
+		if err != nil {
+			return err
+		}
+
+		handlers = append(handlers, newHandler(o.Format, level, w.write))
+	}
+
+	if o.File != "" {
+		w, err := newFileWriter(o.File, o.FileMaxBytes, o.FileMaxAge, o.FileBackups)
+
+		if err != nil {
+			return err
+		}
+
+		handlers = append(handlers, newHandler(o.Format, level, writeLines(w, o.Format)))
+	}
+
+	if len(handlers) == 1 {
+		slog.SetDefault(slog.New(handlers[0]))
+	} else {
+		slog.SetDefault(slog.New(&multiHandler{handlers: handlers}))
+	}
+
+	return nil
+}
+
+// writeLines writes every line to w, text lines are prefixed with the time and
+// the level like the default handler does.
+func writeLines(w io.Writer, format string) func(r slog.Record, line []byte) error {
+	return func(r slog.Record, line []byte) error {
+		if format != "json" {
+			line = append([]byte(r.Time.Format("2006/01/02 15:04:05")+" "+r.Level.String()+" "), line...)
+		}
+
+		_, err := w.Write(append(line, '\n'))
+
+		return err
+	}
+}
+
+// lineHandler formats every record as a single line, the message followed by
+// the attributes for text or a JSON object, and hands it to write.
+type lineHandler struct {
+	inner  slog.Handler
+	json   bool
+	shared *lineShared
+}
+
+// lineShared is the buffer the handlers derived by WithAttrs and WithGroup
+// format into.
+type lineShared struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	write func(r slog.Record, line []byte) error
+}
+
+func newHandler(format string, level slog.Leveler, write func(r slog.Record, line []byte) error) slog.Handler {
+	shared := &lineShared{write: write}
+
+	if format == "json" {
+		return &lineHandler{
+			inner:  slog.NewJSONHandler(&shared.buf, &slog.HandlerOptions{Level: level}),
+			json:   true,
+			shared: shared,
+		}
+	}
+
+	// Only the attributes are formatted, the message is written in front of
+	// them like the default handler does
+	return &lineHandler{
+		inner: slog.NewTextHandler(&shared.buf, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+					return slog.Attr{}
+				}
+
+				return a
+			},
+		}),
+		shared: shared,
+	}
+}
+
+func (h *lineHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *lineHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.shared.mu.Lock()
+	defer h.shared.mu.Unlock()
+
+	h.shared.buf.Reset()
+	err := h.inner.Handle(ctx, r)
+
+	if err != nil {
+		return err
+	}
+
+	line := bytes.TrimSuffix(h.shared.buf.Bytes(), []byte("\n"))
+
+	if !h.json {
+		if len(line) > 0 {
+			line = append([]byte(r.Message+" "), line...)
+		} else {
+			line = []byte(r.Message)
+		}
+	}
+
+	return h.shared.write(r, line)
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lineHandler{inner: h.inner.WithAttrs(attrs), json: h.json, shared: h.shared}
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	return &lineHandler{inner: h.inner.WithGroup(name), json: h.json, shared: h.shared}
+}
+
+// multiHandler passes every record to all handlers.
+type multiHandler struct {
+	handlers []slog.Handler
+}
+
+func (h *multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h *multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+
+	return &multiHandler{handlers: handlers}
+}
+
+func (h *multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithGroup(name)
+	}
+
+	return &multiHandler{handlers: handlers}
+}
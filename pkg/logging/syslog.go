@@ -0,0 +1,246 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syslogTimeout limits how long a line may block the logging.
+const syslogTimeout = 2 * time.Second
+
+// syslogRedialDelay is how long lines are dropped after the server couldn't
+// be reached, so logging doesn't block while it's down.
+const syslogRedialDelay = 30 * time.Second
+
+// syslogFacilities are the facility codes of RFC 5424 that make sense for a
+// daemon.
+var syslogFacilities = map[string]int{
+	"user":   1,
+	"daemon": 3,
+	"local0": 16,
+	"local1": 17,
+	"local2": 18,
+	"local3": 19,
+	"local4": 20,
+	"local5": 21,
+	"local6": 22,
+	"local7": 23,
+}
+
+// ParseSyslogAddress splits the address of a syslog server into the network
+// and the address to dial, i.e. udp://192.168.0.2:514, tcp://logs.lan:601 or
+// unix:///dev/log. The port defaults to 514.
+func ParseSyslogAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Hostname() == "" {
+			return "", "", errors.New("expected a host, i.e. udp://192.168.0.2:514")
+		}
+
+		port := u.Port()
+
+		if port == "" {
+			port = "514"
+		}
+
+		return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", errors.New("expected a path, i.e. unix:///dev/log")
+		}
+
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", errors.New("expected an address starting with udp://, tcp:// or unix://")
+	}
+}
+
+// ParseSyslogFacility accepts the facilities syslog lines can be sent with.
+func ParseSyslogFacility(facility string) error {
+	if _, ok := syslogFacilities[facility]; !ok {
+		return errors.New("expected user, daemon or local0 to local7")
+	}
+
+	return nil
+}
+
+// syslogWriter sends the lines to a syslog server in the format of RFC 5424.
+// The connection is established on the first line and again after it broke,
+// lines that can't be sent are dropped.
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// octets frames the lines of TCP by their length, newline those of unix
+	// stream sockets
+	octets  bool
+	newline bool
+	failed  bool
+	redial  time.Time
+}
+
+func newSyslogWriter(address string, facility string) (*syslogWriter, error) {
+	network, address, err := ParseSyslogAddress(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if facility == "" {
+		facility = "daemon"
+	}
+
+	code, ok := syslogFacilities[facility]
+
+	if !ok {
+		return nil, ParseSyslogFacility(facility)
+	}
+
+	hostname, err := os.Hostname()
+
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogWriter{
+		network:  network,
+		address:  address,
+		facility: code,
+		hostname: hostname,
+	}, nil
+}
+
+// severity maps the level to the severity of syslog.
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func (w *syslogWriter) write(r slog.Record, line []byte) error {
+	msg := fmt.Sprintf("<%d>1 %s %s fritzbox-cloudflare-dyndns %d - - %s",
+		w.facility*8+severity(r.Level),
+		r.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname,
+		os.Getpid(),
+		line,
+	)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A connection closed by the server is only noticed by the first write,
+	// so the line is sent once more on a new connection
+	connected := w.conn != nil
+	err := w.send(msg)
+
+	if err != nil && connected {
+		w.close()
+		err = w.send(msg)
+	}
+
+	if err != nil {
+		w.close()
+
+		if !w.failed {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to send logs to syslog, dropping them until it's reachable: %v\n", err)
+		}
+	}
+
+	w.failed = err != nil
+
+	return err
+}
+
+func (w *syslogWriter) send(msg string) error {
+	if w.conn == nil {
+		if time.Now().Before(w.redial) {
+			return errors.New("syslog is unreachable")
+		}
+
+		err := w.dial()
+
+		if err != nil {
+			w.redial = time.Now().Add(syslogRedialDelay)
+			return err
+		}
+	}
+
+	if w.octets {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	} else if w.newline {
+		msg += "\n"
+	}
+
+	_ = w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := w.conn.Write([]byte(msg))
+
+	return err
+}
+
+func (w *syslogWriter) dial() error {
+	if w.network != "unix" {
+		conn, err := net.DialTimeout(w.network, w.address, syslogTimeout)
+
+		if err != nil {
+			return err
+		}
+
+		w.conn = conn
+		w.octets = w.network == "tcp"
+
+		return nil
+	}
+
+	// /dev/log is a datagram socket on most systems, but a stream on some
+	conn, err := net.DialTimeout("unixgram", w.address, syslogTimeout)
+
+	if err == nil {
+		w.conn = conn
+		w.newline = false
+
+		return nil
+	}
+
+	conn, err = net.DialTimeout("unix", w.address, syslogTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+	w.newline = true
+
+	return nil
+}
+
+func (w *syslogWriter) close() {
+	if w.conn != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+}
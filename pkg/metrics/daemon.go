@@ -0,0 +1,30 @@
+package metrics
+
+const namespace = "fritzbox_cloudflare_dyndns_"
+
+var (
+	// BuildInfo is always 1, the version is its label
+	BuildInfo = NewGauge(namespace+"build_info", "Version of the daemon.", "version")
+	StartTime = NewGauge(namespace+"start_time_seconds", "Start time of the daemon in seconds since the epoch.")
+
+	// IpChanges counts the IPs a source reported that differ from its previous
+	// ones
+	IpChanges = NewCounter(namespace+"ip_changes_total", "New IPs reported by the sources.", "source", "family")
+
+	PollFailures = NewCounter(namespace+"poll_failures_total", "Failed polls of the router by polled value.", "value")
+	LastPoll     = NewGauge(namespace+"last_successful_poll_timestamp_seconds", "Time of the last successful poll of the router.")
+
+	// PushRequests counts the requests of the push server by result, which is
+	// one of ok, failed, unauthorized and limited
+	PushRequests = NewCounter(namespace+"push_requests_total", "Push requests by endpoint and result.", "endpoint", "result")
+
+	// RecordChanges counts the records changed at the providers, the change is
+	// one of created, updated and deleted
+	RecordChanges  = NewCounter(namespace+"record_changes_total", "Records changed at the providers.", "provider", "change")
+	ProviderErrors = NewCounter(namespace+"provider_errors_total", "Failed updates of records at the providers.", "provider")
+
+	// NotificationLag is how long the last notification waited in the queue
+	// and for its delivery, it grows while a notifier is slow
+	NotificationLag      = NewGauge(namespace+"notification_lag_seconds", "Time from the last event to its delivery by notifier.", "notifier")
+	NotificationsDropped = NewCounter(namespace+"notifications_dropped_total", "Notifications dropped because the queue of the notifier was full.", "notifier")
+)
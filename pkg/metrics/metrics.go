@@ -0,0 +1,198 @@
+// Package metrics exposes counters and gauges in the Prometheus text format.
+// The metrics of the daemon are declared in daemon.go, they are registered with
+// the Default registry.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds the metrics served together.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// Default is the registry the metrics are added to.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.families {
+		if other.name == f.name {
+			panic(fmt.Sprintf("metric %s registered twice", f.name))
+		}
+	}
+
+	r.families = append(r.families, f)
+}
+
+// Handler serves all metrics of the registry.
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+// family is a metric with all its label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+func newFamily(name string, help string, kind string, labels []string) *family {
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*series{},
+	}
+
+	Default.add(f)
+
+	return f
+}
+
+// add changes the series of the label values by delta, or sets it to delta.
+func (f *family) add(delta float64, set bool, labels []string) {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d", f.name, len(f.labels), len(labels)))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.Join(labels, "\xff")
+	s, ok := f.series[key]
+
+	if !ok {
+		s = &series{labels: slices.Clone(labels)}
+		f.series[key] = s
+	}
+
+	if set {
+		s.value = delta
+	} else {
+		s.value += delta
+	}
+}
+
+func (f *family) write(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escape(f.help, false), f.name, f.kind)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+
+		if len(s.labels) == 0 {
+			_, _ = fmt.Fprintf(w, "%s %s\n", f.name, formatValue(s.value))
+			continue
+		}
+
+		pairs := make([]string, len(s.labels))
+
+		for i, v := range s.labels {
+			pairs[i] = fmt.Sprintf(`%s="%s"`, f.labels[i], escape(v, true))
+		}
+
+		_, _ = fmt.Fprintf(w, "%s{%s} %s\n", f.name, strings.Join(pairs, ","), formatValue(s.value))
+	}
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes the text as the format requires, quotes only in label values.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+
+	return s
+}
+
+// Counter is a value that only goes up, i.e. the number of failed polls.
+type Counter struct {
+	f *family
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{f: newFamily(name, help, "counter", labels)}
+}
+
+// Inc adds one to the series of the label values.
+func (c *Counter) Inc(labels ...string) {
+	c.f.add(1, false, labels)
+}
+
+// Add adds n to the series of the label values.
+func (c *Counter) Add(n int, labels ...string) {
+	c.f.add(float64(n), false, labels)
+}
+
+// Gauge is a value that goes up and down, i.e. the time of the last poll.
+type Gauge struct {
+	f *family
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{f: newFamily(name, help, "gauge", labels)}
+}
+
+// Set sets the series of the label values.
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.f.add(value, true, labels)
+}
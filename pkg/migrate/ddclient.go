@@ -0,0 +1,112 @@
+package migrate
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ParseDdclient parses a ddclient.conf, every line listing hosts becomes a
+// service with the options set so far.
+func ParseDdclient(r io.Reader) ([]*Service, error) {
+	var services []*Service
+
+	options := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	line := ""
+
+	for scanner.Scan() {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+
+		// Lines ending with a backslash continue on the next one
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+
+		line += text
+
+		if line == "" {
+			continue
+		}
+
+		hosts := parseDdclientLine(line, options)
+		line = ""
+
+		if len(hosts) == 0 {
+			continue
+		}
+
+		services = append(services, newDdclientService(options, hosts))
+
+		// Options given together with hosts only apply to them, but ddclient
+		// keeps the global ones
+		options = globalOptions(options)
+	}
+
+	return services, scanner.Err()
+}
+
+// parseDdclientLine stores the `key=value` options of the line and returns the
+// hosts listed after them.
+func parseDdclientLine(line string, options map[string]string) []string {
+	var hosts []string
+
+	for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		key, value, ok := strings.Cut(field, "=")
+
+		if !ok {
+			hosts = append(hosts, field)
+			continue
+		}
+
+		options[strings.ToLower(key)] = strings.Trim(value, `'"`)
+	}
+
+	return hosts
+}
+
+// globalOptions drops the options which only make sense for a single service.
+func globalOptions(options map[string]string) map[string]string {
+	global := make(map[string]string)
+
+	for key, value := range options {
+		switch key {
+		case "protocol", "login", "password", "zone", "ttl":
+			continue
+		}
+
+		global[key] = value
+	}
+
+	return global
+}
+
+func newDdclientService(options map[string]string, hosts []string) *Service {
+	s := &Service{
+		Protocol: normalizeProtocol(options["protocol"]),
+		Login:    options["login"],
+		Password: options["password"],
+		Zone:     options["zone"],
+		Hosts:    hosts,
+		IPv4:     options["usev4"] != "disabled",
+		IPv6:     options["usev6"] != "" && options["usev6"] != "disabled",
+	}
+
+	// Before usev4 and usev6 there was only use, which might query IPv6 as well
+	if use := options["use"]; options["usev4"] == "" && strings.Contains(use, "6") {
+		s.IPv6 = true
+	}
+
+	// ddclient configures the domain of namecheap as login
+	if s.Protocol == "namecheap" && s.Zone == "" {
+		s.Zone = s.Login
+	}
+
+	if ttl, ok := options["ttl"]; ok {
+		s.TTL = atoi(ttl)
+	}
+
+	return s
+}
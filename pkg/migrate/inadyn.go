@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ParseInadyn parses an inadyn.conf of inadyn v2 and later, every provider
+// section becomes a service.
+func ParseInadyn(r io.Reader) ([]*Service, error) {
+	var services []*Service
+	var current *Service
+
+	allowIpv6 := false
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+
+		if text == "" {
+			continue
+		}
+
+		if current == nil {
+			// Sections look like `provider default@namecheap.com {`
+			if kind, rest, ok := strings.Cut(text, " "); ok && (kind == "provider" || kind == "custom") {
+				name := strings.TrimSpace(strings.TrimSuffix(rest, "{"))
+
+				current = &Service{
+					Protocol: normalizeProtocol(name),
+					IPv4:     !strings.HasPrefix(name, "ipv6@"),
+					IPv6:     strings.HasPrefix(name, "ipv6@"),
+				}
+
+				continue
+			}
+
+			if key, value, ok := cutInadynOption(text); ok && key == "allow-ipv6" {
+				allowIpv6 = value == "true"
+			}
+
+			continue
+		}
+
+		if text == "}" {
+			services = append(services, current)
+			current = nil
+
+			continue
+		}
+
+		key, value, ok := cutInadynOption(text)
+
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "username":
+			current.Login = value
+		case "password":
+			current.Password = value
+		case "hostname":
+			current.Hosts = append(current.Hosts, parseInadynList(value)...)
+		case "ttl":
+			current.TTL = atoi(value)
+		case "proxied":
+			current.Proxied = value == "true"
+		}
+	}
+
+	for _, s := range services {
+		// Cloudflare and namecheap use the domain as username
+		if s.Protocol == "cloudflare" || s.Protocol == "namecheap" {
+			s.Zone = s.Login
+		}
+
+		// Without an explicit IPv6 provider, inadyn publishes IPv6 addresses
+		// only if they are allowed at all
+		if allowIpv6 && s.IPv4 {
+			s.IPv6 = true
+		}
+	}
+
+	return services, scanner.Err()
+}
+
+func cutInadynOption(text string) (string, string, bool) {
+	key, value, ok := strings.Cut(text, "=")
+
+	return strings.ToLower(strings.TrimSpace(key)), strings.Trim(strings.TrimSpace(value), `"`), ok
+}
+
+// parseInadynList parses a single value or a list like `{ "a", "b" }`.
+func parseInadynList(value string) []string {
+	var values []string
+
+	for _, v := range strings.Split(strings.Trim(value, "{} "), ",") {
+		v = strings.Trim(strings.TrimSpace(v), `"`)
+
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,64 @@
+package migrate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Service is a dynamic DNS service configured in another client.
+type Service struct {
+	// Protocol is the provider, i.e. `cloudflare` or `namecheap`
+	Protocol string
+	Login    string
+	Password string
+	// Zone is the domain of the hosts, if the client configures it separately
+	Zone  string
+	Hosts []string
+
+	IPv4 bool
+	IPv6 bool
+
+	TTL     int
+	Proxied bool
+}
+
+// Records returns the fully qualified names of the hosts.
+func (s *Service) Records() []string {
+	records := make([]string, 0, len(s.Hosts))
+
+	for _, host := range s.Hosts {
+		switch {
+		case s.Zone == "" || host == s.Zone || strings.HasSuffix(host, "."+s.Zone):
+			records = append(records, host)
+		case host == "@":
+			records = append(records, s.Zone)
+		default:
+			records = append(records, host+"."+s.Zone)
+		}
+	}
+
+	return records
+}
+
+// normalizeProtocol maps the provider names of the clients to ours.
+func normalizeProtocol(protocol string) string {
+	protocol = strings.ToLower(protocol)
+
+	// inadyn uses `[<name>@]<domain of the provider>[:<instance>]`
+	protocol, _, _ = strings.Cut(protocol, ":")
+
+	if _, domain, ok := strings.Cut(protocol, "@"); ok {
+		protocol = domain
+	}
+
+	name, _, _ := strings.Cut(protocol, ".")
+
+	return name
+}
+
+// atoi parses numbers leniently, invalid ones are treated as unset.
+func atoi(value string) int {
+	v, _ := strconv.Atoi(value)
+
+	return v
+}
@@ -0,0 +1,70 @@
+//go:build integration
+
+package mikrotik
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+// NewMock starts a fake RouterOS REST API with ether1 as WAN interface and
+// the prefix assigned to bridge from the pool isp, it has to be closed after
+// use. Any login succeeds.
+func NewMock(ipv4 net.IP, ipv6 net.IP, prefix *net.IPNet) *httptest.Server {
+	ones, _ := prefix.Mask.Size()
+	delegated := &net.IPNet{IP: prefix.IP, Mask: net.CIDRMask(ones-8, 128)}
+
+	lanIp := make(net.IP, len(prefix.IP))
+	copy(lanIp, prefix.IP)
+	lanIp[len(lanIp)-1] = 1
+
+	ipv4Addresses := []address{
+		{Address: ipv4.String() + "/24", Interface: "ether1", Disabled: "false", Invalid: "false"},
+		{Address: "192.168.88.1/24", Interface: "bridge", Disabled: "false", Invalid: "false"},
+	}
+
+	ipv6Addresses := []address{
+		{Address: ipv6.String() + "/64", Interface: "ether1", Disabled: "false", Invalid: "false"},
+		{Address: (&net.IPNet{IP: lanIp, Mask: prefix.Mask}).String(), Interface: "bridge", Disabled: "false", Invalid: "false", FromPool: "isp"},
+	}
+
+	pools := []pool{{Name: "isp", Prefix: delegated.String()}}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var result any
+
+		switch r.URL.Path {
+		case "/rest/ip/address":
+			result = filter(ipv4Addresses, r.URL.Query().Get("interface"), "")
+		case "/rest/ipv6/address":
+			result = filter(ipv6Addresses, r.URL.Query().Get("interface"), r.URL.Query().Get("from-pool"))
+		case "/rest/ipv6/pool":
+			result = pools
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func filter(addresses []address, iface string, fromPool string) []address {
+	filtered := []address{}
+
+	for _, a := range addresses {
+		if (iface == "" || a.Interface == iface) && (fromPool == "" || a.FromPool == fromPool) {
+			filtered = append(filtered, a)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,199 @@
+package mikrotik
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// address is an entry of /ip/address or /ipv6/address, RouterOS sends all
+// values as strings.
+type address struct {
+	Address   string `json:"address"`
+	Interface string `json:"interface"`
+	Disabled  string `json:"disabled"`
+	Invalid   string `json:"invalid"`
+	FromPool  string `json:"from-pool"`
+}
+
+type pool struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+}
+
+// Rest polls the WAN IPs and the IPv6 prefix from a MikroTik router through
+// the REST API of RouterOS 7. The prefix is the one of the LAN addresses
+// assigned from the IPv6 pool, or the whole pool if there are none.
+type Rest struct {
+	// Url of the router, i.e. https://192.168.88.1
+	Url      string
+	Username string
+	Password string
+	// Interface is the WAN interface, i.e. ether1 or pppoe-out1
+	Interface string
+	// Pool is the IPv6 pool of the delegated prefix, the first one if empty
+	Pool    string
+	Timeout time.Duration
+	// Insecure skips verifying the self-signed certificate of the router
+	Insecure bool
+}
+
+func NewRest() *Rest {
+	return &Rest{
+		Url:       "https://192.168.88.1",
+		Username:  "admin",
+		Interface: "ether1",
+		Timeout:   5 * time.Second,
+	}
+}
+
+func (r *Rest) GetWanIpv4() (net.IP, error) {
+	var addresses []address
+
+	err := r.get("/rest/ip/address", url.Values{"interface": {r.Interface}}, &addresses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range active(addresses) {
+		if ip, _, err := net.ParseCIDR(a.Address); err == nil && ip.To4() != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no IPv4 address", r.Interface)
+}
+
+func (r *Rest) GetwanIpv6() (net.IP, error) {
+	var addresses []address
+
+	err := r.get("/rest/ipv6/address", url.Values{"interface": {r.Interface}}, &addresses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range active(addresses) {
+		if ip, _, err := net.ParseCIDR(a.Address); err == nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no global IPv6 address", r.Interface)
+}
+
+func (r *Rest) GetIpv6Prefix() (*net.IPNet, error) {
+	var pools []pool
+
+	err := r.get("/rest/ipv6/pool", nil, &pools)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var selected *pool
+
+	for i := range pools {
+		if r.Pool == "" || pools[i].Name == r.Pool {
+			selected = &pools[i]
+			break
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("no IPv6 pool %q found", r.Pool)
+	}
+
+	var addresses []address
+
+	err = r.get("/rest/ipv6/address", url.Values{"from-pool": {selected.Name}}, &addresses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range active(addresses) {
+		if _, prefix, err := net.ParseCIDR(a.Address); err == nil {
+			return prefix, nil
+		}
+	}
+
+	_, prefix, err := net.ParseCIDR(selected.Prefix)
+
+	if err != nil {
+		return nil, fmt.Errorf("IPv6 pool %s has no prefix: %w", selected.Name, err)
+	}
+
+	return prefix, nil
+}
+
+// active drops the disabled and invalid addresses, i.e. of interfaces that
+// are down.
+func active(addresses []address) []address {
+	var filtered []address
+
+	for _, a := range addresses {
+		if a.Disabled != "true" && a.Invalid != "true" {
+			filtered = append(filtered, a)
+		}
+	}
+
+	return filtered
+}
+
+// get queries the path of the API, filtered by the query, into v.
+func (r *Rest) get(path string, query url.Values, v any) error {
+	u := r.Url + path
+
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest("GET", u, nil)
+
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(r.Username, r.Password)
+
+	client := &http.Client{
+		Timeout: r.Timeout,
+	}
+
+	if r.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		return errors.New("RouterOS rejected the login, check the username and password")
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", path, response.Status)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(v)
+
+	if err != nil {
+		return fmt.Errorf("invalid response of %s: %w", path, err)
+	}
+
+	return nil
+}
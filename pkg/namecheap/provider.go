@@ -0,0 +1,113 @@
+package namecheap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"gopkg.in/xmlpath.v2"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Provider implements the Namecheap dynamic DNS protocol, it only supports A records.
+//
+// see https://www.namecheap.com/support/knowledgebase/article.aspx/29/11/how-to-dynamically-update-the-hosts-ip-with-an-http-request/
+type Provider struct {
+	Url      string
+	Password string
+
+	client *http.Client
+}
+
+func NewProvider(password string) *Provider {
+	return &Provider{
+		Url:      "https://dynamicdns.park-your-domain.com/update",
+		Password: password,
+		client:   &http.Client{},
+	}
+}
+
+func (p *Provider) Update(ctx context.Context, record string, ip net.IP) error {
+	if ip.To4() == nil {
+		return errors.New("namecheap dynamic DNS only supports IPv4")
+	}
+
+	host, domain, err := updater.SplitRecord(record)
+
+	if err != nil {
+		return err
+	}
+
+	// The apex of a domain is addressed by the host "@"
+	if host == "" {
+		host = "@"
+	}
+
+	params := url.Values{}
+	params.Set("host", host)
+	params.Set("domain", domain)
+	params.Set("password", p.Password)
+	params.Set("ip", ip.String())
+
+	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s?%s", p.Url, params.Encode()), nil)
+
+	if err != nil {
+		return err
+	}
+
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return err
+	}
+
+	return parseUpdateResponse(body)
+}
+
+func parseUpdateResponse(body []byte) error {
+	pathErrCount := xmlpath.MustCompile("//ErrCount")
+	pathErr := xmlpath.MustCompile("//errors/Err1")
+
+	// Namecheap declares utf-16 but actually responds with plain utf-8
+	d := xml.NewDecoder(bytes.NewBuffer(body))
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	root, err := xmlpath.ParseDecoder(d)
+
+	if err != nil {
+		return err
+	}
+
+	v, ok := pathErrCount.String(root)
+
+	if !ok {
+		return errors.New("xpath not found")
+	}
+
+	if v == "0" {
+		return nil
+	}
+
+	v, ok = pathErr.String(root)
+
+	if !ok {
+		return errors.New("namecheap rejected the update")
+	}
+
+	return fmt.Errorf("namecheap rejected the update: %s", v)
+}
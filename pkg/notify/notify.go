@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event describes a state change worth telling the user about.
+type Event struct {
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, event Event) error
+}
+
+// Overflow decides what happens to events when a notifier's queue is full.
+type Overflow string
+
+const (
+	// OverflowDrop discards new events while the queue is full
+	OverflowDrop Overflow = "drop"
+	// OverflowAggregate merges new events into a single summary event
+	OverflowAggregate Overflow = "aggregate"
+)
+
+// queue decouples a notifier from the sender, so a slow notifier can not
+// delay anything but its own events.
+type queue struct {
+	notifier Notifier
+	events   chan Event
+	wake     chan struct{}
+
+	mu        sync.Mutex
+	aggregate []Event
+}
+
+// Dispatcher delivers events to all registered notifiers.
+type Dispatcher struct {
+	queues []*queue
+	log    *slog.Logger
+
+	QueueSize int
+	Timeout   time.Duration
+	Overflow  Overflow
+}
+
+func NewDispatcher(log *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		log:       log.With(slog.String("module", "notify")),
+		QueueSize: 16,
+		Timeout:   10 * time.Second,
+		Overflow:  OverflowDrop,
+	}
+}
+
+// Add registers a notifier and starts its worker.
+func (d *Dispatcher) Add(n Notifier) {
+	q := &queue{
+		notifier: n,
+		events:   make(chan Event, d.QueueSize),
+		wake:     make(chan struct{}, 1),
+	}
+
+	d.queues = append(d.queues, q)
+
+	go d.spawnWorker(q)
+}
+
+// Send queues the event for every notifier without ever blocking.
+func (d *Dispatcher) Send(title string, message string) {
+	event := Event{
+		Title:   title,
+		Message: message,
+		Time:    time.Now(),
+	}
+
+	for _, q := range d.queues {
+		d.enqueue(q, event)
+	}
+}
+
+func (d *Dispatcher) enqueue(q *queue, event Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Keep the order once we started aggregating
+	if len(q.aggregate) == 0 {
+		select {
+		case q.events <- event:
+			return
+		default:
+		}
+	}
+
+	if d.Overflow == OverflowAggregate && len(q.aggregate) < d.QueueSize {
+		q.aggregate = append(q.aggregate, event)
+		q.notify()
+		return
+	}
+
+	metrics.NotificationsDropped.Inc(q.notifier.Name())
+	d.log.Warn("Notifier is lagging behind, dropping notification", slog.String("notifier", q.notifier.Name()))
+}
+
+func (d *Dispatcher) spawnWorker(q *queue) {
+	for {
+		select {
+		case event := <-q.events:
+			d.deliver(q, event)
+		case <-q.wake:
+		}
+
+		// Aggregated events are sent once the queue is drained
+		if len(q.events) == 0 {
+			if summary, ok := q.takeAggregate(); ok {
+				d.deliver(q, summary)
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(q *queue, event Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	err := q.notifier.Notify(ctx, event)
+	cancel()
+
+	metrics.NotificationLag.Set(time.Since(event.Time).Seconds(), q.notifier.Name())
+
+	if err != nil {
+		d.log.Warn("Failed to send notification", slog.String("notifier", q.notifier.Name()), logging.ErrorAttr(err))
+	}
+}
+
+// notify wakes the worker up to deliver the aggregated events.
+func (q *queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeAggregate merges all events that overflowed the queue into one.
+func (q *queue) takeAggregate() (Event, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.aggregate) == 0 {
+		return Event{}, false
+	}
+
+	lines := make([]string, 0, len(q.aggregate))
+
+	for _, event := range q.aggregate {
+		lines = append(lines, fmt.Sprintf("%s: %s", event.Title, event.Message))
+	}
+
+	summary := Event{
+		Title:   fmt.Sprintf("%d aggregated notifications", len(q.aggregate)),
+		Message: strings.Join(lines, "\n"),
+		Time:    q.aggregate[0].Time,
+	}
+
+	q.aggregate = nil
+
+	return summary, true
+}
+
+// Result is the outcome of delivering an event to a single notifier.
+type Result struct {
+	Name string
+	Err  error
+}
+
+// Test sends a sample event to every notifier directly, bypassing the queues.
+func (d *Dispatcher) Test() []Result {
+	event := Event{
+		Title:   "Test notification",
+		Message: "If you can read this, notifications are set up correctly.",
+		Time:    time.Now(),
+	}
+
+	results := make([]Result, 0, len(d.queues))
+
+	for _, q := range d.queues {
+		ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+		err := q.notifier.Notify(ctx, event)
+		cancel()
+
+		results = append(results, Result{Name: q.notifier.Name(), Err: err})
+	}
+
+	return results
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Webhook posts events as JSON to an URL.
+type Webhook struct {
+	Url string
+
+	client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		Url:    url,
+		client: &http.Client{},
+	}
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", w.Url, bytes.NewBuffer(body))
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := w.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return nil
+}
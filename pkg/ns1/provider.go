@@ -0,0 +1,147 @@
+package ns1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"io"
+	"net"
+	"net/http"
+)
+
+var errNotFound = errors.New("record not found")
+
+// answer keeps all fields of an NS1 answer, so only the rdata gets touched.
+type answer map[string]any
+
+// Provider updates A and AAAA records of zones hosted at NS1 Connect.
+// Existing records only get their answers' rdata replaced, so metadata
+// and filters configured in NS1 stay intact.
+//
+// see https://developer.ibm.com/apis/catalog/ns1--ibm-ns1-connect-api/api/API--ns1--ibm-ns1-connect-api#createRecord
+type Provider struct {
+	Url    string
+	ApiKey string
+	Ttl    int
+
+	client *http.Client
+}
+
+func NewProvider(apiKey string) *Provider {
+	return &Provider{
+		Url:    "https://api.nsone.net/v1",
+		ApiKey: apiKey,
+		Ttl:    300,
+		client: &http.Client{},
+	}
+}
+
+func (p *Provider) Update(ctx context.Context, name string, ip net.IP) error {
+	_, zone, err := updater.SplitRecord(name)
+
+	if err != nil {
+		return err
+	}
+
+	recordType := "A"
+
+	if ip.To4() == nil {
+		recordType = "AAAA"
+	}
+
+	path := fmt.Sprintf("/zones/%s/%s/%s", zone, name, recordType)
+
+	var record struct {
+		Answers []answer `json:"answers"`
+	}
+
+	err = p.call(ctx, "GET", path, nil, &record)
+
+	if errors.Is(err, errNotFound) {
+		return p.call(ctx, "PUT", path, map[string]any{
+			"zone":    zone,
+			"domain":  name,
+			"type":    recordType,
+			"ttl":     p.Ttl,
+			"answers": []answer{{"answer": []string{ip.String()}}},
+		}, nil)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	changed := false
+
+	for _, a := range record.Answers {
+		if rdata, ok := a["answer"].([]any); ok && len(rdata) == 1 && rdata[0] == ip.String() {
+			continue
+		}
+
+		a["answer"] = []string{ip.String()}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	// A POST only replaces the given fields, so filters and record metadata are kept
+	return p.call(ctx, "POST", path, map[string]any{
+		"answers": record.Answers,
+	}, nil)
+}
+
+func (p *Provider) call(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewBuffer(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, p.Url+path, body)
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("X-NSONE-Key", p.ApiKey)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	if response.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+
+		_ = json.NewDecoder(response.Body).Decode(&e)
+
+		return fmt.Errorf("ns1 API error %s: %s", response.Status, e.Message)
+	}
+
+	if out != nil {
+		return json.NewDecoder(response.Body).Decode(out)
+	}
+
+	return nil
+}
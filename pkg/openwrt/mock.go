@@ -0,0 +1,79 @@
+//go:build integration
+
+package openwrt
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+const mockSession = "5a170000000000000000000000000001"
+
+// NewMock starts a fake OpenWrt ubus endpoint at /ubus answering the status of
+// the wan and wan6 interfaces with the given addresses, it has to be closed
+// after use. Any login succeeds.
+func NewMock(ipv4 net.IP, ipv6 net.IP, prefix *net.IPNet) *httptest.Server {
+	ones, _ := prefix.Mask.Size()
+
+	statuses := map[string]interfaceStatus{
+		"network.interface.wan": {
+			Ipv4Address: []address{{Address: ipv4.String(), Mask: 24}},
+		},
+		"network.interface.wan6": {
+			Ipv6Address: []address{{Address: ipv6.String(), Mask: 64}},
+			Ipv6Prefix:  []address{{Address: prefix.IP.String(), Mask: ones}},
+		},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ubus" || r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req struct {
+			Id     int   `json:"id"`
+			Params []any `json:"params"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Params) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		session, _ := req.Params[0].(string)
+		object, _ := req.Params[1].(string)
+		method, _ := req.Params[2].(string)
+
+		var result []any
+
+		switch {
+		case object == "session" && method == "login":
+			result = []any{0, map[string]any{"ubus_rpc_session": mockSession}}
+		case session != mockSession:
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      req.Id,
+				"error":   map[string]any{"code": errAccessDenied, "message": "Access denied"},
+			})
+			return
+		default:
+			status, ok := statuses[object]
+
+			if !ok || method != "status" {
+				// UBUS_STATUS_NOT_FOUND
+				result = []any{4}
+			} else {
+				result = []any{0, status}
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.Id,
+			"result":  result,
+		})
+	}))
+}
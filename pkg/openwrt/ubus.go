@@ -0,0 +1,270 @@
+package openwrt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// emptySession is the session of anonymous calls, like the login itself.
+const emptySession = "00000000000000000000000000000000"
+
+// statusPermissionDenied is returned by ubus for calls the session isn't
+// allowed to make, i.e. after it expired.
+const statusPermissionDenied = 6
+
+// errAccessDenied is the JSON-RPC error code of unknown sessions.
+const errAccessDenied = -32002
+
+type request struct {
+	JsonRpc string `json:"jsonrpc"`
+	Id      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type response struct {
+	Result []json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type address struct {
+	Address string `json:"address"`
+	Mask    int    `json:"mask"`
+}
+
+// interfaceStatus is the answer of `network.interface.<name> status`.
+type interfaceStatus struct {
+	Ipv4Address []address `json:"ipv4-address"`
+	Ipv6Address []address `json:"ipv6-address"`
+	Ipv6Prefix  []address `json:"ipv6-prefix"`
+}
+
+// sessionError means the session has to be renewed.
+type sessionError struct {
+	message string
+}
+
+func (e *sessionError) Error() string {
+	return e.message
+}
+
+// Ubus polls the WAN IPs and the delegated prefix from an OpenWrt router
+// through the ubus JSON-RPC endpoint of its web interface. The user needs an
+// rpcd ACL allowing the status call of the network interfaces.
+type Ubus struct {
+	// Url of the JSON-RPC endpoint, i.e. http://192.168.1.1/ubus
+	Url      string
+	Username string
+	Password string
+	// Ipv4Interface and Ipv6Interface are the logical interfaces, like in
+	// /etc/config/network
+	Ipv4Interface string
+	Ipv6Interface string
+	Timeout       time.Duration
+
+	mu      sync.Mutex
+	session string
+}
+
+func NewUbus() *Ubus {
+	return &Ubus{
+		Url:           "http://192.168.1.1/ubus",
+		Username:      "root",
+		Ipv4Interface: "wan",
+		Ipv6Interface: "wan6",
+		Timeout:       5 * time.Second,
+	}
+}
+
+func (u *Ubus) GetWanIpv4() (net.IP, error) {
+	status, err := u.status(u.Ipv4Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range status.Ipv4Address {
+		if ip := net.ParseIP(a.Address); ip != nil && ip.To4() != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no IPv4 address", u.Ipv4Interface)
+}
+
+func (u *Ubus) GetwanIpv6() (net.IP, error) {
+	status, err := u.status(u.Ipv6Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range status.Ipv6Address {
+		if ip := net.ParseIP(a.Address); ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no global IPv6 address", u.Ipv6Interface)
+}
+
+func (u *Ubus) GetIpv6Prefix() (*net.IPNet, error) {
+	status, err := u.status(u.Ipv6Interface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range status.Ipv6Prefix {
+		ip := net.ParseIP(p.Address)
+
+		if ip == nil || ip.To4() != nil {
+			continue
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(p.Mask, 128)}, nil
+	}
+
+	return nil, fmt.Errorf("interface %s has no delegated IPv6 prefix", u.Ipv6Interface)
+}
+
+// status calls the status of the interface, logging in again once if the
+// session expired.
+func (u *Ubus) status(name string) (*interfaceStatus, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var status interfaceStatus
+	var err error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if u.session == "" {
+			u.session, err = u.login()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = u.call(u.session, "network.interface."+name, "status", map[string]any{}, &status)
+
+		var se *sessionError
+
+		if errors.As(err, &se) {
+			u.session = ""
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &status, nil
+	}
+
+	return nil, err
+}
+
+func (u *Ubus) login() (string, error) {
+	var result struct {
+		Session string `json:"ubus_rpc_session"`
+	}
+
+	err := u.call(emptySession, "session", "login", map[string]any{
+		"username": u.Username,
+		"password": u.Password,
+	}, &result)
+
+	var se *sessionError
+
+	if errors.As(err, &se) {
+		return "", errors.New("login to ubus failed, check the username and password")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return result.Session, nil
+}
+
+// call invokes the method of the ubus object and decodes its data into v.
+func (u *Ubus) call(session string, object string, method string, args map[string]any, v any) error {
+	body, err := json.Marshal(request{
+		JsonRpc: "2.0",
+		Id:      1,
+		Method:  "call",
+		Params:  []any{session, object, method, args},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: u.Timeout,
+	}
+
+	resp, err := client.Post(u.Url, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ubus responded with %s", resp.Status)
+	}
+
+	var r response
+
+	err = json.NewDecoder(resp.Body).Decode(&r)
+
+	if err != nil {
+		return fmt.Errorf("invalid ubus response: %w", err)
+	}
+
+	if r.Error != nil {
+		if r.Error.Code == errAccessDenied {
+			return &sessionError{r.Error.Message}
+		}
+
+		return fmt.Errorf("ubus call %s %s failed: %s", object, method, r.Error.Message)
+	}
+
+	if len(r.Result) == 0 {
+		return fmt.Errorf("ubus call %s %s returned nothing", object, method)
+	}
+
+	var code int
+
+	err = json.Unmarshal(r.Result[0], &code)
+
+	if err != nil {
+		return fmt.Errorf("invalid ubus response: %w", err)
+	}
+
+	if code == statusPermissionDenied {
+		return &sessionError{fmt.Sprintf("ubus call %s %s not permitted", object, method)}
+	}
+
+	if code != 0 {
+		return fmt.Errorf("ubus call %s %s failed with status %d", object, method, code)
+	}
+
+	if len(r.Result) < 2 {
+		return fmt.Errorf("ubus call %s %s returned no data", object, method)
+	}
+
+	return json.Unmarshal(r.Result[1], v)
+}
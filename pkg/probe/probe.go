@@ -0,0 +1,173 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Dial checks whether the given IP accepts TCP connections on port.
+func Dial(ctx context.Context, ip net.IP, port int) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Probe checks whether a service is reachable on an IP, either by a TCP
+// connect or an HTTP request with an expected status and body.
+type Probe struct {
+	// Protocol is tcp, http or https
+	Protocol string
+	Port     int
+	// Host is sent as Host header and TLS SNI, it defaults to the IP
+	Host string
+	Path string
+
+	// ExpectStatus is the expected HTTP status, 0 accepts any status below 400
+	ExpectStatus int
+	// ExpectBody has to be part of the HTTP response body if set
+	ExpectBody string
+}
+
+// NewTCP creates a probe connecting to the port.
+func NewTCP(port int) *Probe {
+	return &Probe{Protocol: "tcp", Port: port}
+}
+
+// Parse parses a probe definition like `tcp://:22` or
+// `https://www.example.com/health?expect-status=200&expect-body=ok`, the
+// probe always connects to the probed IP instead of resolving the host.
+func Parse(definition string) (*Probe, error) {
+	u, err := url.Parse(definition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Probe{
+		Protocol: u.Scheme,
+		Host:     u.Hostname(),
+		Path:     u.Path,
+	}
+
+	switch p.Protocol {
+	case "tcp":
+	case "http":
+		p.Port = 80
+	case "https":
+		p.Port = 443
+	default:
+		return nil, fmt.Errorf("unknown probe protocol %q, expected tcp, http or https", p.Protocol)
+	}
+
+	if port := u.Port(); port != "" {
+		p.Port, err = strconv.Atoi(port)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe port: %w", err)
+		}
+	}
+
+	if p.Port == 0 {
+		return nil, errors.New("tcp probes require a port")
+	}
+
+	query := u.Query()
+
+	if status := query.Get("expect-status"); status != "" {
+		p.ExpectStatus, err = strconv.Atoi(status)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected status: %w", err)
+		}
+	}
+
+	p.ExpectBody = query.Get("expect-body")
+
+	return p, nil
+}
+
+// Check runs the probe against the IP.
+func (p *Probe) Check(ctx context.Context, ip net.IP) error {
+	if p.Protocol == "tcp" {
+		return Dial(ctx, ip, p.Port)
+	}
+
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(p.Port))
+	host := p.Host
+
+	if host == "" {
+		host = ip.String()
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				var d net.Dialer
+
+				return d.DialContext(ctx, network, address)
+			},
+			TLSClientConfig: &tls.Config{ServerName: p.Host},
+		},
+		// Redirects would leave the probed IP
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s://%s%s", p.Protocol, net.JoinHostPort(host, strconv.Itoa(p.Port)), p.Path), nil)
+
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if p.ExpectStatus != 0 && response.StatusCode != p.ExpectStatus || p.ExpectStatus == 0 && response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	if p.ExpectBody == "" {
+		return nil
+	}
+
+	// Health endpoints are small, don't read more than necessary
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(body), p.ExpectBody) {
+		return fmt.Errorf("response body doesn't contain %q", p.ExpectBody)
+	}
+
+	return nil
+}
+
+func (p *Probe) String() string {
+	if p.Protocol == "tcp" {
+		return fmt.Sprintf("tcp://:%d", p.Port)
+	}
+
+	return fmt.Sprintf("%s://%s%s", p.Protocol, net.JoinHostPort(p.Host, strconv.Itoa(p.Port)), p.Path)
+}
@@ -0,0 +1,142 @@
+package publicip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Services are the known public services, they answer over both IPv4 and IPv6.
+var Services = map[string]string{
+	"ipify":      "https://api64.ipify.org",
+	"icanhazip":  "https://icanhazip.com",
+	"cloudflare": "https://one.one.one.one/cdn-cgi/trace",
+}
+
+// DefaultServices are asked in this order if none are configured.
+var DefaultServices = []string{"cloudflare", "ipify", "icanhazip"}
+
+// Source asks public "what is my IP" services for the IPs the daemon is seen
+// with, for routers in bridge mode or hosts that can't reach the router. Every
+// IP version is asked over a connection of that version, the services are
+// tried in order until one answers.
+type Source struct {
+	// Urls of the services, answering with the plain IP or a Cloudflare trace
+	Urls    []string
+	Timeout time.Duration
+
+	log *slog.Logger
+}
+
+func NewSource(log *slog.Logger) *Source {
+	s := &Source{
+		Timeout: 5 * time.Second,
+		log:     log.With(slog.String("module", "publicip")),
+	}
+
+	for _, name := range DefaultServices {
+		s.Urls = append(s.Urls, Services[name])
+	}
+
+	return s
+}
+
+func (s *Source) GetWanIpv4() (net.IP, error) {
+	return s.ask("tcp4")
+}
+
+func (s *Source) GetwanIpv6() (net.IP, error) {
+	return s.ask("tcp6")
+}
+
+// GetIpv6Prefix always fails, the services only see the address.
+func (s *Source) GetIpv6Prefix() (*net.IPNet, error) {
+	return nil, fmt.Errorf("IPv6 prefix from public services: %w", errors.ErrUnsupported)
+}
+
+func (s *Source) ask(network string) (net.IP, error) {
+	dialer := &net.Dialer{}
+
+	client := &http.Client{
+		Timeout: s.Timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	var errs []error
+
+	for _, url := range s.Urls {
+		ip, err := s.askService(client, url, network)
+
+		if err != nil {
+			s.log.Debug("Service failed, trying the next one", slog.String("url", url), slog.String("network", network), logging.ErrorAttr(err))
+			errs = append(errs, err)
+			continue
+		}
+
+		return ip, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (s *Source) askService(client *http.Client, url string, network string) (net.IP, error) {
+	response, err := client.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", url, response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+
+	if err != nil {
+		return nil, err
+	}
+
+	ip := parse(string(body))
+
+	if ip == nil {
+		return nil, fmt.Errorf("%s responded without an IP", url)
+	}
+
+	// Some services answer over IPv6 with an IPv4 mapped address and the other way around
+	if (network == "tcp4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("%s responded with %s over %s", url, ip, network)
+	}
+
+	return ip, nil
+}
+
+// parse reads a plain IP, or the `ip=` line of a Cloudflare trace.
+func parse(body string) net.IP {
+	if ip := net.ParseIP(strings.TrimSpace(body)); ip != nil {
+		return ip
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "ip="); ok {
+			return net.ParseIP(strings.TrimSpace(v))
+		}
+	}
+
+	return nil
+}
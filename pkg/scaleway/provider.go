@@ -0,0 +1,146 @@
+package scaleway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+type record struct {
+	Data string `json:"data"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Ttl  int    `json:"ttl"`
+}
+
+type idFields struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type change struct {
+	Set struct {
+		IdFields idFields `json:"id_fields"`
+		Records  []record `json:"records"`
+	} `json:"set"`
+}
+
+// Provider upserts A and AAAA records of DNS zones hosted at Scaleway Domains and DNS.
+//
+// see https://www.scaleway.com/en/developers/api/domains-and-dns/
+type Provider struct {
+	Url       string
+	SecretKey string
+	Ttl       int
+
+	client *http.Client
+}
+
+func NewProvider(secretKey string) *Provider {
+	return &Provider{
+		Url:       "https://api.scaleway.com/domain/v2beta1",
+		SecretKey: secretKey,
+		Ttl:       300,
+		client:    &http.Client{},
+	}
+}
+
+func (p *Provider) Update(ctx context.Context, name string, ip net.IP) error {
+	host, zone, err := updater.SplitRecord(name)
+
+	if err != nil {
+		return err
+	}
+
+	recordType := "A"
+
+	if ip.To4() == nil {
+		recordType = "AAAA"
+	}
+
+	params := url.Values{}
+	params.Set("name", host)
+	params.Set("type", recordType)
+
+	var existing struct {
+		Records []record `json:"records"`
+	}
+
+	err = p.call(ctx, "GET", fmt.Sprintf("/dns-zones/%s/records?%s", zone, params.Encode()), nil, &existing)
+
+	if err != nil {
+		return err
+	}
+
+	ttl := p.Ttl
+
+	if len(existing.Records) > 0 {
+		if len(existing.Records) == 1 && existing.Records[0].Data == ip.String() {
+			return nil
+		}
+
+		ttl = existing.Records[0].Ttl
+	}
+
+	// A set change replaces all records matching the id fields, creating them if missing
+	c := change{}
+	c.Set.IdFields = idFields{Name: host, Type: recordType}
+	c.Set.Records = []record{{Data: ip.String(), Name: host, Type: recordType, Ttl: ttl}}
+
+	return p.call(ctx, "PATCH", fmt.Sprintf("/dns-zones/%s/records", zone), map[string]any{
+		"changes": []change{c},
+	}, nil)
+}
+
+func (p *Provider) call(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewBuffer(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, p.Url+path, body)
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("X-Auth-Token", p.SecretKey)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+
+		_ = json.NewDecoder(response.Body).Decode(&e)
+
+		return fmt.Errorf("scaleway API error %s: %s", response.Status, e.Message)
+	}
+
+	if out != nil {
+		return json.NewDecoder(response.Body).Decode(out)
+	}
+
+	return nil
+}
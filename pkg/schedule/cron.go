@@ -0,0 +1,234 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a standard five field cron expression, evaluated in a time zone.
+//
+// DST transitions are handled like most cron daemons do: jobs scheduled at a
+// time skipped by the clock moving forward run right after the transition,
+// jobs at a fixed hour run only once when the clock moves back.
+type Cron struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// Unrestricted days of month and week
+	domStar bool
+	dowStar bool
+	// fixedHour prevents running twice in a repeated hour
+	fixedHour bool
+
+	loc *time.Location
+}
+
+type field struct {
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	minuteField = field{0, 59, nil}
+	hourField   = field{0, 23, nil}
+	domField    = field{1, 31, nil}
+	monthField  = field{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = field{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+func parseCron(expr string, loc *time.Location) (*Cron, error) {
+	fields := strings.Fields(expr)
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", expr)
+	}
+
+	c := &Cron{
+		domStar:   fields[2] == "*",
+		dowStar:   fields[4] == "*",
+		fixedHour: !strings.ContainsAny(fields[1], "*/"),
+		loc:       loc,
+	}
+
+	var err error
+
+	for i, target := range []struct {
+		bits *uint64
+		f    field
+	}{
+		{&c.minute, minuteField},
+		{&c.hour, hourField},
+		{&c.dom, domField},
+		{&c.month, monthField},
+		{&c.dow, dowField},
+	} {
+		*target.bits, err = target.f.parse(fields[i])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+
+	// Sunday is 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// parse parses a comma-separated list of `*`, values, ranges and steps.
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+
+		if hasStep {
+			v, err := strconv.Atoi(stepExpr)
+
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid step %s", stepExpr)
+			}
+
+			step = v
+		}
+
+		low, high := f.min, f.max
+
+		if rangeExpr != "*" {
+			lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-")
+
+			v, err := f.value(lowExpr)
+
+			if err != nil {
+				return 0, err
+			}
+
+			low, high = v, v
+
+			if isRange {
+				high, err = f.value(highExpr)
+
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// `5/15` means from 5 to the end
+				high = f.max
+			}
+		}
+
+		if low > high {
+			return 0, fmt.Errorf("invalid range %s", rangeExpr)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (f field) value(expr string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(expr, name) {
+			return i + f.min, nil
+		}
+	}
+
+	v, err := strconv.Atoi(expr)
+
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %s, expected %d-%d", expr, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// matches checks the wall clock time against the expression.
+func (c *Cron) matches(t time.Time) bool {
+	return c.month&(1<<int(t.Month())) != 0 && c.matchesDay(t) && c.hour&(1<<t.Hour()) != 0 && c.minute&(1<<t.Minute()) != 0
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+
+	// If both are restricted either one has to match, like in every cron
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// wall returns the wall clock time of t as UTC, so it can be stepped through
+// without being affected by DST transitions.
+func wall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := c.loc
+
+	if loc == nil {
+		loc = time.Local
+	}
+
+	candidate := t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	prev := candidate.Add(-time.Minute)
+
+	// Every matching time is found within a few years, unless it's i.e. Feb 30
+	limit := candidate.AddDate(5, 0, 0)
+
+	for candidate.Before(limit) {
+		local := candidate.In(loc)
+
+		// The clock moved forward, run what would have run in between
+		if wall(local).Sub(wall(prev)) > candidate.Sub(prev) {
+			for w := wall(prev).Add(time.Minute); w.Before(wall(local)); w = w.Add(time.Minute) {
+				if c.matches(w) {
+					return local
+				}
+			}
+		}
+
+		prev = local
+
+		if c.month&(1<<int(local.Month())) == 0 || !c.matchesDay(local) {
+			candidate = time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+			prev = candidate.Add(-time.Minute).In(loc)
+			continue
+		}
+
+		if c.hour&(1<<local.Hour()) == 0 {
+			candidate = candidate.Add(time.Duration(60-local.Minute()) * time.Minute)
+			prev = candidate.Add(-time.Minute).In(loc)
+			continue
+		}
+
+		if c.minute&(1<<local.Minute()) == 0 {
+			candidate = candidate.Add(time.Minute)
+			continue
+		}
+
+		// The clock moved back, jobs at a fixed hour already ran
+		if c.fixedHour && wall(candidate.Add(-time.Hour).In(loc)).Equal(wall(local)) {
+			candidate = candidate.Add(time.Minute)
+			continue
+		}
+
+		return local
+	}
+
+	return time.Time{}
+}
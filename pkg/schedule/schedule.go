@@ -0,0 +1,231 @@
+package schedule
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a recurring job runs.
+type Schedule interface {
+	// Next returns the first time after t the job runs
+	Next(t time.Time) time.Time
+}
+
+// Every runs a job at a fixed interval, which is elapsed time and therefore
+// unaffected by DST transitions.
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Adaptive switches to the Degraded schedule while IsDegraded reports
+// a problem, i.e. to poll more often while updates fail.
+type Adaptive struct {
+	Normal   Schedule
+	Degraded Schedule
+	// IsDegraded is checked every time the next run is calculated
+	IsDegraded func() bool
+}
+
+func (a Adaptive) Next(t time.Time) time.Time {
+	if a.IsDegraded() {
+		return a.Degraded.Next(t)
+	}
+
+	return a.Normal.Next(t)
+}
+
+// Any runs a job whenever one of the schedules does, i.e. every minute around
+// the nightly reconnect and every 10 minutes otherwise.
+type Any []Schedule
+
+func (a Any) Next(t time.Time) time.Time {
+	var next time.Time
+
+	for _, s := range a {
+		n := s.Next(t)
+
+		if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+
+	return next
+}
+
+// Jitter delays every run of the schedule by a random duration up to Max, so
+// many instances started at the same time don't run at the same second.
+type Jitter struct {
+	Schedule Schedule
+	Max      time.Duration
+}
+
+func (j Jitter) Next(t time.Time) time.Time {
+	next := j.Schedule.Next(t)
+
+	if next.IsZero() || j.Max <= 0 {
+		return next
+	}
+
+	return next.Add(rand.N(j.Max))
+}
+
+// MaxGap returns the longest time between two runs in the week after t,
+// starting with the time until the first run.
+func MaxGap(s Schedule, t time.Time) time.Duration {
+	end := t.Add(7 * 24 * time.Hour)
+	gap := time.Duration(0)
+
+	// Caps the runs of short intervals, their gaps are all the same
+	for i := 0; i < 10080 && t.Before(end); i++ {
+		next := s.Next(t)
+
+		if next.IsZero() {
+			return max(gap, end.Sub(t))
+		}
+
+		gap = max(gap, next.Sub(t))
+		t = next
+	}
+
+	return gap
+}
+
+// Parse parses a cron expression like `*/5 4 * * *`, a macro like `@daily`
+// or `@every 5m`. Cron expressions are evaluated in loc, unless they are
+// prefixed with `CRON_TZ=<zone>` or `TZ=<zone>`. Several expressions separated
+// by semicolons run the job whenever one of them does.
+func Parse(expr string, loc *time.Location) (Schedule, error) {
+	if exprs := strings.Split(expr, ";"); len(exprs) > 1 {
+		schedules := make(Any, 0, len(exprs))
+
+		for _, e := range exprs {
+			s, err := Parse(e, loc)
+
+			if err != nil {
+				return nil, err
+			}
+
+			schedules = append(schedules, s)
+		}
+
+		return schedules, nil
+	}
+
+	expr = strings.TrimSpace(expr)
+
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if rest, ok := strings.CutPrefix(expr, prefix); ok {
+			name, rest, _ := strings.Cut(rest, " ")
+
+			l, err := time.LoadLocation(name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			loc = l
+			expr = strings.TrimSpace(rest)
+		}
+	}
+
+	if interval, ok := strings.CutPrefix(expr, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid interval %s", interval)
+		}
+
+		return Every(d), nil
+	}
+
+	if macro, ok := macros[expr]; ok {
+		expr = macro
+	}
+
+	return parseCron(expr, loc)
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// maxCheck caps how often a ticker checks the wall clock, so it catches up
+// after the host was suspended or its clock was set.
+const maxCheck = time.Minute
+
+// Ticker delivers the times of a schedule on C, like a time.Ticker. It follows
+// the wall clock instead of counting elapsed time, ticks are dropped if the
+// receiver falls behind.
+type Ticker struct {
+	C <-chan time.Time
+
+	c        chan time.Time
+	schedule Schedule
+	stop     chan struct{}
+}
+
+func NewTicker(s Schedule) *Ticker {
+	c := make(chan time.Time, 1)
+
+	t := &Ticker{
+		C:        c,
+		c:        c,
+		schedule: s,
+		stop:     make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Stop stops the ticker, C is not closed.
+func (t *Ticker) Stop() {
+	close(t.stop)
+}
+
+func (t *Ticker) run() {
+	next := t.schedule.Next(time.Now())
+
+	for {
+		// The schedule never runs again
+		if next.IsZero() {
+			<-t.stop
+			return
+		}
+
+		timer := time.NewTimer(min(time.Until(next), maxCheck))
+
+		select {
+		case <-t.stop:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			if now.Before(next) {
+				continue
+			}
+
+			select {
+			case t.c <- now:
+			default:
+			}
+
+			// Skip the runs that were missed, i.e. while suspended
+			next = t.schedule.Next(now)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tags of the BER encoded values SNMP uses, see RFC 3416.
+const (
+	tagInteger     byte = 0x02
+	tagOctetString byte = 0x04
+	tagNull        byte = 0x05
+	tagOid         byte = 0x06
+	tagSequence    byte = 0x30
+	tagIpAddress   byte = 0x40
+	tagCounter32   byte = 0x41
+
+	tagNoSuchObject   byte = 0x80
+	tagNoSuchInstance byte = 0x81
+	tagEndOfMibView   byte = 0x82
+
+	tagGetRequest     byte = 0xa0
+	tagGetNextRequest byte = 0xa1
+	tagResponse       byte = 0xa2
+	tagReport         byte = 0xa8
+)
+
+var errTruncated = errors.New("truncated BER value")
+
+// element is a decoded tag with its raw content.
+type element struct {
+	tag     byte
+	content []byte
+}
+
+func tlv(tag byte, content ...[]byte) []byte {
+	size := 0
+
+	for _, c := range content {
+		size += len(c)
+	}
+
+	b := []byte{tag}
+
+	switch {
+	case size < 0x80:
+		b = append(b, byte(size))
+	case size < 0x100:
+		b = append(b, 0x81, byte(size))
+	default:
+		b = append(b, 0x82, byte(size>>8), byte(size))
+	}
+
+	for _, c := range content {
+		b = append(b, c...)
+	}
+
+	return b
+}
+
+func encodeInt(v int64) []byte {
+	b := []byte{byte(v)}
+
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+
+	return tlv(tagInteger, b)
+}
+
+func encodeString(s []byte) []byte {
+	return tlv(tagOctetString, s)
+}
+
+func encodeOid(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return tlv(tagOid)
+	}
+
+	b := []byte{byte(oid[0]*40 + oid[1])}
+
+	for _, n := range oid[2:] {
+		var group []byte
+
+		group = append(group, byte(n&0x7f))
+
+		for n >>= 7; n > 0; n >>= 7 {
+			group = append([]byte{byte(n&0x7f) | 0x80}, group...)
+		}
+
+		b = append(b, group...)
+	}
+
+	return tlv(tagOid, b)
+}
+
+// readElement decodes the first element of b and returns the rest. The
+// content shares the memory of b.
+func readElement(b []byte) (element, []byte, error) {
+	if len(b) < 2 {
+		return element{}, nil, errTruncated
+	}
+
+	tag := b[0]
+	size := int(b[1])
+	offset := 2
+
+	if size&0x80 != 0 {
+		n := size & 0x7f
+
+		if n == 0 || n > 3 || len(b) < 2+n {
+			return element{}, nil, errTruncated
+		}
+
+		size = 0
+
+		for _, c := range b[2 : 2+n] {
+			size = size<<8 | int(c)
+		}
+
+		offset += n
+	}
+
+	if len(b) < offset+size {
+		return element{}, nil, errTruncated
+	}
+
+	return element{tag: tag, content: b[offset : offset+size]}, b[offset+size:], nil
+}
+
+// children decodes all elements of a sequence or PDU.
+func (e element) children() ([]element, error) {
+	var elements []element
+
+	for rest := e.content; len(rest) > 0; {
+		var child element
+		var err error
+
+		child, rest, err = readElement(rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, child)
+	}
+
+	return elements, nil
+}
+
+func (e element) int() (int64, error) {
+	if e.tag != tagInteger || len(e.content) == 0 || len(e.content) > 8 {
+		return 0, fmt.Errorf("expected an integer, got tag %#x", e.tag)
+	}
+
+	// Sign extend the first byte
+	v := int64(int8(e.content[0]))
+
+	for _, c := range e.content[1:] {
+		v = v<<8 | int64(c)
+	}
+
+	return v, nil
+}
+
+func (e element) oid() ([]uint32, error) {
+	if e.tag != tagOid || len(e.content) == 0 {
+		return nil, fmt.Errorf("expected an OID, got tag %#x", e.tag)
+	}
+
+	first := uint32(e.content[0])
+	oid := []uint32{min(first/40, 2), first - min(first/40, 2)*40}
+
+	var n uint32
+
+	for _, c := range e.content[1:] {
+		n = n<<7 | uint32(c&0x7f)
+
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		}
+	}
+
+	return oid, nil
+}
+
+// ParseOid reads an OID in dotted notation, with or without leading dot.
+func ParseOid(s string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+
+	oid := make([]uint32, len(parts))
+
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+
+		oid[i] = uint32(n)
+	}
+
+	return oid, nil
+}
+
+func formatOid(oid []uint32) string {
+	parts := make([]string, len(oid))
+
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// hasPrefix tells whether oid lies below prefix in the tree.
+func hasPrefix(oid []uint32, prefix []uint32) bool {
+	if len(oid) <= len(prefix) {
+		return false
+	}
+
+	for i := range prefix {
+		if oid[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,133 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"slices"
+	"testing"
+)
+
+func TestEncodeInt(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want string
+	}{
+		{0, "020100"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{256, "02020100"},
+		{65507, "020300ffe3"},
+		{-1, "0201ff"},
+		{-128, "020180"},
+		{-129, "0202ff7f"},
+	}
+
+	for _, test := range tests {
+		b := encodeInt(test.v)
+
+		if got := hex.EncodeToString(b); got != test.want {
+			t.Errorf("encodeInt(%d) = %s, expected %s", test.v, got, test.want)
+		}
+
+		e, rest, err := readElement(b)
+
+		if err != nil || len(rest) > 0 {
+			t.Fatalf("readElement(%x) failed: %v", b, err)
+		}
+
+		if v, err := e.int(); err != nil || v != test.v {
+			t.Errorf("decoded %x to %d, expected %d", b, v, test.v)
+		}
+	}
+}
+
+func TestEncodeOid(t *testing.T) {
+	tests := []struct {
+		oid  string
+		want string
+	}{
+		// sysDescr.0
+		{"1.3.6.1.2.1.1.1.0", "06082b06010201010100"},
+		// Arcs of several bytes
+		{"1.3.6.1.4.1.2680.1", "06082b06010401947801"},
+		{"1.3.6.1.4294967295", "06082b06018fffffff7f"},
+	}
+
+	for _, test := range tests {
+		oid, err := ParseOid(test.oid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := encodeOid(oid)
+
+		if got := hex.EncodeToString(b); got != test.want {
+			t.Errorf("encodeOid(%s) = %s, expected %s", test.oid, got, test.want)
+		}
+
+		e, _, err := readElement(b)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := e.oid()
+
+		if err != nil || !slices.Equal(decoded, oid) {
+			t.Errorf("decoded %x to %s, expected %s", b, formatOid(decoded), test.oid)
+		}
+	}
+}
+
+func TestParseOid(t *testing.T) {
+	oid, err := ParseOid(".1.3.6.1.2.1.4.20.1.1")
+
+	if err != nil || formatOid(oid) != "1.3.6.1.2.1.4.20.1.1" {
+		t.Errorf("ParseOid returned %s, %v", formatOid(oid), err)
+	}
+
+	for _, invalid := range []string{"", "1", "1.3.x", "1.3.4294967296"} {
+		if _, err := ParseOid(invalid); err == nil {
+			t.Errorf("expected ParseOid(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestTlvLength(t *testing.T) {
+	for _, size := range []int{0, 0x7f, 0x80, 0xff, 0x100, 0x1234} {
+		content := bytes.Repeat([]byte{0xaa}, size)
+		b := tlv(tagOctetString, content)
+
+		var header string
+
+		switch {
+		case size < 0x80:
+			header = hex.EncodeToString([]byte{tagOctetString, byte(size)})
+		case size < 0x100:
+			header = hex.EncodeToString([]byte{tagOctetString, 0x81, byte(size)})
+		default:
+			header = hex.EncodeToString([]byte{tagOctetString, 0x82, byte(size >> 8), byte(size)})
+		}
+
+		if got := hex.EncodeToString(b[:len(b)-size]); got != header {
+			t.Errorf("header of %d bytes is %s, expected %s", size, got, header)
+		}
+
+		e, rest, err := readElement(append(b, 0x05, 0x00))
+
+		if err != nil || !bytes.Equal(e.content, content) || !bytes.Equal(rest, []byte{0x05, 0x00}) {
+			t.Errorf("failed to read back %d bytes: %v", size, err)
+		}
+	}
+}
+
+func TestReadElementTruncated(t *testing.T) {
+	for _, b := range []string{"", "02", "0202ff", "0481", "048201", "0482010000"} {
+		raw, _ := hex.DecodeString(b)
+
+		if _, _, err := readElement(raw); err == nil {
+			t.Errorf("expected readElement(%s) to fail", b)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxWalk stops walking tables that never end, i.e. of broken agents.
+const maxWalk = 10000
+
+// engine is the discovered state of the agent for v3.
+type engine struct {
+	id    []byte
+	boots int64
+	time  int64
+	at    time.Time
+
+	authKey []byte
+	privKey []byte
+}
+
+// now returns the engine time of the agent, which counts seconds since its
+// last boot.
+func (e *engine) now() int64 {
+	return e.time + int64(time.Since(e.at)/time.Second)
+}
+
+// Client gets values from an SNMP agent with v2c or v3.
+type Client struct {
+	// Address of the agent as host:port
+	Address string
+	// Version is 2c or 3
+	Version string
+	// Community authenticates with v2c
+	Community string
+	// Username authenticates with v3, with auth only if AuthPassword is set
+	// and encrypted only if PrivPassword is set as well
+	Username     string
+	AuthProtocol string
+	AuthPassword string
+	PrivProtocol string
+	PrivPassword string
+	Timeout      time.Duration
+	Retries      int
+
+	mu     sync.Mutex
+	engine *engine
+}
+
+func NewClient(address string) *Client {
+	return &Client{
+		Address:      address,
+		Version:      "2c",
+		Community:    "public",
+		AuthProtocol: "sha",
+		PrivProtocol: "aes",
+		Timeout:      5 * time.Second,
+		Retries:      2,
+	}
+}
+
+// Get returns the value of the OID.
+func (c *Client) Get(oid []uint32) (element, error) {
+	p, err := c.request(tagGetRequest, oid)
+
+	if err != nil {
+		return element{}, err
+	}
+
+	v := p.variables[0]
+
+	switch v.value.tag {
+	case tagNoSuchObject, tagNoSuchInstance, tagEndOfMibView:
+		return element{}, fmt.Errorf("agent has no value for %s", formatOid(oid))
+	}
+
+	return v.value, nil
+}
+
+// Walk calls f for every value below the OID in the tree.
+func (c *Client) Walk(oid []uint32, f func(oid []uint32, value element)) error {
+	next := oid
+
+	for i := 0; i < maxWalk; i++ {
+		p, err := c.request(tagGetNextRequest, next)
+
+		if err != nil {
+			return err
+		}
+
+		v := p.variables[0]
+
+		if v.value.tag == tagEndOfMibView || !hasPrefix(v.oid, oid) {
+			return nil
+		}
+
+		f(v.oid, v.value)
+		next = v.oid
+	}
+
+	return fmt.Errorf("walking %s doesn't end", formatOid(oid))
+}
+
+// request sends a request for a single OID and returns the response.
+func (c *Client) request(tag byte, oid []uint32) (pdu, error) {
+	request := pdu{
+		tag:       tag,
+		requestId: int64(rand.Int32()),
+		variables: []variable{{oid: oid, value: element{tag: tagNull}}},
+	}
+
+	var response pdu
+	var err error
+
+	if c.Version == "3" {
+		response, err = c.requestV3(request)
+	} else {
+		response, err = c.requestV2c(request)
+	}
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	if response.errorStatus != 0 {
+		return pdu{}, fmt.Errorf("agent responded with error status %d for %s", response.errorStatus, formatOid(oid))
+	}
+
+	if len(response.variables) != 1 {
+		return pdu{}, errors.New("agent responded with an unexpected number of values")
+	}
+
+	return response, nil
+}
+
+func (c *Client) requestV2c(request pdu) (pdu, error) {
+	var response pdu
+
+	err := c.roundTrip(encodeCommunity(c.Community, request), func(b []byte) bool {
+		_, p, err := decodeCommunity(b)
+
+		if err != nil || p.tag != tagResponse || p.requestId != request.requestId {
+			return false
+		}
+
+		response = p
+		return true
+	})
+
+	return response, err
+}
+
+func (c *Client) requestV3(request pdu) (pdu, error) {
+	e, err := c.discover(false)
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	response, err := c.exchangeV3(e, request)
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	// The agent rebooted or its clock drifted, resynchronize once
+	if response.tag == tagReport && isNotInTimeWindow(response) {
+		e, err = c.discover(true)
+
+		if err != nil {
+			return pdu{}, err
+		}
+
+		response, err = c.exchangeV3(e, request)
+
+		if err != nil {
+			return pdu{}, err
+		}
+	}
+
+	if response.tag == tagReport {
+		return pdu{}, reportError(response)
+	}
+
+	return response, nil
+}
+
+// discover learns the engine ID, boots and time of the agent, which a v3
+// request has to carry, and localizes the keys for it.
+func (c *Client) discover(force bool) (*engine, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.engine != nil && !force {
+		return c.engine, nil
+	}
+
+	request := v3Message{
+		id:     int64(rand.Int32()),
+		flags:  flagReportable,
+		scoped: encodeScoped(nil, pdu{tag: tagGetRequest, requestId: int64(rand.Int32())}),
+	}
+
+	var usm usmParams
+
+	err := c.roundTrip(request.encode(), func(b []byte) bool {
+		m, err := decodeV3(b)
+
+		if err != nil || m.id != request.id || len(m.usm.engineId) == 0 {
+			return false
+		}
+
+		usm = m.usm
+		return true
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("discovering the engine: %w", err)
+	}
+
+	e := &engine{
+		id:    bytes.Clone(usm.engineId),
+		boots: usm.boots,
+		time:  usm.time,
+		at:    time.Now(),
+	}
+
+	if c.AuthPassword != "" {
+		p, ok := authProtocols[c.AuthProtocol]
+
+		if !ok {
+			return nil, fmt.Errorf("unsupported auth protocol %q", c.AuthProtocol)
+		}
+
+		e.authKey = localizeKey(p.hash, c.AuthPassword, e.id)
+
+		if c.PrivPassword != "" {
+			if c.PrivProtocol != "aes" {
+				return nil, fmt.Errorf("unsupported privacy protocol %q", c.PrivProtocol)
+			}
+
+			e.privKey = localizeKey(p.hash, c.PrivPassword, e.id)
+		}
+	}
+
+	c.engine = e
+
+	return e, nil
+}
+
+// exchangeV3 sends the request with the security level of the credentials
+// and returns the verified response.
+func (c *Client) exchangeV3(e *engine, request pdu) (pdu, error) {
+	m := v3Message{
+		id:    int64(rand.Int32()),
+		flags: flagReportable,
+		usm: usmParams{
+			engineId: e.id,
+			boots:    e.boots,
+			time:     e.now(),
+			user:     []byte(c.Username),
+		},
+		scoped: encodeScoped(e.id, request),
+	}
+
+	if e.authKey != nil {
+		m.flags |= flagAuth
+		m.usm.auth = make([]byte, authProtocols[c.AuthProtocol].length)
+	}
+
+	if e.privKey != nil {
+		salt, err := newSalt()
+
+		if err != nil {
+			return pdu{}, err
+		}
+
+		m.scoped, err = cryptAes(e.privKey, m.usm.boots, m.usm.time, salt, m.scoped, false)
+
+		if err != nil {
+			return pdu{}, err
+		}
+
+		m.flags |= flagPriv
+		m.usm.priv = salt
+	}
+
+	packet := m.encode()
+
+	if e.authKey != nil {
+		m.usm.auth = authenticate(c.AuthProtocol, e.authKey, packet)
+		packet = m.encode()
+	}
+
+	var response pdu
+
+	err := c.roundTrip(packet, func(b []byte) bool {
+		p, err := c.decodeV3Response(e, b)
+
+		if err != nil || p.tag != tagReport && p.requestId != request.requestId {
+			return false
+		}
+
+		response = p
+		return true
+	})
+
+	return response, err
+}
+
+// decodeV3Response verifies and decrypts a response. Reports may come without
+// auth, i.e. if the user is unknown.
+func (c *Client) decodeV3Response(e *engine, b []byte) (pdu, error) {
+	m, err := decodeV3(b)
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	if m.flags&flagAuth != 0 {
+		if e.authKey == nil {
+			return pdu{}, errors.New("unexpected authenticated message")
+		}
+
+		zeroed := bytes.Clone(b)
+		clear(zeroed[m.authOffset : m.authOffset+len(m.usm.auth)])
+
+		if !bytes.Equal(authenticate(c.AuthProtocol, e.authKey, zeroed), m.usm.auth) {
+			return pdu{}, errors.New("wrong digest of the response")
+		}
+	}
+
+	scoped := m.scoped
+
+	if m.flags&flagPriv != 0 {
+		if e.privKey == nil {
+			return pdu{}, errors.New("unexpected encrypted message")
+		}
+
+		scoped, err = cryptAes(e.privKey, m.usm.boots, m.usm.time, m.usm.priv, scoped, true)
+
+		if err != nil {
+			return pdu{}, err
+		}
+	}
+
+	p, err := decodeScoped(scoped)
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	// Only reports may lower the security level
+	if p.tag != tagReport && e.authKey != nil && m.flags&flagAuth == 0 {
+		return pdu{}, errors.New("unauthenticated response")
+	}
+
+	return p, nil
+}
+
+// roundTrip sends the packet until accept takes a response or the retries are
+// exhausted.
+func (c *Client) roundTrip(packet []byte, accept func([]byte) bool) error {
+	conn, err := net.Dial("udp", c.Address)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	buf := make([]byte, maxMessageSize)
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		_, err := conn.Write(packet)
+
+		if err != nil {
+			return err
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(c.Timeout))
+
+		for {
+			n, err := conn.Read(buf)
+
+			var netErr net.Error
+
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if accept(buf[:n:n]) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("agent %s didn't respond", c.Address)
+}
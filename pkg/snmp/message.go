@@ -0,0 +1,240 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	version2c = 1
+	version3  = 3
+
+	// maxMessageSize is announced to the agent, the largest UDP payload
+	maxMessageSize = 65507
+)
+
+// variable is a variable binding of a PDU.
+type variable struct {
+	oid   []uint32
+	value element
+}
+
+// pdu is a request or response, see RFC 3416.
+type pdu struct {
+	tag         byte
+	requestId   int64
+	errorStatus int64
+	errorIndex  int64
+	variables   []variable
+}
+
+func (p pdu) encode() []byte {
+	var bindings [][]byte
+
+	for _, v := range p.variables {
+		bindings = append(bindings, tlv(tagSequence, encodeOid(v.oid), tlv(v.value.tag, v.value.content)))
+	}
+
+	return tlv(p.tag,
+		encodeInt(p.requestId),
+		encodeInt(p.errorStatus),
+		encodeInt(p.errorIndex),
+		tlv(tagSequence, bindings...),
+	)
+}
+
+func decodePdu(e element) (pdu, error) {
+	fields, err := e.children()
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	if len(fields) != 4 || fields[3].tag != tagSequence {
+		return pdu{}, errors.New("invalid PDU")
+	}
+
+	p := pdu{tag: e.tag}
+
+	for i, v := range []*int64{&p.requestId, &p.errorStatus, &p.errorIndex} {
+		*v, err = fields[i].int()
+
+		if err != nil {
+			return pdu{}, err
+		}
+	}
+
+	bindings, err := fields[3].children()
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	for _, binding := range bindings {
+		parts, err := binding.children()
+
+		if err != nil {
+			return pdu{}, err
+		}
+
+		if len(parts) != 2 {
+			return pdu{}, errors.New("invalid variable binding")
+		}
+
+		oid, err := parts[0].oid()
+
+		if err != nil {
+			return pdu{}, err
+		}
+
+		p.variables = append(p.variables, variable{oid: oid, value: parts[1]})
+	}
+
+	return p, nil
+}
+
+// encodeCommunity encodes a v2c message.
+func encodeCommunity(community string, p pdu) []byte {
+	return tlv(tagSequence, encodeInt(version2c), encodeString([]byte(community)), p.encode())
+}
+
+// decodeCommunity decodes a v2c message and returns its community.
+func decodeCommunity(b []byte) (string, pdu, error) {
+	e, _, err := readElement(b)
+
+	if err != nil {
+		return "", pdu{}, err
+	}
+
+	fields, err := e.children()
+
+	if err != nil {
+		return "", pdu{}, err
+	}
+
+	if len(fields) != 3 {
+		return "", pdu{}, errors.New("invalid message")
+	}
+
+	if version, err := fields[0].int(); err != nil || version != version2c {
+		return "", pdu{}, errors.New("expected a v2c message")
+	}
+
+	p, err := decodePdu(fields[2])
+
+	return string(fields[1].content), p, err
+}
+
+// v3Message is a message of the user-based security model, see RFC 3412.
+type v3Message struct {
+	id    int64
+	flags byte
+	usm   usmParams
+	// scoped is the encoded scoped PDU, or its cipher text with privacy
+	scoped []byte
+	// authOffset is the position of the auth parameters in the decoded message
+	authOffset int
+}
+
+func (m v3Message) encode() []byte {
+	header := tlv(tagSequence,
+		encodeInt(m.id),
+		encodeInt(maxMessageSize),
+		encodeString([]byte{m.flags}),
+		encodeInt(securityModelUsm),
+	)
+
+	scoped := m.scoped
+
+	if m.flags&flagPriv != 0 {
+		scoped = encodeString(scoped)
+	}
+
+	return tlv(tagSequence, encodeInt(version3), header, encodeString(m.usm.encode()), scoped)
+}
+
+func decodeV3(b []byte) (v3Message, error) {
+	e, _, err := readElement(b)
+
+	if err != nil {
+		return v3Message{}, err
+	}
+
+	fields, err := e.children()
+
+	if err != nil {
+		return v3Message{}, err
+	}
+
+	if len(fields) != 4 {
+		return v3Message{}, errors.New("invalid message")
+	}
+
+	if version, err := fields[0].int(); err != nil || version != version3 {
+		return v3Message{}, errors.New("expected a v3 message")
+	}
+
+	header, err := fields[1].children()
+
+	if err != nil {
+		return v3Message{}, err
+	}
+
+	if len(header) != 4 || len(header[2].content) != 1 {
+		return v3Message{}, errors.New("invalid message header")
+	}
+
+	id, err := header[0].int()
+
+	if err != nil {
+		return v3Message{}, err
+	}
+
+	usm, err := decodeUsm(fields[2].content)
+
+	if err != nil {
+		return v3Message{}, err
+	}
+
+	m := v3Message{
+		id:    id,
+		flags: header[2].content[0],
+		usm:   usm,
+		// The content shares the memory of b, so the distance of their ends
+		// tells the position
+		authOffset: cap(b) - cap(usm.auth),
+	}
+
+	if m.flags&flagPriv != 0 {
+		m.scoped = fields[3].content
+	} else {
+		m.scoped = tlv(fields[3].tag, fields[3].content)
+	}
+
+	return m, nil
+}
+
+// encodeScoped wraps the PDU into a scoped PDU of the default context.
+func encodeScoped(engineId []byte, p pdu) []byte {
+	return tlv(tagSequence, encodeString(engineId), encodeString(nil), p.encode())
+}
+
+func decodeScoped(b []byte) (pdu, error) {
+	e, _, err := readElement(b)
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	fields, err := e.children()
+
+	if err != nil {
+		return pdu{}, err
+	}
+
+	if len(fields) != 3 {
+		return pdu{}, fmt.Errorf("invalid scoped PDU")
+	}
+
+	return decodePdu(fields[2])
+}
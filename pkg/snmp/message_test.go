@@ -0,0 +1,90 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"slices"
+	"testing"
+)
+
+var sysDescr = []uint32{1, 3, 6, 1, 2, 1, 1, 1, 0}
+
+func TestEncodeCommunity(t *testing.T) {
+	request := pdu{
+		tag:       tagGetRequest,
+		requestId: 1,
+		variables: []variable{{oid: sysDescr, value: element{tag: tagNull}}},
+	}
+
+	b := encodeCommunity("public", request)
+	want := "302602010104067075626c6963a019020101020100020100300e300c06082b060102010101000500"
+
+	if got := hex.EncodeToString(b); got != want {
+		t.Errorf("message is %s, expected %s", got, want)
+	}
+
+	community, p, err := decodeCommunity(b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if community != "public" || p.tag != tagGetRequest || p.requestId != 1 || len(p.variables) != 1 ||
+		!slices.Equal(p.variables[0].oid, sysDescr) || p.variables[0].value.tag != tagNull {
+		t.Errorf("decoded %q, %+v", community, p)
+	}
+
+	if _, _, err := decodeCommunity(b[:len(b)-1]); err == nil {
+		t.Error("expected a truncated message to fail")
+	}
+}
+
+func TestV3Message(t *testing.T) {
+	response := pdu{
+		tag:       tagResponse,
+		requestId: 42,
+		variables: []variable{{oid: sysDescr, value: element{tag: tagOctetString, content: []byte("router")}}},
+	}
+
+	engineId := []byte{0x80, 0x00, 0x1f, 0x88, 0x04}
+
+	for _, flags := range []byte{flagReportable, flagAuth, flagAuth | flagPriv} {
+		scoped := encodeScoped(engineId, response)
+
+		m := v3Message{
+			id:    7,
+			flags: flags,
+			usm: usmParams{
+				engineId: engineId,
+				boots:    1,
+				time:     2,
+				user:     []byte("monitor"),
+				auth:     []byte("0123456789ab"),
+				priv:     make([]byte, 8),
+			},
+			scoped: scoped,
+		}
+
+		b := m.encode()
+		decoded, err := decodeV3(b)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.id != m.id || decoded.flags != flags || !bytes.Equal(decoded.scoped, scoped) {
+			t.Errorf("flags %x: decoded %+v, expected %+v", flags, decoded, m)
+		}
+
+		// The client fills in the auth parameters at this offset
+		if !bytes.Equal(b[decoded.authOffset:decoded.authOffset+12], decoded.usm.auth) {
+			t.Errorf("flags %x: auth parameters not at offset %d", flags, decoded.authOffset)
+		}
+
+		p, err := decodeScoped(scoped)
+
+		if err != nil || p.requestId != 42 || string(p.variables[0].value.content) != "router" {
+			t.Errorf("flags %x: decoded scoped PDU %+v, %v", flags, p, err)
+		}
+	}
+}
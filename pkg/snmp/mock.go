@@ -0,0 +1,216 @@
+//go:build integration
+
+package snmp
+
+import (
+	"bytes"
+	"net"
+	"slices"
+	"time"
+)
+
+// The mock has its IPs on the interface MockInterface and serves them under
+// the OIDs as well. With v3 it only accepts MockUsername with SHA and AES.
+const (
+	MockInterface    = 2
+	MockIpv4Oid      = "1.3.6.1.4.1.99999.1.1.0"
+	MockIpv6Oid      = "1.3.6.1.4.1.99999.1.2.0"
+	MockPrefixOid    = "1.3.6.1.4.1.99999.1.3.0"
+	MockUsername     = "integration"
+	MockAuthPassword = "integration-auth"
+	MockPrivPassword = "integration-priv"
+)
+
+var mockEngineId = []byte("\x80\x00\x1f\x88\x04integration")
+
+// mockAgent answers get and get next requests from a sorted list of values.
+type mockAgent struct {
+	variables []variable
+	started   time.Time
+	authKey   []byte
+	privKey   []byte
+}
+
+// NewMock starts a fake SNMP agent over IPv4 answering v2c with any community
+// and v3, it has to be closed after use.
+func NewMock(ipv4 net.IP, ipv6 net.IP, prefix *net.IPNet) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	lan := net.IPv4(192, 168, 100, 1).To4()
+	ones, _ := prefix.Mask.Size()
+
+	// The row of the prefix in the ipAddressPrefixTable
+	prefixRow := append([]uint32{1, 3, 6, 1, 2, 1, 4, 32, 1, 5, 3, 2, 16}, arcs(prefix.IP.To16())...)
+	prefixRow = append(prefixRow, uint32(ones))
+
+	a := &mockAgent{
+		started: time.Now(),
+		authKey: localizeKey(authProtocols["sha"].hash, MockAuthPassword, mockEngineId),
+		privKey: localizeKey(authProtocols["sha"].hash, MockPrivPassword, mockEngineId),
+	}
+
+	a.add(append(append(ipAddressIfIndex, 1, 4), arcs(ipv4.To4())...), encodeInt(MockInterface))
+	a.add(append(append(ipAddressIfIndex, 1, 4), arcs(lan)...), encodeInt(1))
+	a.add(append(append(ipAddressIfIndex, 2, 16), arcs(ipv6.To16())...), encodeInt(MockInterface))
+	a.add(mustParseOid(MockIpv4Oid), tlv(tagIpAddress, ipv4.To4()))
+	a.add(mustParseOid(MockIpv6Oid), encodeString([]byte(ipv6.String())))
+	a.add(mustParseOid(MockPrefixOid), encodeOid(prefixRow))
+
+	slices.SortFunc(a.variables, func(x, y variable) int {
+		return slices.Compare(x.oid, y.oid)
+	})
+
+	go func() {
+		buf := make([]byte, maxMessageSize)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+
+			if err != nil {
+				return
+			}
+
+			if response := a.handle(buf[:n:n]); response != nil {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return conn, nil
+}
+
+func (a *mockAgent) add(oid []uint32, encoded []byte) {
+	value, _, _ := readElement(encoded)
+	a.variables = append(a.variables, variable{oid: slices.Clone(oid), value: value})
+}
+
+func (a *mockAgent) handle(b []byte) []byte {
+	if community, request, err := decodeCommunity(b); err == nil {
+		return encodeCommunity(community, a.respond(request))
+	}
+
+	m, err := decodeV3(b)
+
+	if err != nil {
+		return nil
+	}
+
+	engineTime := int64(time.Since(a.started) / time.Second)
+
+	reply := v3Message{
+		id:  m.id,
+		usm: usmParams{engineId: mockEngineId, boots: 1, time: engineTime, user: m.usm.user},
+	}
+
+	// Discovery, report the engine
+	if len(m.usm.engineId) == 0 {
+		reply.scoped = encodeScoped(mockEngineId, a.report(4))
+		return reply.encode()
+	}
+
+	if string(m.usm.user) != MockUsername {
+		reply.scoped = encodeScoped(mockEngineId, a.report(3))
+		return reply.encode()
+	}
+
+	if m.flags&flagAuth != 0 {
+		zeroed := bytes.Clone(b)
+		clear(zeroed[m.authOffset : m.authOffset+len(m.usm.auth)])
+
+		if !bytes.Equal(authenticate("sha", a.authKey, zeroed), m.usm.auth) {
+			reply.scoped = encodeScoped(mockEngineId, a.report(5))
+			return reply.encode()
+		}
+	}
+
+	scoped := m.scoped
+
+	if m.flags&flagPriv != 0 {
+		scoped, err = cryptAes(a.privKey, m.usm.boots, m.usm.time, m.usm.priv, scoped, true)
+
+		if err != nil {
+			return nil
+		}
+	}
+
+	request, err := decodeScoped(scoped)
+
+	if err != nil {
+		reply.scoped = encodeScoped(mockEngineId, a.report(6))
+		return reply.encode()
+	}
+
+	reply.flags = m.flags &^ flagReportable
+	reply.scoped = encodeScoped(mockEngineId, a.respond(request))
+
+	if reply.flags&flagPriv != 0 {
+		reply.usm.priv, _ = newSalt()
+		reply.scoped, _ = cryptAes(a.privKey, reply.usm.boots, reply.usm.time, reply.usm.priv, reply.scoped, false)
+	}
+
+	if reply.flags&flagAuth != 0 {
+		reply.usm.auth = make([]byte, authProtocols["sha"].length)
+		reply.usm.auth = authenticate("sha", a.authKey, reply.encode())
+	}
+
+	return reply.encode()
+}
+
+// report tells about a failed request, see usmStatsNames.
+func (a *mockAgent) report(stat uint32) pdu {
+	counter, _, _ := readElement(tlv(tagCounter32, []byte{1}))
+
+	return pdu{
+		tag:       tagReport,
+		variables: []variable{{oid: append(slices.Clone(usmStats), stat, 0), value: counter}},
+	}
+}
+
+func (a *mockAgent) respond(request pdu) pdu {
+	response := pdu{tag: tagResponse, requestId: request.requestId}
+
+	for _, v := range request.variables {
+		i, found := slices.BinarySearchFunc(a.variables, v.oid, func(x variable, oid []uint32) int {
+			return slices.Compare(x.oid, oid)
+		})
+
+		switch {
+		case request.tag == tagGetNextRequest && found && i+1 < len(a.variables):
+			response.variables = append(response.variables, a.variables[i+1])
+		case request.tag == tagGetNextRequest && !found && i < len(a.variables):
+			response.variables = append(response.variables, a.variables[i])
+		case request.tag == tagGetRequest && found:
+			response.variables = append(response.variables, a.variables[i])
+		case request.tag == tagGetRequest:
+			response.variables = append(response.variables, variable{oid: v.oid, value: element{tag: tagNoSuchObject}})
+		default:
+			response.variables = append(response.variables, variable{oid: v.oid, value: element{tag: tagEndOfMibView}})
+		}
+	}
+
+	return response
+}
+
+func arcs(b []byte) []uint32 {
+	var a []uint32
+
+	for _, c := range b {
+		a = append(a, uint32(c))
+	}
+
+	return a
+}
+
+func mustParseOid(s string) []uint32 {
+	oid, err := ParseOid(s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return oid
+}
@@ -0,0 +1,240 @@
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// ipAddressIfIndex of the IP-MIB, indexed by the address type, length
+	// and address, see RFC 4293
+	ipAddressIfIndex = []uint32{1, 3, 6, 1, 2, 1, 4, 34, 1, 3}
+	// ipAdEntIfIndex of the deprecated IPv4 only table, indexed by the address
+	ipAdEntIfIndex = []uint32{1, 3, 6, 1, 2, 1, 4, 20, 1, 2}
+)
+
+// Source polls the WAN IPs from business routers and cable modems, that only
+// report them through SNMP. The IPs are either read from the configured OIDs
+// or looked up by the index of the WAN interface in the IP-MIB.
+type Source struct {
+	Client *Client
+	// Ipv4Oid, Ipv6Oid and PrefixOid hold the address or prefix as IpAddress,
+	// octet string or text, the prefix may also point to the row of the
+	// ipAddressPrefixTable
+	Ipv4Oid   string
+	Ipv6Oid   string
+	PrefixOid string
+	// Interface is the ifIndex of the WAN interface, for the IPs without OID
+	Interface int
+}
+
+func NewSource(client *Client) *Source {
+	return &Source{
+		Client: client,
+	}
+}
+
+func (s *Source) GetWanIpv4() (net.IP, error) {
+	ip, err := s.address("IPv4", s.Ipv4Oid, func(ip net.IP) bool {
+		return ip.To4() != nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ip.To4(), nil
+}
+
+func (s *Source) GetwanIpv6() (net.IP, error) {
+	return s.address("IPv6", s.Ipv6Oid, func(ip net.IP) bool {
+		return ip.To4() == nil
+	})
+}
+
+func (s *Source) GetIpv6Prefix() (*net.IPNet, error) {
+	if s.PrefixOid == "" {
+		return nil, fmt.Errorf("IPv6 prefix without an OID: %w", errors.ErrUnsupported)
+	}
+
+	value, err := s.get(s.PrefixOid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, prefix, err := decodeAddress(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix == nil || prefix.IP.To4() != nil {
+		return nil, fmt.Errorf("value of %s is no IPv6 prefix", s.PrefixOid)
+	}
+
+	return prefix, nil
+}
+
+// address reads the address from the OID, or looks it up by the interface.
+func (s *Source) address(what string, oid string, matches func(net.IP) bool) (net.IP, error) {
+	if oid != "" {
+		value, err := s.get(oid)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, err := decodeAddress(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !matches(ip) {
+			return nil, fmt.Errorf("value of %s is no %s address", oid, what)
+		}
+
+		return ip, nil
+	}
+
+	if s.Interface == 0 {
+		return nil, fmt.Errorf("%s without an OID or interface: %w", what, errors.ErrUnsupported)
+	}
+
+	addresses, err := s.interfaceAddresses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range addresses {
+		if matches(ip) && ip.IsGlobalUnicast() && (ip.To4() != nil || !ip.IsPrivate()) {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %d has no global %s address", s.Interface, what)
+}
+
+// interfaceAddresses walks the IP-MIB for the addresses of the interface,
+// falling back to the IPv4 only table of old agents.
+func (s *Source) interfaceAddresses() ([]net.IP, error) {
+	var addresses []net.IP
+
+	err := s.Client.Walk(ipAddressIfIndex, func(oid []uint32, value element) {
+		index, err := value.int()
+
+		if err != nil || index != int64(s.Interface) {
+			return
+		}
+
+		// The index is the type, the length and the bytes of the address
+		suffix := oid[len(ipAddressIfIndex):]
+
+		if len(suffix) < 2 || int(suffix[1]) != len(suffix)-2 {
+			return
+		}
+
+		if ip := toIp(suffix[2:]); ip != nil {
+			addresses = append(addresses, ip)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+
+	err = s.Client.Walk(ipAdEntIfIndex, func(oid []uint32, value element) {
+		index, err := value.int()
+
+		if err == nil && index == int64(s.Interface) {
+			if ip := toIp(oid[len(ipAdEntIfIndex):]); ip != nil {
+				addresses = append(addresses, ip)
+			}
+		}
+	})
+
+	return addresses, err
+}
+
+func (s *Source) get(oid string) (element, error) {
+	parsed, err := ParseOid(oid)
+
+	if err != nil {
+		return element{}, err
+	}
+
+	return s.Client.Get(parsed)
+}
+
+// decodeAddress reads an address or prefix from an IpAddress, an octet
+// string holding text or the raw bytes, or the OID of a row of the
+// ipAddressPrefixTable.
+func decodeAddress(value element) (net.IP, *net.IPNet, error) {
+	switch value.tag {
+	case tagIpAddress:
+		if len(value.content) == net.IPv4len {
+			return net.IP(bytes.Clone(value.content)), nil, nil
+		}
+	case tagOctetString:
+		text := strings.TrimSpace(strings.TrimRight(string(value.content), "\x00"))
+
+		if ip, prefix, err := net.ParseCIDR(text); err == nil {
+			return ip, prefix, nil
+		}
+
+		if ip := net.ParseIP(text); ip != nil {
+			return ip, nil, nil
+		}
+
+		if len(value.content) == net.IPv4len || len(value.content) == net.IPv6len {
+			return net.IP(bytes.Clone(value.content)), nil, nil
+		}
+	case tagOid:
+		oid, err := value.oid()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The row ends with the length and bytes of the prefix followed by
+		// the prefix length
+		if len(oid) > net.IPv6len+2 {
+			bits := int(oid[len(oid)-1])
+			ip := toIp(oid[len(oid)-1-net.IPv6len : len(oid)-1])
+
+			if ip != nil && oid[len(oid)-2-net.IPv6len] == net.IPv6len && bits <= 128 {
+				return ip, &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, 128)), Mask: net.CIDRMask(bits, 128)}, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("value with tag %#x is no address", value.tag)
+}
+
+// toIp converts the arcs of an index to the address they encode.
+func toIp(arcs []uint32) net.IP {
+	if len(arcs) != net.IPv4len && len(arcs) != net.IPv6len {
+		return nil
+	}
+
+	ip := make(net.IP, len(arcs))
+
+	for i, arc := range arcs {
+		if arc > 0xff {
+			return nil
+		}
+
+		ip[i] = byte(arc)
+	}
+
+	return ip
+}
@@ -0,0 +1,227 @@
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// Flags of a v3 message telling its security level.
+const (
+	flagAuth       byte = 0x01
+	flagPriv       byte = 0x02
+	flagReportable byte = 0x04
+)
+
+// securityModelUsm is the user-based security model of RFC 3414.
+const securityModelUsm = 3
+
+// usmStats are the reports of the agent, the last but one arc tells which
+// one, see RFC 3414 section 5.
+var usmStats = []uint32{1, 3, 6, 1, 6, 3, 15, 1, 1}
+
+var usmStatsNames = map[uint32]string{
+	1: "unsupported security level",
+	2: "not in time window",
+	3: "unknown user name",
+	4: "unknown engine ID",
+	5: "wrong digest, check the auth password",
+	6: "decryption error, check the privacy password",
+}
+
+// authProtocols maps the names of the supported auth protocols to their hash
+// and the length of the truncated HMAC.
+var authProtocols = map[string]struct {
+	hash   func() hash.Hash
+	length int
+}{
+	"md5":    {md5.New, 12},
+	"sha":    {sha1.New, 12},
+	"sha256": {sha256.New, 24},
+}
+
+// AuthProtocols lists the names of the supported auth protocols.
+var AuthProtocols = []string{"md5", "sha", "sha256"}
+
+// PrivProtocols lists the names of the supported privacy protocols, DES is
+// broken and not supported.
+var PrivProtocols = []string{"aes"}
+
+// usmParams are the security parameters of a v3 message.
+type usmParams struct {
+	engineId []byte
+	boots    int64
+	time     int64
+	user     []byte
+	auth     []byte
+	priv     []byte
+}
+
+func (u usmParams) encode() []byte {
+	return tlv(tagSequence,
+		encodeString(u.engineId),
+		encodeInt(u.boots),
+		encodeInt(u.time),
+		encodeString(u.user),
+		encodeString(u.auth),
+		encodeString(u.priv),
+	)
+}
+
+// decodeUsm reads the security parameters, the auth parameters share the
+// memory of b.
+func decodeUsm(b []byte) (usmParams, error) {
+	e, _, err := readElement(b)
+
+	if err != nil {
+		return usmParams{}, err
+	}
+
+	fields, err := e.children()
+
+	if err != nil {
+		return usmParams{}, err
+	}
+
+	if len(fields) != 6 {
+		return usmParams{}, errors.New("invalid security parameters")
+	}
+
+	boots, err := fields[1].int()
+
+	if err != nil {
+		return usmParams{}, err
+	}
+
+	engineTime, err := fields[2].int()
+
+	if err != nil {
+		return usmParams{}, err
+	}
+
+	return usmParams{
+		engineId: fields[0].content,
+		boots:    boots,
+		time:     engineTime,
+		user:     fields[3].content,
+		auth:     fields[4].content,
+		priv:     fields[5].content,
+	}, nil
+}
+
+// localizeKey derives the key of the password for the engine, see RFC 3414
+// appendix A.2.
+func localizeKey(h func() hash.Hash, password string, engineId []byte) []byte {
+	digest := h()
+	chunk := make([]byte, 64)
+
+	for i := 0; i < 1048576; i += len(chunk) {
+		for j := range chunk {
+			chunk[j] = password[(i+j)%len(password)]
+		}
+
+		digest.Write(chunk)
+	}
+
+	key := digest.Sum(nil)
+
+	digest = h()
+	digest.Write(key)
+	digest.Write(engineId)
+	digest.Write(key)
+
+	return digest.Sum(nil)
+}
+
+// authenticate returns the truncated HMAC of the message, which has to hold
+// zeros in place of the auth parameters.
+func authenticate(protocol string, key []byte, message []byte) []byte {
+	p := authProtocols[protocol]
+	mac := hmac.New(p.hash, key)
+	mac.Write(message)
+
+	return mac.Sum(nil)[:p.length]
+}
+
+// newSalt returns the random privacy parameters of a message.
+func newSalt() ([]byte, error) {
+	salt := make([]byte, 8)
+	_, err := rand.Read(salt)
+
+	return salt, err
+}
+
+// cryptAes encrypts or decrypts the scoped PDU with AES-128 in CFB mode, the
+// IV consists of the engine boots and time followed by the salt, see RFC 3826.
+func cryptAes(key []byte, boots int64, engineTime int64, salt []byte, data []byte, decrypt bool) ([]byte, error) {
+	if len(key) < 16 || len(salt) != 8 {
+		return nil, errors.New("invalid AES key or salt")
+	}
+
+	block, err := aes.NewCipher(key[:16])
+
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[0:4], uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:8], uint32(engineTime))
+	copy(iv[8:], salt)
+
+	out := make([]byte, len(data))
+	stream := make([]byte, aes.BlockSize)
+
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Encrypt(stream, iv)
+		end := min(i+aes.BlockSize, len(data))
+
+		for j := i; j < end; j++ {
+			out[j] = data[j] ^ stream[j-i]
+		}
+
+		// The next IV is the cipher text of this block
+		if decrypt {
+			copy(iv, data[i:end])
+		} else {
+			copy(iv, out[i:end])
+		}
+	}
+
+	return out, nil
+}
+
+// reportError explains a report of the agent.
+func reportError(p pdu) error {
+	for _, v := range p.variables {
+		if hasPrefix(v.oid, usmStats) {
+			if name, ok := usmStatsNames[v.oid[len(usmStats)]]; ok {
+				return fmt.Errorf("agent reported %s", name)
+			}
+		}
+	}
+
+	if len(p.variables) > 0 {
+		return fmt.Errorf("agent reported %s", formatOid(p.variables[0].oid))
+	}
+
+	return errors.New("agent sent an empty report")
+}
+
+// isNotInTimeWindow tells whether the report asks to resynchronize the time.
+func isNotInTimeWindow(p pdu) bool {
+	for _, v := range p.variables {
+		if hasPrefix(v.oid, usmStats) && v.oid[len(usmStats)] == 2 {
+			return true
+		}
+	}
+
+	return false
+}
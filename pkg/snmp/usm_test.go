@@ -0,0 +1,118 @@
+package snmp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+// TestLocalizeKey checks the keys of RFC 3414 appendix A.3.
+func TestLocalizeKey(t *testing.T) {
+	engineId, _ := hex.DecodeString("000000000000000000000002")
+
+	tests := []struct {
+		name string
+		hash func() hash.Hash
+		want string
+	}{
+		{"md5", md5.New, "526f5eed9fcce26f8964c2930787d82b"},
+		{"sha", sha1.New, "6695febc9288e36282235fc7151f128497b38f3f"},
+		{"sha256", sha256.New, "8982e0e549e866db361a6b625d84cccc11162d453ee8ce3a6445c2d6776f0f8b"},
+	}
+
+	for _, test := range tests {
+		key := localizeKey(test.hash, "maplesyrup", engineId)
+
+		if got := hex.EncodeToString(key); got != test.want {
+			t.Errorf("%s: localized key is %s, expected %s", test.name, got, test.want)
+		}
+	}
+}
+
+// TestAuthenticate checks the truncated HMACs with the vectors of RFC 2202
+// and RFC 4231.
+func TestAuthenticate(t *testing.T) {
+	message := []byte("Hi There")
+
+	tests := []struct {
+		protocol string
+		key      []byte
+		want     string
+	}{
+		{"md5", bytes.Repeat([]byte{0x0b}, 16), "9294727a3638bb1c13f48ef8"},
+		{"sha", bytes.Repeat([]byte{0x0b}, 20), "b617318655057264e28bc0b6"},
+		{"sha256", bytes.Repeat([]byte{0x0b}, 20), "b0344c61d8db38535ca8afceaf0bf12b881dc200c9833da7"},
+	}
+
+	for _, test := range tests {
+		if got := hex.EncodeToString(authenticate(test.protocol, test.key, message)); got != test.want {
+			t.Errorf("%s: HMAC is %s, expected %s", test.protocol, got, test.want)
+		}
+	}
+}
+
+// TestCryptAes checks the cipher with the CFB128-AES128 vector of NIST
+// SP 800-38A F.3.13, the IV is made up of the boots, time and salt.
+func TestCryptAes(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	salt, _ := hex.DecodeString("08090a0b0c0d0e0f")
+	plain, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51")
+	want := "3b3fd92eb72dad20333449f8e83cfb4ac8a64537a0b3a93fcde3cdad9f1ce58b"
+
+	cipher, err := cryptAes(key, 0x00010203, 0x04050607, salt, plain, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := hex.EncodeToString(cipher); got != want {
+		t.Errorf("cipher text is %s, expected %s", got, want)
+	}
+
+	// Scoped PDUs are rarely a multiple of the block size
+	for _, size := range []int{0, 1, 16, 17, 31} {
+		decrypted, err := cryptAes(key, 7, 1234, salt, must(cryptAes(key, 7, 1234, salt, plain[:size], false)), true)
+
+		if err != nil || !bytes.Equal(decrypted, plain[:size]) {
+			t.Errorf("%d bytes don't decrypt to the plain text: %x, %v", size, decrypted, err)
+		}
+	}
+
+	if _, err := cryptAes(key[:8], 0, 0, salt, plain, false); err == nil {
+		t.Error("expected a short key to fail")
+	}
+}
+
+func TestUsmParams(t *testing.T) {
+	params := usmParams{
+		engineId: []byte{0x80, 0x00, 0x1f, 0x88, 0x04},
+		boots:    3,
+		time:     123456,
+		user:     []byte("monitor"),
+		auth:     make([]byte, 12),
+		priv:     []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+
+	decoded, err := decodeUsm(params.encode())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded.engineId, params.engineId) || decoded.boots != params.boots || decoded.time != params.time ||
+		!bytes.Equal(decoded.user, params.user) || !bytes.Equal(decoded.auth, params.auth) || !bytes.Equal(decoded.priv, params.priv) {
+		t.Errorf("decoded %+v, expected %+v", decoded, params)
+	}
+}
+
+func must(b []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
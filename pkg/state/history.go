@@ -0,0 +1,138 @@
+package state
+
+import (
+	"sync"
+)
+
+// entryOverhead approximates the memory used by an entry besides its strings.
+const entryOverhead = 96
+
+// HistoryStats describe the usage of a history.
+type HistoryStats struct {
+	Entries int `json:"entries"`
+	Bytes   int `json:"bytes"`
+	// Evicted counts the entries dropped to stay within the limits
+	Evicted uint64 `json:"evicted"`
+}
+
+// History is a ring buffer of the last update attempts, bounded by the number
+// of entries and their approximate size, so it stays small on small devices.
+// The oldest entries are evicted first, a nil history keeps nothing.
+type History struct {
+	MaxEntries int
+	MaxBytes   int
+
+	mu      sync.Mutex
+	ring    []Record
+	start   int
+	count   int
+	bytes   int
+	evicted uint64
+}
+
+func NewHistory(maxEntries int, maxBytes int) *History {
+	if maxEntries <= 0 {
+		return nil
+	}
+
+	return &History{
+		MaxEntries: maxEntries,
+		MaxBytes:   maxBytes,
+	}
+}
+
+// Add appends the record, evicting the oldest entries if a limit is reached.
+func (h *History) Add(r Record) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	size := recordSize(r)
+
+	// Entries larger than the whole budget are not kept at all
+	if h.MaxBytes > 0 && size > h.MaxBytes {
+		h.evicted++
+		return
+	}
+
+	for h.count >= h.MaxEntries || (h.MaxBytes > 0 && h.bytes+size > h.MaxBytes) {
+		h.bytes -= recordSize(h.ring[h.start])
+		h.ring[h.start] = Record{}
+		h.start = (h.start + 1) % len(h.ring)
+		h.count--
+		h.evicted++
+	}
+
+	// The ring grows up to MaxEntries, so large limits cost nothing until used
+	if h.count == len(h.ring) {
+		ring := make([]Record, min(max(2*h.count, 8), h.MaxEntries))
+		copy(ring, h.ordered())
+
+		h.ring = ring
+		h.start = 0
+	}
+
+	h.ring[(h.start+h.count)%len(h.ring)] = r
+
+	h.count++
+	h.bytes += size
+}
+
+// Entries returns the entries from the oldest to the newest.
+func (h *History) Entries() []Record {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.ordered()
+}
+
+func (h *History) ordered() []Record {
+	ordered := make([]Record, 0, h.count)
+
+	for i := 0; i < h.count; i++ {
+		ordered = append(ordered, h.ring[(h.start+i)%len(h.ring)])
+	}
+
+	return ordered
+}
+
+func (h *History) Stats() HistoryStats {
+	if h == nil {
+		return HistoryStats{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return HistoryStats{
+		Entries: h.count,
+		Bytes:   h.bytes,
+		Evicted: h.evicted,
+	}
+}
+
+// restore adds the persisted entries, applying the limits.
+func (h *History) restore(entries []Record, evicted uint64) {
+	if h == nil {
+		return
+	}
+
+	for _, r := range entries {
+		h.Add(r)
+	}
+
+	h.mu.Lock()
+	h.evicted += evicted
+	h.mu.Unlock()
+}
+
+func recordSize(r Record) int {
+	return entryOverhead + len(r.Name) + len(r.Provider) + len(r.ZoneId) + len(r.Content) + len(r.Error)
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"net"
+	"sync"
+)
+
+// Last holds the last published IP of each IP version and the last prefix.
+// The workers of the updaters write it while the servers read it, so it's
+// safe for concurrent use. The zero value holds nothing.
+type Last struct {
+	mu     sync.RWMutex
+	ipv4   net.IP
+	ipv6   net.IP
+	prefix *net.IPNet
+}
+
+// Get returns the last IP of the same IP version as ip, or nil.
+func (l *Last) Get(ip net.IP) net.IP {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if ip.To4() == nil {
+		return l.ipv6
+	}
+
+	return l.ipv4
+}
+
+// Set remembers ip as the last IP of its IP version.
+func (l *Last) Set(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ip.To4() == nil {
+		l.ipv6 = ip
+	} else {
+		l.ipv4 = ip
+	}
+}
+
+// Is tells whether ip is the last IP of its IP version.
+func (l *Last) Is(ip net.IP) bool {
+	last := l.Get(ip)
+
+	return last != nil && last.Equal(ip)
+}
+
+// Forget drops the last IP of the IP version, i.e. after deleting its records.
+func (l *Last) Forget(ipVersion int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ipVersion == 6 {
+		l.ipv6 = nil
+	} else {
+		l.ipv4 = nil
+	}
+}
+
+func (l *Last) Ipv4() net.IP {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.ipv4
+}
+
+func (l *Last) Ipv6() net.IP {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.ipv6
+}
+
+func (l *Last) Prefix() *net.IPNet {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.prefix
+}
+
+func (l *Last) SetPrefix(prefix *net.IPNet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prefix = prefix
+}
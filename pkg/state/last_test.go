@@ -0,0 +1,74 @@
+package state
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestLast(t *testing.T) {
+	var l Last
+
+	ipv4 := net.ParseIP("203.0.113.1")
+	ipv6 := net.ParseIP("2001:db8::1")
+
+	if l.Is(ipv4) || l.Get(ipv6) != nil {
+		t.Fatal("expected the zero value to hold nothing")
+	}
+
+	l.Set(ipv4)
+	l.Set(ipv6)
+
+	if !l.Is(ipv4) || !l.Is(ipv6) {
+		t.Fatal("expected both IPs to be the last ones")
+	}
+
+	if got := l.Get(net.ParseIP("203.0.113.2")); !got.Equal(ipv4) {
+		t.Errorf("Get returned %s for IPv4, expected %s", got, ipv4)
+	}
+
+	l.Forget(4)
+
+	if l.Ipv4() != nil || !l.Ipv6().Equal(ipv6) {
+		t.Errorf("Forget(4) left %s and %s, expected only %s", l.Ipv4(), l.Ipv6(), ipv6)
+	}
+
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/56")
+	l.SetPrefix(prefix)
+
+	if got := l.Prefix(); got.String() != prefix.String() {
+		t.Errorf("Prefix returned %s, expected %s", got, prefix)
+	}
+}
+
+// TestLastConcurrent is meant to be run with -race, the worker of an updater
+// writes while the servers read.
+func TestLastConcurrent(t *testing.T) {
+	var l Last
+	var wg sync.WaitGroup
+
+	for i := range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range 500 {
+				ip := net.IPv4(203, 0, 113, byte(i*j))
+				_, prefix, _ := net.ParseCIDR(fmt.Sprintf("2001:db8:%x::/56", j))
+
+				l.Set(ip)
+				l.Is(ip)
+				l.Get(ip)
+				l.SetPrefix(prefix)
+				l.Prefix()
+				l.Ipv4()
+				l.Ipv6()
+				l.Forget(4)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
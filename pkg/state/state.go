@@ -0,0 +1,262 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record is the outcome of the last update attempt of a single record.
+type Record struct {
+	Name      string    `json:"name"`
+	IpVersion int       `json:"ipVersion"`
+	Provider  string    `json:"provider"`
+	ZoneId    string    `json:"zoneId,omitempty"`
+	Content   string    `json:"content"`
+	Attempt   time.Time `json:"attempt"`
+	Error     string    `json:"error,omitempty"`
+	// Success is the time of the last successful update
+	Success time.Time `json:"success,omitempty"`
+	// Failures counts the failed attempts since the last success
+	Failures int `json:"failures,omitempty"`
+}
+
+// Store keeps the state of every record, if it has a path it's persisted to
+// a JSON file after every change, i.e. for the explain command.
+type Store struct {
+	path    string
+	history *History
+
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+// file is the format of the persisted store.
+type file struct {
+	Records []*Record    `json:"records"`
+	History []Record     `json:"history,omitempty"`
+	Stats   HistoryStats `json:"historyStats"`
+}
+
+// NewStore creates an empty store, history keeps the last attempts and may
+// be nil to keep none.
+func NewStore(path string, history *History) *Store {
+	return &Store{
+		path:    path,
+		history: history,
+		records: make(map[string]*Record),
+	}
+}
+
+// Load reads the store persisted at path into a new store, a missing file is
+// an empty store.
+func Load(path string, history *History) (*Store, error) {
+	s := NewStore(path, history)
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+
+	// Older versions persisted only the records
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &f.Records)
+	} else {
+		err = json.Unmarshal(data, &f)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+
+	for _, r := range f.Records {
+		s.records[key(r.Provider, r.Name, r.IpVersion)] = r
+	}
+
+	history.restore(f.History, f.Stats.Evicted)
+
+	return s, nil
+}
+
+func key(provider string, name string, ipVersion int) string {
+	return fmt.Sprintf("%s/%s/IPv%d", provider, name, ipVersion)
+}
+
+// Attempt records the outcome of an update attempt, a nil store ignores it.
+func (s *Store) Attempt(r Record, err error) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(r.Provider, r.Name, r.IpVersion)
+
+	r.Failures = 0
+
+	if previous, ok := s.records[k]; ok {
+		r.Success = previous.Success
+		r.Failures = previous.Failures
+	}
+
+	r.Attempt = time.Now()
+	r.Error = ""
+
+	if err != nil {
+		r.Error = err.Error()
+		r.Failures++
+	} else {
+		r.Success = r.Attempt
+		r.Failures = 0
+	}
+
+	s.records[k] = &r
+	s.history.Add(r)
+
+	return s.save()
+}
+
+// Find returns the state of all records with the name.
+func (s *Store) Find(name string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+
+	for _, r := range s.records {
+		if r.Name == name {
+			records = append(records, *r)
+		}
+	}
+
+	sortRecords(records)
+
+	return records
+}
+
+// Records returns the state of all records.
+func (s *Store) Records() []Record {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]Record, 0, len(s.records))
+
+	for _, r := range s.records {
+		records = append(records, *r)
+	}
+
+	sortRecords(records)
+
+	return records
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		return key(records[i].Provider, records[i].Name, records[i].IpVersion) < key(records[j].Provider, records[j].Name, records[j].IpVersion)
+	})
+}
+
+// Failing returns the records whose last threshold attempts all failed, so
+// sources can react to updates not going through.
+func (s *Store) Failing(threshold int) []Record {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+
+	for _, r := range s.records {
+		if r.Failures >= threshold {
+			records = append(records, *r)
+		}
+	}
+
+	return records
+}
+
+// FindHistory returns the last attempts of the record with the name, from the
+// oldest to the newest.
+func (s *Store) FindHistory(name string) []Record {
+	var records []Record
+
+	for _, r := range s.history.Entries() {
+		if r.Name == name {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// Close writes the state a last time, later attempts are only kept in memory.
+// It waits for an attempt being written, so the file is never left behind
+// partially.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.save()
+	s.path = ""
+
+	return err
+}
+
+func (s *Store) HistoryStats() HistoryStats {
+	return s.history.Stats()
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	f := file{
+		Records: make([]*Record, 0, len(s.records)),
+		History: s.history.Entries(),
+		Stats:   s.history.Stats(),
+	}
+
+	for _, r := range s.records {
+		f.Records = append(f.Records, r)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	// Replace the file atomically, so readers never see a partial file
+	tmp := s.path + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
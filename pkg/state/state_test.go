@@ -0,0 +1,146 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStoreAttempt(t *testing.T) {
+	s := NewStore("", NewHistory(10, 0))
+	r := Record{Name: "home.example.com", IpVersion: 4, Provider: "cloudflare", Content: "203.0.113.1"}
+
+	_ = s.Attempt(r, errors.New("timeout"))
+	_ = s.Attempt(r, errors.New("timeout"))
+
+	records := s.Find("home.example.com")
+
+	if len(records) != 1 || records[0].Failures != 2 || records[0].Error != "timeout" {
+		t.Fatalf("expected a single record with 2 failures, got %+v", records)
+	}
+
+	if failing := s.Failing(2); len(failing) != 1 {
+		t.Errorf("expected the record to be failing, got %+v", failing)
+	}
+
+	_ = s.Attempt(r, nil)
+
+	records = s.Find("home.example.com")
+
+	if records[0].Failures != 0 || records[0].Error != "" || records[0].Success.IsZero() {
+		t.Errorf("expected the success to reset the failures, got %+v", records[0])
+	}
+
+	if history := s.FindHistory("home.example.com"); len(history) != 3 {
+		t.Errorf("expected 3 attempts in the history, got %d", len(history))
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := NewStore(path, NewHistory(10, 0))
+
+	err := s.Attempt(Record{Name: "home.example.com", IpVersion: 6, Provider: "cloudflare", Content: "2001:db8::1"}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path, NewHistory(10, 0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := loaded.Records()
+
+	if len(records) != 1 || records[0].Content != "2001:db8::1" || records[0].Success.IsZero() {
+		t.Errorf("expected the persisted record, got %+v", records)
+	}
+
+	if history := loaded.FindHistory("home.example.com"); len(history) != 1 {
+		t.Errorf("expected the persisted history, got %+v", history)
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := NewStore(path, NewHistory(10, 0))
+
+	_ = s.Attempt(Record{Name: "home.example.com", IpVersion: 4, Provider: "cloudflare", Content: "203.0.113.1"}, nil)
+
+	err := s.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Attempts after closing must not touch the file anymore
+	_ = s.Attempt(Record{Name: "vpn.example.com", IpVersion: 4, Provider: "cloudflare", Content: "203.0.113.1"}, nil)
+
+	loaded, err := Load(path, NewHistory(10, 0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if records := loaded.Records(); len(records) != 1 || records[0].Name != "home.example.com" {
+		t.Errorf("expected only the record before closing, got %+v", records)
+	}
+
+	if len(s.Records()) != 2 {
+		t.Errorf("expected both records in memory, got %+v", s.Records())
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "missing.json"), nil)
+
+	if err != nil || len(s.Records()) != 0 {
+		t.Errorf("expected an empty store, got %+v, %v", s.Records(), err)
+	}
+}
+
+// TestStoreConcurrent is meant to be run with -race, the updaters record
+// their attempts while the servers read them.
+func TestStoreConcurrent(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "state.json"), NewHistory(20, 4096))
+	var wg sync.WaitGroup
+
+	for i := range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			name := fmt.Sprintf("r%d.example.com", i)
+
+			for j := range 50 {
+				var err error
+
+				if j%3 == 0 {
+					err = errors.New("timeout")
+				}
+
+				if saveErr := s.Attempt(Record{Name: name, IpVersion: 4, Provider: "cloudflare", Content: "203.0.113.1"}, err); saveErr != nil {
+					t.Error(saveErr)
+					return
+				}
+
+				s.Find(name)
+				s.Records()
+				s.Failing(1)
+				s.FindHistory(name)
+				s.HistoryStats()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if records := s.Records(); len(records) != 8 {
+		t.Errorf("expected 8 records, got %d", len(records))
+	}
+}
@@ -0,0 +1,241 @@
+package stun
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// magicCookie is part of every STUN message, see RFC 5389.
+const magicCookie uint32 = 0x2112A442
+
+const (
+	bindingRequest  uint16 = 0x0001
+	bindingResponse uint16 = 0x0101
+
+	attrMappedAddress    uint16 = 0x0001
+	attrXorMappedAddress uint16 = 0x0020
+)
+
+const headerSize = 20
+
+// DefaultServers are public STUN servers answering over IPv4 and IPv6.
+var DefaultServers = []string{
+	"stun.cloudflare.com:3478",
+	"stun.l.google.com:19302",
+}
+
+// Source asks STUN servers for the address the daemon is seen with, which is
+// lighter than asking HTTP services and not rate limited. Every IP version is
+// asked over a connection of that version, the servers are tried in order
+// until one answers.
+type Source struct {
+	// Servers as host:port
+	Servers []string
+	Timeout time.Duration
+
+	log *slog.Logger
+}
+
+func NewSource(log *slog.Logger) *Source {
+	return &Source{
+		Servers: DefaultServers,
+		Timeout: 5 * time.Second,
+		log:     log.With(slog.String("module", "stun")),
+	}
+}
+
+func (s *Source) GetWanIpv4() (net.IP, error) {
+	return s.ask("udp4")
+}
+
+func (s *Source) GetwanIpv6() (net.IP, error) {
+	return s.ask("udp6")
+}
+
+// GetIpv6Prefix always fails, STUN only reveals the address.
+func (s *Source) GetIpv6Prefix() (*net.IPNet, error) {
+	return nil, fmt.Errorf("IPv6 prefix over STUN: %w", errors.ErrUnsupported)
+}
+
+func (s *Source) ask(network string) (net.IP, error) {
+	var errs []error
+
+	for _, server := range s.Servers {
+		ip, err := Bind(network, server, s.Timeout)
+
+		if err != nil {
+			s.log.Debug("Server failed, trying the next one", slog.String("server", server), slog.String("network", network), logging.ErrorAttr(err))
+			errs = append(errs, err)
+			continue
+		}
+
+		return ip, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Bind sends a binding request to the server and returns the mapped address.
+func Bind(network string, server string, timeout time.Duration) (net.IP, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+
+	request := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(request[0:2], bindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], magicCookie)
+
+	transactionId := request[8:20]
+
+	if _, err := rand.Read(transactionId); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+
+	// UDP may drop the request or the response, so the request is repeated
+	// until the timeout, see the retransmissions in RFC 5389 section 7.2.1
+	for wait := 500 * time.Millisecond; ; wait *= 2 {
+		_, err = conn.Write(request)
+
+		if err != nil {
+			return nil, err
+		}
+
+		readDeadline := time.Now().Add(wait)
+
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+
+		err = conn.SetReadDeadline(readDeadline)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			n, err := conn.Read(buf)
+
+			if err != nil {
+				var netErr net.Error
+
+				if errors.As(err, &netErr) && netErr.Timeout() && time.Now().Before(deadline) {
+					break
+				}
+
+				return nil, fmt.Errorf("no response from %s: %w", server, err)
+			}
+
+			ip, err := parseResponse(buf[:n], transactionId)
+
+			// Stray datagrams are ignored, the request may still be answered
+			if errors.Is(err, errOtherTransaction) {
+				continue
+			}
+
+			return ip, err
+		}
+	}
+}
+
+var errOtherTransaction = errors.New("response to another transaction")
+
+// parseResponse reads the mapped address from a binding response, servers
+// following RFC 3489 only send the plain MAPPED-ADDRESS.
+func parseResponse(msg []byte, transactionId []byte) (net.IP, error) {
+	if len(msg) < headerSize || binary.BigEndian.Uint32(msg[4:8]) != magicCookie {
+		return nil, errors.New("invalid STUN response")
+	}
+
+	if !bytes.Equal(msg[8:20], transactionId) {
+		return nil, errOtherTransaction
+	}
+
+	if t := binary.BigEndian.Uint16(msg[0:2]); t != bindingResponse {
+		return nil, fmt.Errorf("unexpected STUN message type 0x%04x", t)
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+
+	if headerSize+length > len(msg) {
+		return nil, errors.New("truncated STUN response")
+	}
+
+	var mapped net.IP
+	attrs := msg[headerSize : headerSize+length]
+
+	for len(attrs) >= 4 {
+		t := binary.BigEndian.Uint16(attrs[0:2])
+		l := int(binary.BigEndian.Uint16(attrs[2:4]))
+
+		if 4+l > len(attrs) {
+			return nil, errors.New("truncated STUN attribute")
+		}
+
+		value := attrs[4 : 4+l]
+
+		switch t {
+		case attrXorMappedAddress:
+			return parseAddress(value, msg[4:20])
+		case attrMappedAddress:
+			mapped, _ = parseAddress(value, nil)
+		}
+
+		// Attributes are padded to 4 bytes
+		attrs = attrs[min(4+(l+3)&^3, len(attrs)):]
+	}
+
+	if mapped == nil {
+		return nil, errors.New("STUN response without a mapped address")
+	}
+
+	return mapped, nil
+}
+
+// parseAddress reads an address attribute, xor is the magic cookie followed
+// by the transaction id for XOR-MAPPED-ADDRESS, or nil.
+func parseAddress(value []byte, xor []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, errors.New("invalid STUN address")
+	}
+
+	var size int
+
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unknown STUN address family 0x%02x", value[1])
+	}
+
+	if len(value) < 4+size {
+		return nil, errors.New("invalid STUN address")
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+
+	for i := range xor {
+		if i < size {
+			ip[i] ^= xor[i]
+		}
+	}
+
+	return ip, nil
+}
@@ -0,0 +1,36 @@
+package updater
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Noop is a Provider that only remembers the IPs it received, i.e. for testing a setup.
+type Noop struct {
+	mu      sync.Mutex
+	records map[string]net.IP
+}
+
+func NewNoop() *Noop {
+	return &Noop{
+		records: make(map[string]net.IP),
+	}
+}
+
+func (n *Noop) Update(_ context.Context, record string, ip net.IP) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.records[record] = ip
+
+	return nil
+}
+
+// Get returns the last IP received for the record, or nil if none was received yet.
+func (n *Noop) Get(record string) net.IP {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.records[record]
+}
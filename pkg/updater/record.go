@@ -0,0 +1,18 @@
+package updater
+
+import (
+	"golang.org/x/net/publicsuffix"
+	"strings"
+)
+
+// SplitRecord splits a record name into its host part and the registered domain,
+// the host is empty for the apex of the domain.
+func SplitRecord(record string) (string, string, error) {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(record)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(record, domain), "."), domain, nil
+}
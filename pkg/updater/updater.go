@@ -0,0 +1,230 @@
+package updater
+
+import (
+	"context"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/state"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider publishes an IP to a single DNS record of a DNS service.
+type Provider interface {
+	Update(ctx context.Context, record string, ip net.IP) error
+}
+
+// Updater relays incoming IPs to a Provider for every configured record,
+// it is the generic counterpart of the CloudFlare updater for simpler backends.
+type Updater struct {
+	ipv4Zones []string
+	ipv6Zones []string
+
+	name     string
+	provider Provider
+	log      *slog.Logger
+	dryRun   bool
+
+	// State optionally keeps the outcome of every update attempt
+	State *state.Store
+	// Source tells where the last IP came from, for the logs
+	Source func() string
+
+	In chan *net.IP
+
+	last state.Last
+
+	// stop ends the worker, which closes stopped once it returned
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	started  bool
+}
+
+func NewUpdater(name string, provider Provider, log *slog.Logger) *Updater {
+	return &Updater{
+		In:        make(chan *net.IP, 10),
+		name:      name,
+		log:       log.With(slog.String("updater", name)),
+		provider:  provider,
+		ipv4Zones: make([]string, 0),
+		ipv6Zones: make([]string, 0),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+func (u *Updater) SetIPv4Zones(zones string) {
+	u.ipv4Zones = strings.Split(zones, ",")
+}
+
+func (u *Updater) SetIPv6Zones(zones string) {
+	u.ipv6Zones = strings.Split(zones, ",")
+}
+
+// SetDryRun makes the updater log the records it would update instead of
+// updating them.
+func (u *Updater) SetDryRun(dryRun bool) {
+	u.dryRun = dryRun
+}
+
+// Last returns the last published IPs, it's safe to read while the worker is
+// running.
+func (u *Updater) Last() *state.Last {
+	return &u.last
+}
+
+// source returns where the last IP came from, unknown if Source isn't set.
+func (u *Updater) source() string {
+	if u.Source == nil {
+		return "unknown"
+	}
+
+	return u.Source()
+}
+
+func (u *Updater) StartWorker() {
+	u.started = true
+
+	go u.spawnWorker()
+}
+
+// Stop stops the worker once it finished the current update and waits for
+// it, queued IPs are dropped.
+func (u *Updater) Stop() {
+	if !u.started {
+		return
+	}
+
+	u.stopOnce.Do(func() {
+		close(u.stop)
+	})
+
+	<-u.stopped
+}
+
+func (u *Updater) spawnWorker() {
+	defer close(u.stopped)
+
+	for {
+		select {
+		case ip := <-u.In:
+			u.update(ip)
+		case <-u.stop:
+			return
+		}
+	}
+}
+
+// update publishes the IP to the records of its IP version.
+func (u *Updater) update(ip *net.IP) {
+	zones := u.ipv4Zones
+
+	if ip.To4() == nil {
+		zones = u.ipv6Zones
+	}
+
+	if u.last.Is(*ip) {
+		return
+	}
+
+	u.log.Info("Received update request", slog.String("source", u.source()), slog.Any("ip", ip))
+
+	failed := false
+
+	for _, zone := range zones {
+		if u.dryRun {
+			u.log.Info("Dry run, would update DNS record", slog.String("zone", zone), slog.Any("old", u.last.Get(*ip)), slog.Any("new", ip))
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := u.provider.Update(ctx, zone, *ip)
+		cancel()
+
+		u.recordAttempt(zone, ip, err)
+
+		if err != nil {
+			u.log.Error("Action failed, could not update DNS record", slog.String("zone", zone), logging.ErrorAttr(err))
+			failed = true
+			continue
+		}
+
+		u.log.Info("Updated DNS record", slog.String("zone", zone))
+		metrics.RecordChanges.Inc(u.name, "updated")
+	}
+
+	// Failed updates are tried again with the next IP received, even if it didn't change
+	if !failed {
+		u.last.Set(*ip)
+	}
+}
+
+// recordAttempt keeps the outcome of updating the record.
+func (u *Updater) recordAttempt(record string, ip *net.IP, err error) {
+	ipVersion := 4
+
+	if ip.To4() == nil {
+		ipVersion = 6
+	}
+
+	if err != nil {
+		metrics.ProviderErrors.Inc(u.name)
+	}
+
+	saveErr := u.State.Attempt(state.Record{
+		Name:      record,
+		IpVersion: ipVersion,
+		Provider:  u.name,
+		Content:   ip.String(),
+	}, err)
+
+	if saveErr != nil {
+		u.log.Warn("Failed to save state", logging.ErrorAttr(saveErr))
+	}
+}
+
+// Cleanup points the records to the fallback IP of their IP version on
+// shutdown, the providers can't delete records, so records of IP versions
+// without fallback are left alone. The worker is stopped first, so a late
+// update can't overwrite the fallback.
+func (u *Updater) Cleanup(fallbackIpv4 net.IP, fallbackIpv6 net.IP) {
+	u.Stop()
+
+	for _, fallback := range []net.IP{fallbackIpv4, fallbackIpv6} {
+		if fallback == nil {
+			continue
+		}
+
+		zones := u.ipv4Zones
+
+		if fallback.To4() == nil {
+			zones = u.ipv6Zones
+		}
+
+		for _, zone := range zones {
+			if u.dryRun {
+				u.log.Info("Dry run, would update DNS record", slog.String("zone", zone), slog.Any("new", fallback))
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := u.provider.Update(ctx, zone, fallback)
+			cancel()
+
+			if err != nil {
+				u.log.Error("Action failed, could not update DNS record", slog.String("zone", zone), logging.ErrorAttr(err))
+				continue
+			}
+
+			u.log.Info("Updated DNS record to fallback IP", slog.String("zone", zone))
+		}
+	}
+
+	if fallbackIpv4 == nil && len(u.ipv4Zones) > 0 || fallbackIpv6 == nil && len(u.ipv6Zones) > 0 {
+		u.log.Warn("Records can't be deleted with this provider, set a fallback IP instead")
+	}
+}
@@ -0,0 +1,369 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"gopkg.in/xmlpath.v2"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ssdpAddress = "239.255.255.250:1900"
+
+const mSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: %d\r\n" +
+	"ST: %s\r\n\r\n"
+
+const soapAction string = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/" xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+    <s:Body>
+        <u:%s xmlns:u="%s" />
+    </s:Body>
+</s:Envelope>
+`
+
+// searchTargets are the device types answering to the discovery, version 2 of
+// IGD is a superset of version 1 but some routers only announce one of them.
+var searchTargets = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+}
+
+// connectionServices are the services offering GetExternalIPAddress, DSL
+// connections usually are PPP connections.
+var connectionServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:",
+	"urn:schemas-upnp-org:service:WANPPPConnection:",
+}
+
+// service is a connection service of the gateway.
+type service struct {
+	controlUrl  string
+	serviceType string
+}
+
+type serviceDescription struct {
+	ServiceType string `xml:"serviceType"`
+	ControlUrl  string `xml:"controlURL"`
+}
+
+type device struct {
+	Services []serviceDescription `xml:"serviceList>service"`
+	Devices  []device             `xml:"deviceList>device"`
+}
+
+type description struct {
+	UrlBase string `xml:"URLBase"`
+	Device  device `xml:"device"`
+}
+
+// Igd polls the WAN IPv4 from any UPnP Internet Gateway Device, the router is
+// discovered with SSDP unless the location of its description is given.
+type Igd struct {
+	// Location is the URL of the device description, i.e. http://192.168.1.1:5000/rootDesc.xml
+	Location string
+	Timeout  time.Duration
+
+	log *slog.Logger
+
+	mu      sync.Mutex
+	service *service
+}
+
+func NewIgd(log *slog.Logger) *Igd {
+	return &Igd{
+		Timeout: 5 * time.Second,
+		log:     log.With(slog.String("module", "upnp")),
+	}
+}
+
+func (i *Igd) GetWanIpv4() (net.IP, error) {
+	s, err := i.connection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := i.externalIp(s)
+
+	if err != nil {
+		// The router may have been replaced, discover it again next time
+		i.mu.Lock()
+		i.service = nil
+		i.mu.Unlock()
+
+		return nil, err
+	}
+
+	return ip, nil
+}
+
+// GetwanIpv6 always fails, IGD has no standard action for the IPv6.
+func (i *Igd) GetwanIpv6() (net.IP, error) {
+	return nil, fmt.Errorf("WAN IPv6 over UPnP IGD: %w", errors.ErrUnsupported)
+}
+
+// GetIpv6Prefix always fails, IGD has no standard action for the prefix.
+func (i *Igd) GetIpv6Prefix() (*net.IPNet, error) {
+	return nil, fmt.Errorf("IPv6 prefix over UPnP IGD: %w", errors.ErrUnsupported)
+}
+
+// connection finds the connection service with a WAN IP, the first one of the
+// first gateway responding wins.
+func (i *Igd) connection() (service, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.service != nil {
+		return *i.service, nil
+	}
+
+	locations := []string{i.Location}
+
+	if i.Location == "" {
+		var err error
+		locations, err = Discover(i.Timeout)
+
+		if err != nil {
+			return service{}, err
+		}
+
+		if len(locations) == 0 {
+			return service{}, errors.New("no UPnP gateway found, make sure UPnP is enabled on the router")
+		}
+	}
+
+	var errs []error
+
+	for _, location := range locations {
+		services, err := i.services(location)
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, s := range services {
+			if _, err := i.externalIp(s); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			i.log.Info("Found UPnP gateway", slog.String("location", location), slog.String("service", s.serviceType))
+			i.service = &s
+
+			return s, nil
+		}
+	}
+
+	if len(errs) == 0 {
+		return service{}, errors.New("no UPnP gateway with a WAN connection found")
+	}
+
+	return service{}, errors.Join(errs...)
+}
+
+// services reads the description at location and returns its connection
+// services.
+func (i *Igd) services(location string) ([]service, error) {
+	client := &http.Client{
+		Timeout: i.Timeout,
+	}
+
+	response, err := client.Get(location)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gateway description %s responded with %s", location, response.Status)
+	}
+
+	var desc description
+
+	err = xml.NewDecoder(response.Body).Decode(&desc)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway description %s: %w", location, err)
+	}
+
+	base, err := url.Parse(location)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative URLs are resolved against URLBase, which is deprecated but
+	// still sent by older routers
+	if desc.UrlBase != "" {
+		if b, err := url.Parse(desc.UrlBase); err == nil {
+			base = b
+		}
+	}
+
+	var services []service
+
+	for _, prefix := range connectionServices {
+		for _, s := range findServices(desc.Device, prefix) {
+			controlUrl, err := base.Parse(s.ControlUrl)
+
+			if err != nil {
+				i.log.Debug("Ignoring service with invalid control URL", slog.String("service", s.ServiceType), logging.ErrorAttr(err))
+				continue
+			}
+
+			services = append(services, service{controlUrl: controlUrl.String(), serviceType: s.ServiceType})
+		}
+	}
+
+	return services, nil
+}
+
+// findServices returns the services of the device and its embedded devices
+// with the type prefix.
+func findServices(d device, prefix string) []serviceDescription {
+	var found []serviceDescription
+
+	for _, s := range d.Services {
+		if strings.HasPrefix(s.ServiceType, prefix) {
+			found = append(found, s)
+		}
+	}
+
+	for _, child := range d.Devices {
+		found = append(found, findServices(child, prefix)...)
+	}
+
+	return found
+}
+
+func (i *Igd) externalIp(s service) (net.IP, error) {
+	request, err := http.NewRequest("POST", s.controlUrl, bytes.NewBufferString(fmt.Sprintf(soapAction, "GetExternalIPAddress", s.serviceType)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "text/xml; charset=utf-8;")
+	request.Header.Set("SoapAction", fmt.Sprintf("%q", s.serviceType+"#GetExternalIPAddress"))
+
+	client := &http.Client{
+		Timeout: i.Timeout,
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetExternalIPAddress of %s responded with %s", s.controlUrl, response.Status)
+	}
+
+	root, err := xmlpath.Parse(bytes.NewBuffer(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	v, _ := xmlpath.MustCompile("//NewExternalIPAddress").String(root)
+	ip := net.ParseIP(strings.TrimSpace(v))
+
+	// Disconnected gateways report an empty address or 0.0.0.0
+	if ip == nil || ip.To4() == nil || ip.IsUnspecified() {
+		return nil, fmt.Errorf("no WAN IPv4 reported by %s", s.controlUrl)
+	}
+
+	return ip, nil
+}
+
+// Discover searches the local network for gateways with SSDP and returns the
+// locations of their descriptions.
+func Discover(timeout time.Duration) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Devices wait a random time up to MX seconds before answering
+	mx := max(int(timeout.Seconds())-1, 1)
+
+	for _, target := range searchTargets {
+		_, err := conn.WriteTo([]byte(fmt.Sprintf(mSearch, mx, target)), addr)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []string
+	buf := make([]byte, 2048)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+
+		if err != nil {
+			var netErr net.Error
+
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return locations, nil
+			}
+
+			return locations, err
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+
+		if err != nil {
+			continue
+		}
+
+		_ = response.Body.Close()
+
+		location := response.Header.Get("Location")
+
+		if location != "" && !slices.Contains(locations, location) {
+			locations = append(locations, location)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package whoami
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"golang.org/x/net/dns/dnsmessage"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+)
+
+// Service is a DNS server answering a special name with the address the
+// query came from.
+type Service struct {
+	// Server4 and Server6 are the addresses of the server for each IP version
+	Server4 string
+	Server6 string
+	Name    string
+	// Type is TXT, or A and AAAA answered with the matching query per IP version
+	Type  dnsmessage.Type
+	Class dnsmessage.Class
+}
+
+// Services are the known services, they answer over both IPv4 and IPv6.
+var Services = map[string]Service{
+	"cloudflare": {
+		Server4: "1.1.1.1:53",
+		Server6: "[2606:4700:4700::1111]:53",
+		Name:    "whoami.cloudflare.",
+		Type:    dnsmessage.TypeTXT,
+		Class:   dnsmessage.ClassCHAOS,
+	},
+	"opendns": {
+		Server4: "208.67.222.222:53",
+		Server6: "[2620:119:35::35]:53",
+		Name:    "myip.opendns.com.",
+		Type:    dnsmessage.TypeA,
+		Class:   dnsmessage.ClassINET,
+	},
+	"google": {
+		Server4: "216.239.32.10:53",
+		Server6: "[2001:4860:4802:32::a]:53",
+		Name:    "o-o.myaddr.l.google.com.",
+		Type:    dnsmessage.TypeTXT,
+		Class:   dnsmessage.ClassINET,
+	},
+}
+
+// DefaultServices are asked in this order if none are configured.
+var DefaultServices = []string{"cloudflare", "opendns", "google"}
+
+// Source asks DNS servers for the IPs the daemon is seen with, for networks
+// that filter outgoing HTTP but not DNS. The queries go directly to the
+// servers of the services, a resolver in between would be seen instead.
+type Source struct {
+	Services []Service
+	Timeout  time.Duration
+
+	log *slog.Logger
+}
+
+func NewSource(log *slog.Logger) *Source {
+	s := &Source{
+		Timeout: 5 * time.Second,
+		log:     log.With(slog.String("module", "whoami")),
+	}
+
+	for _, name := range DefaultServices {
+		s.Services = append(s.Services, Services[name])
+	}
+
+	return s
+}
+
+func (s *Source) GetWanIpv4() (net.IP, error) {
+	return s.ask("udp4")
+}
+
+func (s *Source) GetwanIpv6() (net.IP, error) {
+	return s.ask("udp6")
+}
+
+// GetIpv6Prefix always fails, the servers only see the address.
+func (s *Source) GetIpv6Prefix() (*net.IPNet, error) {
+	return nil, fmt.Errorf("IPv6 prefix over DNS: %w", errors.ErrUnsupported)
+}
+
+func (s *Source) ask(network string) (net.IP, error) {
+	var errs []error
+
+	for _, service := range s.Services {
+		ip, err := Query(service, network, s.Timeout)
+
+		if err != nil {
+			s.log.Debug("Service failed, trying the next one", slog.String("name", service.Name), slog.String("network", network), logging.ErrorAttr(err))
+			errs = append(errs, err)
+			continue
+		}
+
+		return ip, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Query asks the service over network, udp4 or udp6, for the address.
+func Query(service Service, network string, timeout time.Duration) (net.IP, error) {
+	server := service.Server4
+	t := service.Type
+
+	if network == "udp6" {
+		server = service.Server6
+
+		if t == dnsmessage.TypeA {
+			t = dnsmessage.TypeAAAA
+		}
+	}
+
+	name, err := dnsmessage.NewName(service.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := uint16(rand.UintN(1 << 16))
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: t, Class: service.Class},
+		},
+	}
+
+	packed, err := query.Pack()
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout(network, server, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write(packed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1232)
+
+	for {
+		n, err := conn.Read(buf)
+
+		if err != nil {
+			return nil, fmt.Errorf("no response from %s: %w", server, err)
+		}
+
+		var response dnsmessage.Message
+
+		// Stray datagrams are ignored, the query may still be answered
+		if err := response.Unpack(buf[:n]); err != nil || response.ID != id || !response.Response {
+			continue
+		}
+
+		if response.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("%s responded with %s for %s", server, response.RCode, service.Name)
+		}
+
+		return parseAnswers(response.Answers, network, server)
+	}
+}
+
+// parseAnswers returns the first address of the matching IP version, TXT
+// records contain it as text.
+func parseAnswers(answers []dnsmessage.Resource, network string, server string) (net.IP, error) {
+	for _, answer := range answers {
+		var ip net.IP
+
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = body.A[:]
+		case *dnsmessage.AAAAResource:
+			ip = body.AAAA[:]
+		case *dnsmessage.TXTResource:
+			ip = net.ParseIP(strings.TrimSpace(strings.Join(body.TXT, "")))
+		}
+
+		if ip != nil && (network == "udp4") == (ip.To4() != nil) {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s responded without an IP over %s", server, network)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/avm"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/health"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestZonesConfigured(t *testing.T) {
+	for _, prefix := range zonePrefixes {
+		t.Setenv(prefix+"_ZONES_IPV4", "")
+		t.Setenv(prefix+"_ZONES_IPV6", "")
+	}
+
+	t.Setenv("DNSIMPLE_ZONES_IPV6", "home.example.com")
+
+	if zonesConfigured("IPV4") || !zonesConfigured("IPV6") {
+		t.Errorf("expected only IPv6 zones, got IPv4 %t and IPv6 %t", zonesConfigured("IPV4"), zonesConfigured("IPV6"))
+	}
+}
+
+// TestPollWithoutCloudflare checks that the FritzBox is polled for the
+// zones of another provider.
+func TestPollWithoutCloudflare(t *testing.T) {
+	fritzbox := avm.NewMock(net.ParseIP("203.0.113.7"), nil, nil)
+	defer fritzbox.Close()
+
+	for _, prefix := range zonePrefixes {
+		t.Setenv(prefix+"_ZONES_IPV4", "")
+		t.Setenv(prefix+"_ZONES_IPV6", "")
+	}
+
+	t.Setenv("FRITZBOX_ENDPOINT_SOURCE", "")
+	t.Setenv("FRITZBOX_ENDPOINT_FALLBACK", "")
+	t.Setenv("FRITZBOX_ENDPOINT_URL", fritzbox.URL)
+	t.Setenv("FRITZBOX_ENDPOINT_INTERVAL", "1h")
+	t.Setenv("NAMECHEAP_ZONES_IPV4", "home.example.com")
+
+	out := make(chan *net.IP, 1)
+	var localIp net.IP
+
+	startPollServer(out, nil, &localIp, func() bool { return false }, nil, health.New())
+
+	select {
+	case ip := <-out:
+		if !ip.Equal(net.ParseIP("203.0.113.7")) {
+			t.Errorf("polled %s, expected 203.0.113.7", ip)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the FritzBox wasn't polled")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// profiles provide defaults for common ISP setups, variables that are set
+// explicitly always take precedence.
+var profiles = map[string]map[string]string{
+	// Dual-stack lite only offers a shared IPv4, so only IPv6 is reachable
+	"dslite": {
+		"PUBLISH_IPV4":                  "false",
+		"PUBLISH_IPV6":                  "true",
+		"CLOUDFLARE_DELETE_UNPUBLISHED": "true",
+	},
+	// A public IPv4 and IPv6, publish both
+	"dualstack": {
+		"PUBLISH_IPV4":                  "true",
+		"PUBLISH_IPV6":                  "true",
+		"CLOUDFLARE_DELETE_UNPUBLISHED": "false",
+	},
+	// Mobile connections hide IPv4 behind a carrier-grade NAT and change often
+	"cgnat-lte": {
+		"PUBLISH_IPV4":                  "false",
+		"PUBLISH_IPV6":                  "true",
+		"CLOUDFLARE_DELETE_UNPUBLISHED": "true",
+		"CLOUDFLARE_TTL":                "60",
+	},
+}
+
+// applyProfile sets the defaults of the profile for all unset variables.
+func applyProfile(name string) error {
+	profile, ok := profiles[name]
+
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	for key, value := range profile {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+
+		err := os.Setenv(key, value)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
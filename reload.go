@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/logging"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+)
+
+// handoverEnv passes the last known IPs to the process started by a reload.
+const handoverEnv = "RELOAD_HANDOVER"
+
+// handover is what the process started by a reload takes over from the
+// previous one.
+type handover struct {
+	Ipv4 *knownIp `json:"ipv4,omitempty"`
+	Ipv6 *knownIp `json:"ipv6,omitempty"`
+	// LocalIpv6 is the DEVICE_LOCAL_ADDRESS_IPV6 the IPv6 was constructed with
+	LocalIpv6 string `json:"localIpv6,omitempty"`
+}
+
+// takeHandover returns the state passed on by the previous process, nil if the
+// service wasn't started by a reload.
+func takeHandover() *handover {
+	data, ok := os.LookupEnv(handoverEnv)
+
+	if !ok {
+		return nil
+	}
+
+	_ = os.Unsetenv(handoverEnv)
+
+	var h handover
+	err := json.Unmarshal([]byte(data), &h)
+
+	if err != nil {
+		slog.Warn("Failed to take over the last IPs from before the reload", logging.ErrorAttr(err))
+		return nil
+	}
+
+	return &h
+}
+
+// resume relays the IPs known before the reload, so they are published with
+// the new configuration without waiting for the next poll or push.
+func (h *handover) resume(tracker *sourceTracker, sources chan<- *net.IP) {
+	// The IPv6 was constructed from the prefix with another address, or it's
+	// the address of the router which isn't published anymore
+	if h.LocalIpv6 != os.Getenv("DEVICE_LOCAL_ADDRESS_IPV6") {
+		h.Ipv6 = nil
+	}
+
+	for _, known := range []*knownIp{h.Ipv4, h.Ipv6} {
+		if known == nil {
+			continue
+		}
+
+		ip := net.ParseIP(known.IP)
+
+		if ip == nil {
+			continue
+		}
+
+		slog.Info("Taking over IP from before the reload", slog.Any("ip", ip), slog.String("source", known.Source))
+
+		tracker.restore(known)
+		tracker.Tag(known.Source, sources) <- &ip
+	}
+}
+
+// checkConfig validates the configuration a reload would start with, by
+// running the check command in the environment the service was started in.
+func checkConfig(environ []string) bool {
+	executable, err := os.Executable()
+
+	if err != nil {
+		slog.Error("Failed to find the executable", logging.ErrorAttr(err))
+		return false
+	}
+
+	cmd := exec.Command(executable, append(slices.Clone(os.Args[1:]), "check")...)
+	cmd.Env = environ
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run() == nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// startConfigWatcher does nothing, without SIGHUP the configuration is only
+// read on start.
+func startConfigWatcher(chan<- os.Signal) {}
+
+// reload fails, the process can't be replaced without exec.
+func reload([]string, *sourceTracker) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,92 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// configWatchInterval is how often the configuration files are checked for
+// changes.
+const configWatchInterval = 5 * time.Second
+
+// configFiles are the files the configuration is read from.
+func configFiles() []string {
+	files := []string{".env", ".env.dev"}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		files = append(files, path)
+	}
+
+	return files
+}
+
+// startConfigWatcher raises SIGHUP once one of the configuration files is
+// created, changed or removed.
+func startConfigWatcher(signals chan<- os.Signal) {
+	if !envBool("CONFIG_WATCH", false) {
+		return
+	}
+
+	files := configFiles()
+	last := fileStamps(files)
+
+	go func() {
+		ticker := time.NewTicker(configWatchInterval)
+
+		for range ticker.C {
+			current := fileStamps(files)
+
+			if slices.Equal(current, last) {
+				continue
+			}
+
+			last = current
+
+			slog.Info("Configuration file changed")
+			signals <- syscall.SIGHUP
+		}
+	}()
+}
+
+// fileStamps returns the modification time and size of every file, an empty
+// stamp if it doesn't exist.
+func fileStamps(files []string) []string {
+	stamps := make([]string, len(files))
+
+	for i, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps[i] = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+
+	return stamps
+}
+
+// reload replaces the process with a new one of the executable, which reads
+// the configuration again and takes over the last known IPs. It only returns
+// if the new process can't be started.
+func reload(environ []string, tracker *sourceTracker) error {
+	executable, err := os.Executable()
+
+	if err != nil {
+		return err
+	}
+
+	h := handover{LocalIpv6: os.Getenv("DEVICE_LOCAL_ADDRESS_IPV6")}
+	h.Ipv4, h.Ipv6 = tracker.Known()
+
+	data, err := json.Marshal(h)
+
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(executable, os.Args, append(slices.Clone(environ), handoverEnv+"="+string(data)))
+}
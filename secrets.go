@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretEnv lists the variables holding secrets, they can also be read from
+// the file named in the variable with the _FILE suffix, i.e.
+// CLOUDFLARE_API_TOKEN_FILE.
+var secretEnv = []string{
+	"CLOUDFLARE_API_KEY",
+	"CLOUDFLARE_API_TOKEN",
+	"CLOUDFLARE_ZONE_TOKENS",
+	"DNSIMPLE_API_TOKEN",
+	"DYNDNS_SERVER_HMAC_SECRET",
+	"DYNDNS_SERVER_INTROSPECTION_CLIENT_SECRET",
+	"DYNDNS_SERVER_PASSWORD",
+	"DYNDNS_SERVER_TOKEN",
+	"FIREWALL_KEY",
+	"FIREWALL_SECRET",
+	"FRITZBOX_TR064_PASSWORD",
+	"FRITZBOX_WEBUI_PASSWORD",
+	"INFOMANIAK_API_TOKEN",
+	"JSON_PASSWORD",
+	"JSON_TOKEN",
+	"MIKROTIK_PASSWORD",
+	"NAMECHEAP_DDNS_PASSWORD",
+	"NOTIFY_WEBHOOK_URL",
+	"NS1_API_KEY",
+	"OPENWRT_PASSWORD",
+	"SCALEWAY_SECRET_KEY",
+	"SNMP_AUTH_PASSWORD",
+	"SNMP_COMMUNITY",
+	"SNMP_PRIV_PASSWORD",
+}
+
+// loadSecretFiles sets the secrets from the files named in their _FILE
+// variables, i.e. Docker secrets or systemd credentials. The secrets set
+// neither way are looked up in the secrets directory.
+func loadSecretFiles() error {
+	dir := secretsDir()
+
+	for _, name := range secretEnv {
+		path := os.Getenv(name + "_FILE")
+		set := os.Getenv(name) != ""
+
+		switch {
+		case path != "" && set:
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		case set:
+			continue
+		case path == "":
+			path = findSecret(dir, name)
+		}
+
+		if path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", name, err)
+		}
+
+		slog.Debug("Read secret from file", slog.String("name", name), slog.String("path", path))
+
+		// Editors and echo add a trailing newline, which is never part of the secret
+		err = os.Setenv(name, strings.TrimRight(string(data), "\r\n"))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// secretsDir is where the secrets are looked up, the credentials of systemd
+// if it passed any, otherwise where Docker and Podman mount the secrets.
+func secretsDir() string {
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		return dir
+	}
+
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		return dir
+	}
+
+	return "/run/secrets"
+}
+
+// findSecret returns the file of the secret in dir, named like its variable
+// in lower or upper case, i.e. cloudflare_api_token. It returns an empty path
+// if there is none.
+func findSecret(dir string, name string) string {
+	for _, file := range []string{strings.ToLower(name), name} {
+		path := filepath.Join(dir, file)
+
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/avm"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/dyndns"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	selftestIpv4Record = "ipv4.selftest.invalid"
+	selftestIpv6Record = "ipv6.selftest.invalid"
+)
+
+var (
+	selftestIpv4         = net.ParseIP("203.0.113.10")
+	selftestIpv6         = net.ParseIP("2001:db8::10")
+	selftestLocalIp      = net.ParseIP("::1234:5678:90ab:cdef")
+	selftestConstructed  = net.ParseIP("2001:db8:1:2:1234:5678:90ab:cdef")
+	_, selftestPrefix, _ = net.ParseCIDR("2001:db8:1:2::/64")
+)
+
+type selftestCase struct {
+	source   string
+	family   string
+	record   string
+	expected net.IP
+	localIp  net.IP
+	trigger  func(out chan<- *net.IP, localIp *net.IP) error
+}
+
+// runSelftest runs simulated pushes and polls through the pipeline into a noop
+// updater and prints a pass/fail matrix, it returns whether all cases passed.
+func runSelftest() bool {
+	// Keep the matrix readable, the failures get reported there
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	mock := avm.NewMock(selftestIpv4, selftestIpv6, selftestPrefix)
+	defer mock.Close()
+
+	poll := func(out chan<- *net.IP, localIp *net.IP) error {
+		fb := avm.NewFritzBox()
+		fb.Url = mock.URL
+
+		return newPoller(fb, out, nil, localIp, true, true)()
+	}
+
+	push := func(out chan<- *net.IP, localIp *net.IP) error {
+		return selftestPush(out, localIp, url.Values{
+			"v4":     {selftestIpv4.String()},
+			"v6":     {selftestIpv6.String()},
+			"prefix": {selftestPrefix.String()},
+		})
+	}
+
+	cases := []selftestCase{
+		{"push", "IPv4", selftestIpv4Record, selftestIpv4, nil, push},
+		{"push", "IPv6", selftestIpv6Record, selftestIpv6, nil, push},
+		{"push", "IPv6 prefix", selftestIpv6Record, selftestConstructed, selftestLocalIp, push},
+		{"poll", "IPv4", selftestIpv4Record, selftestIpv4, nil, poll},
+		{"poll", "IPv6", selftestIpv6Record, selftestIpv6, nil, poll},
+		{"poll", "IPv6 prefix", selftestIpv6Record, selftestConstructed, selftestLocalIp, poll},
+	}
+
+	passed := true
+
+	fmt.Printf("%-8s %-12s %s\n", "SOURCE", "FAMILY", "RESULT")
+
+	for _, c := range cases {
+		err := c.run()
+		result := "pass"
+
+		if err != nil {
+			result = fmt.Sprintf("FAIL: %s", err)
+			passed = false
+		}
+
+		fmt.Printf("%-8s %-12s %s\n", c.source, c.family, result)
+	}
+
+	return passed
+}
+
+func (c *selftestCase) run() error {
+	noop := updater.NewNoop()
+
+	u := updater.NewUpdater("selftest", noop, slog.Default())
+	u.SetIPv4Zones(selftestIpv4Record)
+	u.SetIPv6Zones(selftestIpv6Record)
+	u.StartWorker()
+
+	in := make(chan *net.IP, 10)
+	defer close(in)
+
+	go fanOut(in, []chan *net.IP{u.In}, ipVersion)
+
+	err := c.trigger(in, &c.localIp)
+
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if ip := noop.Get(c.record); ip != nil {
+			if !ip.Equal(c.expected) {
+				return fmt.Errorf("expected %s, got %s", c.expected, ip)
+			}
+
+			return nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return fmt.Errorf("expected %s, got nothing", c.expected)
+}
+
+// selftestPush sends a push request to a push server listening on an ephemeral port.
+func selftestPush(out chan<- *net.IP, localIp *net.IP, params url.Values) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return err
+	}
+
+	server := dyndns.NewServer(out, localIp, slog.Default())
+	server.Username = "selftest"
+	server.Password = "selftest"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ip", server.Handler)
+
+	s := &http.Server{Handler: mux}
+	defer s.Close()
+
+	go func() {
+		_ = s.Serve(listener)
+	}()
+
+	params.Set("username", server.Username)
+	params.Set("password", server.Password)
+
+	response, err := http.Get(fmt.Sprintf("http://%s/ip?%s", listener.Addr(), params.Encode()))
+
+	if err != nil {
+		return err
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("push server responded with %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// cutoverSignals is empty, without SIGUSR1 the cutover is only confirmed by
+// the probes.
+var cutoverSignals []os.Signal
+
+func isCutoverSignal(os.Signal) bool {
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// cutoverSignals confirm a pending cutover, i.e. `docker kill -s USR1 <container>`.
+var cutoverSignals = []os.Signal{syscall.SIGUSR1}
+
+func isCutoverSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/metrics"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sourceTracker remembers which source relayed the last IP, i.e. poll or push.
+type sourceTracker struct {
+	last atomic.Value
+
+	mu   sync.Mutex
+	ipv4 *knownIp
+	ipv6 *knownIp
+}
+
+// knownIp is the last IP of a family relayed by any source.
+type knownIp struct {
+	IP     string `json:"ip"`
+	Source string `json:"source"`
+	// Since is when the IP was first relayed
+	Since time.Time `json:"since"`
+}
+
+// Tag returns a channel relaying to out, which marks name as the last source
+// and counts the IPs differing from the previous one of the source.
+func (t *sourceTracker) Tag(name string, out chan<- *net.IP) chan<- *net.IP {
+	in := make(chan *net.IP, 10)
+
+	go func() {
+		var lastIpv4, lastIpv6 net.IP
+
+		for ip := range in {
+			t.last.Store(name)
+			t.relayed(name, *ip)
+
+			if ip.To4() != nil && !lastIpv4.Equal(*ip) {
+				lastIpv4 = *ip
+				metrics.IpChanges.Inc(name, "ipv4")
+			} else if ip.To4() == nil && !lastIpv6.Equal(*ip) {
+				lastIpv6 = *ip
+				metrics.IpChanges.Inc(name, "ipv6")
+			}
+
+			out <- ip
+		}
+	}()
+
+	return in
+}
+
+// relayed remembers the IP as the last one of its family.
+func (t *sourceTracker) relayed(name string, ip net.IP) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known := &t.ipv6
+
+	if ip.To4() != nil {
+		known = &t.ipv4
+	}
+
+	if *known != nil && (*known).IP == ip.String() {
+		(*known).Source = name
+		return
+	}
+
+	*known = &knownIp{IP: ip.String(), Source: name, Since: time.Now()}
+}
+
+// restore takes over an IP known before a reload, along with the time it was
+// first relayed.
+func (t *sourceTracker) restore(known *knownIp) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if net.ParseIP(known.IP).To4() != nil {
+		t.ipv4 = known
+	} else {
+		t.ipv6 = known
+	}
+}
+
+// Last returns the source of the last IP.
+func (t *sourceTracker) Last() string {
+	if name, ok := t.last.Load().(string); ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// Known returns copies of the last IPv4 and IPv6, nil if none was relayed yet.
+func (t *sourceTracker) Known() (*knownIp, *knownIp) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return copyKnown(t.ipv4), copyKnown(t.ipv6)
+}
+
+func copyKnown(known *knownIp) *knownIp {
+	if known == nil {
+		return nil
+	}
+
+	c := *known
+
+	return &c
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/state"
+	"net"
+	"net/http"
+	"time"
+)
+
+// status is the state of the daemon served at /status.
+type status struct {
+	Version       string    `json:"version"`
+	Started       time.Time `json:"started"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+
+	Ipv4 *knownIp `json:"ipv4"`
+	Ipv6 *knownIp `json:"ipv6"`
+	// ConstructedIpv6 replaces Ipv6 if the IPv6 of the device is constructed
+	// from the prefix
+	ConstructedIpv6 *knownIp `json:"constructedIpv6,omitempty"`
+
+	// Records hold the last update and the last successful one of every
+	// record
+	Records []state.Record `json:"records"`
+	// Errors are the last errors of the records failing right now
+	Errors []statusError `json:"errors"`
+}
+
+type statusError struct {
+	Provider  string    `json:"provider"`
+	Name      string    `json:"name"`
+	IpVersion int       `json:"ipVersion"`
+	Error     string    `json:"error"`
+	Attempt   time.Time `json:"attempt"`
+	Failures  int       `json:"failures"`
+}
+
+// newStatusHandler serves the state of the daemon as JSON, for dashboards and
+// scripts.
+func newStatusHandler(tracker *sourceTracker, store *state.Store, localIp *net.IP, started time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s := status{
+			Version:       version(),
+			Started:       started,
+			UptimeSeconds: int64(time.Since(started).Seconds()),
+			Records:       store.Records(),
+			Errors:        []statusError{},
+		}
+
+		s.Ipv4, s.Ipv6 = tracker.Known()
+
+		if *localIp != nil {
+			s.ConstructedIpv6, s.Ipv6 = s.Ipv6, nil
+		}
+
+		for _, record := range s.Records {
+			if record.Error == "" {
+				continue
+			}
+
+			s.Errors = append(s.Errors, statusError{
+				Provider:  record.Provider,
+				Name:      record.Name,
+				IpVersion: record.IpVersion,
+				Error:     record.Error,
+				Attempt:   record.Attempt,
+				Failures:  record.Failures,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(s)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !notzdata
+
+package main
+
+// Time zones for schedules, the container image has no zoneinfo
+import _ "time/tzdata"
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/dnsimple"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/infomaniak"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/namecheap"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/ns1"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/scaleway"
+	"github.com/cromefire/fritzbox-cloudflare-dyndns/pkg/updater"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// zonePrefixes are the prefixes of the <prefix>_ZONES_IPV4 and
+// <prefix>_ZONES_IPV6 variables of all updaters.
+var zonePrefixes = []string{"CLOUDFLARE", "NAMECHEAP", "INFOMANIAK", "SCALEWAY", "DNSIMPLE", "NS1"}
+
+// zonesConfigured tells whether any updater has zones for the family, i.e.
+// "IPV4" or "IPV6".
+func zonesConfigured(family string) bool {
+	for _, prefix := range zonePrefixes {
+		if os.Getenv(prefix+"_ZONES_"+family) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newZoneUpdater creates an updater for the zones configured in <prefix>_ZONES_IPV4
+// and <prefix>_ZONES_IPV6, it returns nil if neither is set.
+func newZoneUpdater(name string, prefix string, provider updater.Provider) *updater.Updater {
+	ipv4Zone := os.Getenv(prefix + "_ZONES_IPV4")
+	ipv6Zone := os.Getenv(prefix + "_ZONES_IPV6")
+
+	if ipv4Zone == "" && ipv6Zone == "" {
+		slog.Info(fmt.Sprintf("Env %[1]s_ZONES_IPV4 and %[1]s_ZONES_IPV6 not found, disabling %[2]s updates", prefix, name))
+		return nil
+	}
+
+	u := updater.NewUpdater(strings.ToLower(name), provider, slog.Default())
+
+	if ipv4Zone != "" {
+		u.SetIPv4Zones(ipv4Zone)
+	}
+
+	if ipv6Zone != "" {
+		u.SetIPv6Zones(ipv6Zone)
+	}
+
+	return u
+}
+
+func newNamecheapUpdater() *updater.Updater {
+	password := os.Getenv("NAMECHEAP_DDNS_PASSWORD")
+	ipv4Zone := os.Getenv("NAMECHEAP_ZONES_IPV4")
+
+	if password == "" || ipv4Zone == "" {
+		slog.Info("Env NAMECHEAP_DDNS_PASSWORD or NAMECHEAP_ZONES_IPV4 not found, disabling Namecheap updates")
+		return nil
+	}
+
+	u := updater.NewUpdater("namecheap", namecheap.NewProvider(password), slog.Default())
+	u.SetIPv4Zones(ipv4Zone)
+
+	return u
+}
+
+func newInfomaniakUpdater() *updater.Updater {
+	token := os.Getenv("INFOMANIAK_API_TOKEN")
+
+	if token == "" {
+		slog.Info("Env INFOMANIAK_API_TOKEN not found, disabling Infomaniak updates")
+		return nil
+	}
+
+	return newZoneUpdater("Infomaniak", "INFOMANIAK", infomaniak.NewProvider(token))
+}
+
+func newScalewayUpdater() *updater.Updater {
+	secretKey := os.Getenv("SCALEWAY_SECRET_KEY")
+
+	if secretKey == "" {
+		slog.Info("Env SCALEWAY_SECRET_KEY not found, disabling Scaleway updates")
+		return nil
+	}
+
+	return newZoneUpdater("Scaleway", "SCALEWAY", scaleway.NewProvider(secretKey))
+}
+
+func newDnsimpleUpdater() *updater.Updater {
+	token := os.Getenv("DNSIMPLE_API_TOKEN")
+
+	if token == "" {
+		slog.Info("Env DNSIMPLE_API_TOKEN not found, disabling DNSimple updates")
+		return nil
+	}
+
+	return newZoneUpdater("DNSimple", "DNSIMPLE", dnsimple.NewProvider(token))
+}
+
+func newNs1Updater() *updater.Updater {
+	apiKey := os.Getenv("NS1_API_KEY")
+
+	if apiKey == "" {
+		slog.Info("Env NS1_API_KEY not found, disabling NS1 updates")
+		return nil
+	}
+
+	return newZoneUpdater("NS1", "NS1", ns1.NewProvider(apiKey))
+}
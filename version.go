@@ -0,0 +1,30 @@
+package main
+
+import (
+	"runtime/debug"
+)
+
+// buildVersion can be set at build time, i.e. with
+// `go build -ldflags "-X main.buildVersion=v1.2.3"`.
+var buildVersion string
+
+// version returns the version of the binary, or the commit it was built from.
+func version() string {
+	if buildVersion != "" {
+		return buildVersion
+	}
+
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return "unknown"
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+			return setting.Value[:7]
+		}
+	}
+
+	return info.Main.Version
+}